@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig controls inbound limits on the license verification
+// endpoints. Zero values fall back to the defaults below.
+type RateLimitConfig struct {
+	// PerIP limits /validate-license calls per client IP; the public API has
+	// its own budget in Config.PublicRateLimit
+	PerIP           int `json:"per_ip,omitempty"`
+	IPWindowSeconds int `json:"ip_window_seconds,omitempty"`
+	// PerKey limits verifications of a single license key from any client
+	PerKey           int `json:"per_key,omitempty"`
+	KeyWindowSeconds int `json:"key_window_seconds,omitempty"`
+	// BanAfterInvalid bans an IP once it submits this many unknown keys
+	// within InvalidWindowSeconds
+	BanAfterInvalid      int `json:"ban_after_invalid,omitempty"`
+	InvalidWindowSeconds int `json:"invalid_window_seconds,omitempty"`
+	BanSeconds           int `json:"ban_seconds,omitempty"`
+}
+
+const (
+	defaultRatePerIP            = 30
+	defaultRateIPWindow         = 60
+	defaultRatePerKey           = 10
+	defaultRateKeyWindow        = 60
+	defaultBanAfterInvalid      = 20
+	defaultInvalidWindowSeconds = 600
+	defaultBanSeconds           = 900
+)
+
+// How long a throttled client stays listed on the throttling page after its
+// last rejected request.
+const throttleListRetention = time.Hour
+
+// maxThrottledClients caps the throttling list, so a flood of requests from
+// many addresses cannot grow it without bound. The least recently seen
+// client that is not banned makes room for a new one.
+const maxThrottledClients = 10000
+
+// ThrottledClient is a client that recently hit a limit or was banned.
+type ThrottledClient struct {
+	Kind        string // "ip" or "license_key"
+	Client      string
+	Rejected    int
+	LastSeen    time.Time
+	BannedUntil time.Time
+}
+
+// Banned reports whether the client is currently banned.
+func (c ThrottledClient) Banned() bool {
+	return time.Now().Before(c.BannedUntil)
+}
+
+// expired reports whether the client has dropped off the throttling list.
+func (c ThrottledClient) expired(now time.Time) bool {
+	return now.Sub(c.LastSeen) > throttleListRetention && !now.Before(c.BannedUntil)
+}
+
+// abuseGuard tracks per-IP and per-key request rates, invalid key attempts
+// and temporary bans for the verification endpoints.
+type abuseGuard struct {
+	ipLimiter      *rateLimiter
+	keyLimiter     *rateLimiter
+	invalidCounter *rateLimiter
+	banDuration    time.Duration
+
+	mu           sync.Mutex
+	bans         map[string]time.Time
+	throttled    map[string]*ThrottledClient
+	maxThrottled int
+	lastSweep    time.Time
+}
+
+func withDefault(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func withDefaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func newAbuseGuard(cfg RateLimitConfig) *abuseGuard {
+	seconds := func(n int) time.Duration { return time.Duration(n) * time.Second }
+	return &abuseGuard{
+		ipLimiter: newRateLimiter(withDefault(cfg.PerIP, defaultRatePerIP),
+			seconds(withDefault(cfg.IPWindowSeconds, defaultRateIPWindow))),
+		keyLimiter: newRateLimiter(withDefault(cfg.PerKey, defaultRatePerKey),
+			seconds(withDefault(cfg.KeyWindowSeconds, defaultRateKeyWindow))),
+		invalidCounter: newRateLimiter(withDefault(cfg.BanAfterInvalid, defaultBanAfterInvalid),
+			seconds(withDefault(cfg.InvalidWindowSeconds, defaultInvalidWindowSeconds))),
+		banDuration:  seconds(withDefault(cfg.BanSeconds, defaultBanSeconds)),
+		bans:         make(map[string]time.Time),
+		throttled:    make(map[string]*ThrottledClient),
+		maxThrottled: maxThrottledClients,
+		lastSweep:    time.Now(),
+	}
+}
+
+// allowIP applies the ban list and the given per-IP limiter to a
+// verification request. It runs before the request body is read, so
+// malformed requests count against the client too. When it returns false the
+// duration says how long the client should wait.
+func (g *abuseGuard) allowIP(ipLimiter *rateLimiter, ip string) (bool, time.Duration) {
+	g.mu.Lock()
+	bannedUntil, banned := g.bans[ip]
+	g.mu.Unlock()
+
+	if banned {
+		if wait := time.Until(bannedUntil); wait > 0 {
+			g.noteThrottled("ip", ip, time.Time{})
+			return false, wait
+		}
+		g.mu.Lock()
+		delete(g.bans, ip)
+		g.mu.Unlock()
+	}
+
+	if ok, wait := ipLimiter.allow(ip); !ok {
+		g.noteThrottled("ip", ip, time.Time{})
+		return false, wait
+	}
+	return true, 0
+}
+
+// allowKey applies the per-key limiter once the request names a license key.
+func (g *abuseGuard) allowKey(productID, licenseKey string) (bool, time.Duration) {
+	if ok, wait := g.keyLimiter.allow(productID + ":" + licenseKey); !ok {
+		g.noteThrottled("license_key", maskLicenseKey(licenseKey), time.Time{})
+		return false, wait
+	}
+	return true, 0
+}
+
+// recordInvalidKey counts an unknown key submitted by ip and bans the IP
+// once it crosses the configured threshold.
+func (g *abuseGuard) recordInvalidKey(ip string) {
+	if ok, _ := g.invalidCounter.allow(ip); ok {
+		return
+	}
+
+	until := time.Now().Add(g.banDuration)
+	g.mu.Lock()
+	g.bans[ip] = until
+	g.mu.Unlock()
+
+	log.Printf("Banning %s until %s after repeated invalid license keys", ip, until.Format(time.RFC3339))
+	g.noteThrottled("ip", ip, until)
+}
+
+// unban lifts a ban early.
+func (g *abuseGuard) unban(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, ok := g.bans[ip]
+	delete(g.bans, ip)
+	if entry, listed := g.throttled["ip:"+ip]; listed {
+		entry.BannedUntil = time.Time{}
+	}
+	return ok
+}
+
+func (g *abuseGuard) noteThrottled(kind, client string, bannedUntil time.Time) {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Drop expired clients and bans once a minute, like the rate limiters,
+	// rather than only when someone opens the throttling page
+	if now.Sub(g.lastSweep) >= time.Minute {
+		for id, entry := range g.throttled {
+			if entry.expired(now) {
+				delete(g.throttled, id)
+			}
+		}
+		for ip, until := range g.bans {
+			if !now.Before(until) {
+				delete(g.bans, ip)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	id := kind + ":" + client
+	entry, ok := g.throttled[id]
+	if !ok {
+		if len(g.throttled) >= g.maxThrottled {
+			g.evictThrottled(now)
+		}
+		entry = &ThrottledClient{Kind: kind, Client: client}
+		g.throttled[id] = entry
+	}
+	if bannedUntil.IsZero() {
+		entry.Rejected++
+	} else {
+		entry.BannedUntil = bannedUntil
+	}
+	entry.LastSeen = now
+}
+
+// evictThrottled removes the least recently seen client from the throttling
+// list, preferring clients that are not banned. The caller holds g.mu.
+func (g *abuseGuard) evictThrottled(now time.Time) {
+	oldest := ""
+	for id, entry := range g.throttled {
+		if oldest == "" {
+			oldest = id
+			continue
+		}
+		current := g.throttled[oldest]
+		if banned := now.Before(entry.BannedUntil); banned != now.Before(current.BannedUntil) {
+			if !banned {
+				oldest = id
+			}
+			continue
+		}
+		if entry.LastSeen.Before(current.LastSeen) {
+			oldest = id
+		}
+	}
+	delete(g.throttled, oldest)
+}
+
+// throttledClients lists recently throttled clients, banned ones first and
+// then by most recent activity.
+func (g *abuseGuard) throttledClients() []ThrottledClient {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	clients := make([]ThrottledClient, 0, len(g.throttled))
+	for id, entry := range g.throttled {
+		if entry.expired(now) {
+			delete(g.throttled, id)
+			continue
+		}
+		clients = append(clients, *entry)
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Banned() != clients[j].Banned() {
+			return clients[i].Banned()
+		}
+		return clients[i].LastSeen.After(clients[j].LastSeen)
+	})
+	return clients
+}
+
+// maskLicenseKey keeps just enough of a key to recognise it on admin pages.
+func maskLicenseKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("•", len(key))
+	}
+	return key[:4] + strings.Repeat("•", 4) + key[len(key)-4:]
+}
+
+func (app *App) throttlingHandler(w http.ResponseWriter, r *http.Request) {
+	data := PageData{
+		Title:            app.localizer(r).t("throttling.title"),
+		CurrentPage:      "throttling",
+		ThrottledClients: app.guard.throttledClients(),
+	}
+
+	app.renderPage(w, r, data)
+}
+
+func (app *App) unbanHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		IP string `json:"ip"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.IP == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Missing ip",
+		})
+		return
+	}
+
+	if !app.guard.unban(requestData.IP) {
+		app.audit(r, AuditEvent{Action: auditThrottlingUnban, TargetType: "ip", Target: requestData.IP}, errors.New("IP is not banned"))
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "IP is not banned",
+		})
+		return
+	}
+
+	log.Printf("Ban lifted for %s", requestData.IP)
+	app.audit(r, AuditEvent{Action: auditThrottlingUnban, TargetType: "ip", Target: requestData.IP}, nil)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/verify", nil)
+	req.RemoteAddr = "192.0.2.10:5123"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	app := &App{}
+	if ip := app.clientIP(req); ip != "192.0.2.10" {
+		t.Errorf("untrusted proxy: clientIP = %q, want the remote address", ip)
+	}
+	app.config.TrustProxyHeaders = true
+	if ip := app.clientIP(req); ip != "203.0.113.7" {
+		t.Errorf("trusted proxy: clientIP = %q, want the first forwarded address", ip)
+	}
+	req.Header.Del("X-Forwarded-For")
+	if ip := app.clientIP(req); ip != "192.0.2.10" {
+		t.Errorf("trusted proxy without header: clientIP = %q", ip)
+	}
+}
+
+func TestAbuseGuardBans(t *testing.T) {
+	guard := newAbuseGuard(RateLimitConfig{BanAfterInvalid: 2, PerKey: 2})
+	ipLimiter := newRateLimiter(100, time.Minute)
+	const ip = "192.0.2.10"
+
+	for i := 0; i < 2; i++ {
+		guard.recordInvalidKey(ip)
+	}
+	if ok, _ := guard.allowIP(ipLimiter, ip); !ok {
+		t.Fatal("banned before crossing the invalid key threshold")
+	}
+	guard.recordInvalidKey(ip)
+	ok, wait := guard.allowIP(ipLimiter, ip)
+	if ok || wait <= 0 || wait > time.Duration(defaultBanSeconds)*time.Second {
+		t.Fatalf("after the threshold: allowed %v, wait %v", ok, wait)
+	}
+	if ok, _ := guard.allowIP(ipLimiter, "192.0.2.11"); !ok {
+		t.Error("a ban affected another IP")
+	}
+	clients := guard.throttledClients()
+	if len(clients) != 1 || !clients[0].Banned() || clients[0].Client != ip || clients[0].Rejected != 1 {
+		t.Errorf("throttled clients %+v", clients)
+	}
+
+	if !guard.unban(ip) {
+		t.Fatal("unban reported the IP as not banned")
+	}
+	if guard.unban(ip) {
+		t.Error("second unban reported a ban")
+	}
+	if ok, _ := guard.allowIP(ipLimiter, ip); !ok {
+		t.Error("still rejected after unban")
+	}
+	if clients := guard.throttledClients(); clients[0].Banned() {
+		t.Errorf("unbanned client still listed as banned %+v", clients[0])
+	}
+
+	// Expired bans are dropped on the next request
+	guard.mu.Lock()
+	guard.bans[ip] = time.Now().Add(-time.Second)
+	guard.mu.Unlock()
+	if ok, _ := guard.allowIP(ipLimiter, ip); !ok {
+		t.Error("expired ban still applied")
+	}
+	if guard.unban(ip) {
+		t.Error("expired ban was not removed")
+	}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := guard.allowKey("prod", "KEY"); !ok {
+			t.Fatalf("key rejected on request %d", i+1)
+		}
+	}
+	if ok, _ := guard.allowKey("prod", "KEY"); ok {
+		t.Error("per-key limit not applied")
+	}
+	if ok, _ := guard.allowKey("other", "KEY"); !ok {
+		t.Error("per-key limit shared between products")
+	}
+}
+
+func TestPublicVerifyLimitsBeforeReadingBody(t *testing.T) {
+	app := newTestApp(t)
+	app.publicLimiter = newRateLimiter(2, time.Minute)
+
+	post := func(body string) int {
+		req := httptest.NewRequest("POST", "/api/verify", strings.NewReader(body))
+		req.RemoteAddr = "192.0.2.10:5123"
+		return serve(app.publicVerifyHandler, req, nil).Code
+	}
+
+	oversized := `{"product":"app","license_key":"` + strings.Repeat("A", maxVerifyRequestSize) + `"}`
+	for _, body := range []string{oversized, "not json"} {
+		if status := post(body); status != http.StatusBadRequest {
+			t.Errorf("bad body: status %d, want 400", status)
+		}
+	}
+	if status := post("not json"); status != http.StatusTooManyRequests {
+		t.Errorf("malformed bodies skipped the IP limit: status %d, want 429", status)
+	}
+}
+
+func TestUnbanHandler(t *testing.T) {
+	app := newTestApp(t)
+	app.guard = newAbuseGuard(RateLimitConfig{BanAfterInvalid: 1})
+	app.guard.recordInvalidKey("192.0.2.10")
+	app.guard.recordInvalidKey("192.0.2.10")
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"ip":"192.0.2.99"}`, http.StatusNotFound},
+		{`{"ip":"192.0.2.10"}`, http.StatusOK},
+		{`{"ip":"192.0.2.10"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		recorder := serve(app.unbanHandler, httptest.NewRequest("POST", "/throttling/unban", strings.NewReader(tt.body)), nil)
+		if recorder.Code != tt.status {
+			t.Errorf("unban %s: status %d, want %d", tt.body, recorder.Code, tt.status)
+		}
+	}
+}
+
+// TestThrottledClientsBounded checks that the throttling list stays bounded
+// under a flood of clients, whether or not anyone opens the page.
+func TestThrottledClientsBounded(t *testing.T) {
+	g := newAbuseGuard(RateLimitConfig{})
+	g.maxThrottled = 3
+
+	g.noteThrottled("ip", "10.0.0.1", time.Now().Add(time.Hour))
+	for i := 2; i <= 10; i++ {
+		g.noteThrottled("ip", fmt.Sprintf("10.0.0.%d", i), time.Time{})
+	}
+	if len(g.throttled) != 3 {
+		t.Fatalf("%d clients listed, want at most 3", len(g.throttled))
+	}
+	// The banned client is kept over the older throttled ones
+	for _, id := range []string{"ip:10.0.0.1", "ip:10.0.0.9", "ip:10.0.0.10"} {
+		if _, ok := g.throttled[id]; !ok {
+			t.Errorf("%s was evicted, listed: %v", id, g.throttled)
+		}
+	}
+
+	// Expired clients and bans are swept as new clients are throttled
+	g.mu.Lock()
+	for _, entry := range g.throttled {
+		entry.LastSeen = time.Now().Add(-2 * throttleListRetention)
+		entry.BannedUntil = time.Time{}
+	}
+	g.bans["10.0.0.1"] = time.Now().Add(-time.Minute)
+	g.lastSweep = time.Now().Add(-2 * time.Minute)
+	g.mu.Unlock()
+	g.noteThrottled("ip", "10.0.0.11", time.Time{})
+	if _, ok := g.throttled["ip:10.0.0.11"]; len(g.throttled) != 1 || !ok || len(g.bans) != 0 {
+		t.Errorf("after sweep: listed %v, bans %v", g.throttled, g.bans)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const apiV1Prefix = "/api/v1"
+
+// Error codes returned in the "error" envelope of the v1 API. Clients switch
+// on these, so existing ones must never change meaning.
+const (
+	apiErrBadRequest       = "bad_request"
+	apiErrNotFound         = "not_found"
+	apiErrMethodNotAllowed = "method_not_allowed"
+	apiErrNotConfigured    = "not_configured"
+	apiErrUnauthorized     = "unauthorized"
+	apiErrForbidden        = "forbidden"
+	apiErrUpstream         = "upstream_error"
+	apiErrUpstreamDown     = "upstream_unavailable"
+	apiErrInternal         = "internal_error"
+)
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type APIErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// apiEnvelope wraps every successful v1 response. Pagination is only set on
+// list endpoints.
+type apiEnvelope struct {
+	Data       interface{} `json:"data"`
+	Pagination *tablePage  `json:"pagination,omitempty"`
+}
+
+type LicenseActionRequest struct {
+	ProductID  string `json:"product_id"`
+	LicenseKey string `json:"license_key"`
+}
+
+// Customer aggregates the sales made to one email address.
+type Customer struct {
+	Email     string   `json:"email"`
+	Purchases int      `json:"purchases"`
+	Refunds   int      `json:"refunds"`
+	Products  []string `json:"products"`
+	// Spent is the total paid in minor units, per currency
+	Spent         map[string]int `json:"spent"`
+	FirstPurchase Timestamp      `json:"first_purchase"`
+	LastPurchase  Timestamp      `json:"last_purchase"`
+}
+
+// apiParam is a query parameter of a v1 endpoint.
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// apiRoute is one v1 endpoint. The route table drives both the router and
+// the OpenAPI document, so the two cannot drift apart.
+type apiRoute struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Tag         string
+	Query       []apiParam
+	// Request and Response are zero values of the body types, used to
+	// generate schemas. Response is the type of the "data" field.
+	Request   interface{}
+	Response  interface{}
+	Paginated bool
+	// Scope is the API key scope the route needs; public routes have none
+	// and are served even before a Gumroad token is configured
+	Scope   string
+	Handler func(app *App, w http.ResponseWriter, r *http.Request)
+}
+
+var tableQueryParams = []apiParam{
+	{"q", "string", "Search by email, license key or order ID"},
+	{"status", "string", "active, refunded, disputed or chargebacked"},
+	{"from", "string", "Earliest sale date (YYYY-MM-DD)"},
+	{"to", "string", "Latest sale date (YYYY-MM-DD), inclusive"},
+	{"sort", "string", "Sort column, prefixed with - for descending"},
+	{"page", "integer", "Page number, starting at 1"},
+	{"limit", "integer", "Page size, at most 500"},
+}
+
+// apiV1Routes is a function rather than a variable because the OpenAPI
+// handler it contains reads the table itself.
+func apiV1Routes() []apiRoute {
+	return []apiRoute{
+		{
+			Method: "GET", Path: "/products", OperationID: "listProducts", Tag: "Products",
+			Summary:  "List products",
+			Response: []Product{},
+			Scope:    scopeRead,
+			Handler:  (*App).v1ListProducts,
+		},
+		{
+			Method: "GET", Path: "/products/{id}", OperationID: "getProduct", Tag: "Products",
+			Summary:  "Get a product",
+			Response: Product{},
+			Scope:    scopeRead,
+			Handler:  (*App).v1GetProduct,
+		},
+		{
+			Method: "GET", Path: "/products/{id}/licenses", OperationID: "listProductLicenses", Tag: "Licenses",
+			Summary:  "List a product's licenses",
+			Query:    tableQueryParams,
+			Response: []License{}, Paginated: true,
+			Scope:   scopeRead,
+			Handler: (*App).v1ListLicenses,
+		},
+		{
+			Method: "GET", Path: "/products/{id}/sales", OperationID: "listProductSales", Tag: "Sales",
+			Summary:  "List a product's sales",
+			Query:    tableQueryParams,
+			Response: []Sale{}, Paginated: true,
+			Scope:   scopeRead,
+			Handler: (*App).v1ListSales,
+		},
+		{
+			Method: "GET", Path: "/customers", OperationID: "listCustomers", Tag: "Customers",
+			Summary: "List customers aggregated from sales",
+			Query: []apiParam{
+				{"product_id", "string", "Only count sales of this product"},
+				{"q", "string", "Search by email"},
+				{"sort", "string", "date (last purchase), email, purchases; prefix with - for descending"},
+				{"page", "integer", "Page number, starting at 1"},
+				{"limit", "integer", "Page size, at most 500"},
+			},
+			Response: []Customer{}, Paginated: true,
+			Scope:   scopeRead,
+			Handler: (*App).v1ListCustomers,
+		},
+		{
+			Method: "POST", Path: "/licenses/verify", OperationID: "verifyLicense", Tag: "Licenses",
+			Summary: "Verify a license key without incrementing its uses",
+			Request: LicenseActionRequest{}, Response: LicenseValidationResponse{},
+			Scope:   scopeRead,
+			Handler: (*App).v1VerifyLicense,
+		},
+		{
+			Method: "PUT", Path: "/licenses/enable", OperationID: "enableLicense", Tag: "Licenses",
+			Summary: "Enable a license key",
+			Request: LicenseActionRequest{}, Response: LicenseValidationResponse{},
+			Scope:   scopeLicenseActions,
+			Handler: licenseActionHandler("enable"),
+		},
+		{
+			Method: "PUT", Path: "/licenses/disable", OperationID: "disableLicense", Tag: "Licenses",
+			Summary: "Disable a license key",
+			Request: LicenseActionRequest{}, Response: LicenseValidationResponse{},
+			Scope:   scopeLicenseActions,
+			Handler: licenseActionHandler("disable"),
+		},
+		{
+			Method: "PUT", Path: "/licenses/decrement_uses_count", OperationID: "decrementLicenseUses", Tag: "Licenses",
+			Summary: "Decrement the uses count of a license key",
+			Request: LicenseActionRequest{}, Response: LicenseValidationResponse{},
+			Scope:   scopeLicenseActions,
+			Handler: licenseActionHandler("decrement_uses_count"),
+		},
+		{
+			Method: "PUT", Path: "/licenses/rotate", OperationID: "rotateLicense", Tag: "Licenses",
+			Summary: "Replace a license key with a new one",
+			Request: LicenseActionRequest{}, Response: LicenseValidationResponse{},
+			Scope:   scopeLicenseActions,
+			Handler: licenseActionHandler("rotate"),
+		},
+		{
+			Method: "GET", Path: "/api-keys", OperationID: "listAPIKeys", Tag: "API Keys",
+			Summary:  "List API keys",
+			Response: []APIKey{},
+			Scope:    scopeAdmin,
+			Handler:  (*App).v1ListAPIKeys,
+		},
+		{
+			Method: "POST", Path: "/api-keys", OperationID: "createAPIKey", Tag: "API Keys",
+			Summary: "Create an API key; the token is only returned once",
+			Request: createAPIKeyRequest{}, Response: CreatedAPIKey{},
+			Scope:   scopeAdmin,
+			Handler: (*App).v1CreateAPIKey,
+		},
+		{
+			Method: "DELETE", Path: "/api-keys/{id}", OperationID: "revokeAPIKey", Tag: "API Keys",
+			Summary:  "Revoke an API key",
+			Response: APIKey{},
+			Scope:    scopeAdmin,
+			Handler:  (*App).v1RevokeAPIKey,
+		},
+		{
+			Method: "GET", Path: "/openapi.json", OperationID: "getOpenAPI", Tag: "Meta",
+			Summary: "This OpenAPI document",
+			Handler: (*App).openAPIHandler,
+		},
+	}
+}
+
+// registerAPIV1 adds the v1 routes to the router, on a subrouter that
+// authenticates API keys.
+func (app *App) registerAPIV1(r *mux.Router) {
+	v1 := r.PathPrefix(apiV1Prefix).Subrouter()
+	scopes := make(map[string]string)
+
+	for _, route := range apiV1Routes() {
+		handler := route.Handler
+		handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+			handler(app, w, r)
+		}
+		if route.Scope != "" {
+			handlerFunc = app.apiV1Middleware(handlerFunc)
+		}
+		scopes[route.OperationID] = route.Scope
+		v1.HandleFunc(route.Path, handlerFunc).Methods(route.Method).Name(route.OperationID)
+	}
+
+	v1.Use(app.apiKeyAuth(scopes))
+	v1.NotFoundHandler = apiNotFoundHandler(v1)
+}
+
+// apiV1Middleware answers with an error envelope instead of redirecting to
+// the setup page when no Gumroad token is configured.
+func (app *App) apiV1Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, err := loadConfig(app.configPath)
+		if err != nil || !isTokenConfigured(config) {
+			writeAPIError(w, http.StatusServiceUnavailable, apiErrNotConfigured, "No Gumroad token is configured, visit /setup")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIErrorEnvelope{Error: APIError{Code: code, Message: message}})
+}
+
+func writeAPIData(w http.ResponseWriter, data interface{}, page *tablePage) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiEnvelope{Data: data, Pagination: page})
+}
+
+// writeUpstreamError maps a failed Gumroad request to an error envelope.
+func writeUpstreamError(w http.ResponseWriter, what string, err error) {
+	var statusErr *gumroadStatusError
+	switch {
+	case errors.As(err, &statusErr) && statusErr.Status == http.StatusNotFound:
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, what+" not found")
+	case errors.As(err, &statusErr):
+		writeAPIError(w, http.StatusBadGateway, apiErrUpstream, fmt.Sprintf("Gumroad answered with status %d", statusErr.Status))
+	default:
+		log.Printf("API v1: failed to fetch %s: %v", strings.ToLower(what), err)
+		writeAPIError(w, http.StatusBadGateway, apiErrUpstreamDown, "Gumroad could not be reached")
+	}
+}
+
+// apiNotFoundHandler answers unmatched v1 requests with the error envelope.
+// mux reports a method mismatch on a subrouter as not found, so the other
+// methods are tried here to tell the two apart.
+func apiNotFoundHandler(v1 *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+			if method == r.Method {
+				continue
+			}
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if v1.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, http.StatusMethodNotAllowed, apiErrMethodNotAllowed, r.Method+" is not supported on this endpoint")
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, "No such endpoint")
+	})
+}
+
+func (app *App) v1ListProducts(w http.ResponseWriter, r *http.Request) {
+	products, err := app.getProducts()
+	if err != nil {
+		writeUpstreamError(w, "Products", err)
+		return
+	}
+	if products == nil {
+		products = []Product{}
+	}
+	writeAPIData(w, products, nil)
+}
+
+func (app *App) v1GetProduct(w http.ResponseWriter, r *http.Request) {
+	product, err := app.getProduct(mux.Vars(r)["id"])
+	if err != nil {
+		writeUpstreamError(w, "Product", err)
+		return
+	}
+	writeAPIData(w, product, nil)
+}
+
+func (app *App) v1ListLicenses(w http.ResponseWriter, r *http.Request) {
+	licenses, err := app.getLicenses(mux.Vars(r)["id"])
+	if err != nil {
+		writeUpstreamError(w, "Product", err)
+		return
+	}
+	rows, page := queryLicenses(licenses, parseTableQuery(r, licenseSortColumns, app.timezone(r)))
+	writeAPIData(w, rows, &page)
+}
+
+func (app *App) v1ListSales(w http.ResponseWriter, r *http.Request) {
+	sales, err := app.getSales(mux.Vars(r)["id"])
+	if err != nil {
+		writeUpstreamError(w, "Product", err)
+		return
+	}
+	rows, page := querySales(sales, parseTableQuery(r, saleSortColumns, app.timezone(r)))
+	writeAPIData(w, rows, &page)
+}
+
+var customerSortColumns = []string{"date", "email", "purchases"}
+
+var customerSorts = map[string]func(a, b Customer) bool{
+	"date": func(a, b Customer) bool {
+		return a.LastPurchase.Before(b.LastPurchase.Time)
+	},
+	"email":     func(a, b Customer) bool { return a.Email < b.Email },
+	"purchases": func(a, b Customer) bool { return a.Purchases < b.Purchases },
+}
+
+// customersFromSales groups sales by lower-cased purchaser email.
+func customersFromSales(sales []Sale) []Customer {
+	byEmail := make(map[string]*Customer)
+	var order []string
+
+	for _, sale := range sales {
+		email := strings.ToLower(strings.TrimSpace(sale.Email))
+		if email == "" {
+			continue
+		}
+		customer, ok := byEmail[email]
+		if !ok {
+			customer = &Customer{Email: email, Products: []string{}, Spent: make(map[string]int)}
+			byEmail[email] = customer
+			order = append(order, email)
+		}
+
+		customer.Purchases++
+		if sale.Refunded {
+			customer.Refunds++
+		} else {
+			customer.Spent[strings.ToLower(sale.Currency)] += sale.Price
+		}
+
+		known := false
+		for _, name := range customer.Products {
+			if name == sale.ProductName {
+				known = true
+			}
+		}
+		if !known && sale.ProductName != "" {
+			customer.Products = append(customer.Products, sale.ProductName)
+		}
+
+		if customer.FirstPurchase.IsZero() || sale.CreatedAt.Before(customer.FirstPurchase.Time) {
+			customer.FirstPurchase = sale.CreatedAt
+		}
+		if customer.LastPurchase.IsZero() || sale.CreatedAt.After(customer.LastPurchase.Time) {
+			customer.LastPurchase = sale.CreatedAt
+		}
+	}
+
+	customers := make([]Customer, 0, len(order))
+	for _, email := range order {
+		customers = append(customers, *byEmail[email])
+	}
+	return customers
+}
+
+func (app *App) v1ListCustomers(w http.ResponseWriter, r *http.Request) {
+	sales, err := app.getSales(r.URL.Query().Get("product_id"))
+	if err != nil {
+		writeUpstreamError(w, "Product", err)
+		return
+	}
+
+	q := parseTableQuery(r, customerSortColumns, app.timezone(r))
+	customers := customersFromSales(sales)
+	matching := make([]Customer, 0, len(customers))
+	for _, customer := range customers {
+		if strings.Contains(customer.Email, strings.ToLower(q.Search)) {
+			matching = append(matching, customer)
+		}
+	}
+
+	less := customerSorts[q.Sort]
+	sort.SliceStable(matching, func(i, j int) bool {
+		if q.Descending {
+			return less(matching[j], matching[i])
+		}
+		return less(matching[i], matching[j])
+	})
+
+	start, end, page := q.paginate(len(matching), len(customers))
+	writeAPIData(w, matching[start:end], &page)
+}
+
+func decodeLicenseActionRequest(w http.ResponseWriter, r *http.Request) (LicenseActionRequest, bool) {
+	var req LicenseActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "Request body must be JSON")
+		return req, false
+	}
+	if req.ProductID == "" || req.LicenseKey == "" {
+		writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "product_id and license_key are required")
+		return req, false
+	}
+	return req, true
+}
+
+func (app *App) v1VerifyLicense(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeLicenseActionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := app.verifyLicense(req.ProductID, req.LicenseKey, verifyOptions{Live: true})
+	event := AuditEvent{
+		Action:     auditLicenseValidate,
+		TargetType: "license",
+		Target:     licenseKeyHash(req.LicenseKey),
+		ProductID:  req.ProductID,
+		Detail:     fmt.Sprintf("valid=%v", response.Success),
+	}
+	app.audit(r, event, err)
+	if err != nil {
+		if errors.Is(err, errGumroadUnavailable) {
+			writeAPIError(w, http.StatusBadGateway, apiErrUpstreamDown, "Gumroad could not be reached")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, err.Error())
+		return
+	}
+	writeAPIData(w, response, nil)
+}
+
+// licenseActionHandler returns a handler for one of Gumroad's license write
+// endpoints (PUT /v2/licenses/{action}).
+func licenseActionHandler(action string) func(app *App, w http.ResponseWriter, r *http.Request) {
+	return func(app *App, w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeLicenseActionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		response, err := app.licenseAction(requestActor(r), action, req.ProductID, req.LicenseKey)
+		app.audit(r, AuditEvent{
+			Action:     "license." + action,
+			TargetType: "license",
+			Target:     licenseKeyHash(req.LicenseKey),
+			ProductID:  req.ProductID,
+		}, err)
+		if err != nil {
+			writeUpstreamError(w, "License", err)
+			return
+		}
+		writeAPIData(w, response, nil)
+	}
+}
+
+// licenseAction performs a license write on Gumroad on behalf of actor and
+// drops any cached verification of the key, since its state has just changed.
+func (app *App) licenseAction(actor, action, productID, licenseKey string) (LicenseValidationResponse, error) {
+	form := url.Values{}
+	form.Set("product_id", productID)
+	form.Set("license_key", licenseKey)
+
+	apiCall, body, err := app.sendGumroadRequestAs(actor, "PUT", app.gumroadURL("/licenses/"+action), form.Encode())
+	app.verifyCache.invalidate(productID, licenseKey)
+	if err != nil {
+		return LicenseValidationResponse{}, err
+	}
+	if apiCall.Status != http.StatusOK {
+		return LicenseValidationResponse{}, &gumroadStatusError{Status: apiCall.Status, Body: string(body)}
+	}
+
+	var response LicenseValidationResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return LicenseValidationResponse{}, err
+	}
+
+	log.Printf("License action %s on product %s by %s: success=%v", action, productID, actor, response.Success)
+	return response, nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const apiKeysFile = "api_keys.json"
+
+// Every key starts with this prefix so leaked keys are easy to grep for.
+const apiKeyTokenPrefix = "glm_"
+
+// Scopes, from least to most privileged. A key may call any endpoint whose
+// scope is at or below its own.
+const (
+	scopeRead           = "read"
+	scopeLicenseActions = "license-actions"
+	scopeAdmin          = "admin"
+)
+
+var scopeRanks = map[string]int{
+	scopeRead:           1,
+	scopeLicenseActions: 2,
+	scopeAdmin:          3,
+}
+
+func scopeAllows(have, need string) bool {
+	return scopeRanks[have] > 0 && scopeRanks[have] >= scopeRanks[need]
+}
+
+// Last-used times are written to disk at most this often per key.
+const apiKeyUsageFlushInterval = time.Minute
+
+// APIKey is a stored API key. Only the SHA-256 hash of the secret is kept;
+// Prefix is the start of the token, shown so keys can be told apart.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"hash,omitempty"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+}
+
+// public returns the key without its hash, for API and UI responses.
+func (k APIKey) public() APIKey {
+	k.Hash = ""
+	return k
+}
+
+// Expired reports whether the key is past its expiry time.
+func (k APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// Active reports whether the key can still be used.
+func (k APIKey) Active() bool {
+	return k.RevokedAt == nil && !k.Expired()
+}
+
+// Status is the key's state as shown in the UI.
+func (k APIKey) Status() string {
+	switch {
+	case k.RevokedAt != nil:
+		return "revoked"
+	case k.Expired():
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// Actor identifies the key in logs and in the API call log.
+func (k APIKey) Actor() string {
+	return fmt.Sprintf("api-key:%s (%s)", k.Name, k.ID)
+}
+
+// apiKeyStore keeps API keys in a JSON file in the data directory.
+type apiKeyStore struct {
+	mu   sync.Mutex
+	path string
+	keys []APIKey
+}
+
+func newAPIKeyStore(dataDir string) (*apiKeyStore, error) {
+	store := &apiKeyStore{path: filepath.Join(dataDir, apiKeysFile)}
+
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", store.path, err)
+	}
+	return store, nil
+}
+
+// save writes the keys atomically. The caller holds s.mu.
+func (s *apiKeyStore) save() error {
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func hashAPIKeyToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// create generates a new key and returns it with its token. The token is
+// not stored and cannot be shown again.
+func (s *apiKeyStore) create(name, scope string, expiresAt *time.Time) (APIKey, string, error) {
+	if scopeRanks[scope] == 0 {
+		return APIKey{}, "", fmt.Errorf("unknown scope %q", scope)
+	}
+
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	token := apiKeyTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Prefix:    token[:len(apiKeyTokenPrefix)+6],
+		Hash:      hashAPIKeyToken(token),
+		Scope:     scope,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, key)
+	if err := s.save(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return APIKey{}, "", err
+	}
+	return key, token, nil
+}
+
+// revoke marks a key as revoked. Revoked keys are kept so the UI and logs
+// can still name them.
+func (s *apiKeyStore) revoke(id string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		if s.keys[i].ID != id {
+			continue
+		}
+		if s.keys[i].RevokedAt == nil {
+			now := time.Now()
+			s.keys[i].RevokedAt = &now
+			if err := s.save(); err != nil {
+				s.keys[i].RevokedAt = nil
+				return APIKey{}, err
+			}
+		}
+		return s.keys[i], nil
+	}
+	return APIKey{}, errAPIKeyNotFound
+}
+
+var errAPIKeyNotFound = errors.New("API key not found")
+
+// list returns the keys, newest first.
+func (s *apiKeyStore) list() []APIKey {
+	s.mu.Lock()
+	keys := make([]APIKey, len(s.keys))
+	copy(keys, s.keys)
+	s.mu.Unlock()
+
+	for i := range keys {
+		keys[i] = keys[i].public()
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys
+}
+
+// authenticate finds the active key for a token and records its use.
+func (s *apiKeyStore) authenticate(token, ip string) (APIKey, bool) {
+	hash := hashAPIKeyToken(token)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		key := &s.keys[i]
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if !key.Active() {
+			return APIKey{}, false
+		}
+
+		flush := key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUsageFlushInterval
+		key.LastUsedAt = &now
+		key.LastUsedIP = ip
+		if flush {
+			if err := s.save(); err != nil {
+				log.Printf("Failed to save API key usage: %v", err)
+			}
+		}
+		return *key, true
+	}
+	return APIKey{}, false
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyFromContext returns the key that authenticated the request, if any.
+func apiKeyFromContext(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(APIKey)
+	return key, ok
+}
+
+// requestActor names whoever made the request, for logs.
+func requestActor(r *http.Request) string {
+	if key, ok := apiKeyFromContext(r.Context()); ok {
+		return key.Actor()
+	}
+	if user, ok := currentUser(r); ok {
+		return user.Actor()
+	}
+	return ""
+}
+
+// apiKeyAuth is the mux middleware guarding /api/v1. Requests must carry
+// "Authorization: Bearer <key>" with a scope covering the route's scope.
+// Routes without a scope are public.
+func (app *App) apiKeyAuth(scopes map[string]string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			need := scopes[route.GetName()]
+			if need == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !strings.HasPrefix(token, apiKeyTokenPrefix) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				writeAPIError(w, http.StatusUnauthorized, apiErrUnauthorized, "An API key is required: Authorization: Bearer <key>")
+				return
+			}
+
+			key, ok := app.apiKeys.authenticate(strings.TrimSpace(token), app.clientIP(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+				writeAPIError(w, http.StatusUnauthorized, apiErrUnauthorized, "API key is invalid, expired or revoked")
+				return
+			}
+			if !scopeAllows(key.Scope, need) {
+				writeAPIError(w, http.StatusForbidden, apiErrForbidden, fmt.Sprintf("This endpoint needs the %s scope", need))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+		})
+	}
+}
+
+type createAPIKeyRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// ExpiresInDays of 0 creates a key that never expires
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+type CreatedAPIKey struct {
+	Key   APIKey `json:"key"`
+	Token string `json:"token"`
+}
+
+func (app *App) createAPIKey(req createAPIKeyRequest) (CreatedAPIKey, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return CreatedAPIKey{}, errors.New("name is required")
+	}
+	if req.ExpiresInDays < 0 {
+		return CreatedAPIKey{}, errors.New("expires_in_days cannot be negative")
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	key, token, err := app.apiKeys.create(req.Name, req.Scope, expiresAt)
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	return CreatedAPIKey{Key: key.public(), Token: token}, nil
+}
+
+func writeAPIKeysError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
+
+// apiKeysHandler lists API keys and offers forms to create and revoke them.
+func (app *App) apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	data := PageData{
+		Title:       app.localizer(r).t("api_keys.title"),
+		CurrentPage: "api-keys",
+		APIKeys:     app.apiKeys.list(),
+	}
+
+	app.renderPage(w, r, data)
+}
+
+func (app *App) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIKeysError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	created, err := app.createAPIKey(req)
+	app.audit(r, AuditEvent{
+		Action:     auditAPIKeyCreate,
+		TargetType: "api_key",
+		Target:     created.Key.ID,
+		Detail:     fmt.Sprintf("%s, scope %s", req.Name, req.Scope),
+	}, err)
+	if err != nil {
+		writeAPIKeysError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Printf("API key %s (%s) created with scope %s", created.Key.Name, created.Key.ID, created.Key.Scope)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"key":     created.Key,
+		"token":   created.Token,
+	})
+}
+
+func (app *App) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := app.apiKeys.revoke(mux.Vars(r)["id"])
+	app.audit(r, AuditEvent{Action: auditAPIKeyRevoke, TargetType: "api_key", Target: mux.Vars(r)["id"], Detail: key.Name}, err)
+	if errors.Is(err, errAPIKeyNotFound) {
+		writeAPIKeysError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeAPIKeysError(w, http.StatusInternalServerError, "Failed to revoke key: "+err.Error())
+		return
+	}
+
+	log.Printf("API key %s (%s) revoked", key.Name, key.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"key":     key.public(),
+	})
+}
+
+func (app *App) v1ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	writeAPIData(w, app.apiKeys.list(), nil)
+}
+
+func (app *App) v1CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "Request body must be JSON")
+		return
+	}
+
+	created, err := app.createAPIKey(req)
+	app.audit(r, AuditEvent{
+		Action:     auditAPIKeyCreate,
+		TargetType: "api_key",
+		Target:     created.Key.ID,
+		Detail:     fmt.Sprintf("%s, scope %s", req.Name, req.Scope),
+	}, err)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, err.Error())
+		return
+	}
+
+	log.Printf("API key %s (%s) created with scope %s by %s", created.Key.Name, created.Key.ID, created.Key.Scope, requestActor(r))
+	writeAPIData(w, created, nil)
+}
+
+func (app *App) v1RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, err := app.apiKeys.revoke(mux.Vars(r)["id"])
+	app.audit(r, AuditEvent{Action: auditAPIKeyRevoke, TargetType: "api_key", Target: mux.Vars(r)["id"], Detail: key.Name}, err)
+	if errors.Is(err, errAPIKeyNotFound) {
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, err.Error())
+		return
+	}
+
+	log.Printf("API key %s (%s) revoked by %s", key.Name, key.ID, requestActor(r))
+	writeAPIData(w, key.public(), nil)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestScopeAllows(t *testing.T) {
+	scopes := []string{scopeRead, scopeLicenseActions, scopeAdmin}
+	for i, have := range scopes {
+		for j, need := range scopes {
+			if got := scopeAllows(have, need); got != (i >= j) {
+				t.Errorf("scopeAllows(%s, %s) = %v", have, need, got)
+			}
+		}
+	}
+	if scopeAllows("", scopeRead) || scopeAllows("superuser", scopeRead) {
+		t.Error("an unknown scope was allowed")
+	}
+}
+
+// apiKeyTestRouter serves one route per scope behind apiKeyAuth. Each route
+// answers with the actor that authenticated the request.
+func apiKeyTestRouter(app *App) *mux.Router {
+	router := mux.NewRouter()
+	actor := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(requestActor(r))) }
+	for _, name := range []string{scopeRead, scopeLicenseActions, scopeAdmin, "public"} {
+		router.HandleFunc("/"+name, actor).Name(name)
+	}
+	router.Use(app.apiKeyAuth(map[string]string{
+		scopeRead:           scopeRead,
+		scopeLicenseActions: scopeLicenseActions,
+		scopeAdmin:          scopeAdmin,
+	}))
+	return router
+}
+
+func TestAPIKeyAuthScopes(t *testing.T) {
+	store, err := newAPIKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{apiKeys: store}
+	router := apiKeyTestRouter(app)
+
+	tokens := make(map[string]string)
+	for _, scope := range []string{scopeRead, scopeLicenseActions, scopeAdmin} {
+		_, token, err := store.create(scope+" key", scope, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[scope] = token
+	}
+
+	call := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	tests := []struct {
+		key, route string
+		status     int
+	}{
+		{scopeRead, scopeRead, http.StatusOK},
+		{scopeRead, scopeLicenseActions, http.StatusForbidden},
+		{scopeRead, scopeAdmin, http.StatusForbidden},
+		{scopeLicenseActions, scopeRead, http.StatusOK},
+		{scopeLicenseActions, scopeLicenseActions, http.StatusOK},
+		{scopeLicenseActions, scopeAdmin, http.StatusForbidden},
+		{scopeAdmin, scopeRead, http.StatusOK},
+		{scopeAdmin, scopeLicenseActions, http.StatusOK},
+		{scopeAdmin, scopeAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if recorder := call("/"+tt.route, "Bearer "+tokens[tt.key]); recorder.Code != tt.status {
+			t.Errorf("%s key on %s route: status %d, want %d", tt.key, tt.route, recorder.Code, tt.status)
+		}
+	}
+
+	recorder := call("/read", "Bearer "+tokens[scopeAdmin])
+	if !strings.HasPrefix(recorder.Body.String(), "api-key:admin key (") {
+		t.Errorf("actor %q", recorder.Body.String())
+	}
+	if recorder := call("/public", ""); recorder.Code != http.StatusOK {
+		t.Errorf("public route without a key: status %d", recorder.Code)
+	}
+}
+
+func TestAPIKeyAuthRejectsKeys(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newAPIKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{apiKeys: store}
+	router := apiKeyTestRouter(app)
+
+	_, active, _ := store.create("active", scopeAdmin, nil)
+	revokedKey, revoked, _ := store.create("revoked", scopeAdmin, nil)
+	if _, err := store.revoke(revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	_, expired, _ := store.create("expired", scopeAdmin, &past)
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{"no header", ""},
+		{"basic auth", "Basic dXNlcjpwYXNz"},
+		{"bearer without prefix", "Bearer " + active[len(apiKeyTokenPrefix):]},
+		{"other prefix", "Bearer ghp_" + active[len(apiKeyTokenPrefix):]},
+		{"lowercase scheme", "bearer " + active},
+		{"unknown key", "Bearer " + apiKeyTokenPrefix + "not-a-real-key"},
+		{"truncated key", "Bearer " + active[:len(active)-1]},
+		{"revoked key", "Bearer " + revoked},
+		{"expired key", "Bearer " + expired},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/read", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: status %d, WWW-Authenticate %q, want 401", tt.name, recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	// Revocation and usage survive a restart; tokens themselves are not stored
+	reopened, err := newAPIKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.authenticate(revoked, "192.0.2.1"); ok {
+		t.Error("revoked key accepted after reopening the store")
+	}
+	key, ok := reopened.authenticate(active, "192.0.2.1")
+	if !ok || key.LastUsedIP != "192.0.2.1" || key.LastUsedAt == nil {
+		t.Errorf("active key after reopening: %+v, %v", key, ok)
+	}
+	for _, listed := range reopened.list() {
+		if listed.Hash != "" {
+			t.Errorf("list exposes the hash of %s", listed.Name)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Buffered events per stream subscriber. A subscriber that falls further
+// behind is disconnected and catches up through Last-Event-ID on reconnect.
+const apiCallStreamBuffer = 64
+
+const apiCallStreamHeartbeat = 15 * time.Second
+
+// Default number of calls kept in memory; see Config.APILogRetention.
+const defaultAPILogRetention = 1000
+
+const (
+	defaultAPILogPageSize = 50
+	maxAPILogPageSize     = 500
+)
+
+// apiCallFilter selects API calls. Zero values match everything.
+type apiCallFilter struct {
+	From   time.Time
+	To     time.Time
+	Method string
+	// StatusClass is "2xx", "3xx", "4xx", "5xx" or "error"
+	StatusClass string
+	StatusMin   int
+	StatusMax   int
+	// URLContains is a case-insensitive substring, or a glob when it
+	// contains '*'
+	URLContains string
+	ErrorOnly   bool
+	MinDuration time.Duration
+}
+
+// parseFilterTime accepts the formats produced by date and datetime-local
+// inputs, which are wall times in loc, as well as RFC 3339.
+func parseFilterTime(value string, loc *time.Location) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.In(loc)
+		}
+	}
+	return time.Time{}
+}
+
+// filterEnd returns the exclusive end of a "to" filter. A bare date, parsed
+// as midnight, means up to the end of that day, which is not always 24 hours
+// away when the clocks change.
+func filterEnd(to time.Time) time.Time {
+	if to.Hour() == 0 && to.Minute() == 0 && to.Second() == 0 {
+		return to.AddDate(0, 0, 1)
+	}
+	return to
+}
+
+func parseAPICallFilter(query url.Values, loc *time.Location) apiCallFilter {
+	filter := apiCallFilter{
+		From:        parseFilterTime(query.Get("from"), loc),
+		To:          parseFilterTime(query.Get("to"), loc),
+		Method:      strings.ToUpper(strings.TrimSpace(query.Get("method"))),
+		StatusClass: strings.ToLower(strings.TrimSpace(query.Get("status"))),
+		URLContains: strings.TrimSpace(query.Get("url")),
+		ErrorOnly:   query.Get("errors") == "1" || query.Get("errors") == "true",
+	}
+	filter.StatusMin, _ = strconv.Atoi(query.Get("status_min"))
+	filter.StatusMax, _ = strconv.Atoi(query.Get("status_max"))
+	if ms, err := strconv.Atoi(query.Get("min_ms")); err == nil {
+		filter.MinDuration = time.Duration(ms) * time.Millisecond
+	}
+	return filter
+}
+
+// failed reports whether a call counts as an error: transport failures and
+// 4xx/5xx responses.
+func (call APICall) failed() bool {
+	return call.Error != "" || call.Status == 0 || call.Status >= 400
+}
+
+func (f apiCallFilter) matches(call APICall) bool {
+	if !f.From.IsZero() && call.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !call.Timestamp.Before(filterEnd(f.To)) {
+		return false
+	}
+
+	if f.Method != "" && call.Method != f.Method {
+		return false
+	}
+
+	switch f.StatusClass {
+	case "":
+	case "error":
+		if !call.failed() {
+			return false
+		}
+	default:
+		if len(f.StatusClass) != 3 || !strings.HasSuffix(f.StatusClass, "xx") ||
+			strconv.Itoa(call.Status/100) != f.StatusClass[:1] {
+			return false
+		}
+	}
+
+	if f.StatusMin > 0 && call.Status < f.StatusMin {
+		return false
+	}
+	if f.StatusMax > 0 && call.Status > f.StatusMax {
+		return false
+	}
+
+	if f.URLContains != "" {
+		pattern := strings.ToLower(f.URLContains)
+		target := strings.ToLower(call.URL)
+		if strings.Contains(pattern, "*") {
+			if !globMatch(pattern, target) {
+				return false
+			}
+		} else if !strings.Contains(target, pattern) {
+			return false
+		}
+	}
+
+	if f.ErrorOnly && !call.failed() {
+		return false
+	}
+	if f.MinDuration > 0 && call.Duration < f.MinDuration {
+		return false
+	}
+	return true
+}
+
+// globMatch matches s against a pattern where '*' stands for any run of
+// characters. The pattern is unanchored, like a substring search.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	for _, part := range parts {
+		index := strings.Index(s, part)
+		if index < 0 {
+			return false
+		}
+		s = s[index+len(part):]
+	}
+	return true
+}
+
+// apiCallSorts maps sort names to "less" functions. Ties are broken by ID so
+// every call has a unique position, which keyset cursors rely on.
+var apiCallSorts = map[string]func(a, b APICall) bool{
+	"time": func(a, b APICall) bool { return a.ID < b.ID },
+	"duration": func(a, b APICall) bool {
+		if a.Duration != b.Duration {
+			return a.Duration < b.Duration
+		}
+		return a.ID < b.ID
+	},
+	"status": func(a, b APICall) bool {
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+		return a.ID < b.ID
+	},
+	"url": func(a, b APICall) bool {
+		if a.URL != b.URL {
+			return a.URL < b.URL
+		}
+		return a.ID < b.ID
+	},
+}
+
+// apiCallQuery is a filtered, sorted page request over the API call log.
+type apiCallQuery struct {
+	Filter     apiCallFilter
+	Sort       string
+	Descending bool
+	Limit      int
+	Cursor     string
+}
+
+// SortParam returns the sort in its query string form, e.g. "-time".
+func (q apiCallQuery) SortParam() string {
+	if q.Descending {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+func parseAPICallQuery(query url.Values, loc *time.Location, defaultLimit int) apiCallQuery {
+	q := apiCallQuery{
+		Filter:     parseAPICallFilter(query, loc),
+		Sort:       "time",
+		Descending: true,
+		Limit:      defaultLimit,
+		Cursor:     query.Get("cursor"),
+	}
+
+	if sortParam := query.Get("sort"); sortParam != "" {
+		name := strings.TrimPrefix(sortParam, "-")
+		if _, ok := apiCallSorts[name]; ok {
+			q.Sort = name
+			q.Descending = strings.HasPrefix(sortParam, "-")
+		}
+	}
+
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		q.Limit = limit
+	}
+	if q.Limit > maxAPILogPageSize {
+		q.Limit = maxAPILogPageSize
+	}
+	return q
+}
+
+// apiCallCursor is the position of the last call on a page. It carries the
+// sort key as well as the ID so pagination keeps working after that call
+// has been rotated out of the log.
+type apiCallCursor struct {
+	ID       int64         `json:"id"`
+	Duration time.Duration `json:"d,omitempty"`
+	Status   int           `json:"s,omitempty"`
+	URL      string        `json:"u,omitempty"`
+}
+
+func encodeAPICallCursor(call APICall) string {
+	data, _ := json.Marshal(apiCallCursor{ID: call.ID, Duration: call.Duration, Status: call.Status, URL: call.URL})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeAPICallCursor(cursor string) (APICall, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return APICall{}, false
+	}
+	var c apiCallCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return APICall{}, false
+	}
+	return APICall{ID: c.ID, Duration: c.Duration, Status: c.Status, URL: c.URL}, true
+}
+
+// apiCallPage is one page of query results plus statistics over every
+// matching call.
+type apiCallPage struct {
+	Calls      []APICall
+	NextCursor string
+	Stats      []endpointStats
+	Total      int
+}
+
+// queryAPICalls filters, sorts and paginates the API call log.
+func (app *App) queryAPICalls(q apiCallQuery) apiCallPage {
+	app.mu.RLock()
+	matching := make([]APICall, 0, len(app.apiCalls))
+	for _, call := range app.apiCalls {
+		if q.Filter.matches(call) {
+			matching = append(matching, call)
+		}
+	}
+	app.mu.RUnlock()
+
+	less := apiCallSorts[q.Sort]
+	before := less
+	if q.Descending {
+		before = func(a, b APICall) bool { return less(b, a) }
+	}
+	sort.Slice(matching, func(i, j int) bool { return before(matching[i], matching[j]) })
+
+	page := apiCallPage{
+		Stats: computeEndpointStats(matching),
+		Total: len(matching),
+	}
+
+	start := 0
+	if cursor, ok := decodeAPICallCursor(q.Cursor); ok {
+		start = sort.Search(len(matching), func(i int) bool { return before(cursor, matching[i]) })
+	}
+
+	end := start + q.Limit
+	if end >= len(matching) {
+		end = len(matching)
+	} else {
+		page.NextCursor = encodeAPICallCursor(matching[end-1])
+	}
+	page.Calls = matching[start:end]
+	return page
+}
+
+// endpointStats summarises calls to one Gumroad endpoint.
+type endpointStats struct {
+	Endpoint  string        `json:"endpoint"`
+	Count     int           `json:"count"`
+	Errors    int           `json:"errors"`
+	ErrorRate float64       `json:"error_rate"`
+	P50       time.Duration `json:"p50"`
+	P95       time.Duration `json:"p95"`
+}
+
+// Path segments that are followed by an ID in Gumroad API URLs.
+var idCollections = map[string]bool{
+	"products":               true,
+	"subscribers":            true,
+	"sales":                  true,
+	"offer_codes":            true,
+	"variant_categories":     true,
+	"variants":               true,
+	"custom_fields":          true,
+	"resource_subscriptions": true,
+}
+
+// endpointName groups calls by method and path, with IDs replaced by ":id",
+// e.g. "GET /v2/products/:id/subscribers".
+func endpointName(method, rawURL string) string {
+	path := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil {
+		path = parsed.Path
+	}
+
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		if idCollections[segments[i-1]] && segments[i] != "" && !idCollections[segments[i]] {
+			segments[i] = ":id"
+		}
+	}
+	return method + " " + strings.Join(segments, "/")
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func computeEndpointStats(calls []APICall) []endpointStats {
+	durations := make(map[string][]time.Duration)
+	errorCounts := make(map[string]int)
+	for _, call := range calls {
+		name := endpointName(call.Method, call.URL)
+		durations[name] = append(durations[name], call.Duration)
+		if call.failed() {
+			errorCounts[name]++
+		}
+	}
+
+	stats := make([]endpointStats, 0, len(durations))
+	for name, ds := range durations {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		stats = append(stats, endpointStats{
+			Endpoint:  name,
+			Count:     len(ds),
+			Errors:    errorCounts[name],
+			ErrorRate: float64(errorCounts[name]) / float64(len(ds)),
+			P50:       percentile(ds, 50),
+			P95:       percentile(ds, 95),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Endpoint < stats[j].Endpoint
+	})
+	return stats
+}
+
+// apiCallStatsHandler returns per-endpoint statistics for the calls
+// matching the log filters.
+func (app *App) apiCallStatsHandler(w http.ResponseWriter, r *http.Request) {
+	page := app.queryAPICalls(parseAPICallQuery(r.URL.Query(), app.timezone(r), 1))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page.Stats)
+}
+
+// subscribeAPICalls registers a stream subscriber and returns the buffered
+// calls newer than afterID. Both happen under the same lock so no call can
+// fall between the replay and the live feed.
+func (app *App) subscribeAPICalls(afterID int64) (chan APICall, []APICall) {
+	ch := make(chan APICall, apiCallStreamBuffer)
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var backlog []APICall
+	for _, call := range app.apiCalls {
+		if call.ID > afterID {
+			backlog = append(backlog, call)
+		}
+	}
+	app.apiCallSubscribers[ch] = struct{}{}
+	return ch, backlog
+}
+
+func (app *App) unsubscribeAPICalls(ch chan APICall) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	if _, ok := app.apiCallSubscribers[ch]; ok {
+		delete(app.apiCallSubscribers, ch)
+		close(ch)
+	}
+}
+
+// publishAPICall fans a new call out to stream subscribers. The caller must
+// hold app.mu.
+func (app *App) publishAPICall(call APICall) {
+	for ch := range app.apiCallSubscribers {
+		select {
+		case ch <- call:
+		default:
+			// Too slow; drop it and let the client resume from its last ID
+			delete(app.apiCallSubscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// apiCallsStreamHandler pushes API calls to the browser as Server-Sent
+// Events. The resume point comes from the Last-Event-ID header that
+// EventSource sends on reconnect, or the last_event_id query parameter on the
+// first connection.
+func (app *App) apiCallsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filter := parseAPICallFilter(r.URL.Query(), app.timezone(r))
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	afterID, _ := strconv.ParseInt(lastID, 10, 64)
+
+	ch, backlog := app.subscribeAPICalls(afterID)
+	defer app.unsubscribeAPICalls(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(call APICall) error {
+		if !filter.matches(call) {
+			return nil
+		}
+		data, err := json.Marshal(call)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: api-call\ndata: %s\n\n", call.ID, data)
+		return err
+	}
+
+	for _, call := range backlog {
+		if err := send(call); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(apiCallStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case call, open := <-ch:
+			if !open {
+				log.Printf("API call stream subscriber fell behind, disconnecting")
+				return
+			}
+			if err := send(call); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
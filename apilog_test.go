@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEventIDs reads SSE events from the stream until it has n IDs.
+func readEventIDs(t *testing.T, reader *bufio.Reader, n int) []string {
+	t.Helper()
+	var ids []string
+	for len(ids) < n {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream after %v: %v", ids, err)
+		}
+		if id, ok := strings.CutPrefix(strings.TrimSpace(line), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestAPICallStreamReplaysAfterLastEventID(t *testing.T) {
+	app := newTestApp(t)
+	server := httptest.NewServer(http.HandlerFunc(app.apiCallsStreamHandler))
+	defer server.Close()
+
+	for _, url := range []string{"/v2/products", "/v2/sales", "/v2/licenses/verify"} {
+		app.recordAPICall(APICall{Method: "GET", URL: url, Status: http.StatusOK})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	if ids := readEventIDs(t, reader, 2); strings.Join(ids, ",") != "2,3" {
+		t.Errorf("replayed %v, want only the events after 1", ids)
+	}
+
+	// Wait for the subscription before publishing, so the next call is live
+	// rather than part of the backlog
+	for {
+		app.mu.Lock()
+		subscribed := len(app.apiCallSubscribers) == 1
+		app.mu.Unlock()
+		if subscribed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	app.recordAPICall(APICall{Method: "GET", URL: "/v2/products", Status: http.StatusOK})
+	if ids := readEventIDs(t, reader, 1); ids[0] != "4" {
+		t.Errorf("live event %v, want 4", ids)
+	}
+}
+
+func TestAPICallStreamDropsSlowSubscriber(t *testing.T) {
+	app := newTestApp(t)
+	slow, _ := app.subscribeAPICalls(0)
+	fast, _ := app.subscribeAPICalls(0)
+
+	var received []APICall
+	for i := 0; i <= apiCallStreamBuffer; i++ {
+		app.recordAPICall(APICall{Method: "GET", URL: "/v2/products"})
+		received = append(received, <-fast)
+	}
+
+	app.mu.Lock()
+	_, slowSubscribed := app.apiCallSubscribers[slow]
+	_, fastSubscribed := app.apiCallSubscribers[fast]
+	app.mu.Unlock()
+	if slowSubscribed || !fastSubscribed {
+		t.Fatalf("subscribed: slow %v, fast %v", slowSubscribed, fastSubscribed)
+	}
+
+	// The slow subscriber keeps what was buffered, then sees the channel close
+	buffered := 0
+	for range slow {
+		buffered++
+	}
+	if buffered != apiCallStreamBuffer || len(received) != apiCallStreamBuffer+1 {
+		t.Errorf("slow subscriber got %d calls, fast %d", buffered, len(received))
+	}
+
+	// Unsubscribing after being dropped must not close the channel twice
+	app.unsubscribeAPICalls(slow)
+	app.unsubscribeAPICalls(fast)
+}
+
+// TestAPICallLogHidesGumroadToken checks that support users, who cannot see
+// the token on the setup page, cannot read it from the API call log either.
+func TestAPICallLogHidesGumroadToken(t *testing.T) {
+	app := newTestApp(t)
+	users, err := newUserStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.users = users
+	if _, err := users.create("owner", "password1", roleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.create("helpdesk", "password1", roleSupport); err != nil {
+		t.Fatal(err)
+	}
+	_, session, ok := users.login("helpdesk", "password1")
+	if !ok {
+		t.Fatal("support login failed")
+	}
+
+	gumroad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testGumroadToken {
+			t.Errorf("Gumroad request without the token: %q", r.Header.Get("Authorization"))
+		}
+		http.SetCookie(w, &http.Cookie{Name: "_gumroad_session", Value: "upstream-session"})
+		w.Write([]byte(`{"success":true,"products":[]}`))
+	}))
+	defer gumroad.Close()
+	if _, _, err := app.sendGumroadRequest("GET", gumroad.URL+"/v2/products", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+	get := func(path string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+path, nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", path, resp.StatusCode)
+		}
+		if path == "/api/api-calls/stream" {
+			reader := bufio.NewReader(resp.Body)
+			line, err := reader.ReadString('\n')
+			for err == nil && !strings.HasPrefix(line, "data: ") {
+				line, err = reader.ReadString('\n')
+			}
+			return line
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	for _, path := range []string{"/api/api-calls", "/api/api-calls/stream"} {
+		body := get(path)
+		if !strings.Contains(body, "/v2/products") {
+			t.Errorf("%s does not list the call: %s", path, body)
+		}
+		if strings.Contains(body, testGumroadToken) || strings.Contains(body, "upstream-session") {
+			t.Errorf("%s leaks credentials: %s", path, body)
+		}
+	}
+}
+
+func TestAPICallFilter(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(value string) time.Time {
+		parsed, _ := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		return parsed
+	}
+	call := APICall{
+		Timestamp: at("2024-11-20 23:30"),
+		Method:    "GET",
+		URL:       "https://api.gumroad.com/v2/products/prod/subscribers",
+		Status:    http.StatusNotFound,
+		Duration:  300 * time.Millisecond,
+	}
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		// A bare "to" date includes the whole day, as in the tables
+		{"to=2024-11-20", true},
+		{"to=2024-11-19", false},
+		{"to=2024-11-20T23:00", false},
+		{"from=2024-11-20", true},
+		{"from=2024-11-21", false},
+		{"from=2024-11-20&to=2024-11-20", true},
+		{"method=get", true},
+		{"method=POST", false},
+		{"status=4xx", true},
+		{"status=2xx", false},
+		{"status=error", true},
+		{"status_min=500", false},
+		{"status_max=404", true},
+		{"url=SUBSCRIBERS", true},
+		{"url=products/*/subscribers", true},
+		{"url=sales/*", false},
+		{"errors=1", true},
+		{"min_ms=300", true},
+		{"min_ms=301", false},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if got := parseAPICallFilter(query, loc).matches(call); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"products", "/v2/products/prod", true},
+		{"/v2/*/sales", "/v2/products/prod/sales", true},
+		{"/v2/*/sales", "/v2/sales", false},
+		{"a*b*c", "xaybzc", true},
+		{"a*b*c", "xacb", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestQueryAPICallsCursor walks every sort a page at a time. Most calls share
+// their timestamp, duration and status, so the cursor has to fall back on
+// the ID to neither skip nor repeat calls.
+func TestQueryAPICallsCursor(t *testing.T) {
+	app := newTestApp(t)
+	durations := []time.Duration{20, 10, 20, 20, 10, 30, 20}
+	for i, d := range durations {
+		app.recordAPICall(APICall{
+			Timestamp: testNow,
+			Method:    "GET",
+			URL:       []string{"/v2/products", "/v2/sales"}[i%2],
+			Status:    []int{200, 404}[i%3/2],
+			Duration:  d * time.Millisecond,
+		})
+	}
+
+	for _, sortParam := range []string{"time", "-time", "duration", "-duration", "status", "-status", "url", "-url"} {
+		all := app.queryAPICalls(parseAPICallQuery(url.Values{"sort": {sortParam}}, time.UTC, 100))
+		if all.Total != len(durations) || len(all.Calls) != len(durations) || all.NextCursor != "" {
+			t.Fatalf("%s: %d of %d calls, cursor %q", sortParam, len(all.Calls), all.Total, all.NextCursor)
+		}
+
+		var walked []int64
+		cursor := ""
+		for pages := 0; pages <= len(durations); pages++ {
+			page := app.queryAPICalls(parseAPICallQuery(url.Values{"sort": {sortParam}, "limit": {"2"}, "cursor": {cursor}}, time.UTC, 100))
+			for _, call := range page.Calls {
+				walked = append(walked, call.ID)
+			}
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		var want []int64
+		for _, call := range all.Calls {
+			want = append(want, call.ID)
+		}
+		if fmt.Sprint(walked) != fmt.Sprint(want) {
+			t.Errorf("%s: pages %v, want %v", sortParam, walked, want)
+		}
+	}
+
+	// The cursor keeps its place after the call it points at is rotated out
+	first := app.queryAPICalls(parseAPICallQuery(url.Values{"sort": {"duration"}, "limit": {"3"}}, time.UTC, 100))
+	app.mu.Lock()
+	for i, call := range app.apiCalls {
+		if call.ID == first.Calls[2].ID {
+			app.apiCalls = append(app.apiCalls[:i:i], app.apiCalls[i+1:]...)
+			break
+		}
+	}
+	app.mu.Unlock()
+	next := app.queryAPICalls(parseAPICallQuery(url.Values{"sort": {"duration"}, "limit": {"100"}, "cursor": {first.NextCursor}}, time.UTC, 100))
+	if len(next.Calls) != len(durations)-3 || next.Calls[0].Duration < first.Calls[2].Duration {
+		t.Errorf("after rotation: %+v", next.Calls)
+	}
+
+	if q := parseAPICallQuery(url.Values{"sort": {"-secret"}, "limit": {"9999"}}, time.UTC, 50); q.Sort != "time" || !q.Descending || q.Limit != maxAPILogPageSize {
+		t.Errorf("unknown sort and large limit: %+v", q)
+	}
+}
+
+func TestComputeEndpointStats(t *testing.T) {
+	var calls []APICall
+	for i := 1; i <= 20; i++ {
+		calls = append(calls, APICall{Method: "GET", URL: "https://api.gumroad.com/v2/products/prod" + strconv.Itoa(i) + "/sales?page=2", Status: 200, Duration: time.Duration(i) * time.Millisecond})
+	}
+	calls[0].Status = 500
+	calls = append(calls,
+		APICall{Method: "POST", URL: "https://api.gumroad.com/v2/licenses/verify", Error: "timeout"},
+		APICall{Method: "GET", URL: "https://api.gumroad.com/v2/products", Status: 200, Duration: time.Millisecond},
+	)
+
+	stats := computeEndpointStats(calls)
+	want := []endpointStats{
+		{Endpoint: "GET /v2/products/:id/sales", Count: 20, Errors: 1, ErrorRate: 0.05, P50: 10 * time.Millisecond, P95: 19 * time.Millisecond},
+		{Endpoint: "GET /v2/products", Count: 1, P50: time.Millisecond, P95: time.Millisecond},
+		{Endpoint: "POST /v2/licenses/verify", Count: 1, Errors: 1, ErrorRate: 1},
+	}
+	if fmt.Sprint(stats) != fmt.Sprint(want) {
+		t.Errorf("stats\n%+v\nwant\n%+v", stats, want)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// The templates, static files and message catalogs are compiled into the
+// binary, so it runs from any directory. The --dev flag reads them from disk
+// instead.
+//
+//go:embed templates static locales
+var embeddedAssets embed.FS
+
+const (
+	// staticHashLength is the number of hex characters of a file's SHA-256
+	// put into its URL, as in /static/css/style.0123456789ab.css
+	staticHashLength = 12
+	// Content-hashed URLs change whenever the file does, so browsers may
+	// keep them for a year without asking again
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+// assets holds the templates, static files and message catalogs.
+type assets struct {
+	// dev reads files from ./templates, ./static and ./locales on every use
+	dev       bool
+	templates fs.FS
+	static    fs.FS
+	locales   fs.FS
+	// files are the embedded static files by path, such as "css/style.css"
+	files map[string]*staticFile
+}
+
+// staticFile is an embedded static file with its content hash and gzip and
+// brotli encodings, compressed once at startup.
+type staticFile struct {
+	content     []byte
+	gzip        []byte
+	brotli      []byte
+	hash        string
+	contentType string
+}
+
+func newAssets(dev bool) (*assets, error) {
+	if dev {
+		return &assets{
+			dev:       true,
+			templates: os.DirFS("templates"),
+			static:    os.DirFS("static"),
+			locales:   os.DirFS("locales"),
+		}, nil
+	}
+
+	templates, err := fs.Sub(embeddedAssets, "templates")
+	if err != nil {
+		return nil, err
+	}
+	static, err := fs.Sub(embeddedAssets, "static")
+	if err != nil {
+		return nil, err
+	}
+	locales, err := fs.Sub(embeddedAssets, "locales")
+	if err != nil {
+		return nil, err
+	}
+	a := &assets{
+		templates: templates,
+		static:    static,
+		locales:   locales,
+		files:     make(map[string]*staticFile),
+	}
+
+	err = fs.WalkDir(static, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(static, name)
+		if err != nil {
+			return err
+		}
+		a.files[name], err = newStaticFile(name, content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func newStaticFile(name string, content []byte) (*staticFile, error) {
+	sum := sha256.Sum256(content)
+	file := &staticFile{
+		content:     content,
+		hash:        hex.EncodeToString(sum[:])[:staticHashLength],
+		contentType: mime.TypeByExtension(path.Ext(name)),
+	}
+	if file.contentType == "" {
+		file.contentType = http.DetectContentType(content)
+	}
+	if !compressible(file.contentType) {
+		return file, nil
+	}
+
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	gz.Write(content)
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() < len(content) {
+		file.gzip = bytes.Clone(buf.Bytes())
+	}
+
+	buf.Reset()
+	br := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	br.Write(content)
+	if err := br.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() < len(content) {
+		file.brotli = bytes.Clone(buf.Bytes())
+	}
+	return file, nil
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/javascript" ||
+		mediaType == "application/json" ||
+		mediaType == "image/svg+xml"
+}
+
+// url returns the URL of a static file, with its content hash in the name
+// unless in dev mode. It backs the "asset" template function.
+func (a *assets) url(name string) string {
+	if file, ok := a.files[name]; ok {
+		ext := path.Ext(name)
+		return "/static/" + strings.TrimSuffix(name, ext) + "." + file.hash + ext
+	}
+	return "/static/" + name
+}
+
+// templatesStamp summarizes the names, sizes and modification times of the
+// template and catalog files, so dev mode can tell when to parse them again.
+func (a *assets) templatesStamp() (string, error) {
+	var stamp strings.Builder
+	for _, fsys := range []fs.FS{a.templates, a.locales} {
+		if fsys == nil {
+			continue
+		}
+		entries, err := fs.ReadDir(fsys, ".")
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&stamp, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return stamp.String(), nil
+}
+
+// pageTemplates returns a clone of the parsed templates for one request. In
+// dev mode the templates are parsed again first if a file has changed.
+func (app *App) pageTemplates() (*template.Template, error) {
+	app.templatesMu.Lock()
+	defer app.templatesMu.Unlock()
+
+	if app.assets.dev {
+		stamp, err := app.assets.templatesStamp()
+		if err != nil {
+			return nil, err
+		}
+		if stamp != app.templatesStamp {
+			log.Printf("Templates changed, reloading")
+			if err := app.loadTemplates(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return app.templates.Clone()
+}
+
+// ServeHTTP serves static files below /static/. Embedded files are sent
+// brotli or gzip encoded when the browser accepts it. Requests for the
+// current content-hashed name may be cached for good; plain names, and old
+// hashes still referenced by pages loaded before a deploy, must be
+// revalidated.
+func (a *assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.dev {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.FileServer(http.FS(a.static)).ServeHTTP(w, r)
+		return
+	}
+
+	name := r.URL.Path
+	cacheControl := "no-cache"
+	file, ok := a.files[name]
+	if !ok {
+		ext := path.Ext(name)
+		stem := strings.TrimSuffix(name, ext)
+		if dot := strings.LastIndex(stem, "."); dot >= 0 && len(stem)-dot-1 == staticHashLength {
+			if file, ok = a.files[stem[:dot]+ext]; ok && stem[dot+1:] == file.hash {
+				cacheControl = immutableCacheControl
+			}
+		}
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, encoding := file.content, ""
+	switch accept := r.Header.Get("Accept-Encoding"); {
+	case file.brotli != nil && acceptsEncoding(accept, "br"):
+		body, encoding = file.brotli, "br"
+	case file.gzip != nil && acceptsEncoding(accept, "gzip"):
+		body, encoding = file.gzip, "gzip"
+	}
+
+	header := w.Header()
+	if file.gzip != nil || file.brotli != nil {
+		header.Set("Vary", "Accept-Encoding")
+	}
+	etag := `"` + file.hash + `"`
+	if encoding != "" {
+		etag = `"` + file.hash + "-" + encoding + `"`
+	}
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", cacheControl)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", file.contentType)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+	if r.Method != "HEAD" {
+		w.Write(body)
+	}
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows the
+// encoding, ignoring preferences other than an explicit q=0.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestStaticFiles(t *testing.T) {
+	assets, err := testAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := os.ReadFile("static/css/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := assets.url("css/style.css")
+	if hashed == "/static/css/style.css" || !strings.HasSuffix(hashed, ".css") {
+		t.Fatalf("url = %q, want a content-hashed name", hashed)
+	}
+	stale := "/static/css/style.000000000000.css"
+
+	tests := []struct {
+		name         string
+		path         string
+		encoding     string
+		want         int
+		wantEncoding string
+		wantCache    string
+	}{
+		{name: "hashed, brotli", path: hashed, encoding: "gzip, deflate, br", want: http.StatusOK, wantEncoding: "br", wantCache: immutableCacheControl},
+		{name: "hashed, gzip", path: hashed, encoding: "gzip", want: http.StatusOK, wantEncoding: "gzip", wantCache: immutableCacheControl},
+		{name: "hashed, brotli refused", path: hashed, encoding: "br;q=0, gzip", want: http.StatusOK, wantEncoding: "gzip", wantCache: immutableCacheControl},
+		{name: "hashed, identity", path: hashed, want: http.StatusOK, wantCache: immutableCacheControl},
+		{name: "plain name", path: "/static/css/style.css", encoding: "br", want: http.StatusOK, wantEncoding: "br", wantCache: "no-cache"},
+		{name: "stale hash", path: stale, want: http.StatusOK, wantCache: "no-cache"},
+		{name: "unknown file", path: "/static/css/missing.css", want: http.StatusNotFound},
+	}
+	handler := http.StripPrefix("/static/", assets)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.encoding != "" {
+				req.Header.Set("Accept-Encoding", tt.encoding)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.want {
+				t.Fatalf("status %d, want %d", recorder.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			header := recorder.Header()
+			if got := header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := header.Get("Cache-Control"); got != tt.wantCache {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCache)
+			}
+			if !strings.HasPrefix(header.Get("Content-Type"), "text/css") {
+				t.Errorf("Content-Type = %q", header.Get("Content-Type"))
+			}
+
+			var body io.Reader = recorder.Body
+			switch tt.wantEncoding {
+			case "br":
+				body = brotli.NewReader(body)
+			case "gzip":
+				if body, err = gzip.NewReader(body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			decoded, err := io.ReadAll(body)
+			if err != nil || !bytes.Equal(decoded, original) {
+				t.Errorf("decoded body differs from static/css/style.css (%v)", err)
+			}
+
+			// A revalidation with the ETag is answered without a body
+			req.Header.Set("If-None-Match", header.Get("ETag"))
+			recorder = httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusNotModified {
+				t.Errorf("revalidation: status %d, want 304", recorder.Code)
+			}
+		})
+	}
+}
+
+func TestDevModeReloadsTemplates(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "base.html")
+	if err := os.WriteFile(page, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app := &App{assets: &assets{dev: true, templates: os.DirFS(dir), static: os.DirFS(dir)}}
+	if err := app.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	render := func() string {
+		templates, err := app.pageTemplates()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		if err := templates.ExecuteTemplate(&out, "base.html", nil); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	if got := render(); got != "first" {
+		t.Fatalf("rendered %q, want first", got)
+	}
+
+	if err := os.WriteFile(page, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the change is visible even on filesystems with coarse times
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(page, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(); got != "second" {
+		t.Errorf("after editing the template rendered %q, want second", got)
+	}
+}
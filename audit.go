@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const auditLogFile = "audit.jsonl"
+
+// auditHeadFile records the sequence number and hash of the last event
+// written. The chain alone cannot tell a log whose last lines were removed
+// from a shorter one, so verification also checks the file ends at the head.
+const auditHeadFile = "audit.head"
+
+// genesisHash is the previous hash of the first event in the chain.
+var genesisHash = strings.Repeat("0", 64)
+
+// Audited actions. The part before the dot is the kind of thing acted on,
+// which the audit page filters by. License actions taken through the API are
+// recorded as "license.<action>", e.g. license.rotate.
+const (
+	auditSetupToken        = "setup.token"
+	auditLicenseValidate   = "license.validate"
+	auditLicenseBulk       = "license.bulk_validate"
+	auditBulkExport        = "bulk.export"
+	auditAPILogExport      = "api_log.export"
+	auditAPILogReplay      = "api_log.replay"
+	auditThrottlingUnban   = "throttling.unban"
+	auditAPIKeyCreate      = "api_key.create"
+	auditAPIKeyRevoke      = "api_key.revoke"
+	auditUserCreate        = "user.create"
+	auditUserUpdate        = "user.update"
+	auditUserDelete        = "user.delete"
+	auditUserLogin         = "user.login"
+	auditUserLogout        = "user.logout"
+	auditAuditExport       = "audit.export"
+	auditAuditVerify       = "audit.verify"
+	auditOfferCodeCreate   = "offer_code.create"
+	auditOfferCodeUpdate   = "offer_code.update"
+	auditOfferCodeDelete   = "offer_code.delete"
+	auditOfferCodeGenerate = "offer_code.generate"
+	auditOfferCodeExport   = "offer_code.export"
+	// Product edits
+	auditProductEnable         = "product.enable"
+	auditProductDisable        = "product.disable"
+	auditVariantCategoryCreate = "product.variant_category_create"
+	auditVariantCategoryUpdate = "product.variant_category_update"
+	auditVariantCategoryDelete = "product.variant_category_delete"
+	auditVariantCreate         = "product.variant_create"
+	auditVariantUpdate         = "product.variant_update"
+	auditVariantDelete         = "product.variant_delete"
+	auditCustomFieldCreate     = "product.custom_field_create"
+	auditCustomFieldUpdate     = "product.custom_field_update"
+	auditCustomFieldDelete     = "product.custom_field_delete"
+	// Sale actions
+	auditSaleRefund        = "sale.refund"
+	auditSaleResendReceipt = "sale.resend_receipt"
+	auditSaleMarkShipped   = "sale.mark_shipped"
+)
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+	auditActorAnonymous = "anonymous"
+	// auditActorCLI is recorded for admins created with create-admin
+	auditActorCLI = "cli"
+)
+
+// AuditEvent is one entry in the audit log. Each event's hash covers its
+// own fields and the previous event's hash, so editing, removing or
+// reordering entries in the file breaks the chain from that point on.
+type AuditEvent struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	IP         string    `json:"ip,omitempty"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	ProductID  string    `json:"product_id,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Detail     string    `json:"detail,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// computeHash hashes the event with its Hash field cleared.
+func (e AuditEvent) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// licenseKeyHash identifies a license key in the audit log without storing
+// the key itself.
+func licenseKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// auditLog is an append-only JSON Lines file in the data directory. Events
+// are also kept in memory for the audit page.
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	headPath string
+	file     *os.File
+	events   []AuditEvent
+}
+
+// auditHead is the contents of auditHeadFile.
+type auditHead struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+func newAuditLog(dataDir string) (*auditLog, error) {
+	l := &auditLog{
+		path:     filepath.Join(dataDir, auditLogFile),
+		headPath: filepath.Join(dataDir, auditHeadFile),
+	}
+
+	events, err := readAuditFile(l.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l.events = events
+
+	head, err := readAuditHead(l.headPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && len(events) > 0:
+		// Logs written before the head file existed start being anchored now
+		last := events[len(events)-1]
+		if err := writeAuditHead(l.headPath, auditHead{Seq: last.Seq, Hash: last.Hash}); err != nil {
+			return nil, err
+		}
+		head = &auditHead{Seq: last.Seq, Hash: last.Hash}
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	if _, err := verifyAuditLog(events, head); err != nil {
+		log.Printf("WARNING: audit log %s failed verification: %v", l.path, err)
+	}
+
+	l.file, err = os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func readAuditFile(path string) ([]AuditEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return events, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// auditChainError reports the first event where the hash chain breaks.
+type auditChainError struct {
+	Seq    int64
+	Reason string
+}
+
+func (e *auditChainError) Error() string {
+	return fmt.Sprintf("event %d: %s", e.Seq, e.Reason)
+}
+
+// verifyAuditChain checks every event's hash and link to its predecessor,
+// returning the number of events checked.
+func verifyAuditChain(events []AuditEvent) (int, error) {
+	prevHash := genesisHash
+	for i, event := range events {
+		if event.Seq != int64(i+1) {
+			return i, &auditChainError{Seq: event.Seq, Reason: fmt.Sprintf("expected sequence number %d", i+1)}
+		}
+		if event.PrevHash != prevHash {
+			return i, &auditChainError{Seq: event.Seq, Reason: "previous hash does not match the preceding event"}
+		}
+		if event.computeHash() != event.Hash {
+			return i, &auditChainError{Seq: event.Seq, Reason: "hash does not match the event's contents"}
+		}
+		prevHash = event.Hash
+	}
+	return len(events), nil
+}
+
+// verifyAuditLog checks the chain and that it ends at the recorded head, if
+// there is one, so removing events from the end of the file is caught too.
+func verifyAuditLog(events []AuditEvent, head *auditHead) (int, error) {
+	checked, err := verifyAuditChain(events)
+	if err != nil || head == nil {
+		return checked, err
+	}
+
+	var last AuditEvent
+	if len(events) > 0 {
+		last = events[len(events)-1]
+	}
+	switch {
+	case last.Seq < head.Seq:
+		return checked, &auditChainError{Seq: last.Seq + 1, Reason: fmt.Sprintf("missing, the log ends at event %d but %d were written", last.Seq, head.Seq)}
+	case last.Seq > head.Seq:
+		return checked, &auditChainError{Seq: head.Seq + 1, Reason: fmt.Sprintf("not recorded as written, the log should end at event %d", head.Seq)}
+	case last.Hash != head.Hash:
+		return checked, &auditChainError{Seq: last.Seq, Reason: "hash does not match the recorded last event"}
+	}
+	return checked, nil
+}
+
+func readAuditHead(path string) (*auditHead, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var head auditHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &head, nil
+}
+
+// writeAuditHead replaces the head file atomically.
+func writeAuditHead(path string, head auditHead) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// append chains an event onto the log and writes it to disk.
+func (l *auditLog) append(event AuditEvent) (AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event.Seq = 1
+	event.PrevHash = genesisHash
+	if n := len(l.events); n > 0 {
+		event.Seq = l.events[n-1].Seq + 1
+		event.PrevHash = l.events[n-1].Hash
+	}
+	event.Hash = event.computeHash()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return event, err
+	}
+	if err := l.file.Sync(); err != nil {
+		return event, err
+	}
+	l.events = append(l.events, event)
+	return event, writeAuditHead(l.headPath, auditHead{Seq: event.Seq, Hash: event.Hash})
+}
+
+func (l *auditLog) snapshot() []AuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := make([]AuditEvent, len(l.events))
+	copy(events, l.events)
+	return events
+}
+
+// verify re-reads the file and the head, so edits made on disk since startup
+// are caught.
+func (l *auditLog) verify() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events, err := readAuditFile(l.path)
+	if err != nil {
+		return len(events), err
+	}
+	head, err := readAuditHead(l.headPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	return verifyAuditLog(events, head)
+}
+
+// audit records an action taken by the requester. A nil err is a success;
+// otherwise the error becomes the event's detail. Failing to write the
+// audit log is logged but does not fail the action.
+func (app *App) audit(r *http.Request, event AuditEvent, err error) {
+	if app.auditLog == nil {
+		return
+	}
+
+	event.Time = time.Now()
+	if event.Actor == "" {
+		event.Actor = requestActor(r)
+	}
+	if event.Actor == "" {
+		event.Actor = auditActorAnonymous
+	}
+	event.IP = app.clientIP(r)
+	event.Outcome = auditOutcomeSuccess
+	if err != nil {
+		event.Outcome = auditOutcomeFailure
+		if event.Detail != "" {
+			event.Detail += ": "
+		}
+		event.Detail += err.Error()
+	}
+
+	if _, err := app.auditLog.append(event); err != nil {
+		log.Printf("Failed to write audit event %s: %v", event.Action, err)
+	}
+}
+
+var auditSortColumns = []string{"date", "actor", "action"}
+
+var auditSorts = map[string]func(a, b AuditEvent) bool{
+	"date":   func(a, b AuditEvent) bool { return a.Seq < b.Seq },
+	"actor":  func(a, b AuditEvent) bool { return a.Actor < b.Actor },
+	"action": func(a, b AuditEvent) bool { return a.Action < b.Action },
+}
+
+// auditActionKinds are the prefixes offered by the audit page's action filter.
+var auditActionKinds = []string{"api_key", "api_log", "audit", "bulk", "license", "offer_code", "product", "sale", "setup", "throttling", "user"}
+
+// queryAuditEvents filters and sorts events; the status filter matches the
+// outcome and the action filter matches the action or its kind prefix.
+func queryAuditEvents(events []AuditEvent, q tableQuery, action string) []AuditEvent {
+	matching := make([]AuditEvent, 0, len(events))
+	for _, event := range events {
+		if action != "" && event.Action != action && !strings.HasPrefix(event.Action, action+".") {
+			continue
+		}
+		if q.matches(event.Outcome, event.Time, event.Actor, event.Action, event.Target, event.ProductID, event.IP, event.Detail) {
+			matching = append(matching, event)
+		}
+	}
+
+	less := auditSorts[q.Sort]
+	sort.SliceStable(matching, func(i, j int) bool {
+		if q.Descending {
+			return less(matching[j], matching[i])
+		}
+		return less(matching[i], matching[j])
+	})
+	return matching
+}
+
+func (app *App) auditHandler(w http.ResponseWriter, r *http.Request) {
+	query := parseTableQuery(r, auditSortColumns, app.timezone(r))
+	action := r.URL.Query().Get("action")
+	events := app.auditLog.snapshot()
+
+	matching := queryAuditEvents(events, query, action)
+	start, end, page := query.paginate(len(matching), len(events))
+
+	app.renderPage(w, r, PageData{
+		Title:         app.localizer(r).t("audit.title"),
+		CurrentPage:   "audit",
+		AuditEvents:   matching[start:end],
+		AuditAction:   action,
+		AuditActions:  auditActionKinds,
+		TableQuery:    query,
+		TablePage:     page,
+		ExportURLJSON: query.link(map[string]string{"format": "jsonl", "page": "", "limit": ""}),
+		ExportURLCSV:  query.link(map[string]string{"format": "csv", "page": "", "limit": ""}),
+	})
+}
+
+// auditExportHandler downloads the events matching the page's filters as
+// JSON Lines, which keeps the hashes for offline verification, or as CSV.
+func (app *App) auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	query := parseTableQuery(r, auditSortColumns, app.timezone(r))
+	action := r.URL.Query().Get("action")
+	matching := queryAuditEvents(app.auditLog.snapshot(), query, action)
+	format := r.URL.Query().Get("format")
+
+	app.audit(r, AuditEvent{
+		Action: auditAuditExport,
+		Detail: fmt.Sprintf("%d events as %s", len(matching), withDefaultString(format, "jsonl")),
+	}, nil)
+
+	filename := "audit-" + time.Now().Format("20060102-150405")
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		// Targets include the usernames of failed logins, which anyone can
+		// type, so every free-form cell is escaped
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"seq", "time", "actor", "ip", "action", "target_type", "target", "product_id", "outcome", "detail", "prev_hash", "hash"})
+		for _, event := range matching {
+			writer.Write([]string{
+				strconv.FormatInt(event.Seq, 10),
+				event.Time.Format(time.RFC3339),
+				csvCell(event.Actor),
+				csvCell(event.IP),
+				event.Action,
+				event.TargetType,
+				csvCell(event.Target),
+				csvCell(event.ProductID),
+				event.Outcome,
+				csvCell(event.Detail),
+				event.PrevHash,
+				event.Hash,
+			})
+		}
+		writer.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.jsonl"`)
+	encoder := json.NewEncoder(w)
+	for _, event := range matching {
+		encoder.Encode(event)
+	}
+}
+
+// auditVerifyHandler checks the hash chain of the audit log file.
+func (app *App) auditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	checked, err := app.auditLog.verify()
+
+	result := map[string]interface{}{
+		"success": true,
+		"valid":   err == nil,
+		"checked": checked,
+	}
+	if err != nil {
+		log.Printf("Audit log verification failed: %v", err)
+		result["error"] = err.Error()
+		var chainErr *auditChainError
+		if errors.As(err, &chainErr) {
+			result["broken_at"] = chainErr.Seq
+		}
+	}
+	app.audit(r, AuditEvent{Action: auditAuditVerify, Detail: fmt.Sprintf("%d events checked", checked)}, err)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAuditEvents creates a log in a new directory with n events and
+// returns the directory and the file's lines.
+func writeAuditEvents(t *testing.T, n int) (string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	l, err := newAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := l.append(AuditEvent{Actor: "user:admin", Action: auditUserCreate, Target: string(rune('a' + i)), Outcome: auditOutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.file.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, auditLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// reopenAuditLog rewrites the log file with lines and opens it again.
+func reopenAuditLog(t *testing.T, dir string, lines []string) *auditLog {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, auditLogFile), []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := newAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.file.Close() })
+	return l
+}
+
+func TestAuditLogVerify(t *testing.T) {
+	dir, lines := writeAuditEvents(t, 5)
+	l := reopenAuditLog(t, dir, lines)
+	if checked, err := l.verify(); err != nil || checked != 5 {
+		t.Fatalf("untouched log: %d checked, %v", checked, err)
+	}
+
+	// Appending after a reopen continues the chain
+	if event, err := l.append(AuditEvent{Actor: "user:admin", Action: auditAuditVerify, Outcome: auditOutcomeSuccess}); err != nil || event.Seq != 6 {
+		t.Fatalf("append after reopen: %+v, %v", event, err)
+	}
+	if checked, err := l.verify(); err != nil || checked != 6 {
+		t.Errorf("after append: %d checked, %v", checked, err)
+	}
+}
+
+func TestAuditLogDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(lines []string) []string
+		brokenAt int64
+	}{
+		{"edited", func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], `"target":"c"`, `"target":"x"`, 1)
+			return lines
+		}, 3},
+		{"reordered", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, 3},
+		{"deleted from the middle", func(lines []string) []string {
+			return append(lines[:2:2], lines[3:]...)
+		}, 4},
+		{"truncated", func(lines []string) []string {
+			return lines[:3]
+		}, 4},
+		{"emptied", func(lines []string) []string {
+			return []string{""}
+		}, 1},
+		{"last event replaced", func(lines []string) []string {
+			// A rebuilt final event chains correctly but is not the one written
+			var previous AuditEvent
+			json.Unmarshal([]byte(lines[3]), &previous)
+			event := AuditEvent{Seq: 5, Actor: "user:admin", Action: auditUserDelete, Outcome: auditOutcomeSuccess, PrevHash: previous.Hash}
+			event.Hash = event.computeHash()
+			data, _ := json.Marshal(event)
+			return append(lines[:4], string(data))
+		}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, lines := writeAuditEvents(t, 5)
+			l := reopenAuditLog(t, dir, tt.tamper(lines))
+			_, err := l.verify()
+			var chainErr *auditChainError
+			if !errors.As(err, &chainErr) || chainErr.Seq != tt.brokenAt {
+				t.Errorf("verify = %v, want a break at event %d", err, tt.brokenAt)
+			}
+		})
+	}
+}
+
+// TestAuditLogWithoutHead checks that logs from before the head file are
+// anchored when opened, and truncation is caught from then on.
+func TestAuditLogWithoutHead(t *testing.T) {
+	dir, lines := writeAuditEvents(t, 4)
+	if err := os.Remove(filepath.Join(dir, auditHeadFile)); err != nil {
+		t.Fatal(err)
+	}
+	l := reopenAuditLog(t, dir, lines)
+	if _, err := l.verify(); err != nil {
+		t.Fatalf("log without a head: %v", err)
+	}
+	head, err := readAuditHead(filepath.Join(dir, auditHeadFile))
+	if err != nil || head.Seq != 4 {
+		t.Fatalf("head after reopen: %+v, %v", head, err)
+	}
+	l.file.Close()
+
+	l = reopenAuditLog(t, dir, lines[:3])
+	if _, err := l.verify(); err == nil {
+		t.Error("truncation after the head was recreated went unnoticed")
+	}
+}
+
+// TestAuditExportEscapesFormulas checks that a username typed into the login
+// form cannot become a formula in the CSV export.
+func TestAuditExportEscapesFormulas(t *testing.T) {
+	dir := t.TempDir()
+	app := newTestApp(t)
+	app.users, _ = newUserStore(dir)
+	app.auditLog, _ = newAuditLog(dir)
+	t.Cleanup(func() { app.auditLog.file.Close() })
+	app.loginLimiter = newRateLimiter(loginRateLimit, time.Minute)
+	if _, err := app.users.create("owner", "password1", roleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"username": {`=HYPERLINK("https://attacker.example","x")`}, "password": {"guess"}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if recorder := serve(app.loginSubmitHandler, req, nil); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("failed login: status %d", recorder.Code)
+	}
+
+	recorder := serve(app.auditExportHandler, httptest.NewRequest("GET", "/audit/export?format=csv", nil), nil)
+	rows, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][4] != auditUserLogin {
+		t.Fatalf("export = %v, want the failed login", rows)
+	}
+	if target := rows[1][6]; target != `'=HYPERLINK("https://attacker.example","x")` {
+		t.Errorf("target = %q, want it escaped", target)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// BulkValidationConfig bounds how hard bulk checks hit Gumroad. Zero values
+// fall back to the defaults below.
+type BulkValidationConfig struct {
+	Workers           int `json:"workers,omitempty"`
+	RequestsPerSecond int `json:"requests_per_second,omitempty"`
+}
+
+const (
+	defaultBulkWorkers           = 4
+	defaultBulkRequestsPerSecond = 5
+
+	maxBulkKeys       = 5000
+	maxBulkUploadSize = 5 << 20
+	// Finished jobs are kept this long so their reports can be downloaded
+	bulkJobRetention = 24 * time.Hour
+)
+
+// Statuses reported for each key in a bulk validation.
+const (
+	bulkStatusValid        = "valid"
+	bulkStatusInvalid      = "invalid"
+	bulkStatusDisabled     = "disabled"
+	bulkStatusRefunded     = "refunded"
+	bulkStatusChargebacked = "chargebacked"
+	bulkStatusDisputed     = "disputed"
+	bulkStatusRejected     = "rejected"
+	bulkStatusError        = "error"
+)
+
+type BulkResult struct {
+	LicenseKey string `json:"license_key"`
+	Status     string `json:"status"`
+	Uses       int    `json:"uses"`
+	Email      string `json:"email,omitempty"`
+	PolicyRule string `json:"policy_rule,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// bulkJob is one bulk validation run. Results are filled in by the workers
+// in the same order as the submitted keys.
+type bulkJob struct {
+	ID        string
+	ProductID string
+	Keys      []string
+	StartedAt time.Time
+
+	mu         sync.Mutex
+	results    []BulkResult
+	processed  int
+	counts     map[string]int
+	finishedAt time.Time
+}
+
+type BulkJobStatus struct {
+	ID         string         `json:"id"`
+	ProductID  string         `json:"product_id"`
+	Total      int            `json:"total"`
+	Processed  int            `json:"processed"`
+	Counts     map[string]int `json:"counts"`
+	Done       bool           `json:"done"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+func (job *bulkJob) status() BulkJobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	counts := make(map[string]int, len(job.counts))
+	for status, n := range job.counts {
+		counts[status] = n
+	}
+
+	status := BulkJobStatus{
+		ID:        job.ID,
+		ProductID: job.ProductID,
+		Total:     len(job.Keys),
+		Processed: job.processed,
+		Counts:    counts,
+		StartedAt: job.StartedAt,
+	}
+	if !job.finishedAt.IsZero() {
+		finishedAt := job.finishedAt
+		status.Done = true
+		status.FinishedAt = &finishedAt
+	}
+	return status
+}
+
+// bulkValidator owns the running and finished bulk jobs.
+type bulkValidator struct {
+	workers  int
+	interval time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*bulkJob
+}
+
+func newBulkValidator(cfg BulkValidationConfig) *bulkValidator {
+	return &bulkValidator{
+		workers:  withDefault(cfg.Workers, defaultBulkWorkers),
+		interval: time.Second / time.Duration(withDefault(cfg.RequestsPerSecond, defaultBulkRequestsPerSecond)),
+		jobs:     make(map[string]*bulkJob),
+	}
+}
+
+func (b *bulkValidator) job(id string) (*bulkJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	job, ok := b.jobs[id]
+	return job, ok
+}
+
+// startBulkValidation registers a job and validates its keys in the background.
+func (app *App) startBulkValidation(productID string, keys []string) (*bulkJob, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	job := &bulkJob{
+		ID:        hex.EncodeToString(id),
+		ProductID: productID,
+		Keys:      keys,
+		StartedAt: time.Now(),
+		results:   make([]BulkResult, len(keys)),
+		counts:    make(map[string]int),
+	}
+
+	b := app.bulk
+	b.mu.Lock()
+	for id, old := range b.jobs {
+		if status := old.status(); status.Done && time.Since(*status.FinishedAt) > bulkJobRetention {
+			delete(b.jobs, id)
+		}
+	}
+	b.jobs[job.ID] = job
+	b.mu.Unlock()
+
+	go app.runBulkValidation(job)
+	return job, nil
+}
+
+func (app *App) runBulkValidation(job *bulkJob) {
+	indexes := make(chan int)
+	ticker := time.NewTicker(app.bulk.interval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < app.bulk.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				result := app.bulkValidateKey(job.ProductID, job.Keys[index])
+
+				job.mu.Lock()
+				job.results[index] = result
+				job.counts[result.Status]++
+				job.processed++
+				job.mu.Unlock()
+			}
+		}()
+	}
+
+	// The ticker paces dispatch, which caps the request rate across all
+	// workers regardless of how many there are
+	for index := range job.Keys {
+		<-ticker.C
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	job.mu.Lock()
+	job.finishedAt = time.Now()
+	job.mu.Unlock()
+
+	log.Printf("Bulk validation %s finished: %d keys for product %s", job.ID, len(job.Keys), job.ProductID)
+}
+
+func (app *App) bulkValidateKey(productID, licenseKey string) BulkResult {
+	result := BulkResult{LicenseKey: licenseKey}
+
+	response, err := app.verifyLicense(productID, licenseKey, verifyOptions{Live: true})
+	if err != nil {
+		result.Status = bulkStatusError
+		result.Message = err.Error()
+		return result
+	}
+
+	result.Uses = response.Uses
+	result.Message = response.Message
+	if email, ok := response.Purchase["email"].(string); ok {
+		result.Email = email
+	}
+	if response.Policy != nil {
+		result.PolicyRule = response.Policy.Rule
+	}
+
+	switch {
+	case response.Purchase == nil && strings.Contains(strings.ToLower(response.Message), "disabled"):
+		result.Status = bulkStatusDisabled
+	case response.Purchase == nil:
+		result.Status = bulkStatusInvalid
+	case purchaseBool(response.Purchase, "refunded"):
+		result.Status = bulkStatusRefunded
+	case purchaseBool(response.Purchase, "chargebacked"):
+		result.Status = bulkStatusChargebacked
+	case purchaseBool(response.Purchase, "disputed"):
+		result.Status = bulkStatusDisputed
+	case !response.Success:
+		result.Status = bulkStatusRejected
+	default:
+		result.Status = bulkStatusValid
+	}
+	return result
+}
+
+// parseBulkKeys reads license keys from pasted text or an uploaded CSV. The
+// key is taken from a "license_key" column when there is a header row, and
+// from the first column otherwise. Blank lines and duplicates are dropped.
+func parseBulkKeys(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var keys []string
+	seen := make(map[string]bool)
+	column := 0
+	first := true
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if first {
+			first = false
+			found := false
+			for i, field := range record {
+				name := strings.ToLower(strings.TrimSpace(field))
+				if name == "license_key" || name == "license key" {
+					column = i
+					found = true
+				}
+			}
+			if found {
+				continue
+			}
+		}
+
+		if column >= len(record) {
+			continue
+		}
+		key := strings.TrimSpace(record[column])
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+
+		if len(keys) > maxBulkKeys {
+			return nil, fmt.Errorf("too many license keys, the limit is %d", maxBulkKeys)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no license keys found")
+	}
+	return keys, nil
+}
+
+func writeBulkError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
+
+// bulkValidateHandler accepts a product ID plus either pasted keys or an
+// uploaded CSV file, and starts a bulk validation job.
+func (app *App) bulkValidateHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkUploadSize)
+	if err := r.ParseMultipartForm(maxBulkUploadSize); err != nil {
+		writeBulkError(w, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+
+	productID := r.FormValue("product_id")
+	if productID == "" {
+		writeBulkError(w, http.StatusBadRequest, "Missing product_id")
+		return
+	}
+
+	var source io.Reader = strings.NewReader(r.FormValue("keys"))
+	if file, _, err := r.FormFile("file"); err == nil {
+		defer file.Close()
+		source = file
+	}
+
+	keys, err := parseBulkKeys(source)
+	if err != nil {
+		writeBulkError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job, err := app.startBulkValidation(productID, keys)
+	event := AuditEvent{Action: auditLicenseBulk, TargetType: "product", Target: productID, ProductID: productID}
+	if err != nil {
+		app.audit(r, event, err)
+		writeBulkError(w, http.StatusInternalServerError, "Failed to start bulk validation: "+err.Error())
+		return
+	}
+
+	log.Printf("Bulk validation %s started: %d keys for product %s", job.ID, len(keys), productID)
+	event.Detail = fmt.Sprintf("job %s, %d keys", job.ID, len(keys))
+	app.audit(r, event, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"job":     job.status(),
+	})
+}
+
+func (app *App) bulkStatusHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.bulk.job(mux.Vars(r)["id"])
+	if !ok {
+		writeBulkError(w, http.StatusNotFound, "Bulk validation job not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.status())
+}
+
+// bulkReportHandler downloads the results of a job as CSV. Keys that have not
+// been checked yet are reported as "pending".
+func (app *App) bulkReportHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.bulk.job(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Bulk validation job not found", http.StatusNotFound)
+		return
+	}
+
+	job.mu.Lock()
+	results := make([]BulkResult, len(job.results))
+	copy(results, job.results)
+	job.mu.Unlock()
+
+	app.audit(r, AuditEvent{
+		Action:     auditBulkExport,
+		TargetType: "bulk_job",
+		Target:     job.ID,
+		ProductID:  job.ProductID,
+		Detail:     fmt.Sprintf("%d results", len(results)),
+	}, nil)
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="bulk-validation-%s.csv"`, job.ID))
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"license_key", "status", "uses", "purchaser_email", "policy_rule", "message"})
+	for i, result := range results {
+		if result.Status == "" {
+			result = BulkResult{LicenseKey: job.Keys[i], Status: "pending"}
+		}
+		// Keys come from the uploaded file and emails and messages from
+		// Gumroad, so they are escaped like any user-controlled cell
+		writer.Write([]string{
+			csvCell(result.LicenseKey),
+			result.Status,
+			strconv.Itoa(result.Uses),
+			csvCell(result.Email),
+			result.PolicyRule,
+			csvCell(result.Message),
+		})
+	}
+	writer.Flush()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseBulkKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"pasted keys", "AAA\nBBB\n\nCCC\n", []string{"AAA", "BBB", "CCC"}},
+		{"first column without header", "AAA,alice@example.com\nBBB,bob@example.com", []string{"AAA", "BBB"}},
+		{"license_key header", "email,license_key\na@example.com,AAA\nb@example.com,BBB", []string{"AAA", "BBB"}},
+		{"license key header in any case", "Email, License Key ,Uses\na@example.com, AAA ,1\nb@example.com,BBB,2", []string{"AAA", "BBB"}},
+		{"duplicates and blanks", "AAA\nBBB\nAAA\n \nBBB\nCCC", []string{"AAA", "BBB", "CCC"}},
+		{"short rows skipped", "email,license_key\nonly-email@example.com\nb@example.com,BBB", []string{"BBB"}},
+	}
+	for _, tt := range tests {
+		keys, err := parseBulkKeys(strings.NewReader(tt.input))
+		if err != nil || strings.Join(keys, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, keys, err, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "\n\n", "license_key\n", "\"unterminated"} {
+		if keys, err := parseBulkKeys(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: got %v, want an error", input, keys)
+		}
+	}
+}
+
+func TestParseBulkKeysLimit(t *testing.T) {
+	var lines []string
+	for i := 0; i < maxBulkKeys; i++ {
+		lines = append(lines, fmt.Sprintf("KEY-%d", i))
+	}
+	// Duplicates do not count towards the limit
+	keys, err := parseBulkKeys(strings.NewReader(strings.Join(append(lines, "KEY-0"), "\n")))
+	if err != nil || len(keys) != maxBulkKeys {
+		t.Fatalf("%d keys: got %d, %v", maxBulkKeys, len(keys), err)
+	}
+	if _, err := parseBulkKeys(strings.NewReader(strings.Join(append(lines, "ONE-MORE"), "\n"))); err == nil {
+		t.Errorf("%d keys were accepted", maxBulkKeys+1)
+	}
+}
+
+// TestBulkValidationWorkers runs a job against a slow fake Gumroad and checks
+// that results keep the order of the keys, no more than the configured number
+// of workers call Gumroad at once, and dispatch is paced by the ticker.
+func TestBulkValidationWorkers(t *testing.T) {
+	const (
+		workers = 2
+		keys    = 8
+		latency = 40 * time.Millisecond
+	)
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(latency)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		switch key := r.FormValue("license_key"); {
+		case strings.HasPrefix(key, "VALID"):
+			fmt.Fprintf(w, `{"success":true,"uses":2,"purchase":{"email":"%s@example.com"}}`, strings.ToLower(key))
+		case key == "REFUNDED":
+			fmt.Fprint(w, `{"success":true,"purchase":{"refunded":true}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"success":false,"message":"That license does not exist for the provided product."}`)
+		}
+	}))
+	defer server.Close()
+
+	app := newTestApp(t)
+	app.config.GumroadAPIBase = server.URL + "/v2"
+	app.bulk = newBulkValidator(BulkValidationConfig{Workers: workers, RequestsPerSecond: 100})
+
+	input := []string{"VALID-1", "BOGUS", "REFUNDED", "VALID-2", "VALID-3", "VALID-4", "NOPE", "VALID-5"}
+	start := time.Now()
+	job, err := app.startBulkValidation("prod", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !job.status().Done {
+		if time.Now().After(deadline) {
+			t.Fatalf("job not done: %+v", job.status())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+
+	status := job.status()
+	if status.Processed != keys || status.Counts[bulkStatusValid] != 5 || status.Counts[bulkStatusInvalid] != 2 || status.Counts[bulkStatusRefunded] != 1 {
+		t.Errorf("status %+v", status)
+	}
+	for i, result := range job.results {
+		if result.LicenseKey != input[i] {
+			t.Errorf("result %d is for %s, want %s", i, result.LicenseKey, input[i])
+		}
+	}
+	if job.results[0].Email != "valid-1@example.com" || job.results[0].Uses != 2 {
+		t.Errorf("first result %+v", job.results[0])
+	}
+
+	if maxInFlight != workers {
+		t.Errorf("%d concurrent Gumroad calls, want %d", maxInFlight, workers)
+	}
+	// With two workers each taking the latency per key, the pool needs at
+	// least keys/workers round trips however fast the ticker is
+	if min := keys / workers * latency; elapsed < min {
+		t.Errorf("job took %v, want at least %v", elapsed, min)
+	}
+
+	// Pacing alone bounds a fast Gumroad: the ticker fires once per interval
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success":true,"purchase":{}}`)
+	}))
+	defer fast.Close()
+	app.config.GumroadAPIBase = fast.URL + "/v2"
+	app.bulk = newBulkValidator(BulkValidationConfig{Workers: 8, RequestsPerSecond: 50})
+	start = time.Now()
+	job, _ = app.startBulkValidation("prod", input)
+	for !job.status().Done {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if min := time.Duration(len(input)) * app.bulk.interval; time.Since(start) < min {
+		t.Errorf("%d keys at 50/s took %v, want at least %v", len(input), time.Since(start), min)
+	}
+}
+
+func TestBulkReportEscapesFormulas(t *testing.T) {
+	app := newTestApp(t)
+	app.bulk = newBulkValidator(BulkValidationConfig{})
+	app.bulk.jobs["job"] = &bulkJob{
+		ID:   "job",
+		Keys: []string{"=1+1", "@SUM(A1)"},
+		results: []BulkResult{
+			{LicenseKey: "=1+1", Status: bulkStatusInvalid, Email: "+buyer@example.com", Message: "-That license does not exist"},
+			{},
+		},
+	}
+
+	recorder := serve(app.bulkReportHandler, httptest.NewRequest("GET", "/bulk-validate/job/report.csv", nil), map[string]string{"id": "job"})
+	rows, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"license_key", "status", "uses", "purchaser_email", "policy_rule", "message"},
+		{"'=1+1", bulkStatusInvalid, "0", "'+buyer@example.com", "", "'-That license does not exist"},
+		{"'@SUM(A1)", "pending", "0", "", "", ""},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("report\n%v\nwant\n%v", rows, want)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// VerificationCacheConfig controls how long successful verifications are
+// reused. Zero values fall back to the defaults below.
+type VerificationCacheConfig struct {
+	// TTLSeconds is how long a result is served without asking Gumroad
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+	// GraceHours is how long a result may be served as stale while Gumroad
+	// is unreachable
+	GraceHours int `json:"grace_hours,omitempty"`
+}
+
+const (
+	defaultCacheTTLSeconds = 300
+	defaultCacheGraceHours = 72
+)
+
+type cachedVerification struct {
+	productID  string
+	licenseKey string
+	response   LicenseValidationResponse
+	storedAt   time.Time
+}
+
+// verificationCache keeps the last successful Gumroad answer per product and
+// license key. Policy is not cached; it is applied again on every read.
+type verificationCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	grace   time.Duration
+	entries map[string]*cachedVerification
+}
+
+func newVerificationCache(cfg VerificationCacheConfig) *verificationCache {
+	return &verificationCache{
+		ttl:     time.Duration(withDefault(cfg.TTLSeconds, defaultCacheTTLSeconds)) * time.Second,
+		grace:   time.Duration(withDefault(cfg.GraceHours, defaultCacheGraceHours)) * time.Hour,
+		entries: make(map[string]*cachedVerification),
+	}
+}
+
+func verificationCacheKey(productID, licenseKey string) string {
+	return productID + "\x00" + licenseKey
+}
+
+// store records a successful verification, replacing any older entry.
+func (c *verificationCache) store(productID, licenseKey string, response LicenseValidationResponse) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[verificationCacheKey(productID, licenseKey)] = &cachedVerification{
+		productID:  productID,
+		licenseKey: licenseKey,
+		response:   response,
+		storedAt:   now,
+	}
+
+	for key, entry := range c.entries {
+		if now.Sub(entry.storedAt) > c.grace {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// fresh returns a cached result that is still within its TTL.
+func (c *verificationCache) fresh(productID, licenseKey string) (LicenseValidationResponse, bool) {
+	return c.lookup(productID, licenseKey, c.ttl)
+}
+
+// lastKnownGood returns a cached result that is still within the offline
+// grace window, however old it is otherwise.
+func (c *verificationCache) lastKnownGood(productID, licenseKey string) (LicenseValidationResponse, bool) {
+	return c.lookup(productID, licenseKey, c.grace)
+}
+
+func (c *verificationCache) lookup(productID, licenseKey string, maxAge time.Duration) (LicenseValidationResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[verificationCacheKey(productID, licenseKey)]
+	if !ok || time.Since(entry.storedAt) > maxAge {
+		return LicenseValidationResponse{}, false
+	}
+
+	response := entry.response
+	storedAt := entry.storedAt
+	response.Cached = true
+	response.CachedAt = &storedAt
+	return response, true
+}
+
+// invalidate drops the entry for a single license.
+func (c *verificationCache) invalidate(productID, licenseKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, verificationCacheKey(productID, licenseKey))
+}
+
+// invalidateMatching drops every entry whose purchase matches one of the
+// non-empty identifiers, and returns how many were removed.
+func (c *verificationCache) invalidateMatching(productID, licenseKey, saleID, subscriptionID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, entry := range c.entries {
+		purchase := entry.response.Purchase
+		matched := (licenseKey != "" && entry.licenseKey == licenseKey && (productID == "" || entry.productID == productID)) ||
+			(saleID != "" && purchase["sale_id"] == saleID) ||
+			(subscriptionID != "" && purchase["subscription_id"] == subscriptionID)
+		if matched {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// gumroadWebhookHandler receives Gumroad resource subscription pings. Any
+// ping about a purchase (refund, dispute, cancellation, ...) drops the
+// cached verification for it so the next check goes to Gumroad. The
+// endpoint is unauthenticated apart from the secret, so it is disabled until
+// one is configured.
+func (app *App) gumroadWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.WebhookSecret == "" {
+		http.NotFound(w, r)
+		return
+	}
+	secret := r.URL.Query().Get("secret")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(app.config.WebhookSecret)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	productID := r.PostForm.Get("product_id")
+	licenseKey := r.PostForm.Get("license_key")
+	saleID := r.PostForm.Get("sale_id")
+	subscriptionID := r.PostForm.Get("subscription_id")
+
+	removed := app.verifyCache.invalidateMatching(productID, licenseKey, saleID, subscriptionID)
+	log.Printf("Gumroad webhook (sale=%s, subscription=%s): invalidated %d cached verifications", saleID, subscriptionID, removed)
+
+	w.WriteHeader(http.StatusNoContent)
+}
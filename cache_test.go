@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeVerifyServer answers license verifications with the status and body
+// currently stored in it, counting the calls.
+type fakeVerifyServer struct {
+	status atomic.Int32
+	body   atomic.Value
+	calls  atomic.Int32
+}
+
+func newFakeVerifyServer(t *testing.T, app *App) *fakeVerifyServer {
+	t.Helper()
+	fake := &fakeVerifyServer{}
+	fake.respond(http.StatusOK, `{"success":true,"uses":1,"purchase":{"sale_id":"sale-1","subscription_id":"sub-1","email":"a@example.com"}}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.calls.Add(1)
+		w.WriteHeader(int(fake.status.Load()))
+		w.Write([]byte(fake.body.Load().(string)))
+	}))
+	t.Cleanup(server.Close)
+	app.config.GumroadAPIBase = server.URL + "/v2"
+	return fake
+}
+
+func (f *fakeVerifyServer) respond(status int, body string) {
+	f.status.Store(int32(status))
+	f.body.Store(body)
+}
+
+// age moves a cache entry's store time into the past.
+func (c *verificationCache) age(productID, licenseKey string, by time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[verificationCacheKey(productID, licenseKey)].storedAt = time.Now().Add(-by)
+}
+
+func TestVerificationCacheFreshHits(t *testing.T) {
+	app := newTestApp(t)
+	fake := newFakeVerifyServer(t, app)
+
+	first, err := app.verifyLicense("prod", "KEY", verifyOptions{})
+	if err != nil || !first.Success || first.Cached {
+		t.Fatalf("first verification %+v, %v", first, err)
+	}
+	second, err := app.verifyLicense("prod", "KEY", verifyOptions{})
+	if err != nil || !second.Success || !second.Cached || second.Stale || second.CachedAt == nil {
+		t.Errorf("second verification %+v, %v", second, err)
+	}
+	if calls := fake.calls.Load(); calls != 1 {
+		t.Errorf("fresh hit reached Gumroad: %d calls", calls)
+	}
+
+	for _, opts := range []verifyOptions{{Live: true}, {IncrementUses: true}} {
+		if response, _ := app.verifyLicense("prod", "KEY", opts); response.Cached {
+			t.Errorf("%+v served from the cache", opts)
+		}
+	}
+	if calls := fake.calls.Load(); calls != 3 {
+		t.Errorf("live and increment verifications: %d calls, want 3", calls)
+	}
+
+	app.verifyCache.age("prod", "KEY", time.Duration(defaultCacheTTLSeconds+1)*time.Second)
+	if response, _ := app.verifyLicense("prod", "KEY", verifyOptions{}); response.Cached || fake.calls.Load() != 4 {
+		t.Errorf("expired entry was served %+v", response)
+	}
+
+	// Policy is applied to cached reads too
+	app.config.Policies = map[string]LicensePolicy{"prod": {BlockedKeys: []string{"KEY"}}}
+	if response, _ := app.verifyLicense("prod", "KEY", verifyOptions{}); !response.Cached || response.Success || response.Policy.Rule != ruleBlockedKey {
+		t.Errorf("policy on a cached read %+v", response)
+	}
+}
+
+func TestVerificationCacheServesStaleWhenGumroadIsDown(t *testing.T) {
+	app := newTestApp(t)
+	fake := newFakeVerifyServer(t, app)
+
+	if _, err := app.verifyLicense("prod", "KEY", verifyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	app.verifyCache.age("prod", "KEY", time.Hour)
+
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		fake.respond(status, `{"error":"down"}`)
+		response, err := app.verifyLicense("prod", "KEY", verifyOptions{})
+		if err != nil || !response.Success || !response.Stale || !response.Cached {
+			t.Errorf("status %d: %+v, %v", status, response, err)
+		}
+	}
+
+	if _, err := app.verifyLicense("prod", "OTHER", verifyOptions{}); !errors.Is(err, errGumroadUnavailable) {
+		t.Errorf("uncached key while down: %v", err)
+	}
+
+	app.verifyCache.age("prod", "KEY", time.Duration(defaultCacheGraceHours)*time.Hour+time.Minute)
+	if _, err := app.verifyLicense("prod", "KEY", verifyOptions{}); !errors.Is(err, errGumroadUnavailable) {
+		t.Errorf("entry past the grace window was served: %v", err)
+	}
+
+	// An answer that the key is invalid is not an outage and drops the entry
+	fake.respond(http.StatusOK, `{"success":true}`)
+	app.verifyLicense("prod", "KEY", verifyOptions{})
+	fake.respond(http.StatusNotFound, `{"success":false,"message":"That license does not exist for the provided product."}`)
+	if response, err := app.verifyLicense("prod", "KEY", verifyOptions{Live: true}); err != nil || response.Success || response.Stale {
+		t.Errorf("invalid key: %+v, %v", response, err)
+	}
+	if _, ok := app.verifyCache.lastKnownGood("prod", "KEY"); ok {
+		t.Error("invalid key left in the cache")
+	}
+}
+
+func TestVerificationCacheInvalidateMatching(t *testing.T) {
+	cache := newVerificationCache(VerificationCacheConfig{})
+	purchase := func(sale, subscription string) LicenseValidationResponse {
+		return LicenseValidationResponse{Success: true, Purchase: map[string]interface{}{"sale_id": sale, "subscription_id": subscription}}
+	}
+	reset := func() {
+		cache.entries = make(map[string]*cachedVerification)
+		cache.store("p1", "K1", purchase("s1", "sub1"))
+		cache.store("p1", "K2", purchase("s2", ""))
+		cache.store("p2", "K1", purchase("s3", "sub3"))
+	}
+
+	tests := []struct {
+		name                                        string
+		productID, licenseKey, saleID, subscription string
+		removed                                     int
+	}{
+		{"nothing given", "", "", "", "", 0},
+		{"key on any product", "", "K1", "", "", 2},
+		{"key on one product", "p2", "K1", "", "", 1},
+		{"sale", "", "", "s2", "", 1},
+		{"subscription", "", "", "", "sub1", 1},
+		{"any identifier", "", "K2", "", "sub3", 2},
+		{"unknown sale", "", "", "nope", "", 0},
+	}
+	for _, tt := range tests {
+		reset()
+		if removed := cache.invalidateMatching(tt.productID, tt.licenseKey, tt.saleID, tt.subscription); removed != tt.removed || len(cache.entries) != 3-tt.removed {
+			t.Errorf("%s: removed %d, %d left, want %d removed", tt.name, removed, len(cache.entries), tt.removed)
+		}
+	}
+}
+
+func TestGumroadWebhookHandler(t *testing.T) {
+	app := newTestApp(t)
+	ping := func(query string) int {
+		form := url.Values{"resource_name": {"refund"}, "sale_id": {"s1"}}
+		req := httptest.NewRequest("POST", "/webhooks/gumroad"+query, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(app.gumroadWebhookHandler, req, nil).Code
+	}
+	app.verifyCache.store("p1", "K1", LicenseValidationResponse{Success: true, Purchase: map[string]interface{}{"sale_id": "s1"}})
+
+	if status := ping(""); status != http.StatusNotFound {
+		t.Errorf("without a configured secret: status %d, want 404", status)
+	}
+	app.config.WebhookSecret = "s3cret"
+	for _, query := range []string{"", "?secret=wrong"} {
+		if status := ping(query); status != http.StatusForbidden {
+			t.Errorf("secret %q: status %d, want 403", query, status)
+		}
+	}
+	if _, ok := app.verifyCache.fresh("p1", "K1"); !ok {
+		t.Fatal("rejected pings invalidated the cache")
+	}
+	if status := ping("?secret=s3cret"); status != http.StatusNoContent {
+		t.Errorf("valid ping: status %d, want 204", status)
+	}
+	if _, ok := app.verifyCache.fresh("p1", "K1"); ok {
+		t.Error("valid ping did not invalidate the sale")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CSRF protection uses the double-submit pattern: every page load makes sure
+// the browser holds a random token in the glm_csrf cookie, base.html embeds
+// the same token in a meta tag, and state-changing requests must echo it in
+// the X-CSRF-Token header (fetch) or a csrf_token field (HTML forms). Another
+// site can make the browser send the cookie but cannot read it to copy it
+// into the request. The Origin (or Referer) header is checked as well.
+const (
+	csrfCookieName = "glm_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+// csrfExemptPrefixes are endpoints that are not used from the browser with
+// cookies: the public verification endpoint and webhooks are called by other
+// servers, and /api/v1 is authenticated with API keys only.
+var csrfExemptPrefixes = []string{
+	"/v1/licenses/verify",
+	"/webhooks/",
+	apiV1Prefix + "/",
+}
+
+type csrfContextKey struct{}
+
+// csrfToken returns the token to embed in the page being rendered: the one
+// just issued, or the one the browser already holds.
+func csrfToken(r *http.Request) string {
+	if token, ok := r.Context().Value(csrfContextKey{}).(string); ok {
+		return token
+	}
+	if cookie, err := r.Cookie(csrfCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func newCSRFToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+func isCSRFExempt(path string) bool {
+	for _, prefix := range csrfExemptPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfMiddleware issues the CSRF cookie on safe requests and checks the
+// origin and token on everything else.
+func (app *App) csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isCSRFExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookieToken := ""
+		if cookie, err := r.Cookie(csrfCookieName); err == nil {
+			cookieToken = cookie.Value
+		}
+
+		if isSafeMethod(r.Method) {
+			if cookieToken == "" {
+				token, err := newCSRFToken()
+				if err != nil {
+					log.Printf("Failed to generate CSRF token: %v", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				cookieToken = token
+				http.SetCookie(w, &http.Cookie{
+					Name:     csrfCookieName,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   app.isHTTPS(r),
+					SameSite: http.SameSiteStrictMode,
+				})
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, cookieToken)))
+			return
+		}
+
+		if !app.sameOrigin(r) {
+			log.Printf("CSRF: rejected %s %s from origin %q (referer %q)", r.Method, r.URL.Path, r.Header.Get("Origin"), r.Header.Get("Referer"))
+			writeCSRFError(w, r, "Cross-site request rejected")
+			return
+		}
+
+		sent := r.Header.Get(csrfHeaderName)
+		if sent == "" && isFormPost(r) {
+			sent = r.PostFormValue(csrfFormField)
+		}
+		if cookieToken == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(cookieToken)) != 1 {
+			log.Printf("CSRF: rejected %s %s with missing or invalid token", r.Method, r.URL.Path)
+			writeCSRFError(w, r, "Invalid or missing CSRF token, reload the page and try again")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin checks the Origin header, or the Referer when a browser omits
+// Origin, against the host the request was sent to. Requests carrying
+// neither header are left to the token check.
+func (app *App) sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return r.Header.Get("Origin") != "null"
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	forwarded := r.Header.Get("X-Forwarded-Host")
+	return app.config.TrustProxyHeaders && forwarded != "" && strings.EqualFold(u.Host, forwarded)
+}
+
+func isFormPost(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded"
+}
+
+// writeCSRFError answers HTML form posts with a plain page and everything
+// else with the usual JSON error.
+func writeCSRFError(w http.ResponseWriter, r *http.Request, message string) {
+	if isFormPost(r) {
+		http.Error(w, "Forbidden: "+message, http.StatusForbidden)
+		return
+	}
+	writeAuthError(w, http.StatusForbidden, message)
+}
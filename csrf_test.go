@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testCSRFToken = "test-csrf-token"
+
+// csrfTestHandler wraps a handler that reports 200 when reached.
+func csrfTestHandler(app *App) http.Handler {
+	return app.csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestCSRFRejectsCrossSiteRequests(t *testing.T) {
+	handler := csrfTestHandler(&App{})
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		cookie string
+		form   string
+		want   int
+	}{
+		{
+			name: "no token",
+			header: map[string]string{
+				"Origin": "http://example.test",
+			},
+			cookie: testCSRFToken,
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "no cookie",
+			header: map[string]string{
+				"Origin":       "http://example.test",
+				csrfHeaderName: testCSRFToken,
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "token does not match cookie",
+			header: map[string]string{
+				"Origin":       "http://example.test",
+				csrfHeaderName: "forged",
+			},
+			cookie: testCSRFToken,
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "cross-site origin with valid token",
+			header: map[string]string{
+				"Origin":       "http://evil.test",
+				csrfHeaderName: testCSRFToken,
+			},
+			cookie: testCSRFToken,
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "cross-site referer without origin",
+			header: map[string]string{
+				"Referer":      "http://evil.test/page",
+				csrfHeaderName: testCSRFToken,
+			},
+			cookie: testCSRFToken,
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "opaque origin",
+			header: map[string]string{
+				"Origin":       "null",
+				csrfHeaderName: testCSRFToken,
+			},
+			cookie: testCSRFToken,
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "cross-site form post",
+			header: map[string]string{
+				"Origin":       "http://evil.test",
+				"Content-Type": "application/x-www-form-urlencoded",
+			},
+			cookie: testCSRFToken,
+			form:   url.Values{csrfFormField: {testCSRFToken}}.Encode(),
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "same origin with header token",
+			header: map[string]string{
+				"Origin":       "http://example.test",
+				csrfHeaderName: testCSRFToken,
+			},
+			cookie: testCSRFToken,
+			want:   http.StatusOK,
+		},
+		{
+			name: "same-origin referer with header token",
+			header: map[string]string{
+				"Referer":      "http://example.test/licenses/0",
+				csrfHeaderName: testCSRFToken,
+			},
+			cookie: testCSRFToken,
+			want:   http.StatusOK,
+		},
+		{
+			name: "same-origin form post with field token",
+			header: map[string]string{
+				"Origin":       "http://example.test",
+				"Content-Type": "application/x-www-form-urlencoded",
+			},
+			cookie: testCSRFToken,
+			form:   url.Values{csrfFormField: {testCSRFToken}}.Encode(),
+			want:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.test/validate-license", strings.NewReader(tt.form))
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %q)", recorder.Code, tt.want, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestCSRFTrustsForwardedHostBehindProxy(t *testing.T) {
+	req := httptest.NewRequest("POST", "http://127.0.0.1:8086/validate-license", nil)
+	req.Header.Set("Origin", "https://licenses.example.test")
+	req.Header.Set("X-Forwarded-Host", "licenses.example.test")
+	req.Header.Set(csrfHeaderName, testCSRFToken)
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: testCSRFToken})
+
+	for _, trust := range []bool{false, true} {
+		app := &App{config: Config{TrustProxyHeaders: trust}}
+		recorder := httptest.NewRecorder()
+		csrfTestHandler(app).ServeHTTP(recorder, req)
+
+		want := http.StatusForbidden
+		if trust {
+			want = http.StatusOK
+		}
+		if recorder.Code != want {
+			t.Errorf("trust_proxy_headers=%v: status = %d, want %d", trust, recorder.Code, want)
+		}
+	}
+}
+
+func TestCSRFIssuesTokenOnSafeRequests(t *testing.T) {
+	var seen string
+	handler := (&App{}).csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = csrfToken(r)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("cookies = %v, want one %s cookie", cookies, csrfCookieName)
+	}
+	cookie := cookies[0]
+	if cookie.Value == "" || cookie.Value != seen {
+		t.Errorf("cookie token %q does not match page token %q", cookie.Value, seen)
+	}
+	if cookie.SameSite != http.SameSiteStrictMode || !cookie.HttpOnly {
+		t.Errorf("cookie SameSite=%v HttpOnly=%v, want Strict and HttpOnly", cookie.SameSite, cookie.HttpOnly)
+	}
+
+	// An existing token is reused rather than rotated on every page load
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: testCSRFToken})
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if len(recorder.Result().Cookies()) != 0 {
+		t.Error("existing CSRF cookie was replaced")
+	}
+	if seen != testCSRFToken {
+		t.Errorf("page token = %q, want %q", seen, testCSRFToken)
+	}
+}
+
+// TestCSRFProtectsRoutes sends cross-site requests through the real router,
+// which must reject them before they reach the handlers, and checks that the
+// public verification endpoint, webhooks and the API-key authenticated
+// /api/v1 do not require a token.
+func TestCSRFProtectsRoutes(t *testing.T) {
+	router := (&App{}).routes()
+
+	protected := []string{
+		"/setup/submit",
+		"/validate-license",
+		"/bulk-validate",
+		"/throttling/unban",
+		"/api-keys",
+		"/users",
+		"/login",
+		"/logout",
+		"/audit/verify",
+	}
+	for _, path := range protected {
+		req := httptest.NewRequest("POST", "http://example.test"+path, strings.NewReader("{}"))
+		req.Header.Set("Origin", "http://evil.test")
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("POST %s from another origin: status %d, want 403", path, recorder.Code)
+		}
+	}
+
+	handler := csrfTestHandler(&App{})
+	for _, path := range []string{"/v1/licenses/verify", "/webhooks/gumroad", apiV1Prefix + "/licenses/rotate"} {
+		req := httptest.NewRequest("POST", "http://example.test"+path, strings.NewReader("{}"))
+		req.Header.Set("Origin", "http://evil.test")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("POST %s: status %d, want it exempt from CSRF checks", path, recorder.Code)
+		}
+	}
+}
@@ -2,4 +2,7 @@ module gumroad-license-manager
 
 go 1.21
 
-require github.com/gorilla/mux v1.8.1
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/gorilla/mux v1.8.1
+)
@@ -0,0 +1,314 @@
+// Package gumroadsim is a local stand-in for the parts of the Gumroad API
+// the license manager uses: products and their variants and custom fields,
+// offer codes, subscribers, paged sales and their refunds, receipts and
+// shipping, license verification and write actions, and resource
+// subscriptions. Its data comes from a JSON fixture, which can be generated
+// from a seed, and it can inject latency, rate limiting and server errors and
+// fire webhook pings.
+package gumroadsim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// Fixture is the simulator's data set. It is also the format of fixture
+// files, so a generated fixture can be saved, edited and loaded again.
+type Fixture struct {
+	// AccessToken is the token API requests must present
+	AccessToken string       `json:"access_token"`
+	Products    []Product    `json:"products"`
+	Sales       []Sale       `json:"sales"`
+	Subscribers []Subscriber `json:"subscribers,omitempty"`
+	OfferCodes  []OfferCode  `json:"offer_codes,omitempty"`
+}
+
+type Product struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       int    `json:"price"`
+	Currency    string `json:"currency"`
+	Permalink   string `json:"custom_permalink"`
+	Published   bool   `json:"published"`
+	// Recurrence is set ("monthly", "yearly") for membership products
+	Recurrence string `json:"subscription_duration,omitempty"`
+	// RequireShipping is set for physical products, whose sales carry a
+	// shipping address and can be marked as shipped
+	RequireShipping   bool              `json:"require_shipping,omitempty"`
+	VariantCategories []VariantCategory `json:"variant_categories,omitempty"`
+	CustomFields      []CustomField     `json:"custom_fields,omitempty"`
+	FileInfo          map[string]string `json:"file_info,omitempty"`
+}
+
+// VariantCategory is a group of variants buyers choose one of, such as a
+// license tier or a size.
+type VariantCategory struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Variants []Variant `json:"variants"`
+}
+
+type Variant struct {
+	ID                   string `json:"id"`
+	Name                 string `json:"name"`
+	PriceDifferenceCents int    `json:"price_difference_cents"`
+	MaxPurchaseCount     *int   `json:"max_purchase_count"`
+}
+
+// CustomField is a question asked at checkout.
+type CustomField struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+}
+
+// Sale is a purchase. Sales of products with licenses carry the license
+// state too, since Gumroad keys licenses by purchase.
+type Sale struct {
+	ID             string `json:"id"`
+	ProductID      string `json:"product_id"`
+	Email          string `json:"email"`
+	Price          int    `json:"price"`
+	Currency       string `json:"currency"`
+	OrderID        int64  `json:"order_id"`
+	CreatedAt      string `json:"created_at"`
+	PurchaserID    string `json:"purchaser_id"`
+	Refunded       bool   `json:"refunded"`
+	Disputed       bool   `json:"disputed"`
+	Chargebacked   bool   `json:"chargebacked"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	// OfferCode is the name of the offer code the sale was bought with
+	OfferCode  string `json:"offer_code,omitempty"`
+	LicenseKey string `json:"license_key,omitempty"`
+	// LicenseUses and LicenseDisabled are the license's verification count
+	// and enabled state
+	LicenseUses     int  `json:"license_uses,omitempty"`
+	LicenseDisabled bool `json:"license_disabled,omitempty"`
+	// RefundedCents is how much of the price has been refunded; Refunded is
+	// set once that is all of it
+	RefundedCents int    `json:"refunded_cents,omitempty"`
+	Shipped       bool   `json:"shipped,omitempty"`
+	TrackingURL   string `json:"tracking_url,omitempty"`
+	// ReceiptsSent counts the receipts resent with /resend_receipt
+	ReceiptsSent int `json:"receipts_sent,omitempty"`
+}
+
+type Subscriber struct {
+	ID                    string   `json:"id"`
+	ProductID             string   `json:"product_id"`
+	ProductName           string   `json:"product_name"`
+	UserID                string   `json:"user_id"`
+	UserEmail             string   `json:"user_email"`
+	PurchaseIDs           []string `json:"purchase_ids"`
+	CreatedAt             string   `json:"created_at"`
+	CancelledAt           *string  `json:"cancelled_at"`
+	EndedAt               *string  `json:"ended_at"`
+	FailedAt              *string  `json:"failed_at"`
+	ChargeOccurrenceCount *int     `json:"charge_occurrence_count"`
+	Recurrence            string   `json:"recurrence"`
+	Status                string   `json:"status"`
+}
+
+// OfferCode is a product's discount code, taking either a fixed amount or a
+// percentage off. Its times_used is counted from the sales.
+type OfferCode struct {
+	ID               string `json:"id"`
+	ProductID        string `json:"product_id"`
+	Name             string `json:"name"`
+	AmountCents      *int   `json:"amount_cents,omitempty"`
+	PercentOff       *int   `json:"percent_off,omitempty"`
+	MaxPurchaseCount *int   `json:"max_purchase_count"`
+	Universal        bool   `json:"universal"`
+}
+
+// LoadFixture reads a fixture file.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &fixture, nil
+}
+
+// Save writes the fixture to a file.
+func (f *Fixture) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+var (
+	productNames = []string{"Pixel Icons Pro", "Markdown Studio", "Focus Timer", "Invoice Kit", "Sound Pack Vol. 2", "Regex Workbench"}
+	firstNames   = []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy", "mallory", "oscar"}
+	mailDomains  = []string{"example.com", "example.org", "example.net"}
+	currencies   = []string{"usd", "usd", "usd", "eur", "gbp"}
+)
+
+// Generate builds a fixture from a seed, so the same seed always gives the
+// same products, sales, license keys and subscribers. Every other product is
+// a monthly membership with subscribers.
+func Generate(seed int64, products, salesPerProduct int) *Fixture {
+	rng := rand.New(rand.NewSource(seed))
+	fixture := &Fixture{AccessToken: "sim-token"}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	orderID := int64(100000)
+
+	for p := 0; p < products; p++ {
+		name := productNames[p%len(productNames)]
+		if p >= len(productNames) {
+			name = fmt.Sprintf("%s %d", name, p/len(productNames)+1)
+		}
+		product := Product{
+			ID:          randomID(rng, 22),
+			Name:        name,
+			Description: "Simulated product for local development.",
+			Price:       (rng.Intn(9) + 1) * 500,
+			Currency:    currencies[rng.Intn(len(currencies))],
+			Permalink:   strings.ToLower(strings.ReplaceAll(name, " ", "-")),
+			Published:   true,
+		}
+		if p%2 == 1 {
+			product.Recurrence = "monthly"
+		}
+		fixture.Products = append(fixture.Products, product)
+
+		for s := 0; s < salesPerProduct; s++ {
+			orderID++
+			email := fmt.Sprintf("%s%d@%s", firstNames[rng.Intn(len(firstNames))], rng.Intn(1000), mailDomains[rng.Intn(len(mailDomains))])
+			created := start.Add(time.Duration(rng.Int63n(int64(365 * 24 * time.Hour))))
+			sale := Sale{
+				ID:          randomID(rng, 22),
+				ProductID:   product.ID,
+				Email:       email,
+				Price:       product.Price,
+				Currency:    product.Currency,
+				OrderID:     orderID,
+				CreatedAt:   created.Format(time.RFC3339),
+				PurchaserID: randomID(rng, 12),
+				LicenseKey:  randomLicenseKey(rng),
+				LicenseUses: rng.Intn(4),
+			}
+			switch n := rng.Intn(40); {
+			case n == 0:
+				sale.Refunded = true
+			case n == 1:
+				sale.Disputed = true
+			case n == 2:
+				sale.Chargebacked = true
+			case n == 3:
+				sale.LicenseDisabled = true
+			}
+
+			if product.Recurrence != "" {
+				sale.SubscriptionID = randomID(rng, 22)
+				charges := rng.Intn(12) + 1
+				subscriber := Subscriber{
+					ID:                    sale.SubscriptionID,
+					ProductID:             product.ID,
+					ProductName:           product.Name,
+					UserID:                sale.PurchaserID,
+					UserEmail:             sale.Email,
+					PurchaseIDs:           []string{sale.ID},
+					CreatedAt:             sale.CreatedAt,
+					ChargeOccurrenceCount: &charges,
+					Recurrence:            product.Recurrence,
+					Status:                "alive",
+				}
+				if rng.Intn(5) == 0 {
+					cancelled := created.Add(time.Duration(charges) * 30 * 24 * time.Hour).Format(time.RFC3339)
+					subscriber.CancelledAt = &cancelled
+					subscriber.Status = "cancelled"
+				}
+				fixture.Subscribers = append(fixture.Subscribers, subscriber)
+			}
+			fixture.Sales = append(fixture.Sales, sale)
+		}
+	}
+	addOfferCodes(fixture, rand.New(rand.NewSource(seed+1)))
+	addProductDetails(fixture, rand.New(rand.NewSource(seed+2)))
+	return fixture
+}
+
+// addProductDetails gives products file info, every other product a license
+// tier variant category and a checkout question, and makes every third
+// product physical. Like addOfferCodes it has its own random source.
+func addProductDetails(fixture *Fixture, rng *rand.Rand) {
+	for i := range fixture.Products {
+		product := &fixture.Products[i]
+		product.FileInfo = map[string]string{
+			"Size": fmt.Sprintf("%.1f MB", float64(rng.Intn(500)+10)/10),
+		}
+		product.RequireShipping = i%3 == 2
+		if i%2 == 1 {
+			continue
+		}
+		product.VariantCategories = []VariantCategory{{
+			ID:    randomID(rng, 22),
+			Title: "License",
+			Variants: []Variant{
+				{ID: randomID(rng, 22), Name: "Personal"},
+				{ID: randomID(rng, 22), Name: "Team", PriceDifferenceCents: product.Price * 2},
+			},
+		}}
+		product.CustomFields = []CustomField{{Name: "Company"}}
+	}
+}
+
+// addOfferCodes gives each product a percentage and a limited fixed amount
+// code and applies them to some of its sales. They use their own random
+// source, so a seed's other data is the same as before offer codes existed.
+func addOfferCodes(fixture *Fixture, rng *rand.Rand) {
+	for _, product := range fixture.Products {
+		percent, amount, limit := 20, product.Price/5, 10
+		prefix := strings.ToUpper(strings.Fields(product.Name)[0])
+		codes := []OfferCode{
+			{ID: randomID(rng, 22), ProductID: product.ID, Name: prefix + "20", PercentOff: &percent},
+			{ID: randomID(rng, 22), ProductID: product.ID, Name: "FRIENDS", AmountCents: &amount, MaxPurchaseCount: &limit},
+		}
+		fixture.OfferCodes = append(fixture.OfferCodes, codes...)
+
+		for i := range fixture.Sales {
+			sale := &fixture.Sales[i]
+			if sale.ProductID != product.ID || rng.Intn(4) != 0 {
+				continue
+			}
+			code := codes[rng.Intn(len(codes))]
+			sale.OfferCode = code.Name
+			if code.PercentOff != nil {
+				sale.Price -= sale.Price * *code.PercentOff / 100
+			} else {
+				sale.Price -= *code.AmountCents
+			}
+		}
+	}
+}
+
+const idAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+func randomID(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = idAlphabet[rng.Intn(len(idAlphabet))]
+	}
+	return string(b)
+}
+
+// randomLicenseKey returns a key in Gumroad's format, four groups of eight
+// uppercase hex characters.
+func randomLicenseKey(rng *rand.Rand) string {
+	groups := make([]string, 4)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%08X", rng.Uint32())
+	}
+	return strings.Join(groups, "-")
+}
@@ -0,0 +1,311 @@
+package gumroadsim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*Server, *Fixture, *httptest.Server) {
+	t.Helper()
+	fixture := Generate(7, 2, 25)
+	sim := New(fixture, 7)
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return sim, fixture, server
+}
+
+func call(t *testing.T, method, target string, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	if !reflect.DeepEqual(Generate(42, 3, 10), Generate(42, 3, 10)) {
+		t.Error("the same seed generated different fixtures")
+	}
+}
+
+func TestSalesPaging(t *testing.T) {
+	_, fixture, server := newTestServer(t)
+
+	seen := map[string]bool{}
+	next := server.URL + "/v2/sales?access_token=" + fixture.AccessToken
+	pages := 0
+	for next != "" {
+		status, body := call(t, "GET", next, nil)
+		if status != http.StatusOK {
+			t.Fatalf("GET %s: status %d", next, status)
+		}
+		pages++
+		for _, sale := range body["sales"].([]interface{}) {
+			seen[sale.(map[string]interface{})["id"].(string)] = true
+		}
+		next = ""
+		if key, ok := body["next_page_key"].(string); ok {
+			next = server.URL + "/v2/sales?access_token=" + fixture.AccessToken + "&page_key=" + key
+		}
+	}
+	if len(seen) != len(fixture.Sales) || pages != 5 {
+		t.Errorf("paged through %d sales in %d pages, want %d in 5", len(seen), pages, len(fixture.Sales))
+	}
+
+	if status, _ := call(t, "GET", server.URL+"/v2/sales", nil); status != http.StatusUnauthorized {
+		t.Errorf("sales without a token: status %d, want 401", status)
+	}
+}
+
+func TestLicenseActions(t *testing.T) {
+	_, fixture, server := newTestServer(t)
+
+	var sale Sale
+	for _, s := range fixture.Sales {
+		if !s.LicenseDisabled {
+			sale = s
+			break
+		}
+	}
+	form := url.Values{"product_id": {sale.ProductID}, "license_key": {sale.LicenseKey}}
+	auth := url.Values{"access_token": {fixture.AccessToken}, "product_id": {sale.ProductID}, "license_key": {sale.LicenseKey}}
+
+	status, body := call(t, "POST", server.URL+"/v2/licenses/verify", form)
+	if status != http.StatusOK || body["uses"].(float64) != float64(sale.LicenseUses+1) {
+		t.Fatalf("verify: status %d, body %v", status, body)
+	}
+
+	if status, _ := call(t, "PUT", server.URL+"/v2/licenses/disable", auth); status != http.StatusOK {
+		t.Fatalf("disable: status %d", status)
+	}
+	if status, _ := call(t, "POST", server.URL+"/v2/licenses/verify", form); status != http.StatusNotFound {
+		t.Errorf("verify disabled license: status %d, want 404", status)
+	}
+	call(t, "PUT", server.URL+"/v2/licenses/enable", auth)
+
+	status, body = call(t, "PUT", server.URL+"/v2/licenses/rotate", auth)
+	newKey := body["purchase"].(map[string]interface{})["license_key"].(string)
+	if status != http.StatusOK || newKey == sale.LicenseKey {
+		t.Fatalf("rotate: status %d, key %q", status, newKey)
+	}
+	if status, _ := call(t, "POST", server.URL+"/v2/licenses/verify", form); status != http.StatusNotFound {
+		t.Errorf("verify rotated-out key: status %d, want 404", status)
+	}
+}
+
+func TestOfferCodes(t *testing.T) {
+	_, fixture, server := newTestServer(t)
+	product := fixture.Products[0]
+	base := server.URL + "/v2/products/" + product.ID + "/offer_codes"
+	auth := url.Values{"access_token": {fixture.AccessToken}}
+
+	status, body := call(t, "GET", base+"?"+auth.Encode(), nil)
+	codes := body["offer_codes"].([]interface{})
+	if status != http.StatusOK || len(codes) != 2 {
+		t.Fatalf("list: status %d, body %v", status, body)
+	}
+	used := 0
+	for _, sale := range fixture.Sales {
+		if sale.ProductID == product.ID && sale.OfferCode != "" {
+			used++
+		}
+	}
+	counted := codes[0].(map[string]interface{})["times_used"].(float64) + codes[1].(map[string]interface{})["times_used"].(float64)
+	if int(counted) != used {
+		t.Errorf("times_used adds up to %v, want %d", counted, used)
+	}
+
+	create := url.Values{"access_token": {fixture.AccessToken}, "name": {"GIVEAWAY1"}, "amount_off": {"100"}, "offer_type": {"percent"}, "max_purchase_count": {"1"}}
+	status, body = call(t, "POST", base, create)
+	if status != http.StatusOK {
+		t.Fatalf("create: status %d, body %v", status, body)
+	}
+	code := body["offer_code"].(map[string]interface{})
+	if code["percent_off"].(float64) != 100 || code["max_purchase_count"].(float64) != 1 {
+		t.Errorf("created %v", code)
+	}
+	if status, _ := call(t, "POST", base, create); status != http.StatusBadRequest {
+		t.Errorf("duplicate name: status %d, want 400", status)
+	}
+
+	id := code["id"].(string)
+	status, body = call(t, "PUT", base+"/"+id, url.Values{"access_token": {fixture.AccessToken}, "max_purchase_count": {"5"}})
+	if status != http.StatusOK || body["offer_code"].(map[string]interface{})["max_purchase_count"].(float64) != 5 {
+		t.Errorf("update: status %d, body %v", status, body)
+	}
+	if status, _ := call(t, "DELETE", base+"/"+id+"?"+auth.Encode(), nil); status != http.StatusOK {
+		t.Errorf("delete: status %d", status)
+	}
+	if status, _ := call(t, "GET", base+"/"+id+"?"+auth.Encode(), nil); status != http.StatusNotFound {
+		t.Errorf("get deleted code: status %d, want 404", status)
+	}
+}
+
+func TestProductEditing(t *testing.T) {
+	_, fixture, server := newTestServer(t)
+	product := fixture.Products[0]
+	base := server.URL + "/v2/products/" + product.ID
+	auth := url.Values{"access_token": {fixture.AccessToken}}
+
+	status, body := call(t, "PUT", base+"/disable", auth)
+	if status != http.StatusOK || body["product"].(map[string]interface{})["published"] != false {
+		t.Fatalf("disable: status %d, body %v", status, body)
+	}
+
+	status, body = call(t, "POST", base+"/variant_categories", url.Values{"access_token": {fixture.AccessToken}, "title": {"Size"}})
+	if status != http.StatusOK {
+		t.Fatalf("create category: status %d, body %v", status, body)
+	}
+	categoryURL := base + "/variant_categories/" + body["variant_category"].(map[string]interface{})["id"].(string)
+	status, body = call(t, "POST", categoryURL+"/variants", url.Values{"access_token": {fixture.AccessToken}, "name": {"Large"}, "price_difference_cents": {"250"}})
+	if status != http.StatusOK || body["variant"].(map[string]interface{})["price_difference_cents"].(float64) != 250 {
+		t.Fatalf("create variant: status %d, body %v", status, body)
+	}
+	if status, _ := call(t, "POST", categoryURL+"/variants", url.Values{"access_token": {fixture.AccessToken}}); status != http.StatusBadRequest {
+		t.Errorf("variant without a name: status %d, want 400", status)
+	}
+
+	status, body = call(t, "POST", base+"/custom_fields", url.Values{"access_token": {fixture.AccessToken}, "name": {"VAT ID"}, "required": {"true"}})
+	if status != http.StatusOK {
+		t.Fatalf("create custom field: status %d, body %v", status, body)
+	}
+	if status, _ := call(t, "PUT", base+"/custom_fields/"+url.PathEscape("VAT ID"), url.Values{"access_token": {fixture.AccessToken}, "required": {"false"}}); status != http.StatusOK {
+		t.Errorf("update custom field: status %d", status)
+	}
+
+	status, body = call(t, "GET", base+"?"+auth.Encode(), nil)
+	got := body["product"].(map[string]interface{})
+	variants := got["variants"].([]interface{})
+	last := variants[len(variants)-1].(map[string]interface{})
+	if status != http.StatusOK || last["title"] != "Size" || len(last["options"].([]interface{})) != 1 {
+		t.Errorf("product variants %v", got["variants"])
+	}
+	fields := got["custom_fields"].([]interface{})
+	if field := fields[len(fields)-1].(map[string]interface{}); field["name"] != "VAT ID" || field["required"] != false {
+		t.Errorf("product custom fields %v", fields)
+	}
+
+	if status, _ := call(t, "DELETE", categoryURL+"?"+auth.Encode(), nil); status != http.StatusOK {
+		t.Errorf("delete category: status %d", status)
+	}
+	if status, _ := call(t, "GET", categoryURL+"/variants?"+auth.Encode(), nil); status != http.StatusNotFound {
+		t.Errorf("variants of deleted category: status %d, want 404", status)
+	}
+}
+
+func TestSaleActions(t *testing.T) {
+	sim, fixture, server := newTestServer(t)
+	auth := url.Values{"access_token": {fixture.AccessToken}}
+	// The test fixture's two products are digital, so one is made physical
+	sim.mu.Lock()
+	fixture.Products[1].RequireShipping = true
+	sim.mu.Unlock()
+	var digital, physical Sale
+	for _, sale := range fixture.Sales {
+		switch {
+		case sale.Refunded || sale.Chargebacked:
+		case sale.ProductID == fixture.Products[1].ID && physical.ID == "":
+			physical = sale
+		case sale.ProductID == fixture.Products[0].ID && digital.ID == "":
+			digital = sale
+		}
+	}
+	base := server.URL + "/v2/sales/"
+
+	status, body := call(t, "PUT", base+digital.ID+"/refund", url.Values{"access_token": {fixture.AccessToken}, "amount_cents": {"100"}})
+	sale := body["sale"].(map[string]interface{})
+	if status != http.StatusOK || sale["partially_refunded"] != true || sale["refunded"] != false {
+		t.Fatalf("partial refund: status %d, body %v", status, body)
+	}
+	if status, _ := call(t, "PUT", base+digital.ID+"/refund", url.Values{"access_token": {fixture.AccessToken}, "amount_cents": {strconv.Itoa(digital.Price)}}); status != http.StatusBadRequest {
+		t.Errorf("refund over the remaining amount: status %d, want 400", status)
+	}
+	status, body = call(t, "PUT", base+digital.ID+"/refund", auth)
+	if status != http.StatusOK || body["sale"].(map[string]interface{})["refunded"] != true {
+		t.Errorf("refund of the rest: status %d, body %v", status, body)
+	}
+
+	if status, _ := call(t, "PUT", base+digital.ID+"/mark_as_shipped", auth); status != http.StatusBadRequest {
+		t.Errorf("shipping a digital sale: status %d, want 400", status)
+	}
+	status, body = call(t, "PUT", base+physical.ID+"/mark_as_shipped", url.Values{"access_token": {fixture.AccessToken}, "tracking_url": {"https://track.example/1"}})
+	sale = body["sale"].(map[string]interface{})
+	if status != http.StatusOK || sale["shipped"] != true || sale["tracking_url"] != "https://track.example/1" {
+		t.Errorf("mark as shipped: status %d, body %v", status, body)
+	}
+
+	if status, _ := call(t, "POST", base+physical.ID+"/resend_receipt", auth); status != http.StatusOK {
+		t.Errorf("resend receipt: status %d", status)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	sim, fixture, server := newTestServer(t)
+
+	sim.SetFaults(Faults{RateLimitRate: 1})
+	status, _ := call(t, "GET", server.URL+"/v2/products?access_token="+fixture.AccessToken, nil)
+	if status != http.StatusTooManyRequests {
+		t.Errorf("rate_limit_rate 1: status %d, want 429", status)
+	}
+
+	sim.SetFaults(Faults{ErrorRate: 1})
+	status, _ = call(t, "GET", server.URL+"/v2/products?access_token="+fixture.AccessToken, nil)
+	if status < 500 {
+		t.Errorf("error_rate 1: status %d, want a 5xx", status)
+	}
+
+	// Control endpoints are never faulted
+	if status, _ := call(t, "GET", server.URL+"/_sim/faults", nil); status != http.StatusOK {
+		t.Errorf("GET /_sim/faults: status %d", status)
+	}
+}
+
+func TestPing(t *testing.T) {
+	_, fixture, server := newTestServer(t)
+
+	received := make(chan url.Values, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		received <- r.PostForm
+	}))
+	defer receiver.Close()
+
+	status, _ := call(t, "PUT", server.URL+"/v2/resource_subscriptions", url.Values{
+		"access_token":  {fixture.AccessToken},
+		"resource_name": {"refund"},
+		"post_url":      {receiver.URL},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("subscribe: status %d", status)
+	}
+
+	sale := fixture.Sales[0]
+	status, body := call(t, "POST", server.URL+"/_sim/ping", url.Values{"resource_name": {"refund"}, "sale_id": {sale.ID}})
+	if status != http.StatusOK || len(body["deliveries"].([]interface{})) != 1 {
+		t.Fatalf("ping: status %d, body %v", status, body)
+	}
+	ping := <-received
+	if ping.Get("sale_id") != sale.ID || ping.Get("refunded") != "true" {
+		t.Errorf("ping form = %v", ping)
+	}
+
+	_, body = call(t, "GET", server.URL+"/v2/sales/"+sale.ID+"?access_token="+fixture.AccessToken, nil)
+	if body["sale"].(map[string]interface{})["refunded"] != true {
+		t.Error("refund ping did not mark the sale refunded")
+	}
+}
@@ -0,0 +1,1426 @@
+package gumroadsim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// salesPageSize matches the page size of Gumroad's /v2/sales.
+const salesPageSize = 10
+
+// Resources that can be subscribed to with /v2/resource_subscriptions.
+var resourceNames = []string{"sale", "refund", "dispute", "dispute_won", "cancellation", "subscription_updated", "subscription_ended", "subscription_restarted"}
+
+// Faults makes the simulator misbehave like the real API sometimes does.
+// Rates are probabilities between 0 and 1 applied to each /v2 request.
+type Faults struct {
+	Latency       time.Duration
+	Jitter        time.Duration
+	ErrorRate     float64
+	RateLimitRate float64
+}
+
+// faultsJSON is Faults as sent to and returned by /_sim/faults, with
+// durations written like "250ms".
+type faultsJSON struct {
+	Latency       string  `json:"latency"`
+	Jitter        string  `json:"jitter"`
+	ErrorRate     float64 `json:"error_rate"`
+	RateLimitRate float64 `json:"rate_limit_rate"`
+}
+
+type ResourceSubscription struct {
+	ID           string `json:"id"`
+	ResourceName string `json:"resource_name"`
+	PostURL      string `json:"post_url"`
+}
+
+// Server is an http.Handler serving the simulated API under /v2 and the
+// simulator's own control endpoints under /_sim.
+type Server struct {
+	mu            sync.Mutex
+	fixture       *Fixture
+	faults        Faults
+	rng           *rand.Rand
+	subscriptions []ResourceSubscription
+	client        *http.Client
+}
+
+func New(fixture *Fixture, seed int64) *Server {
+	return &Server{
+		fixture: fixture,
+		rng:     rand.New(rand.NewSource(seed)),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *Server) SetFaults(faults Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = faults
+}
+
+// Fixture returns a copy of the current data, including changes made by
+// license actions and pings.
+func (s *Server) Fixture() Fixture {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fixture := *s.fixture
+	fixture.Products = append([]Product(nil), s.fixture.Products...)
+	fixture.Sales = append([]Sale(nil), s.fixture.Sales...)
+	fixture.Subscribers = append([]Subscriber(nil), s.fixture.Subscribers...)
+	fixture.OfferCodes = append([]OfferCode(nil), s.fixture.OfferCodes...)
+	return fixture
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeFailure(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"message": message,
+	})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/_sim/"):
+		s.serveControl(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v2/"):
+		if s.injectFault(w) {
+			return
+		}
+		s.serveAPI(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// injectFault sleeps for the configured latency and then, depending on the
+// configured rates, answers with a 429 or a 5xx instead of the real response.
+func (s *Server) injectFault(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	faults := s.faults
+	delay := faults.Latency
+	if faults.Jitter > 0 {
+		delay += time.Duration(s.rng.Int63n(int64(faults.Jitter)))
+	}
+	roll := s.rng.Float64()
+	status := []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}[s.rng.Intn(3)]
+	s.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	switch {
+	case roll < faults.RateLimitRate:
+		w.Header().Set("Retry-After", "1")
+		writeFailure(w, http.StatusTooManyRequests, "Too many requests. Please try again later.")
+		return true
+	case roll < faults.RateLimitRate+faults.ErrorRate:
+		if status == http.StatusBadGateway {
+			// Errors from the load balancer are not JSON
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(status)
+			fmt.Fprint(w, "<html><body><h1>502 Bad Gateway</h1></body></html>")
+			return true
+		}
+		writeFailure(w, status, "Something went wrong.")
+		return true
+	}
+	return false
+}
+
+// authorized checks the access token, sent as a bearer token or an
+// access_token parameter.
+func (s *Server) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.FormValue("access_token")
+	}
+	return token != "" && token == s.fixture.AccessToken
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/"), "/"), "/")
+	r.ParseForm()
+
+	// License verification is the one endpoint Gumroad serves without a token
+	if len(parts) == 2 && parts[0] == "licenses" && parts[1] == "verify" {
+		if r.Method != "POST" {
+			writeFailure(w, http.StatusNotFound, "Not found")
+			return
+		}
+		s.licenseAction(w, r, "verify")
+		return
+	}
+
+	if !s.authorized(r) {
+		writeFailure(w, http.StatusUnauthorized, "The access token is invalid.")
+		return
+	}
+
+	route := r.Method + " " + parts[0]
+	switch {
+	case route == "GET products" && len(parts) == 1:
+		s.listProducts(w)
+	case route == "GET products" && len(parts) == 2:
+		s.getProduct(w, parts[1])
+	case route == "PUT products" && len(parts) == 3 && (parts[2] == "enable" || parts[2] == "disable"):
+		s.setPublished(w, parts[1], parts[2] == "enable")
+	case route == "GET products" && len(parts) == 3 && parts[2] == "variant_categories":
+		s.listVariantCategories(w, parts[1])
+	case route == "POST products" && len(parts) == 3 && parts[2] == "variant_categories":
+		s.createVariantCategory(w, r, parts[1])
+	case route == "GET products" && len(parts) == 4 && parts[2] == "variant_categories":
+		s.getVariantCategory(w, parts[1], parts[3])
+	case route == "PUT products" && len(parts) == 4 && parts[2] == "variant_categories":
+		s.updateVariantCategory(w, r, parts[1], parts[3])
+	case route == "DELETE products" && len(parts) == 4 && parts[2] == "variant_categories":
+		s.deleteVariantCategory(w, parts[1], parts[3])
+	case route == "GET products" && len(parts) == 5 && parts[2] == "variant_categories" && parts[4] == "variants":
+		s.listVariants(w, parts[1], parts[3])
+	case route == "POST products" && len(parts) == 5 && parts[2] == "variant_categories" && parts[4] == "variants":
+		s.createVariant(w, r, parts[1], parts[3])
+	case route == "PUT products" && len(parts) == 6 && parts[2] == "variant_categories" && parts[4] == "variants":
+		s.updateVariant(w, r, parts[1], parts[3], parts[5])
+	case route == "DELETE products" && len(parts) == 6 && parts[2] == "variant_categories" && parts[4] == "variants":
+		s.deleteVariant(w, parts[1], parts[3], parts[5])
+	case route == "GET products" && len(parts) == 3 && parts[2] == "custom_fields":
+		s.listCustomFields(w, parts[1])
+	case route == "POST products" && len(parts) == 3 && parts[2] == "custom_fields":
+		s.createCustomField(w, r, parts[1])
+	case route == "PUT products" && len(parts) == 4 && parts[2] == "custom_fields":
+		s.updateCustomField(w, r, parts[1], parts[3])
+	case route == "DELETE products" && len(parts) == 4 && parts[2] == "custom_fields":
+		s.deleteCustomField(w, parts[1], parts[3])
+	case route == "GET products" && len(parts) == 3 && parts[2] == "subscribers":
+		s.listSubscribers(w, r, parts[1])
+	case route == "GET products" && len(parts) == 3 && parts[2] == "offer_codes":
+		s.listOfferCodes(w, parts[1])
+	case route == "GET products" && len(parts) == 4 && parts[2] == "offer_codes":
+		s.getOfferCode(w, parts[1], parts[3])
+	case route == "POST products" && len(parts) == 3 && parts[2] == "offer_codes":
+		s.createOfferCode(w, r, parts[1])
+	case route == "PUT products" && len(parts) == 4 && parts[2] == "offer_codes":
+		s.updateOfferCode(w, r, parts[1], parts[3])
+	case route == "DELETE products" && len(parts) == 4 && parts[2] == "offer_codes":
+		s.deleteOfferCode(w, parts[1], parts[3])
+	case route == "GET subscribers" && len(parts) == 2:
+		s.getSubscriber(w, parts[1])
+	case route == "GET sales" && len(parts) == 1:
+		s.listSales(w, r)
+	case route == "GET sales" && len(parts) == 2:
+		s.getSale(w, parts[1])
+	case route == "PUT sales" && len(parts) == 3 && parts[2] == "refund":
+		s.refundSale(w, r, parts[1])
+	case route == "PUT sales" && len(parts) == 3 && parts[2] == "mark_as_shipped":
+		s.markSaleShipped(w, r, parts[1])
+	case route == "POST sales" && len(parts) == 3 && parts[2] == "resend_receipt":
+		s.resendReceipt(w, parts[1])
+	case route == "PUT licenses" && len(parts) == 2:
+		s.licenseAction(w, r, parts[1])
+	case route == "GET resource_subscriptions" && len(parts) == 1:
+		s.listResourceSubscriptions(w, r)
+	case route == "PUT resource_subscriptions" && len(parts) == 1:
+		s.createResourceSubscription(w, r)
+	case route == "DELETE resource_subscriptions" && len(parts) == 2:
+		s.deleteResourceSubscription(w, parts[1])
+	default:
+		writeFailure(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) listProducts(w http.ResponseWriter) {
+	s.mu.Lock()
+	products := []map[string]interface{}{}
+	for _, product := range s.fixture.Products {
+		products = append(products, s.productJSON(product))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"products": products,
+	})
+}
+
+// productLocked finds a product by ID or permalink. The caller holds s.mu.
+func (s *Server) productLocked(id string) (Product, bool) {
+	if i, ok := s.productIndexLocked(id); ok {
+		return s.fixture.Products[i], true
+	}
+	return Product{}, false
+}
+
+// productIndexLocked is productLocked for callers that change the product.
+func (s *Server) productIndexLocked(id string) (int, bool) {
+	for i, product := range s.fixture.Products {
+		if product.ID == id || (product.Permalink != "" && product.Permalink == id) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// productJSON renders a product the way /v2/products does, with variants
+// grouped by category and sales_count and sales_usd_cents counted from the
+// sales. Like the rest of the simulator it does not convert currencies, so
+// sales_usd_cents is the plain sum of the prices. The caller holds s.mu.
+func (s *Server) productJSON(product Product) map[string]interface{} {
+	salesCount, salesCents := 0, 0
+	for _, sale := range s.fixture.Sales {
+		if sale.ProductID == product.ID && !sale.Refunded {
+			salesCount++
+			salesCents += sale.Price
+		}
+	}
+	variants := []map[string]interface{}{}
+	for _, category := range product.VariantCategories {
+		options := []map[string]interface{}{}
+		for _, variant := range category.Variants {
+			options = append(options, map[string]interface{}{
+				"name":                 variant.Name,
+				"price_difference":     variant.PriceDifferenceCents,
+				"is_pay_what_you_want": false,
+				"recurrence_prices":    nil,
+			})
+		}
+		variants = append(variants, map[string]interface{}{
+			"title":   category.Title,
+			"options": options,
+		})
+	}
+	customFields := append([]CustomField{}, product.CustomFields...)
+	body := map[string]interface{}{
+		"id":               product.ID,
+		"name":             product.Name,
+		"description":      product.Description,
+		"price":            product.Price,
+		"currency":         product.Currency,
+		"custom_permalink": product.Permalink,
+		"short_url":        "https://gum.co/" + product.Permalink,
+		"published":        product.Published,
+		"variants":         variants,
+		"custom_fields":    customFields,
+		"file_info":        product.FileInfo,
+		"sales_count":      salesCount,
+		"sales_usd_cents":  salesCents,
+	}
+	if product.Recurrence != "" {
+		body["subscription_duration"] = product.Recurrence
+	}
+	if product.RequireShipping {
+		body["require_shipping"] = true
+	}
+	return body
+}
+
+func (s *Server) getProduct(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.productLocked(id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"product": s.productJSON(product),
+	})
+}
+
+// setPublished enables or disables a product.
+func (s *Server) setPublished(w http.ResponseWriter, id string, published bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.productIndexLocked(id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	s.fixture.Products[i].Published = published
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"product": s.productJSON(s.fixture.Products[i]),
+	})
+}
+
+// variantCategoryLocked finds a product's variant category by ID. The
+// caller holds s.mu.
+func (s *Server) variantCategoryLocked(productID, id string) (*VariantCategory, bool) {
+	i, ok := s.productIndexLocked(productID)
+	if !ok {
+		return nil, false
+	}
+	for j := range s.fixture.Products[i].VariantCategories {
+		if category := &s.fixture.Products[i].VariantCategories[j]; category.ID == id {
+			return category, true
+		}
+	}
+	return nil, false
+}
+
+func variantCategoryJSON(category VariantCategory) map[string]interface{} {
+	return map[string]interface{}{"id": category.ID, "title": category.Title}
+}
+
+func (s *Server) listVariantCategories(w http.ResponseWriter, productID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.productLocked(productID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	categories := []map[string]interface{}{}
+	for _, category := range product.VariantCategories {
+		categories = append(categories, variantCategoryJSON(category))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":            true,
+		"variant_categories": categories,
+	})
+}
+
+func (s *Server) getVariantCategory(w http.ResponseWriter, productID, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	category, ok := s.variantCategoryLocked(productID, id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The variant_category was not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":          true,
+		"variant_category": variantCategoryJSON(*category),
+	})
+}
+
+func (s *Server) createVariantCategory(w http.ResponseWriter, r *http.Request, productID string) {
+	title := strings.TrimSpace(r.FormValue("title"))
+	if title == "" {
+		writeFailure(w, http.StatusBadRequest, "A variant category needs a title.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.productIndexLocked(productID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	category := VariantCategory{ID: randomID(s.rng, 22), Title: title}
+	s.fixture.Products[i].VariantCategories = append(s.fixture.Products[i].VariantCategories, category)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":          true,
+		"variant_category": variantCategoryJSON(category),
+	})
+}
+
+func (s *Server) updateVariantCategory(w http.ResponseWriter, r *http.Request, productID, id string) {
+	title := strings.TrimSpace(r.FormValue("title"))
+	if title == "" {
+		writeFailure(w, http.StatusBadRequest, "A variant category needs a title.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	category, ok := s.variantCategoryLocked(productID, id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The variant_category was not found.")
+		return
+	}
+	category.Title = title
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":          true,
+		"variant_category": variantCategoryJSON(*category),
+	})
+}
+
+func (s *Server) deleteVariantCategory(w http.ResponseWriter, productID, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.variantCategoryLocked(productID, id); !ok {
+		writeFailure(w, http.StatusNotFound, "The variant_category was not found.")
+		return
+	}
+	i, _ := s.productIndexLocked(productID)
+	product := &s.fixture.Products[i]
+	for j, category := range product.VariantCategories {
+		if category.ID == id {
+			product.VariantCategories = append(product.VariantCategories[:j], product.VariantCategories[j+1:]...)
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "The variant_category was deleted successfully.",
+	})
+}
+
+// variantForm reads a variant's name, price_difference_cents and
+// max_purchase_count, where an empty max_purchase_count means no limit.
+func variantForm(r *http.Request) (Variant, error) {
+	variant := Variant{Name: strings.TrimSpace(r.FormValue("name"))}
+	if variant.Name == "" {
+		return variant, errors.New("A variant needs a name.")
+	}
+	if value := r.FormValue("price_difference_cents"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return variant, errors.New("Invalid price_difference_cents.")
+		}
+		variant.PriceDifferenceCents = n
+	}
+	if value := r.FormValue("max_purchase_count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return variant, errors.New("Invalid max_purchase_count.")
+		}
+		variant.MaxPurchaseCount = &n
+	}
+	return variant, nil
+}
+
+func (s *Server) listVariants(w http.ResponseWriter, productID, categoryID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	category, ok := s.variantCategoryLocked(productID, categoryID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The variant_category was not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"variants": append([]Variant{}, category.Variants...),
+	})
+}
+
+func (s *Server) createVariant(w http.ResponseWriter, r *http.Request, productID, categoryID string) {
+	variant, err := variantForm(r)
+	if err != nil {
+		writeFailure(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	category, ok := s.variantCategoryLocked(productID, categoryID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The variant_category was not found.")
+		return
+	}
+	variant.ID = randomID(s.rng, 22)
+	category.Variants = append(category.Variants, variant)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"variant": variant,
+	})
+}
+
+func (s *Server) updateVariant(w http.ResponseWriter, r *http.Request, productID, categoryID, id string) {
+	variant, err := variantForm(r)
+	if err != nil {
+		writeFailure(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	category, ok := s.variantCategoryLocked(productID, categoryID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The variant_category was not found.")
+		return
+	}
+	for i := range category.Variants {
+		if category.Variants[i].ID == id {
+			variant.ID = id
+			category.Variants[i] = variant
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"variant": variant,
+			})
+			return
+		}
+	}
+	writeFailure(w, http.StatusNotFound, "The variant was not found.")
+}
+
+func (s *Server) deleteVariant(w http.ResponseWriter, productID, categoryID, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	category, ok := s.variantCategoryLocked(productID, categoryID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The variant_category was not found.")
+		return
+	}
+	for i, variant := range category.Variants {
+		if variant.ID == id {
+			category.Variants = append(category.Variants[:i], category.Variants[i+1:]...)
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": "The variant was deleted successfully.",
+			})
+			return
+		}
+	}
+	writeFailure(w, http.StatusNotFound, "The variant was not found.")
+}
+
+func (s *Server) listCustomFields(w http.ResponseWriter, productID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.productLocked(productID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"custom_fields": append([]CustomField{}, product.CustomFields...),
+	})
+}
+
+// createCustomField takes name and required. Names are unique per product,
+// since the other custom field endpoints address fields by name.
+func (s *Server) createCustomField(w http.ResponseWriter, r *http.Request, productID string) {
+	field := CustomField{Name: strings.TrimSpace(r.FormValue("name")), Required: r.FormValue("required") == "true"}
+	if field.Name == "" {
+		writeFailure(w, http.StatusBadRequest, "A custom field needs a name.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.productIndexLocked(productID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	product := &s.fixture.Products[i]
+	for _, existing := range product.CustomFields {
+		if strings.EqualFold(existing.Name, field.Name) {
+			writeFailure(w, http.StatusBadRequest, "A custom field with that name already exists.")
+			return
+		}
+	}
+	product.CustomFields = append(product.CustomFields, field)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"custom_field": field,
+	})
+}
+
+func (s *Server) updateCustomField(w http.ResponseWriter, r *http.Request, productID, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.productIndexLocked(productID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	product := &s.fixture.Products[i]
+	for j := range product.CustomFields {
+		if field := &product.CustomFields[j]; field.Name == name {
+			field.Required = r.FormValue("required") == "true"
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success":      true,
+				"custom_field": *field,
+			})
+			return
+		}
+	}
+	writeFailure(w, http.StatusNotFound, "The custom field was not found.")
+}
+
+func (s *Server) deleteCustomField(w http.ResponseWriter, productID, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.productIndexLocked(productID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	product := &s.fixture.Products[i]
+	for j, field := range product.CustomFields {
+		if field.Name == name {
+			product.CustomFields = append(product.CustomFields[:j], product.CustomFields[j+1:]...)
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": "The custom_field was deleted successfully.",
+			})
+			return
+		}
+	}
+	writeFailure(w, http.StatusNotFound, "The custom field was not found.")
+}
+
+func (s *Server) listSubscribers(w http.ResponseWriter, r *http.Request, productID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.productLocked(productID); !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	email := r.FormValue("email")
+	subscribers := []Subscriber{}
+	for _, subscriber := range s.fixture.Subscribers {
+		if subscriber.ProductID == productID && (email == "" || strings.EqualFold(subscriber.UserEmail, email)) {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"subscribers": subscribers,
+	})
+}
+
+func (s *Server) getSubscriber(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, subscriber := range s.fixture.Subscribers {
+		if subscriber.ID == id {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success":    true,
+				"subscriber": subscriber,
+			})
+			return
+		}
+	}
+	writeFailure(w, http.StatusNotFound, "The subscriber was not found.")
+}
+
+// saleJSON renders a sale the way /v2/sales does.
+func (s *Server) saleJSON(sale Sale) map[string]interface{} {
+	product, _ := s.productLocked(sale.ProductID)
+	created, _ := time.Parse(time.RFC3339, sale.CreatedAt)
+	body := map[string]interface{}{
+		"id":                sale.ID,
+		"email":             sale.Email,
+		"price":             sale.Price,
+		"gumroad_fee":       sale.Price / 10,
+		"currency":          sale.Currency,
+		"quantity":          1,
+		"discover_fee":      0,
+		"can_contact":       true,
+		"referrer":          "direct",
+		"order_id":          sale.OrderID,
+		"created_at":        sale.CreatedAt,
+		"timestamp":         created.Format("Jan 2, 2006"),
+		"daystamp":          created.Format("2 Jan 2006 3:04 PM"),
+		"product_id":        sale.ProductID,
+		"product_name":      product.Name,
+		"product_permalink": product.Permalink,
+		"refunded":          sale.Refunded,
+		"disputed":          sale.Disputed,
+		"chargebacked":      sale.Chargebacked,
+		"affiliate_credit":  0,
+		"purchaser_id":      sale.PurchaserID,
+	}
+	if sale.LicenseKey != "" {
+		body["license_key"] = sale.LicenseKey
+	}
+	if sale.SubscriptionID != "" {
+		body["subscription_id"] = sale.SubscriptionID
+	}
+	if sale.OfferCode != "" {
+		body["offer_code"] = sale.OfferCode
+	}
+	if sale.RefundedCents > 0 && !sale.Refunded {
+		body["partially_refunded"] = true
+		body["amount_refundable_in_currency"] = fmt.Sprintf("%.2f", float64(sale.Price-sale.RefundedCents)/100)
+	}
+	if product.RequireShipping {
+		body["shipping_information"] = map[string]interface{}{
+			"full_name":      strings.SplitN(sale.Email, "@", 2)[0],
+			"street_address": "1 Example Street",
+			"city":           "Springfield",
+			"zip_code":       "12345",
+			"country":        "United States",
+		}
+		body["shipped"] = sale.Shipped
+		if sale.TrackingURL != "" {
+			body["tracking_url"] = sale.TrackingURL
+		}
+	}
+	return body
+}
+
+// listSales returns sales newest first in pages of ten, like Gumroad.
+// page_key is the offset of the next page.
+func (s *Server) listSales(w http.ResponseWriter, r *http.Request) {
+	after, _ := time.Parse("2006-01-02", r.FormValue("after"))
+	before, _ := time.Parse("2006-01-02", r.FormValue("before"))
+	productID := r.FormValue("product_id")
+	email := r.FormValue("email")
+	orderID := r.FormValue("order_id")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matching []Sale
+	for _, sale := range s.fixture.Sales {
+		created, _ := time.Parse(time.RFC3339, sale.CreatedAt)
+		switch {
+		case productID != "" && sale.ProductID != productID:
+		case email != "" && !strings.EqualFold(sale.Email, email):
+		case orderID != "" && strconv.FormatInt(sale.OrderID, 10) != orderID:
+		case !after.IsZero() && created.Before(after):
+		case !before.IsZero() && !created.Before(before.Add(24*time.Hour)):
+		default:
+			matching = append(matching, sale)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool { return matching[i].CreatedAt > matching[j].CreatedAt })
+
+	offset := 0
+	if key := r.FormValue("page_key"); key != "" {
+		var err error
+		if offset, err = strconv.Atoi(key); err != nil || offset < 0 || offset > len(matching) {
+			writeFailure(w, http.StatusBadRequest, "Invalid page_key.")
+			return
+		}
+	}
+	end := offset + salesPageSize
+	if end > len(matching) {
+		end = len(matching)
+	}
+
+	sales := make([]map[string]interface{}, 0, end-offset)
+	for _, sale := range matching[offset:end] {
+		sales = append(sales, s.saleJSON(sale))
+	}
+	body := map[string]interface{}{
+		"success": true,
+		"sales":   sales,
+	}
+	if end < len(matching) {
+		next := r.URL.Query()
+		next.Set("page_key", strconv.Itoa(end))
+		next.Del("access_token")
+		body["next_page_key"] = strconv.Itoa(end)
+		body["next_page_url"] = "/v2/sales?" + next.Encode()
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) getSale(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sale := range s.fixture.Sales {
+		if sale.ID == id {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"sale":    s.saleJSON(sale),
+			})
+			return
+		}
+	}
+	writeFailure(w, http.StatusNotFound, "The sale was not found.")
+}
+
+// saleIndexLocked finds a sale by ID. The caller holds s.mu.
+func (s *Server) saleIndexLocked(id string) (int, bool) {
+	for i, sale := range s.fixture.Sales {
+		if sale.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// refundSale refunds amount_cents of a sale, or all that is left of it
+// without one.
+func (s *Server) refundSale(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.saleIndexLocked(id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The sale was not found.")
+		return
+	}
+	sale := &s.fixture.Sales[i]
+	if sale.Refunded || sale.Chargebacked {
+		writeFailure(w, http.StatusBadRequest, "The sale has already been refunded or charged back.")
+		return
+	}
+	refundable := sale.Price - sale.RefundedCents
+	amount := refundable
+	if value := r.FormValue("amount_cents"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > refundable {
+			writeFailure(w, http.StatusBadRequest, fmt.Sprintf("The refund amount must be between 1 and %d cents.", refundable))
+			return
+		}
+		amount = n
+	}
+	sale.RefundedCents += amount
+	sale.Refunded = sale.RefundedCents == sale.Price
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Successfully refunded.",
+		"sale":    s.saleJSON(*sale),
+	})
+}
+
+// markSaleShipped marks a physical sale as shipped, with an optional
+// tracking_url.
+func (s *Server) markSaleShipped(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.saleIndexLocked(id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The sale was not found.")
+		return
+	}
+	sale := &s.fixture.Sales[i]
+	if product, _ := s.productLocked(sale.ProductID); !product.RequireShipping {
+		writeFailure(w, http.StatusBadRequest, "The sale is not for a physical product.")
+		return
+	}
+	sale.Shipped = true
+	sale.TrackingURL = r.FormValue("tracking_url")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"sale":    s.saleJSON(*sale),
+	})
+}
+
+func (s *Server) resendReceipt(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.saleIndexLocked(id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The sale was not found.")
+		return
+	}
+	s.fixture.Sales[i].ReceiptsSent++
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "The receipt was resent.",
+	})
+}
+
+// purchaseJSON is the purchase object returned by the license endpoints.
+func (s *Server) purchaseJSON(sale Sale) map[string]interface{} {
+	product, _ := s.productLocked(sale.ProductID)
+	purchase := s.saleJSON(sale)
+	purchase["seller_id"] = "sim-seller"
+	purchase["permalink"] = product.Permalink
+	purchase["sale_id"] = sale.ID
+	purchase["sale_timestamp"] = sale.CreatedAt
+	purchase["order_number"] = sale.OrderID
+	purchase["license_key"] = sale.LicenseKey
+	purchase["is_multiseat_license"] = false
+	purchase["variants"] = ""
+	purchase["custom_fields"] = []string{}
+	purchase["dispute_won"] = false
+	purchase["subscription_ended_at"] = nil
+	purchase["subscription_cancelled_at"] = nil
+	purchase["subscription_failed_at"] = nil
+	if product.Recurrence != "" {
+		purchase["recurrence"] = product.Recurrence
+		for _, subscriber := range s.fixture.Subscribers {
+			if subscriber.ID == sale.SubscriptionID {
+				purchase["subscription_cancelled_at"] = subscriber.CancelledAt
+				purchase["subscription_ended_at"] = subscriber.EndedAt
+				purchase["subscription_failed_at"] = subscriber.FailedAt
+			}
+		}
+	}
+	return purchase
+}
+
+// licenseAction implements /v2/licenses/verify, enable, disable,
+// decrement_uses_count and rotate.
+func (s *Server) licenseAction(w http.ResponseWriter, r *http.Request, action string) {
+	productID := r.FormValue("product_id")
+	if productID == "" {
+		productID = r.FormValue("product_permalink")
+	}
+	licenseKey := strings.TrimSpace(r.FormValue("license_key"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.productLocked(productID)
+	index := -1
+	if ok {
+		for i, sale := range s.fixture.Sales {
+			if sale.ProductID == product.ID && sale.LicenseKey != "" && strings.EqualFold(sale.LicenseKey, licenseKey) {
+				index = i
+			}
+		}
+	}
+	if index < 0 {
+		writeFailure(w, http.StatusNotFound, "That license does not exist for the provided product.")
+		return
+	}
+	sale := &s.fixture.Sales[index]
+
+	switch action {
+	case "verify":
+		if sale.LicenseDisabled {
+			writeFailure(w, http.StatusNotFound, "This license key has been disabled.")
+			return
+		}
+		if r.FormValue("increment_uses_count") != "false" {
+			sale.LicenseUses++
+		}
+	case "enable":
+		sale.LicenseDisabled = false
+	case "disable":
+		sale.LicenseDisabled = true
+	case "decrement_uses_count":
+		if sale.LicenseUses > 0 {
+			sale.LicenseUses--
+		}
+	case "rotate":
+		sale.LicenseKey = randomLicenseKey(s.rng)
+	default:
+		writeFailure(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"uses":     sale.LicenseUses,
+		"purchase": s.purchaseJSON(*sale),
+	})
+}
+
+func (s *Server) listResourceSubscriptions(w http.ResponseWriter, r *http.Request) {
+	resource := r.FormValue("resource_name")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriptions := []ResourceSubscription{}
+	for _, subscription := range s.subscriptions {
+		if resource == "" || subscription.ResourceName == resource {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":                true,
+		"resource_subscriptions": subscriptions,
+	})
+}
+
+func (s *Server) createResourceSubscription(w http.ResponseWriter, r *http.Request) {
+	resource := r.FormValue("resource_name")
+	postURL := r.FormValue("post_url")
+
+	valid := false
+	for _, name := range resourceNames {
+		valid = valid || name == resource
+	}
+	if !valid {
+		writeFailure(w, http.StatusBadRequest, "Invalid resource_name.")
+		return
+	}
+	if u, err := url.Parse(postURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		writeFailure(w, http.StatusBadRequest, "Invalid post_url.")
+		return
+	}
+
+	s.mu.Lock()
+	subscription := ResourceSubscription{ID: randomID(s.rng, 22), ResourceName: resource, PostURL: postURL}
+	s.subscriptions = append(s.subscriptions, subscription)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":               true,
+		"resource_subscription": subscription,
+	})
+}
+
+func (s *Server) deleteResourceSubscription(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, subscription := range s.subscriptions {
+		if subscription.ID == id {
+			s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+			return
+		}
+	}
+	writeFailure(w, http.StatusNotFound, "The resource subscription was not found.")
+}
+
+// PingResult is the outcome of delivering one webhook ping.
+type PingResult struct {
+	PostURL string `json:"post_url"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+var errSaleNotFound = errors.New("sale not found")
+
+// Ping applies a resource event to a sale (refund and dispute mark it,
+// cancellation and subscription_ended end its subscription) and posts the
+// event to every subscription for that resource.
+func (s *Server) Ping(resource, saleID string) ([]PingResult, error) {
+	s.mu.Lock()
+	var sale *Sale
+	for i := range s.fixture.Sales {
+		if s.fixture.Sales[i].ID == saleID {
+			sale = &s.fixture.Sales[i]
+		}
+	}
+	if sale == nil {
+		s.mu.Unlock()
+		return nil, errSaleNotFound
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	switch resource {
+	case "refund":
+		sale.Refunded = true
+	case "dispute":
+		sale.Disputed = true
+	case "cancellation", "subscription_ended":
+		for i := range s.fixture.Subscribers {
+			subscriber := &s.fixture.Subscribers[i]
+			if subscriber.ID == sale.SubscriptionID {
+				subscriber.CancelledAt = &now
+				subscriber.Status = "cancelled"
+				if resource == "subscription_ended" {
+					subscriber.EndedAt = &now
+					subscriber.Status = "ended"
+				}
+			}
+		}
+	}
+
+	product, _ := s.productLocked(sale.ProductID)
+	form := url.Values{}
+	form.Set("resource_name", resource)
+	form.Set("seller_id", "sim-seller")
+	form.Set("sale_id", sale.ID)
+	form.Set("sale_timestamp", sale.CreatedAt)
+	form.Set("order_number", strconv.FormatInt(sale.OrderID, 10))
+	form.Set("product_id", sale.ProductID)
+	form.Set("product_permalink", product.Permalink)
+	form.Set("product_name", product.Name)
+	form.Set("email", sale.Email)
+	form.Set("price", strconv.Itoa(sale.Price))
+	form.Set("currency", sale.Currency)
+	form.Set("license_key", sale.LicenseKey)
+	form.Set("subscription_id", sale.SubscriptionID)
+	form.Set("refunded", strconv.FormatBool(sale.Refunded))
+	form.Set("disputed", strconv.FormatBool(sale.Disputed))
+	form.Set("chargebacked", strconv.FormatBool(sale.Chargebacked))
+	form.Set("test", "true")
+
+	var targets []string
+	for _, subscription := range s.subscriptions {
+		if subscription.ResourceName == resource {
+			targets = append(targets, subscription.PostURL)
+		}
+	}
+	s.mu.Unlock()
+
+	results := []PingResult{}
+	for _, target := range targets {
+		result := PingResult{PostURL: target}
+		resp, err := s.client.PostForm(target, form)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			resp.Body.Close()
+			result.Status = resp.StatusCode
+		}
+		log.Printf("Ping %s for sale %s to %s: status=%d error=%q", resource, saleID, target, result.Status, result.Error)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// serveControl handles the simulator's own endpoints:
+//
+//	GET  /_sim/fixture  current data, in fixture format
+//	GET  /_sim/faults   current fault injection settings
+//	PUT  /_sim/faults   change them, e.g. {"latency":"300ms","error_rate":0.2}
+//	POST /_sim/ping     resource_name and sale_id: fire a webhook ping
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	switch r.Method + " " + r.URL.Path {
+	case "GET /_sim/fixture":
+		writeJSON(w, http.StatusOK, s.Fixture())
+	case "GET /_sim/faults":
+		s.mu.Lock()
+		faults := s.faults
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, faultsJSON{
+			Latency:       faults.Latency.String(),
+			Jitter:        faults.Jitter.String(),
+			ErrorRate:     faults.ErrorRate,
+			RateLimitRate: faults.RateLimitRate,
+		})
+	case "PUT /_sim/faults":
+		var req faultsJSON
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeFailure(w, http.StatusBadRequest, "Request body must be JSON")
+			return
+		}
+		faults, err := req.parse()
+		if err != nil {
+			writeFailure(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.SetFaults(faults)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+	case "POST /_sim/ping":
+		results, err := s.Ping(r.FormValue("resource_name"), r.FormValue("sale_id"))
+		if err != nil {
+			writeFailure(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":    true,
+			"deliveries": results,
+		})
+	default:
+		writeFailure(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (f faultsJSON) parse() (Faults, error) {
+	var faults Faults
+	var err error
+	if f.Latency != "" {
+		if faults.Latency, err = time.ParseDuration(f.Latency); err != nil {
+			return faults, fmt.Errorf("latency: %w", err)
+		}
+	}
+	if f.Jitter != "" {
+		if faults.Jitter, err = time.ParseDuration(f.Jitter); err != nil {
+			return faults, fmt.Errorf("jitter: %w", err)
+		}
+	}
+	if f.ErrorRate < 0 || f.RateLimitRate < 0 || f.ErrorRate+f.RateLimitRate > 1 {
+		return faults, errors.New("error_rate and rate_limit_rate must be between 0 and 1 and add up to at most 1")
+	}
+	faults.ErrorRate = f.ErrorRate
+	faults.RateLimitRate = f.RateLimitRate
+	return faults, nil
+}
+
+// offerCodeJSON is an offer code as Gumroad returns it, with times_used
+// counted from the product's sales. The caller holds s.mu.
+func (s *Server) offerCodeJSON(code OfferCode) map[string]interface{} {
+	used := 0
+	for _, sale := range s.fixture.Sales {
+		if sale.ProductID == code.ProductID && strings.EqualFold(sale.OfferCode, code.Name) {
+			used++
+		}
+	}
+	body := map[string]interface{}{
+		"id":                 code.ID,
+		"name":               code.Name,
+		"max_purchase_count": code.MaxPurchaseCount,
+		"universal":          code.Universal,
+		"times_used":         used,
+	}
+	if code.PercentOff != nil {
+		body["percent_off"] = *code.PercentOff
+	} else if code.AmountCents != nil {
+		body["amount_cents"] = *code.AmountCents
+	}
+	return body
+}
+
+// offerCodeLocked finds a product's offer code by ID. The caller holds s.mu.
+func (s *Server) offerCodeLocked(productID, id string) (int, bool) {
+	product, ok := s.productLocked(productID)
+	if !ok {
+		return 0, false
+	}
+	for i, code := range s.fixture.OfferCodes {
+		if code.ProductID == product.ID && code.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Server) listOfferCodes(w http.ResponseWriter, productID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.productLocked(productID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	codes := []map[string]interface{}{}
+	for _, code := range s.fixture.OfferCodes {
+		if code.ProductID == product.ID {
+			codes = append(codes, s.offerCodeJSON(code))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"offer_codes": codes,
+	})
+}
+
+func (s *Server) getOfferCode(w http.ResponseWriter, productID, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.offerCodeLocked(productID, id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The offer code was not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"offer_code": s.offerCodeJSON(s.fixture.OfferCodes[i]),
+	})
+}
+
+// createOfferCode takes name, amount_off, offer_type ("cents", the default,
+// or "percent"), max_purchase_count and universal.
+func (s *Server) createOfferCode(w http.ResponseWriter, r *http.Request, productID string) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	amount, err := strconv.Atoi(r.FormValue("amount_off"))
+	if name == "" || err != nil || amount <= 0 {
+		writeFailure(w, http.StatusBadRequest, "An offer code needs a name and an amount_off.")
+		return
+	}
+	offerType := r.FormValue("offer_type")
+	if offerType != "" && offerType != "cents" && offerType != "percent" {
+		writeFailure(w, http.StatusBadRequest, "Invalid offer_type.")
+		return
+	}
+	if offerType == "percent" && amount > 100 {
+		writeFailure(w, http.StatusBadRequest, "A percentage off cannot be over 100.")
+		return
+	}
+	var limit *int
+	if value := r.FormValue("max_purchase_count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeFailure(w, http.StatusBadRequest, "Invalid max_purchase_count.")
+			return
+		}
+		limit = &n
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.productLocked(productID)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The product was not found.")
+		return
+	}
+	for _, code := range s.fixture.OfferCodes {
+		if code.ProductID == product.ID && strings.EqualFold(code.Name, name) {
+			writeFailure(w, http.StatusBadRequest, "An offer code with that name already exists.")
+			return
+		}
+	}
+	code := OfferCode{
+		ID:               randomID(s.rng, 22),
+		ProductID:        product.ID,
+		Name:             name,
+		MaxPurchaseCount: limit,
+		Universal:        r.FormValue("universal") == "true",
+	}
+	if offerType == "percent" {
+		code.PercentOff = &amount
+	} else {
+		code.AmountCents = &amount
+	}
+	s.fixture.OfferCodes = append(s.fixture.OfferCodes, code)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"offer_code": s.offerCodeJSON(code),
+	})
+}
+
+// updateOfferCode changes an offer code's max_purchase_count, the one field
+// Gumroad lets you edit; an empty value removes the limit.
+func (s *Server) updateOfferCode(w http.ResponseWriter, r *http.Request, productID, id string) {
+	var limit *int
+	if value := r.FormValue("max_purchase_count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeFailure(w, http.StatusBadRequest, "Invalid max_purchase_count.")
+			return
+		}
+		limit = &n
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.offerCodeLocked(productID, id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The offer code was not found.")
+		return
+	}
+	s.fixture.OfferCodes[i].MaxPurchaseCount = limit
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"offer_code": s.offerCodeJSON(s.fixture.OfferCodes[i]),
+	})
+}
+
+func (s *Server) deleteOfferCode(w http.ResponseWriter, productID, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.offerCodeLocked(productID, id)
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "The offer code was not found.")
+		return
+	}
+	s.fixture.OfferCodes = append(s.fixture.OfferCodes[:i], s.fixture.OfferCodes[i+1:]...)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "The offer_code was deleted successfully.",
+	})
+}
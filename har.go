@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const redactedValue = "[REDACTED]"
+
+// Above this many line pairs the diff falls back to replacing the whole
+// changed region instead of computing an LCS.
+const maxDiffCells = 4_000_000
+
+// HAR 1.2 types, limited to the fields we can fill in from an APICall.
+// See http://www.softwareishard.com/blog/har-12-spec/
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// isCredentialHeader reports whether a header carries credentials that must
+// not be logged or exported.
+func isCredentialHeader(name string) bool {
+	return strings.EqualFold(name, "Authorization") || strings.EqualFold(name, "Cookie") || strings.EqualFold(name, "Set-Cookie")
+}
+
+// recordedHeaders converts headers into the API call log's form, keeping the
+// first value of each and redacting credentials.
+func recordedHeaders(header http.Header) map[string]string {
+	result := make(map[string]string, len(header))
+	for name, values := range header {
+		result[name] = values[0]
+		if isCredentialHeader(name) {
+			result[name] = redactedValue
+		}
+	}
+	return result
+}
+
+// harHeaders converts a header map into sorted HAR headers with credentials
+// redacted, so exports can be shared outside the team.
+func harHeaders(headers map[string]string) []harNameValue {
+	result := make([]harNameValue, 0, len(headers))
+	for name, value := range headers {
+		if isCredentialHeader(name) {
+			value = redactedValue
+		}
+		result = append(result, harNameValue{Name: name, Value: value})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// redactURL removes access tokens passed as query parameters.
+func redactURL(rawURL string) (string, []harNameValue) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL, []harNameValue{}
+	}
+
+	query := parsed.Query()
+	if query.Has("access_token") {
+		query.Set("access_token", redactedValue)
+		parsed.RawQuery = query.Encode()
+	}
+
+	queryString := []harNameValue{}
+	for name, values := range query {
+		for _, value := range values {
+			queryString = append(queryString, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.Slice(queryString, func(i, j int) bool { return queryString[i].Name < queryString[j].Name })
+	return parsed.String(), queryString
+}
+
+func newHAREntry(call APICall) harEntry {
+	ms := float64(call.Duration) / float64(time.Millisecond)
+	requestURL, queryString := redactURL(call.URL)
+
+	entry := harEntry{
+		StartedDateTime: call.Timestamp.Format("2006-01-02T15:04:05.000Z07:00"),
+		Time:            ms,
+		Request: harRequest{
+			Method:      call.Method,
+			URL:         requestURL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(call.Headers),
+			QueryString: queryString,
+			HeadersSize: -1,
+			BodySize:    len(call.RequestBody),
+		},
+		Response: harResponse{
+			Status:      call.Status,
+			StatusText:  http.StatusText(call.Status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(call.ResponseHeaders),
+			Content: harContent{
+				Size:     len(call.ResponseBody),
+				MimeType: call.ResponseHeaders["Content-Type"],
+				Text:     call.ResponseBody,
+			},
+			HeadersSize: -1,
+			BodySize:    len(call.ResponseBody),
+		},
+		Timings: harTimings{Send: 0, Wait: ms, Receive: 0},
+		Comment: call.Error,
+	}
+
+	if call.RequestBody != "" {
+		mimeType := call.Headers["Content-Type"]
+		if mimeType == "" {
+			mimeType = "application/x-www-form-urlencoded"
+		}
+		entry.Request.PostData = &harPostData{MimeType: mimeType, Text: call.RequestBody}
+	}
+	if entry.Response.Content.MimeType == "" {
+		entry.Response.Content.MimeType = "application/json"
+	}
+	return entry
+}
+
+// apiCallByID returns a copy of a logged call.
+func (app *App) apiCallByID(id int64) (APICall, bool) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	for _, call := range app.apiCalls {
+		if call.ID == id {
+			return call, true
+		}
+	}
+	return APICall{}, false
+}
+
+// apiCallsHARHandler exports logged calls as a HAR 1.2 file. Calls are
+// selected with ids=1,2,3, or with the usual log filters when no IDs are
+// given.
+func (app *App) apiCallsHARHandler(w http.ResponseWriter, r *http.Request) {
+	selected := make(map[int64]bool)
+	for _, raw := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64); err == nil {
+			selected[id] = true
+		}
+	}
+	filter := parseAPICallFilter(r.URL.Query(), app.timezone(r))
+
+	har := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "gumroad-license-manager", Version: "1.0"},
+		Entries: []harEntry{},
+	}}
+
+	app.mu.RLock()
+	for _, call := range app.apiCalls {
+		if len(selected) > 0 && !selected[call.ID] {
+			continue
+		}
+		if len(selected) == 0 && !filter.matches(call) {
+			continue
+		}
+		har.Log.Entries = append(har.Log.Entries, newHAREntry(call))
+	}
+	app.mu.RUnlock()
+
+	app.audit(r, AuditEvent{Action: auditAPILogExport, Detail: fmt.Sprintf("%d calls as HAR", len(har.Log.Entries))}, nil)
+
+	filename := fmt.Sprintf("gumroad-api-calls-%s.har", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(har)
+}
+
+type diffLine struct {
+	Op   string `json:"op"` // " " unchanged, "-" only in old, "+" only in new
+	Text string `json:"text"`
+}
+
+// replayable reports whether a logged call can be re-issued without side
+// effects. License verification is allowed because replay never increments
+// the uses count.
+func (app *App) replayable(call APICall) bool {
+	if call.Method == "GET" {
+		return true
+	}
+	return call.Method == "POST" && call.URL == app.gumroadURL("/licenses/verify")
+}
+
+// apiCallReplayHandler re-issues a logged read-only request with the current
+// token and returns both calls with a line diff of their response bodies.
+func (app *App) apiCallReplayHandler(w http.ResponseWriter, r *http.Request) {
+	writeError := func(status int, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   message,
+		})
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(http.StatusBadRequest, "Invalid API call ID")
+		return
+	}
+
+	original, ok := app.apiCallByID(id)
+	if !ok {
+		writeError(http.StatusNotFound, "API call not found, it may have been rotated out of the log")
+		return
+	}
+
+	if !app.replayable(original) {
+		writeError(http.StatusBadRequest, "Only read-only requests can be replayed")
+		return
+	}
+
+	requestBody := original.RequestBody
+	if original.URL == app.gumroadURL("/licenses/verify") {
+		form, err := url.ParseQuery(requestBody)
+		if err != nil {
+			writeError(http.StatusBadRequest, "Logged request body is not valid form data")
+			return
+		}
+		form.Set("increment_uses_count", "false")
+		requestBody = form.Encode()
+	}
+
+	replay, _, err := app.sendGumroadRequestAs(requestActor(r), original.Method, original.URL, requestBody)
+	if err == nil && replay.Status >= 500 {
+		err = &gumroadStatusError{Status: replay.Status, Body: replay.ResponseBody}
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditAPILogReplay,
+		TargetType: "api_call",
+		Target:     strconv.FormatInt(original.ID, 10),
+		Detail:     original.Method + " " + original.URL,
+	}, err)
+	// Without a response from Gumroad there is nothing to compare against
+	if err != nil {
+		writeError(http.StatusBadGateway, "Replay failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"original": original,
+		"replay":   replay,
+		"diff":     diffLines(prettyBody(original.ResponseBody), prettyBody(replay.ResponseBody)),
+	})
+}
+
+// prettyBody indents JSON bodies so diffs are per field rather than one
+// enormous line. Other bodies are returned unchanged.
+func prettyBody(body string) string {
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(body), "", "  "); err != nil {
+		return body
+	}
+	return out.String()
+}
+
+// diffLines computes a line diff via the longest common subsequence of the
+// region between the common prefix and suffix.
+func diffLines(oldText, newText string) []diffLine {
+	a := strings.Split(oldText, "\n")
+	b := strings.Split(newText, "\n")
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var result []diffLine
+	for _, line := range a[:prefix] {
+		result = append(result, diffLine{Op: " ", Text: line})
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, line := range midA {
+			result = append(result, diffLine{Op: "-", Text: line})
+		}
+		for _, line := range midB {
+			result = append(result, diffLine{Op: "+", Text: line})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of midA[i:] and midB[j:]
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(midA) && j < len(midB) {
+			switch {
+			case midA[i] == midB[j]:
+				result = append(result, diffLine{Op: " ", Text: midA[i]})
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				result = append(result, diffLine{Op: "-", Text: midA[i]})
+				i++
+			default:
+				result = append(result, diffLine{Op: "+", Text: midB[j]})
+				j++
+			}
+		}
+		for ; i < len(midA); i++ {
+			result = append(result, diffLine{Op: "-", Text: midA[i]})
+		}
+		for ; j < len(midB); j++ {
+			result = append(result, diffLine{Op: "+", Text: midB[j]})
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		result = append(result, diffLine{Op: " ", Text: line})
+	}
+	return result
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAPICallsHARHandler(t *testing.T) {
+	app := newTestApp(t)
+	app.recordAPICall(APICall{
+		Timestamp:       testNow,
+		Duration:        120 * time.Millisecond,
+		Method:          "GET",
+		URL:             "https://api.gumroad.com/v2/products?access_token=secret-token&page=2",
+		Headers:         map[string]string{"Authorization": "Bearer secret-token", "Cookie": "session=secret", "Accept": "application/json"},
+		Status:          http.StatusOK,
+		ResponseBody:    `{"success":true}`,
+		ResponseHeaders: map[string]string{"Set-Cookie": "session=secret", "Content-Type": "application/json"},
+	})
+	app.recordAPICall(APICall{
+		Timestamp:    testNow,
+		Method:       "POST",
+		URL:          "https://api.gumroad.com/v2/licenses/verify",
+		RequestBody:  "product_id=prod&license_key=KEY",
+		Status:       http.StatusNotFound,
+		ResponseBody: `{"success":false}`,
+	})
+
+	recorder := serve(app.apiCallsHARHandler, httptest.NewRequest("GET", "/api/api-calls/har?ids=1", nil), nil)
+	if recorder.Code != http.StatusOK || !strings.HasSuffix(recorder.Header().Get("Content-Disposition"), `.har"`) {
+		t.Fatalf("status %d, Content-Disposition %q", recorder.Code, recorder.Header().Get("Content-Disposition"))
+	}
+	if strings.Contains(recorder.Body.String(), "secret") {
+		t.Errorf("HAR leaks credentials:\n%s", recorder.Body.String())
+	}
+	var har harFile
+	if err := json.Unmarshal(recorder.Body.Bytes(), &har); err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 1 {
+		t.Fatalf("log %+v, want the one selected call", har.Log)
+	}
+	entry := har.Log.Entries[0]
+	if entry.Time != 120 || entry.StartedDateTime != "2024-11-22T12:00:00.000Z" {
+		t.Errorf("time %v, started %q", entry.Time, entry.StartedDateTime)
+	}
+	wantHeaders := []harNameValue{{"Accept", "application/json"}, {"Authorization", redactedValue}, {"Cookie", redactedValue}}
+	if fmt.Sprint(entry.Request.Headers) != fmt.Sprint(wantHeaders) {
+		t.Errorf("request headers %v, want %v", entry.Request.Headers, wantHeaders)
+	}
+	wantQuery := []harNameValue{{"access_token", redactedValue}, {"page", "2"}}
+	if fmt.Sprint(entry.Request.QueryString) != fmt.Sprint(wantQuery) || strings.Contains(entry.Request.URL, "secret") {
+		t.Errorf("query %v, URL %q", entry.Request.QueryString, entry.Request.URL)
+	}
+	for _, header := range entry.Response.Headers {
+		if header.Name == "Set-Cookie" && header.Value != redactedValue {
+			t.Errorf("Set-Cookie %q", header.Value)
+		}
+	}
+	if entry.Response.StatusText != "OK" || entry.Response.Content.Text != `{"success":true}` {
+		t.Errorf("response %+v", entry.Response)
+	}
+
+	// Without IDs, the log filters select the calls
+	recorder = serve(app.apiCallsHARHandler, httptest.NewRequest("GET", "/api/api-calls/har?method=POST", nil), nil)
+	har = harFile{}
+	json.Unmarshal(recorder.Body.Bytes(), &har)
+	if len(har.Log.Entries) != 1 || har.Log.Entries[0].Request.PostData == nil ||
+		har.Log.Entries[0].Request.PostData.MimeType != "application/x-www-form-urlencoded" {
+		t.Errorf("filtered export %+v", har.Log.Entries)
+	}
+}
+
+func TestReplayable(t *testing.T) {
+	app := newTestApp(t)
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/products", true},
+		{"POST", "/licenses/verify", true},
+		{"PUT", "/licenses/enable", false},
+		{"POST", "/products/prod/offer_codes", false},
+		{"DELETE", "/products/prod", false},
+	}
+	for _, tt := range tests {
+		call := APICall{Method: tt.method, URL: app.gumroadURL(tt.path)}
+		if got := app.replayable(call); got != tt.want {
+			t.Errorf("replayable(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAPICallReplayHandler(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		bodies = append(bodies, r.PostForm.Encode())
+		code := status
+		mu.Unlock()
+		w.WriteHeader(code)
+		fmt.Fprint(w, `{"success":true,"uses":3}`)
+	}))
+	defer server.Close()
+
+	app := newTestApp(t)
+	app.config.GumroadAPIBase = server.URL + "/v2"
+	verify := app.recordAPICall(APICall{
+		Method:       "POST",
+		URL:          app.gumroadURL("/licenses/verify"),
+		RequestBody:  "increment_uses_count=true&license_key=KEY&product_id=prod",
+		Status:       http.StatusOK,
+		ResponseBody: `{"success":true,"uses":2}`,
+	})
+	write := app.recordAPICall(APICall{Method: "PUT", URL: app.gumroadURL("/licenses/enable")})
+
+	replay := func(id int64) (int, map[string]interface{}) {
+		path := fmt.Sprintf("/api/api-calls/%d/replay", id)
+		recorder := serve(app.apiCallReplayHandler, httptest.NewRequest("POST", path, nil), map[string]string{"id": fmt.Sprint(id)})
+		var response map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	code, response := replay(verify.ID)
+	if code != http.StatusOK || response["success"] != true {
+		t.Fatalf("replay: status %d, %v", code, response)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("%d requests to Gumroad, want 1", len(bodies))
+	}
+	if form, _ := url.ParseQuery(bodies[0]); form.Get("increment_uses_count") != "false" || form.Get("license_key") != "KEY" {
+		t.Errorf("replayed body %q, want the uses count left alone", bodies[0])
+	}
+	var changed []string
+	for _, line := range response["diff"].([]interface{}) {
+		if line := line.(map[string]interface{}); line["op"] != " " {
+			changed = append(changed, line["op"].(string)+strings.TrimSpace(line["text"].(string)))
+		}
+	}
+	if strings.Join(changed, " ") != `-"uses": 2 +"uses": 3` {
+		t.Errorf("diff changes %v", changed)
+	}
+
+	if code, response := replay(write.ID); code != http.StatusBadRequest || len(bodies) != 1 {
+		t.Errorf("write replay: status %d, %v", code, response)
+	}
+	if code, _ := replay(99); code != http.StatusNotFound {
+		t.Errorf("unknown call: status %d, want 404", code)
+	}
+
+	mu.Lock()
+	status = http.StatusBadGateway
+	mu.Unlock()
+	if code, response := replay(verify.ID); code != http.StatusBadGateway || response["success"] != false {
+		t.Errorf("replay of a 5xx: status %d, %v", code, response)
+	}
+
+	server.Close()
+	if code, response := replay(verify.ID); code != http.StatusBadGateway || response["success"] != false ||
+		!strings.HasPrefix(response["error"].(string), "Replay failed") {
+		t.Errorf("replay with Gumroad down: status %d, %v", code, response)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	format := func(lines []diffLine) string {
+		var out []string
+		for _, line := range lines {
+			out = append(out, line.Op+line.Text)
+		}
+		return strings.Join(out, "|")
+	}
+	tests := []struct {
+		name, old, new, want string
+	}{
+		{"identical", "a\nb", "a\nb", " a| b"},
+		{"changed line", "a\nb\nc", "a\nx\nc", " a|-b|+x| c"},
+		{"added line", "a\nc", "a\nb\nc", " a|+b| c"},
+		{"removed line", "a\nb\nc", "a\nc", " a|-b| c"},
+		{"interleaved", "a\nb\nc\nd", "b\nx\nd\ne", "-a| b|-c|+x| d|+e"},
+	}
+	for _, tt := range tests {
+		if got := format(diffLines(tt.old, tt.new)); got != tt.want {
+			t.Errorf("%s: diff %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// fallbackLanguage supplies messages missing from other catalogs
+	fallbackLanguage   = "en"
+	languageCookieName = "glm_lang"
+	// preferenceCookieMaxAge is how long the language and timezone picked
+	// from the menus are remembered
+	preferenceCookieMaxAge = 365 * 24 * time.Hour
+	// jsMessagePrefix marks the messages the scripts need; only those are
+	// sent with each page
+	jsMessagePrefix = "js."
+)
+
+// catalog maps message keys to their text in one language. Text may contain
+// the placeholders {0}, {1}, ... for the arguments, so translations can
+// order them as the language needs.
+type catalog map[string]string
+
+// loadCatalogs reads the <language>.json files of the locales directory,
+// keyed by language, such as "de".
+func loadCatalogs(fsys fs.FS) (map[string]catalog, error) {
+	catalogs := make(map[string]catalog)
+	if fsys == nil {
+		return catalogs, nil
+	}
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var messages catalog
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		catalogs[strings.TrimSuffix(name, path.Ext(name))] = messages
+	}
+	return catalogs, nil
+}
+
+// localizer translates messages and formats dates and numbers for one
+// request's language and timezone.
+type localizer struct {
+	language string
+	catalogs map[string]catalog
+	location *time.Location
+	// now is the time relative times are counted from
+	now time.Time
+}
+
+func (l localizer) t(key string, args ...interface{}) string {
+	message, ok := l.catalogs[l.language][key]
+	if !ok {
+		if message, ok = l.catalogs[fallbackLanguage][key]; !ok {
+			return key
+		}
+	}
+	for i, arg := range args {
+		message = strings.ReplaceAll(message, "{"+strconv.Itoa(i)+"}", fmt.Sprint(arg))
+	}
+	return message
+}
+
+func (l localizer) number(n int) string {
+	return formatNumber(int64(n), l.language)
+}
+
+func (l localizer) money(m Money) string {
+	return m.Format(l.language)
+}
+
+// jsMessages returns the messages used by the scripts, for app.js's t().
+func (l localizer) jsMessages() catalog {
+	messages := make(catalog)
+	for _, language := range []string{fallbackLanguage, l.language} {
+		for key, message := range l.catalogs[language] {
+			if strings.HasPrefix(key, jsMessagePrefix) {
+				messages[key] = message
+			}
+		}
+	}
+	return messages
+}
+
+// languageOption is an entry of the language menu.
+type languageOption struct {
+	Code string
+	Name string
+}
+
+// languages lists the available catalogs by code, each named in its own
+// language.
+func (l localizer) languages() []languageOption {
+	var options []languageOption
+	for code, messages := range l.catalogs {
+		options = append(options, languageOption{Code: code, Name: withDefaultString(messages["language.name"], code)})
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].Code < options[j].Code })
+	return options
+}
+
+// localizer returns the localizer for the language a request should be
+// answered in. In order of preference that is the logged-in user's choice,
+// the language cookie set from the menu, the browser's Accept-Language, the
+// configured locale, and English.
+func (app *App) localizer(r *http.Request) localizer {
+	app.templatesMu.Lock()
+	catalogs := app.catalogs
+	app.templatesMu.Unlock()
+	l := localizer{catalogs: catalogs, location: app.timezone(r), now: app.currentTime()}
+
+	user, ok := currentUser(r)
+	if !ok {
+		user, _ = app.sessionUser(r)
+	}
+	var cookie string
+	if c, err := r.Cookie(languageCookieName); err == nil {
+		cookie = c.Value
+	}
+	candidates := []string{
+		user.Language,
+		cookie,
+		negotiateLanguage(r.Header.Get("Accept-Language"), l.catalogs),
+		matchLanguage(app.config.Locale, l.catalogs),
+	}
+	l.language = fallbackLanguage
+	for _, language := range candidates {
+		if _, ok := l.catalogs[language]; ok && language != "" {
+			l.language = language
+			break
+		}
+	}
+	return l
+}
+
+// negotiateLanguage picks the catalog best matching an Accept-Language
+// header such as "de-CH, de;q=0.9, en;q=0.5", or "" when none matches.
+func negotiateLanguage(header string, catalogs map[string]catalog) string {
+	type preference struct {
+		tag    string
+		weight float64
+	}
+	var preferences []preference
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); found {
+			var err error
+			if weight, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if tag != "" && weight > 0 {
+			preferences = append(preferences, preference{tag, weight})
+		}
+	}
+	sort.SliceStable(preferences, func(i, j int) bool { return preferences[i].weight > preferences[j].weight })
+
+	for _, p := range preferences {
+		if language := matchLanguage(p.tag, catalogs); language != "" {
+			return language
+		}
+	}
+	return ""
+}
+
+// matchLanguage returns the catalog for a locale such as "de-AT", trying the
+// full tag before the language alone.
+func matchLanguage(locale string, catalogs map[string]catalog) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if _, ok := catalogs[locale]; ok {
+		return locale
+	}
+	language, _, _ := strings.Cut(locale, "-")
+	if _, ok := catalogs[language]; ok {
+		return language
+	}
+	return ""
+}
+
+// setPreferencesHandler handles the language and timezone menus. The
+// choices are kept in cookies and, for a logged-in user, with their account
+// so they follow them to other browsers. An empty timezone goes back to the
+// configured one.
+func (app *App) setPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	language := r.FormValue("language")
+	timezone := r.FormValue("timezone")
+	app.templatesMu.Lock()
+	_, ok := app.catalogs[language]
+	app.templatesMu.Unlock()
+	if !ok {
+		http.Error(w, "Unknown language", http.StatusBadRequest)
+		return
+	}
+	if _, ok := loadLocation(timezone); !ok && timezone != "" {
+		http.Error(w, "Unknown timezone", http.StatusBadRequest)
+		return
+	}
+
+	for name, value := range map[string]string{languageCookieName: language, timezoneCookieName: timezone} {
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     "/",
+			MaxAge:   int(preferenceCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			Secure:   app.isHTTPS(r),
+			SameSite: http.SameSiteLaxMode,
+		}
+		if value == "" {
+			cookie.MaxAge = -1
+		}
+		http.SetCookie(w, cookie)
+	}
+	if user, ok := app.sessionUser(r); ok {
+		if _, err := app.users.setPreferences(user.ID, language, timezone); err != nil {
+			log.Printf("Failed to save preferences of %s: %v", user.Username, err)
+		}
+	}
+
+	// Go back to the page the menu was on; the Referrer-Policy sends the
+	// full URL to our own origin
+	target := "/"
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host == r.Host {
+		target = safeRedirectTarget(referer.RequestURI())
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	catalogs := map[string]catalog{"en": {}, "de": {}, "es": {}, "pt-br": {}}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"de", "de"},
+		{"de-CH, de;q=0.9, en;q=0.5", "de"},
+		{"fr-FR, fr;q=0.9, es;q=0.8, en;q=0.7", "es"},
+		{"en;q=0.3, es;q=0.6", "es"},
+		{"es;q=0, en", "en"},
+		{"pt-BR", "pt-br"},
+		{"pt-PT", ""},
+		{"fr, *;q=0.1", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateLanguage(tt.header, catalogs); got != tt.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+// TestCatalogsComplete checks that every message used by the templates and
+// scripts is in the English catalog, and every catalog has the same keys.
+func TestCatalogsComplete(t *testing.T) {
+	catalogs, err := loadCatalogs(os.DirFS("locales"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	english := catalogs[fallbackLanguage]
+	if len(english) == 0 {
+		t.Fatal("no English catalog")
+	}
+
+	uses := map[string]*regexp.Regexp{
+		"templates/*.html": regexp.MustCompile(`\{\{t "([^"]+)"`),
+		"static/js/*.js":   regexp.MustCompile(`\bt\('([^']+)'`),
+	}
+	for pattern, use := range uses {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, match := range use.FindAllStringSubmatch(string(content), -1) {
+				if _, ok := english[match[1]]; !ok {
+					t.Errorf("%s uses %q, which is not in locales/en.json", file, match[1])
+				}
+			}
+		}
+	}
+
+	for language, messages := range catalogs {
+		for key := range english {
+			if _, ok := messages[key]; !ok {
+				t.Errorf("locales/%s.json is missing %q", language, key)
+			}
+		}
+		for key := range messages {
+			if _, ok := english[key]; !ok {
+				t.Errorf("locales/%s.json has %q, which is not in locales/en.json", language, key)
+			}
+		}
+	}
+}
+
+func TestLocalizedPages(t *testing.T) {
+	app := newTestApp(t)
+	if err := saveConfig(app.configPath, Config{GumroadToken: "YOUR_GUMROAD_ACCESS_TOKEN_HERE"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		cookie         string
+		want           []string
+	}{
+		{"default", "", "", []string{`<html lang="en">`, "Setup Required"}},
+		{"accept-language", "de-CH, en;q=0.5", "", []string{`<html lang="de">`, "Einrichtung erforderlich", `"js.hide":"Verbergen"`}},
+		{"unsupported", "fr-FR", "", []string{`<html lang="en">`}},
+		{"cookie beats header", "de", "es", []string{`<html lang="es">`, "Configuración necesaria"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/setup", nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: languageCookieName, Value: tt.cookie})
+			}
+			body := serve(app.setupHandler, req, nil).Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("page does not contain %q", want)
+				}
+			}
+		})
+	}
+}
+
+// TestLocalizedSalesPage checks that the sales table, which the support team
+// works in, is translated like the other tables.
+func TestLocalizedSalesPage(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "sales")
+
+	req := httptest.NewRequest("GET", "/sales/0", nil)
+	req.Header.Set("Accept-Language", "de")
+	body := serve(app.salesHandler, req, map[string]string{"index": "0"}).Body.String()
+	for _, want := range []string{"<title>Verkäufe - ", "Verkaufsdatum", "Gumroad-Gebühren", "Abgeschlossen"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+	for _, english := range []string{"Sale Date", "Gumroad Fees", "Completed"} {
+		if strings.Contains(body, english) {
+			t.Errorf("page still contains %q", english)
+		}
+	}
+}
+
+func TestSetPreferencesHandler(t *testing.T) {
+	app := newTestApp(t)
+
+	post := func(form url.Values, referer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/preferences", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if referer != "" {
+			req.Header.Set("Referer", referer)
+		}
+		return serve(app.setPreferencesHandler, req, nil)
+	}
+
+	form := url.Values{"language": {"de"}, "timezone": {"Europe/Berlin"}}
+	recorder := post(form, "http://example.com/sales/0?q=test")
+	if recorder.Code != http.StatusSeeOther || recorder.Header().Get("Location") != "/sales/0?q=test" {
+		t.Errorf("status %d to %q, want 303 back to the page", recorder.Code, recorder.Header().Get("Location"))
+	}
+	cookies := map[string]string{}
+	for _, cookie := range recorder.Result().Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	if cookies[languageCookieName] != "de" || cookies[timezoneCookieName] != "Europe/Berlin" {
+		t.Errorf("cookies = %v, want de and Europe/Berlin", cookies)
+	}
+
+	// Another site's page is not a place to go back to
+	if location := post(form, "https://evil.example/").Header().Get("Location"); location != "/" {
+		t.Errorf("foreign referer: redirected to %q, want /", location)
+	}
+
+	for _, invalid := range []url.Values{
+		{"language": {"xx"}, "timezone": {"UTC"}},
+		{"language": {"en"}, "timezone": {"Mars/Olympus_Mons"}},
+	} {
+		if recorder := post(invalid, ""); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%v: status %d, want 400", invalid, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultTablePageSize = 50
+	maxTablePageSize     = 500
+	// Gumroad returns sales in pages of ten; stop following page keys after
+	// this many so a misbehaving API cannot keep us looping
+	maxSalesPages = 1000
+)
+
+// Purchase statuses used by the licenses and sales status filters.
+const (
+	purchaseStatusActive       = "active"
+	purchaseStatusRefunded     = "refunded"
+	purchaseStatusDisputed     = "disputed"
+	purchaseStatusChargebacked = "chargebacked"
+)
+
+func purchaseStatus(refunded, disputed, chargebacked bool) string {
+	switch {
+	case refunded:
+		return purchaseStatusRefunded
+	case disputed:
+		return purchaseStatusDisputed
+	case chargebacked:
+		return purchaseStatusChargebacked
+	default:
+		return purchaseStatusActive
+	}
+}
+
+// parseGumroadTime parses the timestamps Gumroad returns for sales and
+// licenses. Times without a zone, such as a sale's daystamp, are in UTC.
+// Unparseable values become the zero time, which sorts first.
+func parseGumroadTime(value string) time.Time {
+	layouts := []string{time.RFC3339, "2006-01-02 15:04:05 MST", "2 Jan 2006 3:04 PM", "2006-01-02"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// SoldAt is when a sale was made. Sales without a created_at fall back to
+// the daystamp, which only has minutes.
+func (s Sale) SoldAt() time.Time {
+	if s.CreatedAt.IsZero() {
+		return s.Daystamp.Time
+	}
+	return s.CreatedAt.Time
+}
+
+// tableQuery is a search, filter, sort and page request over the licenses
+// or sales of a product. It is parsed from the page's query string so the
+// same parameters work for the HTML pages and the JSON endpoints.
+type tableQuery struct {
+	// Search matches email, license key and order/purchase ID
+	Search string
+	Status string
+	// OfferCode limits sales to those bought with the named offer code
+	OfferCode  string
+	From       time.Time
+	To         time.Time
+	Sort       string
+	Descending bool
+	Page       int
+	Limit      int
+
+	path   string
+	params url.Values
+}
+
+// parseTableQuery reads a table's query string. Dates in the from and to
+// filters are days in loc, the timezone the user sees times in.
+func parseTableQuery(r *http.Request, sorts []string, loc *time.Location) tableQuery {
+	query := r.URL.Query()
+	q := tableQuery{
+		Search:     strings.TrimSpace(query.Get("q")),
+		Status:     strings.ToLower(strings.TrimSpace(query.Get("status"))),
+		OfferCode:  strings.TrimSpace(query.Get("offer_code")),
+		From:       parseFilterTime(query.Get("from"), loc),
+		To:         parseFilterTime(query.Get("to"), loc),
+		Sort:       "date",
+		Descending: true,
+		Page:       1,
+		Limit:      defaultTablePageSize,
+		path:       r.URL.Path,
+		params:     query,
+	}
+
+	if sortParam := query.Get("sort"); sortParam != "" {
+		name := strings.TrimPrefix(sortParam, "-")
+		for _, allowed := range sorts {
+			if name == allowed {
+				q.Sort = name
+				q.Descending = strings.HasPrefix(sortParam, "-")
+			}
+		}
+	}
+
+	if page, err := strconv.Atoi(query.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		q.Limit = limit
+	}
+	if q.Limit > maxTablePageSize {
+		q.Limit = maxTablePageSize
+	}
+	return q
+}
+
+// matches applies the search, status and date filters to one row.
+func (q tableQuery) matches(status string, date time.Time, fields ...string) bool {
+	if q.Status != "" && q.Status != status {
+		return false
+	}
+	if !q.From.IsZero() && date.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !date.Before(filterEnd(q.To)) {
+		return false
+	}
+	if q.Search == "" {
+		return true
+	}
+	search := strings.ToLower(q.Search)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// SortParam returns the sort in its query string form, e.g. "-date".
+func (q tableQuery) SortParam() string {
+	if q.Descending {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+// link returns the current page URL with some parameters replaced.
+func (q tableQuery) link(changes map[string]string) string {
+	params := url.Values{}
+	for name, values := range q.params {
+		params[name] = values
+	}
+	for name, value := range changes {
+		if value == "" {
+			params.Del(name)
+		} else {
+			params.Set(name, value)
+		}
+	}
+	if len(params) == 0 {
+		return q.path
+	}
+	return q.path + "?" + params.Encode()
+}
+
+// SortLink is the URL for a column header: it sorts by that column, and
+// flips the direction when the table is already sorted by it.
+func (q tableQuery) SortLink(column string) string {
+	sortParam := column
+	if column == "date" {
+		sortParam = "-date"
+	}
+	if q.Sort == column {
+		if q.Descending {
+			sortParam = column
+		} else {
+			sortParam = "-" + column
+		}
+	}
+	return q.link(map[string]string{"sort": sortParam, "page": ""})
+}
+
+// SortIndicator is the arrow shown next to the sorted column header.
+func (q tableQuery) SortIndicator(column string) string {
+	if q.Sort != column {
+		return ""
+	}
+	if q.Descending {
+		return "▼"
+	}
+	return "▲"
+}
+
+// PageLink is the URL of another page of the same results.
+func (q tableQuery) PageLink(page int) string {
+	if page <= 1 {
+		return q.link(map[string]string{"page": ""})
+	}
+	return q.link(map[string]string{"page": strconv.Itoa(page)})
+}
+
+// OfferCodeLink is the URL of the sales made with an offer code.
+func (q tableQuery) OfferCodeLink(name string) string {
+	return q.link(map[string]string{"offer_code": name, "page": ""})
+}
+
+// tablePage describes which slice of the matching rows is shown.
+type tablePage struct {
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+	Total int `json:"total"`
+	Pages int `json:"pages"`
+	// Unfiltered is the number of rows before filtering
+	Unfiltered int `json:"unfiltered"`
+}
+
+// paginate returns the bounds of the requested page within total rows. Pages
+// past the end are clamped to the last page.
+func (q tableQuery) paginate(total, unfiltered int) (int, int, tablePage) {
+	pages := int(math.Ceil(float64(total) / float64(q.Limit)))
+	if pages < 1 {
+		pages = 1
+	}
+	page := q.Page
+	if page > pages {
+		page = pages
+	}
+
+	start := (page - 1) * q.Limit
+	end := start + q.Limit
+	if end > total {
+		end = total
+	}
+	return start, end, tablePage{Page: page, Limit: q.Limit, Total: total, Pages: pages, Unfiltered: unfiltered}
+}
+
+var licenseSortColumns = []string{"date", "email", "key"}
+
+var licenseSorts = map[string]func(a, b License) bool{
+	"date": func(a, b License) bool {
+		return a.SaleDatetime.Before(b.SaleDatetime.Time)
+	},
+	"email": func(a, b License) bool { return strings.ToLower(a.PurchaserEmail) < strings.ToLower(b.PurchaserEmail) },
+	"key":   func(a, b License) bool { return a.LicenseKey < b.LicenseKey },
+}
+
+// queryLicenses filters, sorts and paginates licenses.
+func queryLicenses(licenses []License, q tableQuery) ([]License, tablePage) {
+	matching := make([]License, 0, len(licenses))
+	for _, license := range licenses {
+		status := purchaseStatus(license.Refunded, license.Disputed, license.Chargebacked)
+		if q.matches(status, license.SaleDatetime.Time, license.PurchaserEmail, license.LicenseKey, license.ID) {
+			matching = append(matching, license)
+		}
+	}
+
+	less := licenseSorts[q.Sort]
+	sort.SliceStable(matching, func(i, j int) bool {
+		if q.Descending {
+			return less(matching[j], matching[i])
+		}
+		return less(matching[i], matching[j])
+	})
+
+	start, end, page := q.paginate(len(matching), len(licenses))
+	return matching[start:end], page
+}
+
+var saleSortColumns = []string{"date", "email", "order", "price"}
+
+var saleSorts = map[string]func(a, b Sale) bool{
+	"date": func(a, b Sale) bool {
+		return a.SoldAt().Before(b.SoldAt())
+	},
+	"email": func(a, b Sale) bool { return strings.ToLower(a.Email) < strings.ToLower(b.Email) },
+	"order": func(a, b Sale) bool { return a.OrderID < b.OrderID },
+	"price": func(a, b Sale) bool { return a.Price < b.Price },
+}
+
+// querySales filters, sorts and paginates sales.
+func querySales(sales []Sale, q tableQuery) ([]Sale, tablePage) {
+	matching := filterSales(sales, q)
+
+	less := saleSorts[q.Sort]
+	sort.SliceStable(matching, func(i, j int) bool {
+		if q.Descending {
+			return less(matching[j], matching[i])
+		}
+		return less(matching[i], matching[j])
+	})
+
+	start, end, page := q.paginate(len(matching), len(sales))
+	return matching[start:end], page
+}
+
+// filterSales returns the sales matching the query's search and filters.
+func filterSales(sales []Sale, q tableQuery) []Sale {
+	matching := make([]Sale, 0, len(sales))
+	for _, sale := range sales {
+		status := purchaseStatus(sale.Refunded, sale.Disputed, sale.Chargebacked)
+		if q.OfferCode != "" && !sale.usedOfferCode(q.OfferCode) {
+			continue
+		}
+		orderID := strconv.FormatInt(sale.OrderID, 10)
+		if q.matches(status, sale.SoldAt(), sale.Email, sale.LicenseKey, orderID, sale.ID, string(sale.OfferCode)) {
+			matching = append(matching, sale)
+		}
+	}
+	return matching
+}
+
+func writeTableError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
+
+// productLicensesJSONHandler is the JSON equivalent of the licenses page,
+// addressed by Gumroad product ID and taking the same query parameters.
+func (app *App) productLicensesJSONHandler(w http.ResponseWriter, r *http.Request) {
+	licenses, err := app.getLicenses(mux.Vars(r)["id"])
+	if err != nil {
+		writeTableError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch licenses: %v", err))
+		return
+	}
+	if !app.canViewPII(r) {
+		licenses = maskLicensePII(licenses)
+	}
+
+	rows, page := queryLicenses(licenses, parseTableQuery(r, licenseSortColumns, app.timezone(r)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"licenses":   rows,
+		"pagination": page,
+	})
+}
+
+// productSalesJSONHandler is the JSON equivalent of the sales page.
+func (app *App) productSalesJSONHandler(w http.ResponseWriter, r *http.Request) {
+	sales, err := app.getSales(mux.Vars(r)["id"])
+	if err != nil {
+		writeTableError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch sales: %v", err))
+		return
+	}
+	if !app.canViewPII(r) {
+		sales = maskSalePII(sales)
+	}
+
+	query := parseTableQuery(r, saleSortColumns, app.timezone(r))
+	rows, page := querySales(sales, query)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"sales":      rows,
+		"pagination": page,
+		"totals":     app.totalSales(filterSales(sales, query)),
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTableQuery(t *testing.T) {
+	tests := []struct {
+		query      string
+		sort       string
+		descending bool
+		page       int
+		limit      int
+	}{
+		{"", "date", true, 1, defaultTablePageSize},
+		{"sort=email", "email", false, 1, defaultTablePageSize},
+		{"sort=-key", "key", true, 1, defaultTablePageSize},
+		// Only the table's columns can be sorted on
+		{"sort=price", "date", true, 1, defaultTablePageSize},
+		{"sort=-password", "date", true, 1, defaultTablePageSize},
+		{"page=3&limit=20", "date", true, 3, 20},
+		{"page=0&limit=-5", "date", true, 1, defaultTablePageSize},
+		{"page=abc&limit=abc", "date", true, 1, defaultTablePageSize},
+		{"limit=100000", "date", true, 1, maxTablePageSize},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/licenses/0?"+tt.query, nil)
+		q := parseTableQuery(req, licenseSortColumns, time.UTC)
+		if q.Sort != tt.sort || q.Descending != tt.descending || q.Page != tt.page || q.Limit != tt.limit {
+			t.Errorf("%q: sort %s descending %v page %d limit %d", tt.query, q.Sort, q.Descending, q.Page, q.Limit)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/sales/0?q=+Buyer+&status=Refunded&offer_code=SPRING", nil)
+	if q := parseTableQuery(req, saleSortColumns, time.UTC); q.Search != "Buyer" || q.Status != "refunded" || q.OfferCode != "SPRING" {
+		t.Errorf("filters %+v", q)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		page, limit, total int
+		start, end         int
+		want               tablePage
+	}{
+		{1, 10, 25, 0, 10, tablePage{Page: 1, Limit: 10, Total: 25, Pages: 3}},
+		{3, 10, 25, 20, 25, tablePage{Page: 3, Limit: 10, Total: 25, Pages: 3}},
+		// Pages past the end show the last page
+		{9, 10, 25, 20, 25, tablePage{Page: 3, Limit: 10, Total: 25, Pages: 3}},
+		{2, 10, 20, 10, 20, tablePage{Page: 2, Limit: 10, Total: 20, Pages: 2}},
+		{4, 10, 0, 0, 0, tablePage{Page: 1, Limit: 10, Total: 0, Pages: 1}},
+	}
+	for _, tt := range tests {
+		q := tableQuery{Page: tt.page, Limit: tt.limit}
+		start, end, page := q.paginate(tt.total, 30)
+		tt.want.Unfiltered = 30
+		if start != tt.start || end != tt.end || page != tt.want {
+			t.Errorf("page %d of %d: [%d:%d] %+v, want [%d:%d] %+v", tt.page, tt.total, start, end, page, tt.start, tt.end, tt.want)
+		}
+	}
+}
+
+func TestTableQueryDates(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 23:30 on 30 March in Berlin, the day the clocks go forward
+	sold := time.Date(2024, 3, 30, 22, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"from=2024-03-30", true},
+		{"from=2024-03-31", false},
+		{"to=2024-03-30", true},
+		{"to=2024-03-29", false},
+		{"from=2024-03-30&to=2024-03-30", true},
+		{"to=2024-03-30T23:00", false},
+		{"to=2024-03-30T23:31", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/sales/0?"+tt.query, nil)
+		if got := parseTableQuery(req, saleSortColumns, loc).matches(purchaseStatusActive, sold); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQuerySales(t *testing.T) {
+	sale := func(id string, order int64, email, key string, price int, day int, refunded bool) Sale {
+		return Sale{
+			ID:         id,
+			OrderID:    order,
+			Email:      email,
+			LicenseKey: key,
+			Price:      price,
+			CreatedAt:  Timestamp{Time: time.Date(2024, 11, day, 12, 0, 0, 0, time.UTC)},
+			Refunded:   refunded,
+		}
+	}
+	sales := []Sale{
+		sale("s1", 1001, "ada@example.com", "AAAA-1111", 500, 1, false),
+		sale("s2", 1002, "grace@example.com", "BBBB-2222", 1500, 2, true),
+		sale("s3", 2003, "Linus@Example.com", "CCCC-3333", 1000, 3, false),
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"s3", "s2", "s1"}},
+		{"sort=date", []string{"s1", "s2", "s3"}},
+		{"sort=-price", []string{"s2", "s3", "s1"}},
+		{"sort=email", []string{"s1", "s2", "s3"}},
+		// Search covers email, license key and order ID, ignoring case
+		{"q=LINUS", []string{"s3"}},
+		{"q=bbbb-2222", []string{"s2"}},
+		{"q=100", []string{"s2", "s1"}},
+		{"q=nobody", nil},
+		{"status=refunded", []string{"s2"}},
+		{"status=active&sort=order", []string{"s1", "s3"}},
+		{"limit=2&page=2", []string{"s1"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/sales/0?"+tt.query, nil)
+		rows, page := querySales(sales, parseTableQuery(req, saleSortColumns, time.UTC))
+		var ids []string
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.want) || page.Unfiltered != len(sales) {
+			t.Errorf("%q: %v (%+v), want %v", tt.query, ids, page, tt.want)
+		}
+	}
+}
+
+func TestQueryLicenses(t *testing.T) {
+	licenses := []License{
+		{ID: "l1", LicenseKey: "KEY-B", PurchaserEmail: "zed@example.com", SaleDatetime: Timestamp{Time: time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)}},
+		{ID: "l2", LicenseKey: "KEY-A", PurchaserEmail: "amy@example.com", SaleDatetime: Timestamp{Time: time.Date(2024, 11, 2, 0, 0, 0, 0, time.UTC)}, Chargebacked: true},
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"l2", "l1"}},
+		{"sort=key", []string{"l2", "l1"}},
+		{"sort=-email", []string{"l1", "l2"}},
+		{"q=key-b", []string{"l1"}},
+		{"q=l2", []string{"l2"}},
+		{"status=chargebacked", []string{"l2"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/licenses/0?"+tt.query, nil)
+		rows, _ := queryLicenses(licenses, parseTableQuery(req, licenseSortColumns, time.UTC))
+		var ids []string
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"html"
 	"html/template"
@@ -20,6 +21,55 @@ import (
 
 type Config struct {
 	GumroadToken string `json:"gumroad_token"`
+	// PublicProducts maps the product slugs accepted by /v1/licenses/verify
+	// to Gumroad products
+	PublicProducts map[string]PublicProduct `json:"public_products,omitempty"`
+	// PublicRateLimit is the number of public verification requests allowed
+	// per client IP per minute
+	PublicRateLimit int `json:"public_rate_limit,omitempty"`
+	// Policies holds license policies keyed by Gumroad product ID, with "*"
+	// as the fallback for products without their own entry
+	Policies map[string]LicensePolicy `json:"policies,omitempty"`
+	// RateLimits controls inbound limits and bans on license verification
+	RateLimits RateLimitConfig `json:"rate_limits,omitempty"`
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For; only
+	// enable it when running behind a reverse proxy that sets the header
+	TrustProxyHeaders bool `json:"trust_proxy_headers,omitempty"`
+	// VerificationCache controls reuse of successful verifications and the
+	// offline grace mode
+	VerificationCache VerificationCacheConfig `json:"verification_cache,omitempty"`
+	// WebhookSecret must be passed as ?secret= on Gumroad pings; the webhook
+	// endpoint is disabled while it is empty
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+	// BulkValidation bounds concurrency and request rate of bulk checks
+	BulkValidation BulkValidationConfig `json:"bulk_validation,omitempty"`
+	// APILogRetention is the number of API calls kept in memory
+	APILogRetention int `json:"api_log_retention,omitempty"`
+	// DataDir is where the app keeps the files it writes, such as API keys.
+	// Defaults to the working directory.
+	DataDir string `json:"data_dir,omitempty"`
+	// GumroadAPIBase is the Gumroad API root, e.g. the URL of the local
+	// simulator ("http://localhost:8087/v2"). Defaults to the real API.
+	GumroadAPIBase string `json:"gumroad_api_base,omitempty"`
+	// Locale sets how numbers and amounts of money are written, e.g.
+	// "de-DE". Defaults to "en-US".
+	Locale string `json:"locale,omitempty"`
+	// Timezone is the IANA zone times are shown in for users who have not
+	// picked one, e.g. "Europe/Berlin". Defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// ReportingCurrency, when set, adds a total of all currencies converted
+	// into it to the sales page, using ExchangeRates
+	ReportingCurrency string `json:"reporting_currency,omitempty"`
+	// ExchangeRates gives the value of one unit of each currency in the
+	// reporting currency, e.g. {"EUR": 1.08} for USD reporting
+	ExchangeRates map[string]float64 `json:"exchange_rates,omitempty"`
+}
+
+const defaultGumroadAPIBase = "https://api.gumroad.com/v2"
+
+// gumroadURL returns the URL of a Gumroad API path such as "/products".
+func (app *App) gumroadURL(path string) string {
+	return strings.TrimRight(withDefaultString(app.config.GumroadAPIBase, defaultGumroadAPIBase), "/") + path
 }
 
 type Product struct {
@@ -27,6 +77,29 @@ type Product struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Price       int    `json:"price"`
+	Currency    string `json:"currency"`
+	// CustomPermalink is the product's slug on Gumroad and ShortURL its
+	// public page
+	CustomPermalink string `json:"custom_permalink,omitempty"`
+	ShortURL        string `json:"short_url,omitempty"`
+	// Published is false for products disabled on Gumroad
+	Published bool `json:"published"`
+	// SubscriptionDuration is the billing period of membership products,
+	// such as "monthly"; it is empty for one-time purchases
+	SubscriptionDuration string           `json:"subscription_duration,omitempty"`
+	Variants             []ProductVariant `json:"variants,omitempty"`
+	CustomFields         []CustomField    `json:"custom_fields,omitempty"`
+	// FileInfo describes the product's files, e.g. {"Size": "1.2 MB"}
+	FileInfo map[string]interface{} `json:"file_info,omitempty"`
+	// SalesCount and SalesUSDCents are only sent to tokens with the
+	// view_sales scope
+	SalesCount    gumroadCount `json:"sales_count"`
+	SalesUSDCents gumroadCount `json:"sales_usd_cents"`
+}
+
+// IsMembership reports whether the product is sold as a subscription.
+func (p Product) IsMembership() bool {
+	return p.SubscriptionDuration != ""
 }
 
 type ProductsResponse struct {
@@ -34,51 +107,66 @@ type ProductsResponse struct {
 	Products []Product `json:"products"`
 }
 
+type ProductResponse struct {
+	Success bool    `json:"success"`
+	Product Product `json:"product"`
+}
+
 type License struct {
-	ID             string `json:"id"`
-	ProductName    string `json:"product_name"`
-	LicenseKey     string `json:"license_key"`
-	Permalink      string `json:"permalink"`
-	SaleDatetime   string `json:"sale_datetime"`
-	PurchaserEmail string `json:"purchaser_email"`
-	Refunded       bool   `json:"refunded"`
-	Disputed       bool   `json:"disputed"`
-	Chargebacked   bool   `json:"chargebacked"`
-}
-
-type LicensesResponse struct {
-	Success  bool      `json:"success"`
-	Licenses []License `json:"licenses"`
+	ID             string    `json:"id"`
+	ProductName    string    `json:"product_name"`
+	LicenseKey     string    `json:"license_key"`
+	Permalink      string    `json:"permalink"`
+	SaleDatetime   Timestamp `json:"sale_datetime"`
+	PurchaserEmail string    `json:"purchaser_email"`
+	Refunded       bool      `json:"refunded"`
+	Disputed       bool      `json:"disputed"`
+	Chargebacked   bool      `json:"chargebacked"`
 }
 
 type Sale struct {
-	ID              string `json:"id"`
-	Email           string `json:"email"`
-	Price           int    `json:"price"`
-	GumroadFee      int    `json:"gumroad_fee"`
-	Currency        string `json:"currency"`
-	Quantity        int    `json:"quantity"`
-	DiscoverFee     int    `json:"discover_fee"`
-	CanContact      bool   `json:"can_contact"`
-	Referrer        string `json:"referrer"`
-	OrderID         int64  `json:"order_id"`
-	CreatedAt       string `json:"created_at"`
-	ProductID       string `json:"product_id"`
-	ProductName     string `json:"product_name"`
-	Refunded        bool   `json:"refunded"`
-	Disputed        bool   `json:"disputed"`
-	Chargebacked    bool   `json:"chargebacked"`
-	AffiliateCredit int    `json:"affiliate_credit"`
+	ID               string    `json:"id"`
+	Email            string    `json:"email"`
+	Price            int       `json:"price"`
+	GumroadFee       int       `json:"gumroad_fee"`
+	Currency         string    `json:"currency"`
+	Quantity         int       `json:"quantity"`
+	DiscoverFee      int       `json:"discover_fee"`
+	CanContact       bool      `json:"can_contact"`
+	Referrer         string    `json:"referrer"`
+	OrderID          int64     `json:"order_id"`
+	CreatedAt        Timestamp `json:"created_at"`
+	ProductID        string    `json:"product_id"`
+	ProductName      string    `json:"product_name"`
+	ProductPermalink string    `json:"product_permalink"`
+	Refunded         bool      `json:"refunded"`
+	// PartiallyRefunded is set once part of the price has been refunded;
+	// Refunded only once all of it has
+	PartiallyRefunded bool `json:"partially_refunded"`
+	Disputed          bool `json:"disputed"`
+	Chargebacked      bool `json:"chargebacked"`
+	AffiliateCredit   int  `json:"affiliate_credit"`
 	// Adding some common fields from API response
 	PurchaserID string `json:"purchaser_id"`
 	LicenseKey  string `json:"license_key"`
-	Timestamp   string `json:"timestamp"`
-	Daystamp    string `json:"daystamp"`
+	// OfferCode is the discount code the sale was bought with, if any
+	OfferCode offerCodeName `json:"offer_code"`
+	// SubscriptionID is the subscriber a membership sale was charged to
+	SubscriptionID string    `json:"subscription_id"`
+	Timestamp      string    `json:"timestamp"`
+	Daystamp       Timestamp `json:"daystamp"`
+	// ShippingInformation is the buyer's address, sent for sales of
+	// physical products only
+	ShippingInformation map[string]interface{} `json:"shipping_information,omitempty"`
+	Shipped             bool                   `json:"shipped"`
+	TrackingURL         string                 `json:"tracking_url,omitempty"`
 }
 
 type SalesResponse struct {
 	Success bool   `json:"success"`
 	Sales   []Sale `json:"sales"`
+	// NextPageKey is set when there are more sales to fetch
+	NextPageKey string `json:"next_page_key"`
 }
 
 type ValidateLicenseRequest struct {
@@ -91,9 +179,17 @@ type LicenseValidationResponse struct {
 	Uses     int                    `json:"uses,omitempty"`
 	Purchase map[string]interface{} `json:"purchase,omitempty"`
 	Message  string                 `json:"message,omitempty"`
+	Policy   *PolicyDecision        `json:"policy,omitempty"`
+	// Cached results were served from the verification cache; Stale ones
+	// were served because Gumroad could not be reached
+	Cached   bool       `json:"cached,omitempty"`
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+	Stale    bool       `json:"stale,omitempty"`
 }
 
 type APICall struct {
+	// ID increases monotonically and is used as the SSE event ID
+	ID           int64
 	Timestamp    time.Time
 	Method       string
 	URL          string
@@ -103,29 +199,125 @@ type APICall struct {
 	RequestBody  string
 	ResponseBody string
 	Headers      map[string]string
+	// ResponseHeaders keeps the first value of each response header
+	ResponseHeaders map[string]string
+	// PolicyRule is the license policy rule that matched a verification call
+	PolicyRule string
+	// Actor is the API key (or user) on whose behalf the call was made
+	Actor string `json:",omitempty"`
 }
 
 type PageData struct {
-	Title          string
-	CurrentPage    string
-	BackLink       string
-	Products       []Product
-	Licenses       []License
-	Sales          []Sale
-	ProductID      string
-	APICallsResult []APICall
+	Title       string
+	CurrentPage string
+	BackLink    string
+	Products    []Product
+	Licenses    []License
+	Sales       []Sale
+	ProductID   string
+	// Search, filters and pagination of the licenses and sales tables
+	TableQuery tableQuery
+	TablePage  tablePage
+	// TableStatuses are the status filter's choices when they are not the
+	// purchase statuses
+	TableStatuses []string
+	SalesTotals   salesTotals
+	// Subscribers page and its drill-down into one subscriber's charges
+	Subscribers       []Subscriber
+	ProductIndex      int
+	SubscriberSummary subscriberSummary
+	Subscriber        Subscriber
+	Charges           []Sale
+	// Offer codes page
+	OfferCodes []offerCodeUsage
+	// Product page
+	Product           Product
+	VariantCategories []VariantCategory
+	APICallsResult    []APICall
+	APILogQuery       apiCallQuery
+	APILogStats       []endpointStats
+	APILogTotal       int
+	NextPageURL       string
+	FirstPageURL      string
+	LastAPICallID     int64
+	// APILogLive is set when the page shows the newest calls and can be
+	// extended by the live stream
+	APILogLive bool
+	// Throttling page
+	ThrottledClients []ThrottledClient
+	// API keys page
+	APIKeys []APIKey
+	// Users page and login form
+	Users      []User
+	LoginNext  string
+	LoginError string
+	// CurrentUser is the logged-in user; AccessControl is false while no
+	// users exist and every page is open
+	CurrentUser   User
+	AccessControl bool
+	// CSRFToken is echoed by forms and fetch calls on state-changing requests
+	CSRFToken string
+	// Language is the page's language, chosen from Languages in the menu;
+	// Messages are the translations the scripts use
+	Language  string
+	Languages []languageOption
+	Messages  catalog
+	// Timezone is the zone times are shown in, chosen from Timezones in the
+	// menu
+	Timezone  string
+	Timezones []string
+	// PIIMasked is set when purchaser emails and keys are masked for viewers
+	PIIMasked bool
+	// Audit log page
+	AuditEvents   []AuditEvent
+	AuditAction   string
+	AuditActions  []string
+	ExportURLJSON string
+	ExportURLCSV  string
+}
+
+// Can reports whether the current user has at least the given role.
+func (d PageData) Can(role string) bool {
+	return !d.AccessControl || roleAllows(d.CurrentUser.Role, role)
 }
 
 type App struct {
-	config    Config
-	apiCalls  []APICall
-	mu        sync.RWMutex
-	templates *template.Template
+	config             Config
+	apiCalls           []APICall
+	lastAPICallID      int64
+	apiCallSubscribers map[chan APICall]struct{}
+	mu                 sync.RWMutex
+	assets             *assets
+	templates          *template.Template
+	publicLimiter      *rateLimiter
+	guard              *abuseGuard
+	verifyCache        *verificationCache
+	bulk               *bulkValidator
+	apiKeys            *apiKeyStore
+	users              *userStore
+	loginLimiter       *rateLimiter
+	auditLog           *auditLog
+	// configPath is the config file the setup page reads and writes
+	configPath string
+	// transport carries Gumroad API requests; nil uses the default transport.
+	// Tests replace it to replay recorded responses.
+	transport http.RoundTripper
+	// templatesMu guards templates and catalogs, which dev mode replaces
+	// when the files change; templatesStamp identifies the parsed version
+	templatesMu    sync.Mutex
+	templatesStamp string
+	// catalogs are the UI messages by language
+	catalogs map[string]catalog
+	// now returns the current time; nil uses time.Now. Tests fix it so
+	// relative times in pages do not change.
+	now func() time.Time
 }
 
-func loadConfig() (Config, error) {
+const defaultConfigPath = "config.json"
+
+func loadConfig(path string) (Config, error) {
 	var config Config
-	file, err := os.Open("config.json")
+	file, err := os.Open(path)
 	if err != nil {
 		return config, err
 	}
@@ -136,8 +328,8 @@ func loadConfig() (Config, error) {
 	return config, err
 }
 
-func saveConfig(config Config) error {
-	file, err := os.Create("config.json")
+func saveConfig(path string, config Config) error {
+	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
@@ -148,11 +340,24 @@ func saveConfig(config Config) error {
 	return encoder.Encode(config)
 }
 
+// httpClient returns a client for Gumroad API requests.
+func (app *App) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: app.transport}
+}
+
 func isTokenConfigured(config Config) bool {
 	return config.GumroadToken != "" && config.GumroadToken != "YOUR_GUMROAD_ACCESS_TOKEN_HERE"
 }
 
 func (app *App) loadTemplates() error {
+	catalogs, err := loadCatalogs(app.assets.locales)
+	if err != nil {
+		return err
+	}
+	// t, timestamp, number and money are replaced per request by renderPage
+	// to use the request's language and timezone
+	english := localizer{language: fallbackLanguage, catalogs: catalogs, location: time.UTC}
+
 	funcMap := template.FuncMap{
 		"div":      func(a, b float64) float64 { return a / b },
 		"mul":      func(a, b int) time.Duration { return time.Duration(a * b) },
@@ -164,91 +369,177 @@ func (app *App) loadTemplates() error {
 			b, _ := json.Marshal(v)
 			return template.JS(b)
 		},
-		"sub": func(a, b int) int { return a - b },
+		"sub":  func(a, b int) int { return a - b },
+		"add":  func(a, b int) int { return a + b },
+		"list": func(items ...string) []string { return items },
 		"durationMs": func(d time.Duration) int {
 			return int(d.Nanoseconds() / 1000000)
 		},
+		// nonce is replaced per request by renderPage with the nonce of the
+		// response's Content-Security-Policy
+		"nonce": func() string { return "" },
+		// t translates a message, e.g. {{t "licenses.title" .Name}}
+		"t": english.t,
+		// timestamp renders a time as a <time> element, e.g.
+		// {{timestamp .CreatedAt}}
+		"timestamp": english.timestampOf,
+		// number groups the digits of a count
+		"number": english.number,
+		// money formats an amount in its currency, e.g. {{money .PriceMoney}}
+		"money": english.money,
+		// asset returns the content-hashed URL of a file under static/
+		"asset": func(name string) string { return app.assets.url(name) },
+	}
+
+	if app.assets.dev {
+		stamp, err := app.assets.templatesStamp()
+		if err != nil {
+			return err
+		}
+		app.templatesStamp = stamp
 	}
 
-	templates := template.New("").Funcs(funcMap)
-
 	// Parse all template files
-	var err error
-	templates, err = templates.ParseGlob("templates/*.html")
+	templates, err := template.New("").Funcs(funcMap).ParseFS(app.assets.templates, "*.html")
 	if err != nil {
 		return err
 	}
 
 	app.templates = templates
+	app.catalogs = catalogs
 	return nil
 }
 
-func (app *App) logAPICall(method, url string, status int, duration time.Duration, err error, requestBody, responseBody string, headers map[string]string) {
+// recordAPICall appends a fully populated call to the log and pushes it to
+// live stream subscribers. The returned copy carries the assigned ID.
+func (app *App) recordAPICall(apiCall APICall) APICall {
 	app.mu.Lock()
 	defer app.mu.Unlock()
 
+	app.lastAPICallID++
+	apiCall.ID = app.lastAPICallID
+	app.apiCalls = append(app.apiCalls, apiCall)
+	app.publishAPICall(apiCall)
+
+	// Keep only the most recent calls
+	retention := withDefault(app.config.APILogRetention, defaultAPILogRetention)
+	if len(app.apiCalls) > retention {
+		app.apiCalls = app.apiCalls[len(app.apiCalls)-retention:]
+	}
+	return apiCall
+}
+
+// sendGumroadRequest performs an authenticated Gumroad API request with an
+// optional form-encoded body and records it in the API call log. Non-2xx
+// responses are not treated as errors here; callers decide what they mean.
+func (app *App) sendGumroadRequest(method, url, requestBody string) (APICall, []byte, error) {
+	return app.sendGumroadRequestAs("", method, url, requestBody)
+}
+
+// sendGumroadRequestAs is sendGumroadRequest for calls made on behalf of an
+// API key or user, who is recorded as the call's actor.
+func (app *App) sendGumroadRequestAs(actor, method, url, requestBody string) (APICall, []byte, error) {
+	start := time.Now()
 	apiCall := APICall{
-		Timestamp:    time.Now(),
-		Method:       method,
-		URL:          url,
-		Status:       status,
-		Duration:     duration,
-		RequestBody:  requestBody,
-		ResponseBody: responseBody,
-		Headers:      headers,
+		Timestamp:   start,
+		Method:      method,
+		URL:         url,
+		RequestBody: requestBody,
+		Actor:       actor,
+	}
+
+	var bodyReader io.Reader
+	if requestBody != "" {
+		bodyReader = strings.NewReader(requestBody)
 	}
 
+	req, err := http.NewRequest(method, url, bodyReader)
 	if err != nil {
+		apiCall.Duration = time.Since(start)
 		apiCall.Error = err.Error()
+		return app.recordAPICall(apiCall), nil, err
 	}
 
-	app.apiCalls = append(app.apiCalls, apiCall)
-
-	// Keep only last 100 API calls
-	if len(app.apiCalls) > 100 {
-		app.apiCalls = app.apiCalls[len(app.apiCalls)-100:]
+	req.Header.Set("Authorization", "Bearer "+app.config.GumroadToken)
+	if requestBody != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
-}
 
-func (app *App) makeGumroadRequest(url string) ([]byte, error) {
-	start := time.Now()
+	// Capture request headers, without the token: the log is readable by
+	// support users, who cannot see the token on the setup page
+	apiCall.Headers = recordedHeaders(req.Header)
 
-	req, err := http.NewRequest("GET", url, nil)
+	resp, err := app.httpClient(30 * time.Second).Do(req)
 	if err != nil {
-		app.logAPICall("GET", url, 0, time.Since(start), err, "", "", nil)
-		return nil, err
+		apiCall.Duration = time.Since(start)
+		apiCall.Error = err.Error()
+		return app.recordAPICall(apiCall), nil, err
 	}
+	defer resp.Body.Close()
 
-	req.Header.Set("Authorization", "Bearer "+app.config.GumroadToken)
-
-	// Capture request headers
-	headers := make(map[string]string)
-	for k, v := range req.Header {
-		headers[k] = v[0]
+	body, err := io.ReadAll(resp.Body)
+	apiCall.Duration = time.Since(start)
+	apiCall.Status = resp.StatusCode
+	apiCall.ResponseBody = string(body)
+	apiCall.ResponseHeaders = recordedHeaders(resp.Header)
+	if err != nil {
+		apiCall.Error = err.Error()
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	return app.recordAPICall(apiCall), body, err
+}
+
+// gumroadStatusError is returned when Gumroad answers with a non-200 status.
+type gumroadStatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *gumroadStatusError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.Status, e.Body)
+}
+
+func (app *App) makeGumroadRequest(url string) ([]byte, error) {
+	apiCall, body, err := app.sendGumroadRequest("GET", url, "")
 	if err != nil {
-		app.logAPICall("GET", url, 0, time.Since(start), err, "", "", headers)
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	responseBody := string(body)
+	if apiCall.Status != http.StatusOK {
+		return nil, &gumroadStatusError{Status: apiCall.Status, Body: string(body)}
+	}
 
-	app.logAPICall("GET", url, resp.StatusCode, time.Since(start), err, "", responseBody, headers)
+	return body, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+// sendGumroadWrite sends a change to Gumroad on behalf of actor and decodes
+// the response into result, which may be nil when only success matters.
+func (app *App) sendGumroadWrite(actor, method, target string, form url.Values, result interface{}) error {
+	apiCall, body, err := app.sendGumroadRequestAs(actor, method, target, form.Encode())
+	if err != nil {
+		return err
+	}
+	if apiCall.Status != http.StatusOK {
+		return &gumroadStatusError{Status: apiCall.Status, Body: string(body)}
 	}
 
-	return body, err
+	var response struct {
+		Success bool `json:"success"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("API request was not successful")
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body, result)
 }
 
 func (app *App) getProducts() ([]Product, error) {
-	body, err := app.makeGumroadRequest("https://api.gumroad.com/v2/products")
+	body, err := app.makeGumroadRequest(app.gumroadURL("/products"))
 	if err != nil {
 		return nil, err
 	}
@@ -266,45 +557,92 @@ func (app *App) getProducts() ([]Product, error) {
 	return response.Products, nil
 }
 
-func (app *App) getLicenses(productID string) ([]License, error) {
-	url := fmt.Sprintf("https://api.gumroad.com/v2/products/%s/subscribers", productID)
-	body, err := app.makeGumroadRequest(url)
+func (app *App) getProduct(productID string) (Product, error) {
+	body, err := app.makeGumroadRequest(app.gumroadURL("/products/" + url.PathEscape(productID)))
 	if err != nil {
-		return nil, err
+		return Product{}, err
 	}
 
-	var response LicensesResponse
+	var response ProductResponse
 	err = json.Unmarshal(body, &response)
 	if err != nil {
-		return nil, err
+		return Product{}, err
 	}
 
 	if !response.Success {
-		return nil, fmt.Errorf("API request was not successful")
+		return Product{}, fmt.Errorf("API request was not successful")
 	}
 
-	return response.Licenses, nil
+	return response.Product, nil
 }
 
-func (app *App) getSales(productID string) ([]Sale, error) {
-	url := fmt.Sprintf("https://api.gumroad.com/v2/sales?product_id=%s", productID)
-
-	body, err := app.makeGumroadRequest(url)
+// getLicenses lists the license keys of a product. Gumroad has no endpoint
+// listing licenses, but every sale of a product with license keys enabled
+// carries its key, so they are taken from the product's sales.
+func (app *App) getLicenses(productID string) ([]License, error) {
+	sales, err := app.getSales(productID)
 	if err != nil {
 		return nil, err
 	}
 
-	var response SalesResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, err
+	licenses := make([]License, 0, len(sales))
+	for _, sale := range sales {
+		if sale.LicenseKey == "" {
+			continue
+		}
+		licenses = append(licenses, License{
+			ID:             sale.ID,
+			ProductName:    sale.ProductName,
+			LicenseKey:     sale.LicenseKey,
+			Permalink:      sale.ProductPermalink,
+			SaleDatetime:   sale.CreatedAt,
+			PurchaserEmail: sale.Email,
+			Refunded:       sale.Refunded,
+			Disputed:       sale.Disputed,
+			Chargebacked:   sale.Chargebacked,
+		})
 	}
+	return licenses, nil
+}
 
-	if !response.Success {
-		return nil, fmt.Errorf("API request was not successful")
+// getSales fetches every sale of a product, following Gumroad's page keys.
+// An empty product ID fetches the sales of all products.
+func (app *App) getSales(productID string) ([]Sale, error) {
+	var sales []Sale
+	pageKey := ""
+
+	for page := 0; page < maxSalesPages; page++ {
+		params := url.Values{}
+		if productID != "" {
+			params.Set("product_id", productID)
+		}
+		if pageKey != "" {
+			params.Set("page_key", pageKey)
+		}
+		body, err := app.makeGumroadRequest(app.gumroadURL("/sales?" + params.Encode()))
+		if err != nil {
+			return nil, err
+		}
+
+		var response SalesResponse
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		if !response.Success {
+			return nil, fmt.Errorf("API request was not successful")
+		}
+
+		sales = append(sales, response.Sales...)
+		if response.NextPageKey == "" {
+			return sales, nil
+		}
+		pageKey = response.NextPageKey
 	}
 
-	return response.Sales, nil
+	log.Printf("Stopped fetching sales for product %s after %d pages", productID, maxSalesPages)
+	return sales, nil
 }
 
 func (app *App) indexHandler(w http.ResponseWriter, r *http.Request) {
@@ -318,16 +656,12 @@ func (app *App) indexHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Fetched %d products successfully", len(products))
 	data := PageData{
-		Title:       "Products",
+		Title:       app.localizer(r).t("products.title"),
 		CurrentPage: "products",
 		Products:    products,
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	err = app.templates.ExecuteTemplate(w, "base.html", data)
-	if err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, "Template execution error: "+err.Error(), http.StatusInternalServerError)
+	if err := app.renderPage(w, r, data); err != nil {
 		return
 	}
 	log.Printf("Products template rendered successfully")
@@ -363,21 +697,26 @@ func (app *App) licensesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to fetch licenses: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	masked := !app.canViewPII(r)
+	if masked {
+		licenses = maskLicensePII(licenses)
+	}
+
+	query := parseTableQuery(r, licenseSortColumns, app.timezone(r))
+	licenses, page := queryLicenses(licenses, query)
 
 	data := PageData{
-		Title:       fmt.Sprintf("License Keys - %s", product.Name),
+		Title:       app.localizer(r).t("licenses.title", product.Name),
 		CurrentPage: "licenses",
 		BackLink:    "/",
 		Licenses:    licenses,
 		ProductID:   productID,
+		TableQuery:  query,
+		TablePage:   page,
+		PIIMasked:   masked,
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	err = app.templates.ExecuteTemplate(w, "base.html", data)
-	if err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, "Template execution error: "+err.Error(), http.StatusInternalServerError)
-	}
+	app.renderPage(w, r, data)
 }
 
 func (app *App) salesHandler(w http.ResponseWriter, r *http.Request) {
@@ -410,32 +749,49 @@ func (app *App) salesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to fetch sales: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	data := PageData{
-		Title:       fmt.Sprintf("Sales - %s", product.Name),
-		CurrentPage: "sales",
-		BackLink:    "/",
-		Sales:       sales,
-		ProductID:   productID,
+	masked := !app.canViewPII(r)
+	if masked {
+		sales = maskSalePII(sales)
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	err = app.templates.ExecuteTemplate(w, "base.html", data)
-	if err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, "Template execution error: "+err.Error(), http.StatusInternalServerError)
-	}
+	query := parseTableQuery(r, saleSortColumns, app.timezone(r))
+	totals := app.totalSales(filterSales(sales, query))
+	sales, page := querySales(sales, query)
+
+	data := PageData{
+		Title:        app.localizer(r).t("sales.title", product.Name),
+		CurrentPage:  "sales",
+		BackLink:     "/",
+		Sales:        sales,
+		ProductID:    productID,
+		ProductIndex: index,
+		TableQuery:   query,
+		TablePage:    page,
+		SalesTotals:  totals,
+		PIIMasked:    masked,
+	}
+
+	app.renderPage(w, r, data)
 }
 
 func (app *App) apiLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := parseAPICallQuery(r.URL.Query(), app.timezone(r), defaultAPILogPageSize)
+	page := app.queryAPICalls(query)
+
 	app.mu.RLock()
-	apiCalls := make([]APICall, len(app.apiCalls))
-	copy(apiCalls, app.apiCalls)
+	lastAPICallID := app.lastAPICallID
 	app.mu.RUnlock()
 
-	// Reverse the slice to show newest first
-	for i, j := 0, len(apiCalls)-1; i < j; i, j = i+1, j-1 {
-		apiCalls[i], apiCalls[j] = apiCalls[j], apiCalls[i]
+	nextPageURL, firstPageURL := "", ""
+	if page.NextCursor != "" {
+		params := r.URL.Query()
+		params.Set("cursor", page.NextCursor)
+		nextPageURL = "/api-log?" + params.Encode()
+	}
+	if query.Cursor != "" {
+		params := r.URL.Query()
+		params.Del("cursor")
+		firstPageURL = "/api-log?" + params.Encode()
 	}
 
 	// Determine back link based on referer
@@ -455,23 +811,25 @@ func (app *App) apiLogHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := PageData{
-		Title:          "API Call Log",
+		Title:          app.localizer(r).t("api_log.title"),
 		CurrentPage:    "api-log",
 		BackLink:       backLink,
-		APICallsResult: apiCalls,
+		APICallsResult: page.Calls,
+		APILogQuery:    query,
+		APILogStats:    page.Stats,
+		APILogTotal:    page.Total,
+		NextPageURL:    nextPageURL,
+		FirstPageURL:   firstPageURL,
+		LastAPICallID:  lastAPICallID,
+		APILogLive:     query.Cursor == "" && query.Sort == "time" && query.Descending,
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	err := app.templates.ExecuteTemplate(w, "base.html", data)
-	if err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, "Template execution error: "+err.Error(), http.StatusInternalServerError)
-	}
+	app.renderPage(w, r, data)
 }
 
 func (app *App) setupHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if token is already configured
-	config, err := loadConfig()
+	config, err := loadConfig(app.configPath)
 	log.Printf("Setup handler: token=%s, err=%v", config.GumroadToken, err)
 	if err == nil && config.GumroadToken != "" && config.GumroadToken != "YOUR_GUMROAD_ACCESS_TOKEN_HERE" {
 		// Token is configured, redirect to main page
@@ -482,16 +840,11 @@ func (app *App) setupHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Showing setup page")
 	data := PageData{
-		Title:       "Setup - Gumroad Token",
+		Title:       app.localizer(r).t("setup.title"),
 		CurrentPage: "setup",
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	err = app.templates.ExecuteTemplate(w, "base.html", data)
-	if err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, "Template execution error: "+err.Error(), http.StatusInternalServerError)
-	}
+	app.renderPage(w, r, data)
 }
 
 func (app *App) setupSubmitHandler(w http.ResponseWriter, r *http.Request) {
@@ -526,6 +879,7 @@ func (app *App) setupSubmitHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Test the token by making a simple API call
 	if err := app.testGumroadToken(requestData.Token); err != nil {
+		app.audit(r, AuditEvent{Action: auditSetupToken, Detail: "token rejected"}, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -537,7 +891,8 @@ func (app *App) setupSubmitHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Save the token
 	app.config.GumroadToken = requestData.Token
-	if err := saveConfig(app.config); err != nil {
+	if err := saveConfig(app.configPath, app.config); err != nil {
+		app.audit(r, AuditEvent{Action: auditSetupToken, Detail: "saving config"}, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -547,6 +902,7 @@ func (app *App) setupSubmitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.audit(r, AuditEvent{Action: auditSetupToken, Detail: "token changed"}, nil)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
@@ -555,15 +911,14 @@ func (app *App) setupSubmitHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) testGumroadToken(token string) error {
-	req, err := http.NewRequest("GET", "https://api.gumroad.com/v2/products", nil)
+	req, err := http.NewRequest("GET", app.gumroadURL("/products"), nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := app.httpClient(10 * time.Second).Do(req)
 	if err != nil {
 		return err
 	}
@@ -583,7 +938,7 @@ func (app *App) testGumroadToken(token string) error {
 func (app *App) setupMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check current token status dynamically
-		config, err := loadConfig()
+		config, err := loadConfig(app.configPath)
 		log.Printf("Setup middleware: path=%s, token=%s, err=%v", r.URL.Path, config.GumroadToken, err)
 		if err != nil || config.GumroadToken == "" || config.GumroadToken == "YOUR_GUMROAD_ACCESS_TOKEN_HERE" {
 			// No token configured or placeholder token, redirect to setup
@@ -596,20 +951,71 @@ func (app *App) setupMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
-// apiCallsJSONHandler returns API calls data as JSON
+// renderPage executes base.html with data, filling in the logged-in user for
+// the navigation.
+func (app *App) renderPage(w http.ResponseWriter, r *http.Request, data PageData) error {
+	w.Header().Set("Content-Type", "text/html")
+	err := app.executeTemplate(w, r, "base.html", data)
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Template execution error: "+err.Error(), http.StatusInternalServerError)
+	}
+	return err
+}
+
+// executeTemplate renders one of the page templates, such as a table row
+// sent back after an action, with the same user, language and functions as
+// a whole page.
+func (app *App) executeTemplate(w io.Writer, r *http.Request, name string, data PageData) error {
+	data.AccessControl = app.users.enabled()
+	data.CSRFToken = csrfToken(r)
+	if user, ok := currentUser(r); ok {
+		data.CurrentUser = user
+	} else if user, ok := app.sessionUser(r); ok {
+		data.CurrentUser = user
+	}
+
+	// html/template cannot be re-bound once executed, so each request gets a
+	// clone with its own CSP nonce and language and the parsed set is never
+	// executed
+	l := app.localizer(r)
+	data.Language = l.language
+	data.Languages = l.languages()
+	data.Messages = l.jsMessages()
+	data.Timezone = l.location.String()
+	data.Timezones = app.timezones(l.location)
+
+	templates, err := app.pageTemplates()
+	if err == nil {
+		nonce := cspNonce(r)
+		templates.Funcs(template.FuncMap{
+			"nonce":     func() string { return nonce },
+			"t":         l.t,
+			"timestamp": l.timestampOf,
+			"number":    l.number,
+			"money":     l.money,
+		})
+		err = templates.ExecuteTemplate(w, name, data)
+	}
+	return err
+}
+
+// apiCallsJSONHandler returns API calls data as JSON. The body stays a plain
+// array; the cursor for the next page is sent in the X-Next-Cursor and Link
+// headers.
 func (app *App) apiCallsJSONHandler(w http.ResponseWriter, r *http.Request) {
-	app.mu.RLock()
-	apiCallsCopy := make([]APICall, len(app.apiCalls))
-	copy(apiCallsCopy, app.apiCalls)
-	app.mu.RUnlock()
+	page := app.queryAPICalls(parseAPICallQuery(r.URL.Query(), app.timezone(r), 100))
 
-	// Reverse the slice to show newest first (same as template)
-	for i, j := 0, len(apiCallsCopy)-1; i < j; i, j = i+1, j-1 {
-		apiCallsCopy[i], apiCallsCopy[j] = apiCallsCopy[j], apiCallsCopy[i]
+	if page.NextCursor != "" {
+		params := r.URL.Query()
+		params.Set("cursor", page.NextCursor)
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`</api/api-calls?%s>; rel="next"`, params.Encode()))
 	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(apiCallsCopy)
+	json.NewEncoder(w).Encode(page.Calls)
 }
 
 func (app *App) validateLicenseHandler(w http.ResponseWriter, r *http.Request) {
@@ -618,7 +1024,24 @@ func (app *App) validateLicenseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tooMany := func(wait time.Duration) {
+		setRetryAfter(w, wait)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(LicenseValidationResponse{
+			Success: false,
+			Message: "Too many validation requests, try again later",
+		})
+	}
+
+	ip := app.clientIP(r)
+	if ok, wait := app.guard.allowIP(app.guard.ipLimiter, ip); !ok {
+		tooMany(wait)
+		return
+	}
+
 	var req ValidateLicenseRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxVerifyRequestSize)
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
@@ -630,91 +1053,185 @@ func (app *App) validateLicenseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Call Gumroad license verification API
-	url := "https://api.gumroad.com/v2/licenses/verify"
-
-	// Create form data for the request with increment_uses_count=false
-	data := fmt.Sprintf("product_id=%s&license_key=%s&increment_uses_count=false", req.ProductID, req.LicenseKey)
-
-	startTime := time.Now()
-	httpReq, err := http.NewRequest("POST", url, strings.NewReader(data))
-	if err != nil {
-		duration := time.Since(startTime)
-		app.logAPICall("POST", url, 0, duration, err, data, "", nil)
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+	if ok, wait := app.guard.allowKey(req.ProductID, req.LicenseKey); !ok {
+		tooMany(wait)
 		return
 	}
 
-	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		duration := time.Since(startTime)
-		app.logAPICall("POST", url, 0, duration, err, data, "", nil)
-		http.Error(w, "Failed to validate license", http.StatusInternalServerError)
-		return
+	// The admin UI only inspects keys, so never increment the uses count, and
+	// always ask Gumroad rather than trusting a cached answer
+	response, err := app.verifyLicense(req.ProductID, req.LicenseKey, verifyOptions{Live: true})
+	event := AuditEvent{
+		Action:     auditLicenseValidate,
+		TargetType: "license",
+		Target:     licenseKeyHash(req.LicenseKey),
+		ProductID:  req.ProductID,
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	responseBody := string(body)
-	duration := time.Since(startTime)
-
-	// Log the API call
-	headers := make(map[string]string)
-	headers["Content-Type"] = httpReq.Header.Get("Content-Type")
-	app.logAPICall("POST", url, resp.StatusCode, duration, err, data, responseBody, headers)
-
 	if err != nil {
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
+		app.audit(r, event, err)
+		log.Printf("License validation failed: %v", err)
+		http.Error(w, "Failed to validate license", http.StatusInternalServerError)
 		return
 	}
 
-	// Parse the response
-	var gumroadResponse map[string]interface{}
-	err = json.Unmarshal(body, &gumroadResponse)
-	if err != nil {
-		http.Error(w, "Failed to parse response", http.StatusInternalServerError)
-		return
+	if !response.Success && response.Policy == nil {
+		app.guard.recordInvalidKey(ip)
 	}
+	event.Detail = fmt.Sprintf("valid=%v", response.Success)
+	app.audit(r, event, nil)
 
-	// Create our response
-	response := LicenseValidationResponse{
-		Success: false,
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	if success, ok := gumroadResponse["success"].(bool); ok && success {
-		response.Success = true
+// routes builds the application's router.
+func (app *App) routes() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(app.securityHeaders, app.csrfMiddleware)
 
-		if uses, ok := gumroadResponse["uses"].(float64); ok {
-			response.Uses = int(uses)
-		}
+	// Setup routes (always available)
+	r.HandleFunc("/setup", app.require(roleAdmin, app.setupHandler)).Methods("GET")
+	r.HandleFunc("/setup/submit", app.require(roleAdmin, app.setupSubmitHandler)).Methods("POST")
 
-		if purchase, ok := gumroadResponse["purchase"].(map[string]interface{}); ok {
-			response.Purchase = purchase
-		}
-	} else {
-		if msg, ok := gumroadResponse["message"].(string); ok {
-			response.Message = msg
-		} else {
-			response.Message = "Invalid license key"
-		}
-	}
+	// Favicon handler (returns empty response)
+	r.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("GET")
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	// Login (always available once users exist)
+	r.HandleFunc("/login", app.loginHandler).Methods("GET")
+	r.HandleFunc("/login", app.loginSubmitHandler).Methods("POST")
+	r.HandleFunc("/logout", app.logoutHandler).Methods("POST")
+	r.HandleFunc("/preferences", app.setPreferencesHandler).Methods("POST")
+
+	// Main application routes with setup middleware, each requiring a role
+	// once users exist (see users.go)
+	r.HandleFunc("/", app.setupMiddleware(app.require(roleViewer, app.indexHandler))).Methods("GET")
+	r.HandleFunc("/products/{index:[0-9]+}", app.setupMiddleware(app.require(roleViewer, app.productHandler))).Methods("GET")
+	r.HandleFunc("/products/{index:[0-9]+}/enable", app.setupMiddleware(app.require(roleAdmin, app.setProductPublishedHandler(true)))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/disable", app.setupMiddleware(app.require(roleAdmin, app.setProductPublishedHandler(false)))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/variant-categories", app.setupMiddleware(app.require(roleAdmin, app.variantCategoryHandler))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/variant-categories/{category}", app.setupMiddleware(app.require(roleAdmin, app.variantCategoryHandler))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/variant-categories/{category}/delete", app.setupMiddleware(app.require(roleAdmin, app.deleteVariantCategoryHandler))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/variant-categories/{category}/variants", app.setupMiddleware(app.require(roleAdmin, app.variantHandler))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/variant-categories/{category}/variants/{variant}", app.setupMiddleware(app.require(roleAdmin, app.variantHandler))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/variant-categories/{category}/variants/{variant}/delete", app.setupMiddleware(app.require(roleAdmin, app.deleteVariantHandler))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/custom-fields", app.setupMiddleware(app.require(roleAdmin, app.customFieldHandler))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/custom-fields/{name}", app.setupMiddleware(app.require(roleAdmin, app.customFieldHandler))).Methods("POST")
+	r.HandleFunc("/products/{index:[0-9]+}/custom-fields/{name}/delete", app.setupMiddleware(app.require(roleAdmin, app.deleteCustomFieldHandler))).Methods("POST")
+	r.HandleFunc("/licenses/{index:[0-9]+}", app.setupMiddleware(app.require(roleViewer, app.licensesHandler))).Methods("GET")
+	r.HandleFunc("/sales/{index:[0-9]+}", app.setupMiddleware(app.require(roleViewer, app.salesHandler))).Methods("GET")
+	r.HandleFunc("/sales/{index:[0-9]+}/{id}/refund", app.setupMiddleware(app.require(roleAdmin, app.refundSaleHandler))).Methods("POST")
+	r.HandleFunc("/sales/{index:[0-9]+}/{id}/resend-receipt", app.setupMiddleware(app.require(roleSupport, app.resendReceiptHandler))).Methods("POST")
+	r.HandleFunc("/sales/{index:[0-9]+}/{id}/mark-shipped", app.setupMiddleware(app.require(roleSupport, app.markShippedHandler))).Methods("POST")
+	r.HandleFunc("/subscribers/{index:[0-9]+}", app.setupMiddleware(app.require(roleViewer, app.subscribersHandler))).Methods("GET")
+	r.HandleFunc("/subscribers/{index:[0-9]+}/{id}", app.setupMiddleware(app.require(roleViewer, app.subscriberHandler))).Methods("GET")
+	r.HandleFunc("/offer-codes/{index:[0-9]+}", app.setupMiddleware(app.require(roleViewer, app.offerCodesHandler))).Methods("GET")
+	r.HandleFunc("/offer-codes/{index:[0-9]+}", app.setupMiddleware(app.require(roleAdmin, app.createOfferCodeHandler))).Methods("POST")
+	r.HandleFunc("/offer-codes/{index:[0-9]+}/generate", app.setupMiddleware(app.require(roleAdmin, app.generateOfferCodesHandler))).Methods("POST")
+	r.HandleFunc("/offer-codes/{index:[0-9]+}/export.csv", app.setupMiddleware(app.require(roleAdmin, app.offerCodesExportHandler))).Methods("GET")
+	r.HandleFunc("/offer-codes/{index:[0-9]+}/{id}", app.setupMiddleware(app.require(roleAdmin, app.updateOfferCodeHandler))).Methods("POST")
+	r.HandleFunc("/offer-codes/{index:[0-9]+}/{id}/delete", app.setupMiddleware(app.require(roleAdmin, app.deleteOfferCodeHandler))).Methods("POST")
+	r.HandleFunc("/api/products/{id}/licenses", app.setupMiddleware(app.require(roleViewer, app.productLicensesJSONHandler))).Methods("GET")
+	r.HandleFunc("/api/products/{id}/sales", app.setupMiddleware(app.require(roleViewer, app.productSalesJSONHandler))).Methods("GET")
+	r.HandleFunc("/api/products/{id}/offer_codes", app.setupMiddleware(app.require(roleViewer, app.productOfferCodesJSONHandler))).Methods("GET")
+	r.HandleFunc("/api/products/{id}/subscribers", app.setupMiddleware(app.require(roleViewer, app.productSubscribersJSONHandler))).Methods("GET")
+	r.HandleFunc("/api-log", app.setupMiddleware(app.require(roleSupport, app.apiLogHandler))).Methods("GET")
+	r.HandleFunc("/api/api-calls", app.setupMiddleware(app.require(roleSupport, app.apiCallsJSONHandler))).Methods("GET")
+	r.HandleFunc("/api/api-calls/stream", app.setupMiddleware(app.require(roleSupport, app.apiCallsStreamHandler))).Methods("GET")
+	r.HandleFunc("/api/api-calls/stats", app.setupMiddleware(app.require(roleSupport, app.apiCallStatsHandler))).Methods("GET")
+	r.HandleFunc("/api/api-calls/har", app.setupMiddleware(app.require(roleSupport, app.apiCallsHARHandler))).Methods("GET")
+	r.HandleFunc("/api/api-calls/{id:[0-9]+}/replay", app.setupMiddleware(app.require(roleSupport, app.apiCallReplayHandler))).Methods("POST")
+	r.HandleFunc("/validate-license", app.setupMiddleware(app.require(roleSupport, app.validateLicenseHandler))).Methods("POST")
+	r.HandleFunc("/bulk-validate", app.setupMiddleware(app.require(roleSupport, app.bulkValidateHandler))).Methods("POST")
+	r.HandleFunc("/api/bulk-validate/{id}", app.setupMiddleware(app.require(roleSupport, app.bulkStatusHandler))).Methods("GET")
+	r.HandleFunc("/bulk-validate/{id}/report.csv", app.setupMiddleware(app.require(roleSupport, app.bulkReportHandler))).Methods("GET")
+	r.HandleFunc("/throttling", app.setupMiddleware(app.require(roleSupport, app.throttlingHandler))).Methods("GET")
+	r.HandleFunc("/throttling/unban", app.setupMiddleware(app.require(roleSupport, app.unbanHandler))).Methods("POST")
+	r.HandleFunc("/api-keys", app.setupMiddleware(app.require(roleAdmin, app.apiKeysHandler))).Methods("GET")
+	r.HandleFunc("/api-keys", app.setupMiddleware(app.require(roleAdmin, app.createAPIKeyHandler))).Methods("POST")
+	r.HandleFunc("/api-keys/{id}/revoke", app.setupMiddleware(app.require(roleAdmin, app.revokeAPIKeyHandler))).Methods("POST")
+	r.HandleFunc("/users", app.setupMiddleware(app.require(roleAdmin, app.usersHandler))).Methods("GET")
+	r.HandleFunc("/users", app.setupMiddleware(app.require(roleAdmin, app.createUserHandler))).Methods("POST")
+	r.HandleFunc("/users/{id}", app.setupMiddleware(app.require(roleAdmin, app.updateUserHandler))).Methods("POST")
+	r.HandleFunc("/users/{id}/delete", app.setupMiddleware(app.require(roleAdmin, app.deleteUserHandler))).Methods("POST")
+	r.HandleFunc("/audit", app.setupMiddleware(app.require(roleAdmin, app.auditHandler))).Methods("GET")
+	r.HandleFunc("/audit/export", app.setupMiddleware(app.require(roleAdmin, app.auditExportHandler))).Methods("GET")
+	r.HandleFunc("/audit/verify", app.setupMiddleware(app.require(roleAdmin, app.auditVerifyHandler))).Methods("POST")
+
+	// Versioned JSON API, described by /api/v1/openapi.json
+	app.registerAPIV1(r)
+
+	// Public license verification for client software (rate limited, no setup redirect)
+	r.HandleFunc("/v1/licenses/verify", app.publicVerifyHandler).Methods("POST")
+
+	// Gumroad resource subscription pings (refunds, disputes, cancellations)
+	r.HandleFunc("/webhooks/gumroad", app.gumroadWebhookHandler).Methods("POST")
+
+	// Static files (always available), see assets.go
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", app.assets))
+
+	return r
 }
 
 func main() {
-	config, err := loadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		runSimulator(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		runCreateAdmin(os.Args[2:])
+		return
+	}
+
+	dev := flag.Bool("dev", false, "read templates and static files from disk and reload templates when they change")
+	flag.Parse()
+
+	configPath := withDefaultString(os.Getenv("CONFIG_PATH"), defaultConfigPath)
+	config, err := loadConfig(configPath)
 	if err != nil {
 		log.Fatal("Failed to load config:", err)
 	}
 
+	publicRateLimit := config.PublicRateLimit
+	if publicRateLimit <= 0 {
+		publicRateLimit = defaultPublicRateLimit
+	}
+
 	app := &App{
-		config:   config,
-		apiCalls: make([]APICall, 0),
+		config:             config,
+		apiCalls:           make([]APICall, 0),
+		apiCallSubscribers: make(map[chan APICall]struct{}),
+		publicLimiter:      newRateLimiter(publicRateLimit, time.Minute),
+		guard:              newAbuseGuard(config.RateLimits),
+		verifyCache:        newVerificationCache(config.VerificationCache),
+		bulk:               newBulkValidator(config.BulkValidation),
+		loginLimiter:       newRateLimiter(loginRateLimit, time.Minute),
+		configPath:         configPath,
+	}
+
+	app.apiKeys, err = newAPIKeyStore(withDefaultString(config.DataDir, "."))
+	if err != nil {
+		log.Fatal("Failed to load API keys:", err)
+	}
+	app.users, err = newUserStore(withDefaultString(config.DataDir, "."))
+	if err != nil {
+		log.Fatal("Failed to load users:", err)
+	}
+	app.auditLog, err = newAuditLog(withDefaultString(config.DataDir, "."))
+	if err != nil {
+		log.Fatal("Failed to open audit log:", err)
+	}
+	if !app.users.enabled() {
+		log.Printf("No users exist, so every page is open; run \"%s create-admin -username <name>\" to require logins", os.Args[0])
+	}
+
+	app.assets, err = newAssets(*dev)
+	if err != nil {
+		log.Fatal("Failed to load static files:", err)
+	}
+	if *dev {
+		log.Printf("Development mode: serving templates and static files from disk")
 	}
 
 	// Load templates
@@ -723,28 +1240,6 @@ func main() {
 		log.Fatal("Failed to load templates:", err)
 	}
 
-	r := mux.NewRouter()
-
-	// Setup routes (always available)
-	r.HandleFunc("/setup", app.setupHandler).Methods("GET")
-	r.HandleFunc("/setup/submit", app.setupSubmitHandler).Methods("POST")
-
-	// Favicon handler (returns empty response)
-	r.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNoContent)
-	}).Methods("GET")
-
-	// Main application routes with setup middleware
-	r.HandleFunc("/", app.setupMiddleware(app.indexHandler)).Methods("GET")
-	r.HandleFunc("/licenses/{index:[0-9]+}", app.setupMiddleware(app.licensesHandler)).Methods("GET")
-	r.HandleFunc("/sales/{index:[0-9]+}", app.setupMiddleware(app.salesHandler)).Methods("GET")
-	r.HandleFunc("/api-log", app.setupMiddleware(app.apiLogHandler)).Methods("GET")
-	r.HandleFunc("/api/api-calls", app.setupMiddleware(app.apiCallsJSONHandler)).Methods("GET")
-	r.HandleFunc("/validate-license", app.setupMiddleware(app.validateLicenseHandler)).Methods("POST")
-
-	// Static file server (always available)
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
-
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8086"
@@ -757,7 +1252,7 @@ func main() {
 		log.Printf("Visit http://localhost:%s to access the application", port)
 	}
 
-	err = http.ListenAndServe(":"+port, r)
+	err = http.ListenAndServe(":"+port, app.routes())
 	if err != nil {
 		log.Fatal("Server failed to start:", err)
 	}
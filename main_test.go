@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata/golden")
+
+const testGumroadToken = "test-token"
+
+// testNow is the fixed current time of test apps, a few days after the
+// newest recorded sale, so relative times in golden pages do not change.
+var testNow = time.Date(2024, 11, 22, 12, 0, 0, 0, time.UTC)
+
+// Compressing the embedded assets takes a moment, so tests share one copy.
+var testAssets = sync.OnceValues(func() (*assets, error) { return newAssets(false) })
+
+// newTestApp returns an app configured with a token, writing its config to a
+// temporary directory.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	assets, err := testAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{
+		assets:             assets,
+		config:             Config{GumroadToken: testGumroadToken},
+		configPath:         filepath.Join(t.TempDir(), "config.json"),
+		apiCallSubscribers: make(map[chan APICall]struct{}),
+		guard:              newAbuseGuard(RateLimitConfig{}),
+		verifyCache:        newVerificationCache(VerificationCacheConfig{}),
+		now:                func() time.Time { return testNow },
+	}
+	if err := saveConfig(app.configPath, app.config); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// serve runs a handler on a request, with the given mux route variables.
+func serve(handler http.HandlerFunc, req *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder
+}
+
+// assertGolden compares a rendered page with testdata/golden/<name>.html.
+// Run "go test -update" to accept intended changes.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".html")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run go test -update to create it): %v", err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			t.Errorf("%s differs at line %d (run go test -update if intended)\n got: %s\nwant: %s", path, i+1, g, w)
+			return
+		}
+	}
+}
+
+func TestIndexHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "products")
+
+	recorder := serve(app.indexHandler, httptest.NewRequest("GET", "/", nil), nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "index", recorder.Body.Bytes())
+}
+
+func TestLicensesHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "licenses")
+
+	recorder := serve(app.licensesHandler, httptest.NewRequest("GET", "/licenses/1", nil), map[string]string{"index": "1"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "licenses", recorder.Body.Bytes())
+
+	recorder = serve(app.licensesHandler, httptest.NewRequest("GET", "/licenses/9", nil), map[string]string{"index": "9"})
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown product index: status %d, want 404", recorder.Code)
+	}
+}
+
+func TestSalesHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "sales")
+
+	recorder := serve(app.salesHandler, httptest.NewRequest("GET", "/sales/0", nil), map[string]string{"index": "0"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "sales", recorder.Body.Bytes())
+
+	// The product's sales span two pages of Gumroad's /sales
+	pages := 0
+	for _, call := range app.apiCalls {
+		if strings.Contains(call.URL, "/sales?") {
+			pages++
+		}
+	}
+	if pages != 2 {
+		t.Errorf("fetched %d pages of sales, want 2", pages)
+	}
+
+	recorder = serve(app.salesHandler, httptest.NewRequest("GET", "/sales/x", nil), map[string]string{"index": "x"})
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid product index: status %d, want 400", recorder.Code)
+	}
+}
+
+func TestAPILogHandler(t *testing.T) {
+	app := newTestApp(t)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	app.recordAPICall(APICall{
+		Timestamp:    start,
+		Method:       "GET",
+		URL:          defaultGumroadAPIBase + "/products",
+		Status:       http.StatusOK,
+		Duration:     120 * time.Millisecond,
+		ResponseBody: `{"success":true,"products":[]}`,
+	})
+	app.recordAPICall(APICall{
+		Timestamp:    start.Add(time.Minute),
+		Method:       "POST",
+		URL:          defaultGumroadAPIBase + "/licenses/verify",
+		Status:       http.StatusNotFound,
+		Duration:     80 * time.Millisecond,
+		RequestBody:  "product_id=abc&license_key=XXXX",
+		ResponseBody: `{"success":false,"message":"That license does not exist for the provided product."}`,
+	})
+
+	req := httptest.NewRequest("GET", "/api-log", nil)
+	req.Header.Set("Referer", "http://localhost/sales/0")
+	recorder := serve(app.apiLogHandler, req, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "api-log", recorder.Body.Bytes())
+}
+
+func TestSetupHandler(t *testing.T) {
+	app := newTestApp(t)
+
+	// With a token configured the setup page sends the user on to the app
+	recorder := serve(app.setupHandler, httptest.NewRequest("GET", "/setup", nil), nil)
+	if recorder.Code != http.StatusTemporaryRedirect || recorder.Header().Get("Location") != "/" {
+		t.Errorf("configured: status %d to %q, want 307 to /", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	if err := saveConfig(app.configPath, Config{GumroadToken: "YOUR_GUMROAD_ACCESS_TOKEN_HERE"}); err != nil {
+		t.Fatal(err)
+	}
+	recorder = serve(app.setupHandler, httptest.NewRequest("GET", "/setup", nil), nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("unconfigured: status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "setup", recorder.Body.Bytes())
+}
+
+func TestSetupSubmitHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "setup-submit")
+	if err := os.Remove(app.configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		want      int
+		wantError string
+	}{
+		{name: "invalid JSON", body: `{`, want: http.StatusBadRequest, wantError: "Invalid JSON data"},
+		{name: "empty token", body: `{"token":""}`, want: http.StatusBadRequest, wantError: "Token cannot be empty"},
+		{name: "rejected token", body: `{"token":"wrong-token"}`, want: http.StatusBadRequest, wantError: "Invalid token: unauthorized - invalid token"},
+		{name: "valid token", body: fmt.Sprintf(`{"token":%q}`, testGumroadToken), want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serve(app.setupSubmitHandler, httptest.NewRequest("POST", "/setup/submit", strings.NewReader(tt.body)), nil)
+			if recorder.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", recorder.Code, tt.want, recorder.Body.String())
+			}
+			var response struct {
+				Success bool   `json:"success"`
+				Error   string `json:"error"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Success != (tt.wantError == "") || response.Error != tt.wantError {
+				t.Errorf("response = %+v, want error %q", response, tt.wantError)
+			}
+		})
+	}
+
+	config, err := loadConfig(app.configPath)
+	if err != nil || config.GumroadToken != testGumroadToken {
+		t.Errorf("saved config token = %q (%v), want %q", config.GumroadToken, err, testGumroadToken)
+	}
+}
+
+func TestValidateLicenseHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "validate-license")
+
+	sales, err := app.getSales("")
+	if err != nil || len(sales) == 0 {
+		t.Fatalf("fetching sales to pick a license: %d sales, %v", len(sales), err)
+	}
+	sale := sales[0]
+
+	validate := func(body string) *httptest.ResponseRecorder {
+		return serve(app.validateLicenseHandler, httptest.NewRequest("POST", "/validate-license", strings.NewReader(body)), nil)
+	}
+
+	recorder := validate(fmt.Sprintf(`{"product_id":%q,"license_key":%q}`, sale.ProductID, sale.LicenseKey))
+	var response LicenseValidationResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if !response.Success || response.Purchase["email"] != sale.Email {
+		t.Errorf("valid license: %+v", response)
+	}
+
+	recorder = validate(fmt.Sprintf(`{"product_id":%q,"license_key":"00000000-00000000-00000000-00000000"}`, sale.ProductID))
+	response = LicenseValidationResponse{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if recorder.Code != http.StatusOK || response.Success || response.Message == "" {
+		t.Errorf("unknown license: status %d, %+v", recorder.Code, response)
+	}
+
+	for _, body := range []string{`{`, `{"product_id":"abc"}`} {
+		if recorder := validate(body); recorder.Code != http.StatusBadRequest {
+			t.Errorf("body %s: status %d, want 400", body, recorder.Code)
+		}
+	}
+}
+
+func TestSetupMiddlewareRedirects(t *testing.T) {
+	app := newTestApp(t)
+	reached := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	handler := app.setupMiddleware(reached)
+
+	tests := []struct {
+		name   string
+		config *Config
+		want   int
+	}{
+		{name: "no config file", want: http.StatusTemporaryRedirect},
+		{name: "placeholder token", config: &Config{GumroadToken: "YOUR_GUMROAD_ACCESS_TOKEN_HERE"}, want: http.StatusTemporaryRedirect},
+		{name: "token configured", config: &Config{GumroadToken: testGumroadToken}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(app.configPath)
+			if tt.config != nil {
+				if err := saveConfig(app.configPath, *tt.config); err != nil {
+					t.Fatal(err)
+				}
+			}
+			recorder := serve(handler, httptest.NewRequest("GET", "/", nil), nil)
+			if recorder.Code != tt.want {
+				t.Fatalf("status %d, want %d", recorder.Code, tt.want)
+			}
+			if tt.want == http.StatusTemporaryRedirect && recorder.Header().Get("Location") != "/setup" {
+				t.Errorf("redirected to %q, want /setup", recorder.Header().Get("Location"))
+			}
+		})
+	}
+
+	// Once users exist, pages also need a session with a sufficient role
+	app.users = &userStore{
+		users:    []User{{ID: "u1", Username: "viewer", Role: roleViewer}},
+		sessions: make(map[string]userSession),
+	}
+	session, err := app.users.startSession("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSession := func(path string) *http.Request {
+		req := httptest.NewRequest("GET", path, nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+		return req
+	}
+
+	recorder := serve(app.setupMiddleware(app.require(roleViewer, reached)), httptest.NewRequest("GET", "/sales/0?page=2", nil), nil)
+	if recorder.Code != http.StatusSeeOther || recorder.Header().Get("Location") != "/login?next=%2Fsales%2F0%3Fpage%3D2" {
+		t.Errorf("no session: status %d to %q, want 303 to the login page", recorder.Code, recorder.Header().Get("Location"))
+	}
+	if recorder := serve(app.setupMiddleware(app.require(roleViewer, reached)), withSession("/"), nil); recorder.Code != http.StatusOK {
+		t.Errorf("viewer on a viewer page: status %d, want 200", recorder.Code)
+	}
+	if recorder := serve(app.setupMiddleware(app.require(roleSupport, reached)), withSession("/api-log"), nil); recorder.Code != http.StatusForbidden {
+		t.Errorf("viewer on a support page: status %d, want 403", recorder.Code)
+	}
+}
+
+// TestConcurrentRequests runs page handlers, which record API calls, next to
+// the API log readers. Run it with -race to check the use of App.mu.
+func TestConcurrentRequests(t *testing.T) {
+	if *record {
+		t.Skip("replays the cassette recorded by TestSalesHandler")
+	}
+	app := newTestApp(t)
+	useCassette(t, app, "sales")
+	app.config.APILogRetention = 5
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			serve(app.salesHandler, httptest.NewRequest("GET", "/sales/0", nil), map[string]string{"index": "0"})
+		}()
+		go func() {
+			defer wg.Done()
+			serve(app.apiLogHandler, httptest.NewRequest("GET", "/api-log", nil), nil)
+		}()
+		go func() {
+			defer wg.Done()
+			serve(app.apiCallsJSONHandler, httptest.NewRequest("GET", "/api/api-calls", nil), nil)
+		}()
+	}
+	wg.Wait()
+
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	if len(app.apiCalls) != 5 || app.lastAPICallID != 12 {
+		t.Errorf("kept %d calls with last ID %d, want 5 of 12", len(app.apiCalls), app.lastAPICallID)
+	}
+}
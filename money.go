@@ -0,0 +1,268 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in the minor units of its currency: cents for USD, but
+// whole yen for JPY, which has no minor unit. Gumroad reports prices and fees
+// this way.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// newMoney returns an amount in a currency code as Gumroad sends it ("usd").
+// Amounts without a currency are in US dollars, Gumroad's default.
+func newMoney(amount int, currency string) Money {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		currency = "USD"
+	}
+	return Money{Amount: int64(amount), Currency: currency}
+}
+
+func (p Product) PriceMoney() Money {
+	return newMoney(p.Price, p.Currency)
+}
+
+func (s Sale) PriceMoney() Money {
+	return newMoney(s.Price, s.Currency)
+}
+
+// FeeMoney is Gumroad's fee, charged in the currency of the sale.
+func (s Sale) FeeMoney() Money {
+	return newMoney(s.GumroadFee, s.Currency)
+}
+
+// currencyMinorUnits lists the ISO 4217 currencies that do not have two
+// decimal places.
+var currencyMinorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+func minorUnits(currency string) int {
+	if units, ok := currencyMinorUnits[currency]; ok {
+		return units
+	}
+	return 2
+}
+
+// currencySymbols are used in place of the code for common currencies.
+// Dollars other than the US dollar keep a prefix so they stay distinct.
+var currencySymbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "INR": "₹", "KRW": "₩",
+	"AUD": "A$", "CAD": "CA$", "NZD": "NZ$", "HKD": "HK$", "SGD": "S$",
+	"BRL": "R$", "MXN": "MX$", "ILS": "₪", "PLN": "zł", "PHP": "₱",
+}
+
+// numberFormat is how a locale writes amounts of money.
+type numberFormat struct {
+	decimal string
+	group   string
+	// symbolAfter puts the currency after the number. The symbol or code is
+	// always separated by a no-break space so amounts do not wrap.
+	symbolAfter bool
+}
+
+const defaultLocale = "en-US"
+
+// numberFormats are keyed by language; other languages are written like
+// English.
+var numberFormats = map[string]numberFormat{
+	"en": {decimal: ".", group: ","},
+	"de": {decimal: ",", group: ".", symbolAfter: true},
+	"es": {decimal: ",", group: ".", symbolAfter: true},
+	"fr": {decimal: ",", group: " ", symbolAfter: true},
+	"it": {decimal: ",", group: ".", symbolAfter: true},
+	"nl": {decimal: ",", group: "."},
+	"ja": {decimal: ".", group: ","},
+}
+
+func localeNumberFormat(locale string) numberFormat {
+	language, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	if format, ok := numberFormats[language]; ok {
+		return format
+	}
+	return numberFormats["en"]
+}
+
+// Format writes the amount with its currency's decimal places and symbol, in
+// the conventions of a locale such as "en-US" or "de-DE".
+func (m Money) Format(locale string) string {
+	format := localeNumberFormat(locale)
+	units := minorUnits(m.Currency)
+
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	scale := int64(math.Pow10(units))
+	var number strings.Builder
+	number.WriteString(groupDigits(amount/scale, format.group))
+	if units > 0 {
+		fraction := strconv.FormatInt(amount%scale, 10)
+		number.WriteString(format.decimal)
+		number.WriteString(strings.Repeat("0", units-len(fraction)) + fraction)
+	}
+
+	symbol, ok := currencySymbols[m.Currency]
+	switch {
+	case format.symbolAfter:
+		if !ok {
+			symbol = m.Currency
+		}
+		return sign + number.String() + "\u00a0" + symbol
+	case ok:
+		return sign + symbol + number.String()
+	default:
+		return sign + m.Currency + "\u00a0" + number.String()
+	}
+}
+
+// formatNumber writes a count such as 1234 in the conventions of a locale:
+// "1,234" in English, "1.234" in German.
+func formatNumber(n int64, locale string) string {
+	if n < 0 {
+		return "-" + groupDigits(-n, localeNumberFormat(locale).group)
+	}
+	return groupDigits(n, localeNumberFormat(locale).group)
+}
+
+// groupDigits writes a non-negative number with its digits grouped in threes.
+func groupDigits(n int64, separator string) string {
+	digits := strconv.FormatInt(n, 10)
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteString(separator)
+		}
+		grouped.WriteRune(digit)
+	}
+	return grouped.String()
+}
+
+func (m Money) String() string {
+	return m.Format(defaultLocale)
+}
+
+// convert returns the amount in another currency. rates give the value of
+// one unit of each currency in the target currency; false is returned when
+// there is no rate for the amount's currency.
+func (m Money) convert(to string, rates map[string]float64) (Money, bool) {
+	if m.Currency == to {
+		return m, true
+	}
+	rate, ok := rates[m.Currency]
+	if !ok || rate <= 0 {
+		return Money{}, false
+	}
+	major := float64(m.Amount) / math.Pow10(minorUnits(m.Currency))
+	return Money{
+		Amount:   int64(math.Round(major * rate * math.Pow10(minorUnits(to)))),
+		Currency: to,
+	}, true
+}
+
+// exchangeRates returns the configured rates keyed by upper case currency
+// code, as Money has them.
+func (app *App) exchangeRates() map[string]float64 {
+	rates := make(map[string]float64, len(app.config.ExchangeRates))
+	for currency, rate := range app.config.ExchangeRates {
+		rates[strings.ToUpper(currency)] = rate
+	}
+	return rates
+}
+
+// currencyTotal sums the sales in one currency.
+type currencyTotal struct {
+	Currency string `json:"currency"`
+	Count    int    `json:"count"`
+	Gross    Money  `json:"gross"`
+	Fees     Money  `json:"fees"`
+	Net      Money  `json:"net"`
+	// Excluded counts refunded and charged back sales, which are not summed
+	Excluded int `json:"excluded"`
+}
+
+// salesTotals holds subtotals per currency and, when a reporting currency is
+// configured, their sum converted into it.
+type salesTotals struct {
+	ByCurrency []currencyTotal `json:"by_currency"`
+	// Reporting is the converted sum of every subtotal except those listed
+	// in Unconverted, which have no exchange rate
+	Reporting   *currencyTotal `json:"reporting,omitempty"`
+	Unconverted []string       `json:"unconverted,omitempty"`
+}
+
+// totalSales sums sales per currency, largest count first.
+func (app *App) totalSales(sales []Sale) salesTotals {
+	byCurrency := make(map[string]*currencyTotal)
+	for _, sale := range sales {
+		price := sale.PriceMoney()
+		total, ok := byCurrency[price.Currency]
+		if !ok {
+			total = &currencyTotal{
+				Currency: price.Currency,
+				Gross:    Money{Currency: price.Currency},
+				Fees:     Money{Currency: price.Currency},
+				Net:      Money{Currency: price.Currency},
+			}
+			byCurrency[price.Currency] = total
+		}
+		if sale.Refunded || sale.Chargebacked {
+			total.Excluded++
+			continue
+		}
+		total.Count++
+		total.Gross.Amount += price.Amount
+		total.Fees.Amount += int64(sale.GumroadFee)
+		total.Net.Amount += price.Amount - int64(sale.GumroadFee)
+	}
+
+	var totals salesTotals
+	for _, total := range byCurrency {
+		totals.ByCurrency = append(totals.ByCurrency, *total)
+	}
+	sort.Slice(totals.ByCurrency, func(i, j int) bool {
+		a, b := totals.ByCurrency[i], totals.ByCurrency[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Currency < b.Currency
+	})
+
+	reporting := strings.ToUpper(app.config.ReportingCurrency)
+	if reporting == "" || len(totals.ByCurrency) == 0 {
+		return totals
+	}
+	rates := app.exchangeRates()
+	converted := currencyTotal{
+		Currency: reporting,
+		Gross:    Money{Currency: reporting},
+		Fees:     Money{Currency: reporting},
+		Net:      Money{Currency: reporting},
+	}
+	for _, total := range totals.ByCurrency {
+		gross, ok := total.Gross.convert(reporting, rates)
+		if !ok {
+			totals.Unconverted = append(totals.Unconverted, total.Currency)
+			continue
+		}
+		fees, _ := total.Fees.convert(reporting, rates)
+		converted.Count += total.Count
+		converted.Excluded += total.Excluded
+		converted.Gross.Amount += gross.Amount
+		converted.Fees.Amount += fees.Amount
+		converted.Net.Amount += gross.Amount - fees.Amount
+	}
+	totals.Reporting = &converted
+	return totals
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		money  Money
+		locale string
+		want   string
+	}{
+		{newMoney(1250, "usd"), "en-US", "$12.50"},
+		{newMoney(123456789, "usd"), "en-US", "$1,234,567.89"},
+		{newMoney(5, "usd"), "en-US", "$0.05"},
+		{newMoney(-1250, "usd"), "en-US", "-$12.50"},
+		{newMoney(1250, ""), "en-US", "$12.50"},
+		{newMoney(123456, "eur"), "de-DE", "1.234,56\u00a0€"},
+		{newMoney(123456, "eur"), "en-GB", "€1,234.56"},
+		{newMoney(1500, "jpy"), "en-US", "¥1,500"},
+		{newMoney(1500, "jpy"), "es", "1.500\u00a0¥"},
+		{newMoney(12345, "kwd"), "en-US", "KWD\u00a012.345"},
+		{newMoney(999, "chf"), "de-CH", "9,99\u00a0CHF"},
+		{newMoney(999, "cad"), "en-US", "CA$9.99"},
+		{newMoney(999, "gbp"), "xx", "£9.99"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Format(tt.locale); got != tt.want {
+			t.Errorf("%v %s in %s = %q, want %q", tt.money.Amount, tt.money.Currency, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestTotalSales(t *testing.T) {
+	sales := []Sale{
+		{Price: 1000, GumroadFee: 100, Currency: "usd"},
+		{Price: 2000, GumroadFee: 200, Currency: "usd"},
+		{Price: 5000, GumroadFee: 500, Currency: "usd", Refunded: true},
+		{Price: 1000, GumroadFee: 100, Currency: "eur"},
+		{Price: 3000, GumroadFee: 300, Currency: "jpy"},
+		{Price: 500, GumroadFee: 50, Currency: "chf", Chargebacked: true},
+	}
+
+	app := &App{config: Config{
+		ReportingCurrency: "usd",
+		ExchangeRates:     map[string]float64{"eur": 1.10, "jpy": 0.0067},
+	}}
+	got := app.totalSales(sales)
+
+	want := salesTotals{
+		ByCurrency: []currencyTotal{
+			{Currency: "USD", Count: 2, Gross: Money{3000, "USD"}, Fees: Money{300, "USD"}, Net: Money{2700, "USD"}, Excluded: 1},
+			{Currency: "EUR", Count: 1, Gross: Money{1000, "EUR"}, Fees: Money{100, "EUR"}, Net: Money{900, "EUR"}},
+			{Currency: "JPY", Count: 1, Gross: Money{3000, "JPY"}, Fees: Money{300, "JPY"}, Net: Money{2700, "JPY"}},
+			{Currency: "CHF", Gross: Money{0, "CHF"}, Fees: Money{0, "CHF"}, Net: Money{0, "CHF"}, Excluded: 1},
+		},
+		// $30 + €10 at 1.10 + ¥3000 at 0.0067; CHF has no rate
+		Reporting: &currencyTotal{
+			Currency: "USD", Count: 4, Excluded: 1,
+			Gross: Money{3000 + 1100 + 2010, "USD"},
+			Fees:  Money{300 + 110 + 201, "USD"},
+			Net:   Money{2700 + 990 + 1809, "USD"},
+		},
+		Unconverted: []string{"CHF"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("totals =\n%+v\nwant\n%+v", got, want)
+	}
+
+	app.config.ReportingCurrency = ""
+	if got := app.totalSales(sales); got.Reporting != nil || got.Unconverted != nil {
+		t.Errorf("without a reporting currency: %+v", got)
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		n      int64
+		locale string
+		want   string
+	}{
+		{0, "en", "0"},
+		{999, "en", "999"},
+		{1234567, "en", "1,234,567"},
+		{1234567, "de", "1.234.567"},
+		{-12345, "es", "-12.345"},
+	}
+	for _, tt := range tests {
+		if got := formatNumber(tt.n, tt.locale); got != tt.want {
+			t.Errorf("formatNumber(%d, %s) = %q, want %q", tt.n, tt.locale, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxGeneratedOfferCodes caps one bulk generation; each code is a
+	// separate Gumroad request
+	maxGeneratedOfferCodes = 200
+	// generatedCodeLength is the length of the random part of generated
+	// codes, after the prefix
+	generatedCodeLength = 8
+	// generatedCodeAlphabet leaves out characters that are easily misread,
+	// such as 0 and O
+	generatedCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// offerCodePrefixPattern limits bulk generation prefixes to characters that
+// are safe to read out and type.
+var offerCodePrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,20}$`)
+
+// OfferCode is a discount code of a product, from
+// /v2/products/:id/offer_codes. It takes either AmountCents or PercentOff
+// off the price.
+type OfferCode struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	AmountCents *int   `json:"amount_cents,omitempty"`
+	PercentOff  *int   `json:"percent_off,omitempty"`
+	// MaxPurchaseCount limits how many times the code can be used; nil is
+	// unlimited
+	MaxPurchaseCount *int `json:"max_purchase_count"`
+	// Universal codes apply to all of the seller's products
+	Universal bool `json:"universal"`
+	TimesUsed int  `json:"times_used"`
+}
+
+type OfferCodesResponse struct {
+	Success    bool        `json:"success"`
+	OfferCodes []OfferCode `json:"offer_codes"`
+}
+
+type OfferCodeResponse struct {
+	Success   bool      `json:"success"`
+	OfferCode OfferCode `json:"offer_code"`
+}
+
+// Remaining is how many more times a limited code can be used, or -1 for
+// unlimited codes.
+func (c OfferCode) Remaining() int {
+	if c.MaxPurchaseCount == nil {
+		return -1
+	}
+	if remaining := *c.MaxPurchaseCount - c.TimesUsed; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// discount writes the code's discount, e.g. "20%" or "$5.00" in the
+// currency of the product.
+func (c OfferCode) discount(currency, locale string) string {
+	if c.PercentOff != nil {
+		return strconv.Itoa(*c.PercentOff) + "%"
+	}
+	if c.AmountCents != nil {
+		return newMoney(*c.AmountCents, currency).Format(locale)
+	}
+	return ""
+}
+
+// offerCodeName is the offer code a sale was bought with. Gumroad sends its
+// name, but some responses carry an object with the name instead.
+type offerCodeName string
+
+func (n *offerCodeName) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*n = offerCodeName(name)
+		return nil
+	}
+	var code struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &code); err != nil {
+		return err
+	}
+	*n = offerCodeName(code.Name)
+	return nil
+}
+
+// usedOfferCode reports whether a sale was bought with the named code.
+// Gumroad compares offer codes without regard to case.
+func (s Sale) usedOfferCode(name string) bool {
+	return s.OfferCode != "" && strings.EqualFold(string(s.OfferCode), name)
+}
+
+// offerCodeUsage is an offer code with the product's sales that used it.
+type offerCodeUsage struct {
+	OfferCode
+	// Currency is the product's, which AmountCents is in
+	Currency string `json:"currency"`
+	// Totals sum the sales made with the code
+	Totals salesTotals `json:"totals"`
+	// Deleted is set for codes found on sales but no longer on Gumroad
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// AmountMoney is the fixed amount taken off, in the product's currency.
+func (u offerCodeUsage) AmountMoney() Money {
+	if u.AmountCents == nil {
+		return newMoney(0, u.Currency)
+	}
+	return newMoney(*u.AmountCents, u.Currency)
+}
+
+// Sales is the number of sales made with the code that were not refunded or
+// charged back.
+func (u offerCodeUsage) Sales() int {
+	count := 0
+	for _, total := range u.Totals.ByCurrency {
+		count += total.Count
+	}
+	return count
+}
+
+// correlateOfferCodes matches a product's sales to its offer codes. Codes
+// seen on sales that are not in the list, because they were deleted, get a
+// row of their own at the end.
+func (app *App) correlateOfferCodes(codes []OfferCode, sales []Sale, product Product) []offerCodeUsage {
+	usage := make([]offerCodeUsage, 0, len(codes))
+	known := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		known[strings.ToLower(code.Name)] = true
+		usage = append(usage, offerCodeUsage{OfferCode: code, Currency: product.Currency})
+	}
+
+	var deleted []string
+	for _, sale := range sales {
+		name := strings.ToLower(string(sale.OfferCode))
+		if name != "" && !known[name] {
+			known[name] = true
+			deleted = append(deleted, string(sale.OfferCode))
+		}
+	}
+	sort.Strings(deleted)
+	for _, name := range deleted {
+		usage = append(usage, offerCodeUsage{OfferCode: OfferCode{Name: name}, Currency: product.Currency, Deleted: true})
+	}
+
+	for i := range usage {
+		var used []Sale
+		for _, sale := range sales {
+			if sale.usedOfferCode(usage[i].Name) {
+				used = append(used, sale)
+			}
+		}
+		usage[i].Totals = app.totalSales(used)
+		if usage[i].Deleted {
+			usage[i].TimesUsed = len(used)
+		}
+	}
+	return usage
+}
+
+func (app *App) offerCodesURL(productID string, id string) string {
+	path := "/products/" + url.PathEscape(productID) + "/offer_codes"
+	if id != "" {
+		path += "/" + url.PathEscape(id)
+	}
+	return app.gumroadURL(path)
+}
+
+func (app *App) getOfferCodes(productID string) ([]OfferCode, error) {
+	body, err := app.makeGumroadRequest(app.offerCodesURL(productID, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	var response OfferCodesResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.Success {
+		return nil, fmt.Errorf("API request was not successful")
+	}
+
+	return response.OfferCodes, nil
+}
+
+// offerCodeRequest creates or edits an offer code. Name, AmountOff and
+// OfferType ("cents" or "percent") only apply when creating, since Gumroad
+// only lets the purchase limit be changed afterwards.
+type offerCodeRequest struct {
+	Name      string `json:"name"`
+	AmountOff int    `json:"amount_off"`
+	OfferType string `json:"offer_type"`
+	// MaxPurchaseCount of 0 is unlimited
+	MaxPurchaseCount int  `json:"max_purchase_count"`
+	Universal        bool `json:"universal"`
+}
+
+func (req offerCodeRequest) validate() error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.New("the offer code needs a name")
+	}
+	if req.AmountOff <= 0 {
+		return errors.New("the discount must be more than zero")
+	}
+	switch req.OfferType {
+	case "cents":
+	case "percent":
+		if req.AmountOff > 100 {
+			return errors.New("a percentage off cannot be over 100")
+		}
+	default:
+		return errors.New(`offer_type must be "cents" or "percent"`)
+	}
+	if req.MaxPurchaseCount < 0 {
+		return errors.New("the purchase limit cannot be negative")
+	}
+	return nil
+}
+
+// description is the request as recorded in the audit log.
+func (req offerCodeRequest) description() string {
+	discount := strconv.Itoa(req.AmountOff) + " cents off"
+	if req.OfferType == "percent" {
+		discount = strconv.Itoa(req.AmountOff) + "% off"
+	}
+	limit := "unlimited"
+	if req.MaxPurchaseCount > 0 {
+		limit = fmt.Sprintf("max %d uses", req.MaxPurchaseCount)
+	}
+	return discount + ", " + limit
+}
+
+// offerCodeWrite sends an offer code change to Gumroad on behalf of actor
+// and decodes the code it returns.
+func (app *App) offerCodeWrite(actor, method, target string, form url.Values) (OfferCode, error) {
+	var response OfferCodeResponse
+	err := app.sendGumroadWrite(actor, method, target, form, &response)
+	return response.OfferCode, err
+}
+
+func (app *App) createOfferCode(actor, productID string, req offerCodeRequest) (OfferCode, error) {
+	if err := req.validate(); err != nil {
+		return OfferCode{}, err
+	}
+	form := url.Values{}
+	form.Set("name", strings.TrimSpace(req.Name))
+	form.Set("amount_off", strconv.Itoa(req.AmountOff))
+	form.Set("offer_type", req.OfferType)
+	if req.MaxPurchaseCount > 0 {
+		form.Set("max_purchase_count", strconv.Itoa(req.MaxPurchaseCount))
+	}
+	if req.Universal {
+		form.Set("universal", "true")
+	}
+	return app.offerCodeWrite(actor, "POST", app.offerCodesURL(productID, ""), form)
+}
+
+func (app *App) updateOfferCode(actor, productID, id string, maxPurchaseCount int) (OfferCode, error) {
+	if maxPurchaseCount < 0 {
+		return OfferCode{}, errors.New("the purchase limit cannot be negative")
+	}
+	form := url.Values{}
+	form.Set("max_purchase_count", "")
+	if maxPurchaseCount > 0 {
+		form.Set("max_purchase_count", strconv.Itoa(maxPurchaseCount))
+	}
+	return app.offerCodeWrite(actor, "PUT", app.offerCodesURL(productID, id), form)
+}
+
+func (app *App) deleteOfferCode(actor, productID, id string) error {
+	return app.sendGumroadWrite(actor, "DELETE", app.offerCodesURL(productID, id), nil, nil)
+}
+
+// generateCodeNames returns count new codes of the form PREFIX-XXXXXXXX,
+// none of which is in taken.
+func generateCodeNames(prefix string, count int, taken map[string]bool) ([]string, error) {
+	names := make([]string, 0, count)
+	alphabetSize := big.NewInt(int64(len(generatedCodeAlphabet)))
+	for len(names) < count {
+		// rand.Int picks each character uniformly whatever the alphabet's
+		// length, where a byte modulo the length would favour the first
+		// characters
+		suffix := make([]byte, generatedCodeLength)
+		for i := range suffix {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, err
+			}
+			suffix[i] = generatedCodeAlphabet[n.Int64()]
+		}
+		name := strings.ToUpper(prefix) + "-" + string(suffix)
+		if taken[strings.ToLower(name)] {
+			continue
+		}
+		taken[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// generateOfferCodesRequest asks for Count single-use codes starting with
+// Prefix, each taking the same discount off.
+type generateOfferCodesRequest struct {
+	Prefix    string `json:"prefix"`
+	Count     int    `json:"count"`
+	AmountOff int    `json:"amount_off"`
+	OfferType string `json:"offer_type"`
+}
+
+// generateOfferCodes creates single-use codes one by one. It stops at the
+// first code Gumroad refuses and returns those created so far with the error.
+func (app *App) generateOfferCodes(actor, productID string, req generateOfferCodesRequest) ([]OfferCode, error) {
+	if !offerCodePrefixPattern.MatchString(req.Prefix) {
+		return nil, errors.New("the prefix must be 1 to 20 letters, digits, dashes or underscores")
+	}
+	if req.Count < 1 || req.Count > maxGeneratedOfferCodes {
+		return nil, fmt.Errorf("between 1 and %d codes can be generated at once", maxGeneratedOfferCodes)
+	}
+	single := offerCodeRequest{Name: req.Prefix, AmountOff: req.AmountOff, OfferType: req.OfferType, MaxPurchaseCount: 1}
+	if err := single.validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := app.getOfferCodes(productID)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool, len(existing))
+	for _, code := range existing {
+		taken[strings.ToLower(code.Name)] = true
+	}
+	names, err := generateCodeNames(req.Prefix, req.Count, taken)
+	if err != nil {
+		return nil, err
+	}
+
+	created := make([]OfferCode, 0, len(names))
+	for _, name := range names {
+		single.Name = name
+		code, err := app.createOfferCode(actor, productID, single)
+		if err != nil {
+			return created, fmt.Errorf("created %d of %d codes: %w", len(created), len(names), err)
+		}
+		created = append(created, code)
+	}
+	return created, nil
+}
+
+// offerCodesHandler lists a product's offer codes with the sales made with
+// each, and offers admins forms to manage them.
+func (app *App) offerCodesHandler(w http.ResponseWriter, r *http.Request) {
+	product, index, err := app.productFromIndex(r)
+	if err != nil {
+		http.Error(w, err.Error(), actionErrorStatus(err))
+		return
+	}
+
+	codes, err := app.getOfferCodes(product.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch offer codes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sales, err := app.getSales(product.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch sales: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := PageData{
+		Title:        app.localizer(r).t("offer_codes.title", product.Name),
+		CurrentPage:  "offer-codes",
+		BackLink:     "/",
+		ProductID:    product.ID,
+		ProductIndex: index,
+		OfferCodes:   app.correlateOfferCodes(codes, sales, product),
+	}
+
+	app.renderPage(w, r, data)
+}
+
+func (app *App) createOfferCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var req offerCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeTableError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	product, _, err := app.productFromIndex(r)
+	var code OfferCode
+	if err == nil {
+		code, err = app.createOfferCode(requestActor(r), product.ID, req)
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditOfferCodeCreate,
+		TargetType: "offer_code",
+		Target:     req.Name,
+		ProductID:  product.ID,
+		Detail:     req.description(),
+	}, err)
+	if err == nil {
+		log.Printf("Offer code %s created on product %s by %s", code.Name, product.ID, requestActor(r))
+	}
+	writeActionResult(w, map[string]interface{}{"offer_code": code}, err)
+}
+
+func (app *App) updateOfferCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var req offerCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeTableError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	product, _, err := app.productFromIndex(r)
+	var code OfferCode
+	if err == nil {
+		code, err = app.updateOfferCode(requestActor(r), product.ID, id, req.MaxPurchaseCount)
+	}
+	detail := "unlimited uses"
+	if req.MaxPurchaseCount > 0 {
+		detail = fmt.Sprintf("max %d uses", req.MaxPurchaseCount)
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditOfferCodeUpdate,
+		TargetType: "offer_code",
+		Target:     withDefaultString(code.Name, id),
+		ProductID:  product.ID,
+		Detail:     detail,
+	}, err)
+	writeActionResult(w, map[string]interface{}{"offer_code": code}, err)
+}
+
+func (app *App) deleteOfferCodeHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	product, _, err := app.productFromIndex(r)
+	if err == nil {
+		err = app.deleteOfferCode(requestActor(r), product.ID, id)
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditOfferCodeDelete,
+		TargetType: "offer_code",
+		Target:     withDefaultString(r.FormValue("name"), id),
+		ProductID:  product.ID,
+	}, err)
+	if err == nil {
+		log.Printf("Offer code %s deleted from product %s by %s", id, product.ID, requestActor(r))
+	}
+	writeActionResult(w, map[string]interface{}{}, err)
+}
+
+// generateOfferCodesHandler creates a batch of single-use codes. The page
+// then offers them for download with offerCodesExportHandler.
+func (app *App) generateOfferCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var req generateOfferCodesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeTableError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	product, _, err := app.productFromIndex(r)
+	var codes []OfferCode
+	if err == nil {
+		codes, err = app.generateOfferCodes(requestActor(r), product.ID, req)
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditOfferCodeGenerate,
+		TargetType: "offer_code",
+		Target:     strings.ToUpper(req.Prefix) + "-*",
+		ProductID:  product.ID,
+		Detail:     fmt.Sprintf("%d of %d single-use codes, %s", len(codes), req.Count, offerCodeRequest{AmountOff: req.AmountOff, OfferType: req.OfferType, MaxPurchaseCount: 1}.description()),
+	}, err)
+	if err != nil && len(codes) > 0 {
+		// The codes created before the failure exist now, so they are
+		// returned for download all the same
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(actionErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     false,
+			"error":       actionErrorMessage(err),
+			"offer_codes": codes,
+		})
+		return
+	}
+	if err == nil {
+		log.Printf("%d offer codes with prefix %s created on product %s by %s", len(codes), req.Prefix, product.ID, requestActor(r))
+	}
+	writeActionResult(w, map[string]interface{}{"offer_codes": codes}, err)
+}
+
+// offerCodesExportHandler downloads a product's offer codes as CSV, for
+// handing out in giveaways. The prefix parameter limits the export to codes
+// starting with it, such as the ones just generated.
+func (app *App) offerCodesExportHandler(w http.ResponseWriter, r *http.Request) {
+	product, _, err := app.productFromIndex(r)
+	if err != nil {
+		http.Error(w, err.Error(), actionErrorStatus(err))
+		return
+	}
+	codes, err := app.getOfferCodes(product.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch offer codes: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	prefix := strings.ToLower(r.URL.Query().Get("prefix"))
+	var rows []OfferCode
+	for _, code := range codes {
+		if strings.HasPrefix(strings.ToLower(code.Name), prefix) {
+			rows = append(rows, code)
+		}
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditOfferCodeExport,
+		TargetType: "offer_code",
+		Target:     strings.ToUpper(prefix) + "*",
+		ProductID:  product.ID,
+		Detail:     fmt.Sprintf("%d codes", len(rows)),
+	}, nil)
+
+	filename := "offer-codes-" + strings.ToLower(product.ID)
+	if prefix != "" {
+		filename += "-" + prefix
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"code", "discount", "max_purchase_count", "times_used", "remaining"})
+	for _, code := range rows {
+		limit, remaining := "", ""
+		if code.MaxPurchaseCount != nil {
+			limit, remaining = strconv.Itoa(*code.MaxPurchaseCount), strconv.Itoa(code.Remaining())
+		}
+		writer.Write([]string{csvCell(code.Name), code.discount(product.Currency, defaultLocale), limit, strconv.Itoa(code.TimesUsed), remaining})
+	}
+	writer.Flush()
+}
+
+// csvCell keeps a spreadsheet from reading a cell as a formula, by prefixing
+// cells starting with =, +, - or @ with a quote.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// productOfferCodesJSONHandler is the JSON equivalent of the offer codes
+// page, addressed by Gumroad product ID.
+func (app *App) productOfferCodesJSONHandler(w http.ResponseWriter, r *http.Request) {
+	product, err := app.getProduct(mux.Vars(r)["id"])
+	if err != nil {
+		writeTableError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch product: %v", err))
+		return
+	}
+	codes, err := app.getOfferCodes(product.ID)
+	if err != nil {
+		writeTableError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch offer codes: %v", err))
+		return
+	}
+	sales, err := app.getSales(product.ID)
+	if err != nil {
+		writeTableError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch sales: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"offer_codes": app.correlateOfferCodes(codes, sales, product),
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gumroad-license-manager/gumroadsim"
+)
+
+// useSimulator points app at a fresh simulator, for tests of write actions
+// whose requests, such as random offer codes, cannot be replayed.
+func useSimulator(t *testing.T, app *App) *gumroadsim.Fixture {
+	t.Helper()
+	fixture := gumroadsim.Generate(cassetteSeed, cassetteProducts, cassetteSalesPerProduct)
+	server := httptest.NewServer(gumroadsim.New(fixture, cassetteSeed))
+	t.Cleanup(server.Close)
+	app.config.GumroadAPIBase = server.URL + "/v2"
+	app.config.GumroadToken = fixture.AccessToken
+	return fixture
+}
+
+func TestCorrelateOfferCodes(t *testing.T) {
+	percent, amount, limit := 20, 500, 10
+	codes := []OfferCode{
+		{ID: "1", Name: "LAUNCH20", PercentOff: &percent, TimesUsed: 2},
+		{ID: "2", Name: "FRIENDS", AmountCents: &amount, MaxPurchaseCount: &limit, TimesUsed: 1},
+	}
+	sales := []Sale{
+		{Price: 800, Currency: "usd", OfferCode: "launch20"},
+		{Price: 800, Currency: "usd", OfferCode: "LAUNCH20", Refunded: true},
+		{Price: 500, Currency: "usd", OfferCode: "FRIENDS"},
+		{Price: 1000, Currency: "usd"},
+		{Price: 700, Currency: "usd", OfferCode: "OLDCODE"},
+	}
+	usage := (&App{}).correlateOfferCodes(codes, sales, Product{Currency: "usd"})
+
+	if len(usage) != 3 || !usage[2].Deleted || usage[2].Name != "OLDCODE" || usage[2].TimesUsed != 1 {
+		t.Fatalf("usage = %+v, want the two codes and the deleted OLDCODE", usage)
+	}
+	if usage[0].Sales() != 1 || usage[0].Totals.ByCurrency[0].Gross != (Money{800, "USD"}) || usage[0].Totals.ByCurrency[0].Excluded != 1 {
+		t.Errorf("LAUNCH20 totals %+v", usage[0].Totals)
+	}
+	if usage[1].AmountMoney() != (Money{500, "USD"}) || usage[1].Remaining() != 9 {
+		t.Errorf("FRIENDS: amount %v, remaining %d", usage[1].AmountMoney(), usage[1].Remaining())
+	}
+}
+
+func TestGenerateCodeNames(t *testing.T) {
+	taken := map[string]bool{}
+	names, err := generateCodeNames("Giveaway", 50, taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		suffix, ok := strings.CutPrefix(name, "GIVEAWAY-")
+		if !ok || len(suffix) != generatedCodeLength || strings.Trim(suffix, generatedCodeAlphabet) != "" {
+			t.Errorf("malformed code %q", name)
+		}
+		if seen[name] {
+			t.Errorf("duplicate code %q", name)
+		}
+		seen[name] = true
+	}
+	if len(taken) != 50 {
+		t.Errorf("%d codes marked as taken, want 50", len(taken))
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := map[string]string{
+		"":                  "",
+		"SPRING":            "SPRING",
+		"WIN-ABC":           "WIN-ABC",
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"+1":                "'+1",
+		"-1":                "'-1",
+		"@SUM(A1)":          "'@SUM(A1)",
+	}
+	for value, want := range tests {
+		if got := csvCell(value); got != want {
+			t.Errorf("csvCell(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestOfferCodesHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "offer-codes")
+
+	recorder := serve(app.offerCodesHandler, httptest.NewRequest("GET", "/offer-codes/0", nil), map[string]string{"index": "0"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "offer-codes", recorder.Body.Bytes())
+}
+
+func TestOfferCodeActions(t *testing.T) {
+	app := newTestApp(t)
+	useSimulator(t, app)
+	auditLog, err := newAuditLog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.auditLog = auditLog
+	vars := map[string]string{"index": "0"}
+
+	post := func(handler http.HandlerFunc, path, body string, vars map[string]string) (int, map[string]interface{}) {
+		recorder := serve(handler, httptest.NewRequest("POST", path, strings.NewReader(body)), vars)
+		var response map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	status, response := post(app.createOfferCodeHandler, "/offer-codes/0", `{"name":"SPRING","amount_off":15,"offer_type":"percent","max_purchase_count":50}`, vars)
+	if status != http.StatusOK {
+		t.Fatalf("create: status %d, %v", status, response)
+	}
+	id := response["offer_code"].(map[string]interface{})["id"].(string)
+
+	status, response = post(app.createOfferCodeHandler, "/offer-codes/0", `{"name":"spring","amount_off":15,"offer_type":"percent"}`, vars)
+	if status != http.StatusBadRequest || response["error"] != "An offer code with that name already exists." {
+		t.Errorf("duplicate: status %d, %v", status, response)
+	}
+	if status, _ := post(app.createOfferCodeHandler, "/offer-codes/0", `{"name":"HALF","amount_off":150,"offer_type":"percent"}`, vars); status != http.StatusBadRequest {
+		t.Errorf("150%% off: status %d, want 400", status)
+	}
+
+	codeVars := map[string]string{"index": "0", "id": id}
+	status, response = post(app.updateOfferCodeHandler, "/offer-codes/0/"+id, `{"max_purchase_count":5}`, codeVars)
+	if status != http.StatusOK || response["offer_code"].(map[string]interface{})["max_purchase_count"].(float64) != 5 {
+		t.Errorf("update: status %d, %v", status, response)
+	}
+
+	status, response = post(app.generateOfferCodesHandler, "/offer-codes/0/generate", `{"prefix":"win","count":3,"amount_off":100,"offer_type":"percent"}`, vars)
+	if status != http.StatusOK || len(response["offer_codes"].([]interface{})) != 3 {
+		t.Fatalf("generate: status %d, %v", status, response)
+	}
+	if status, _ := post(app.generateOfferCodesHandler, "/offer-codes/0/generate", `{"prefix":"no spaces","count":3,"amount_off":10,"offer_type":"percent"}`, vars); status != http.StatusBadRequest {
+		t.Errorf("invalid prefix: status %d, want 400", status)
+	}
+
+	recorder := serve(app.offerCodesExportHandler, httptest.NewRequest("GET", "/offer-codes/0/export.csv?prefix=WIN-", nil), vars)
+	rows, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[1][1] != "100%" || rows[1][2] != "1" || rows[1][4] != "1" {
+		t.Errorf("export = %v, want a header and three single-use codes", rows)
+	}
+
+	if status, _ := post(app.deleteOfferCodeHandler, "/offer-codes/0/"+id+"/delete", "", codeVars); status != http.StatusOK {
+		t.Errorf("delete: status %d", status)
+	}
+	codes, err := app.getOfferCodes(productIDAt(t, app, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range codes {
+		if code.ID == id {
+			t.Error("deleted code is still listed")
+		}
+	}
+
+	var actions []string
+	for _, event := range app.auditLog.snapshot() {
+		actions = append(actions, event.Action+":"+event.Outcome)
+	}
+	want := "offer_code.create:success offer_code.create:failure offer_code.create:failure offer_code.update:success " +
+		"offer_code.generate:success offer_code.generate:failure offer_code.export:success offer_code.delete:success"
+	if strings.Join(actions, " ") != want {
+		t.Errorf("audit events\n%s\nwant\n%s", strings.Join(actions, " "), want)
+	}
+}
+
+// productIDAt returns the ID of the product at a products page index.
+func productIDAt(t *testing.T, app *App, index int) string {
+	t.Helper()
+	products, err := app.getProducts()
+	if err != nil || index >= len(products) {
+		t.Fatalf("no product %d: %v", index, err)
+	}
+	return products[index].ID
+}
+
+func TestSalesOfferCodeFilter(t *testing.T) {
+	sales := []Sale{
+		{ID: "1", OfferCode: "LAUNCH20"},
+		{ID: "2"},
+		{ID: "3", OfferCode: "launch20"},
+	}
+	q := parseTableQuery(httptest.NewRequest("GET", "/sales/0?offer_code=Launch20", nil), saleSortColumns, nil)
+	if matching := filterSales(sales, q); len(matching) != 2 {
+		t.Errorf("%d sales match the offer code, want 2", len(matching))
+	}
+}
+
+func TestOfferCodeNameJSON(t *testing.T) {
+	var sales []Sale
+	data := `[{"offer_code":"SPRING"},{"offer_code":{"name":"FALL","displayed_amount_off":"$5"}},{"offer_code":null},{}]`
+	if err := json.Unmarshal([]byte(data), &sales); err != nil {
+		t.Fatal(err)
+	}
+	if sales[0].OfferCode != "SPRING" || sales[1].OfferCode != "FALL" || sales[2].OfferCode != "" || sales[3].OfferCode != "" {
+		t.Errorf("offer codes %q %q %q %q", sales[0].OfferCode, sales[1].OfferCode, sales[2].OfferCode, sales[3].OfferCode)
+	}
+}
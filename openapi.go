@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)\}`)
+
+// schemaBuilder turns Go types into OpenAPI schemas. Named struct types are
+// emitted once under components/schemas and referenced from then on.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.schema(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		// Timestamp marshals to an RFC 3339 string like time.Time does
+		if t == reflect.TypeOf(time.Time{}) || t == reflect.TypeOf(Timestamp{}) {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		return b.structSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	name := schemaName(t)
+	ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	if _, done := b.components[name]; done {
+		return ref
+	}
+	// Register before recursing so self-referencing types terminate
+	b.components[name] = nil
+
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		properties[tag] = b.schema(field.Type)
+	}
+
+	b.components[name] = map[string]interface{}{"type": "object", "properties": properties}
+	return ref
+}
+
+// openAPISpec generates the OpenAPI 3 document for the v1 API from its
+// route table.
+func openAPISpec() map[string]interface{} {
+	b := &schemaBuilder{components: make(map[string]interface{})}
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": b.schema(reflect.TypeOf(APIErrorEnvelope{})),
+			},
+		},
+	}
+	pageSchema := b.schema(reflect.TypeOf(tablePage{}))
+
+	paths := make(map[string]interface{})
+	for _, route := range apiV1Routes() {
+		var parameters []interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, param := range route.Query {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        param.Name,
+				"in":          "query",
+				"description": param.Description,
+				"schema":      map[string]interface{}{"type": param.Type},
+			})
+		}
+
+		var body map[string]interface{}
+		if route.Response != nil {
+			properties := map[string]interface{}{"data": b.schema(reflect.TypeOf(route.Response))}
+			if route.Paginated {
+				properties["pagination"] = pageSchema
+			}
+			body = map[string]interface{}{"type": "object", "properties": properties}
+		} else {
+			body = map[string]interface{}{"type": "object"}
+		}
+
+		operation := map[string]interface{}{
+			"operationId": route.OperationID,
+			"summary":     route.Summary,
+			"tags":        []string{route.Tag},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": body},
+					},
+				},
+				"default": errorResponse,
+			},
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+		if route.Scope != "" {
+			operation["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}}
+			operation["description"] = "Requires an API key with the " + route.Scope + " scope or higher."
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": b.schema(reflect.TypeOf(route.Request)),
+					},
+				},
+			}
+		}
+
+		path := apiV1Prefix + route.Path
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Gumroad License Manager API",
+			"version": "1.0.0",
+			"description": "These are the only endpoints that accept API keys. The other JSON endpoints under /api " +
+				"serve the web pages and need a logged-in session.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "API key created on the API Keys page, sent as Authorization: Bearer <key>",
+				},
+			},
+		},
+	}
+}
+
+func (app *App) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(openAPISpec())
+}
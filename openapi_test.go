@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TestOpenAPIMatchesRoutes checks that every /api/v1 route registered on the
+// router is described in the served OpenAPI document and vice versa.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	app := &App{}
+	router := app.routes()
+
+	registered := make(map[string]bool)
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(path, apiV1Prefix+"/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("route %s has no methods", path)
+			return nil
+		}
+		for _, method := range methods {
+			registered[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", apiV1Prefix+"/openapi.json", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("GET openapi.json: status %d", recorder.Code)
+	}
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi version = %q, want 3.x", spec.OpenAPI)
+	}
+
+	documented := make(map[string]bool)
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	for _, key := range sortedKeys(registered) {
+		if !documented[key] {
+			t.Errorf("route %s is not in the OpenAPI document", key)
+		}
+	}
+	for _, key := range sortedKeys(documented) {
+		if !registered[key] {
+			t.Errorf("OpenAPI operation %s has no registered route", key)
+		}
+	}
+	if len(registered) == 0 {
+		t.Error("no /api/v1 routes registered")
+	}
+}
+
+// TestOpenAPISchemasResolve checks that every $ref points at a component.
+func TestOpenAPISchemasResolve(t *testing.T) {
+	data, err := json.Marshal(openAPISpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range strings.Split(string(data), `"$ref":"#/components/schemas/`)[1:] {
+		name := part[:strings.Index(part, `"`)]
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("unresolved schema reference %q", name)
+		}
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TestOpenAPITimeSchemas checks that times, including Gumroad's Timestamp,
+// are described as the RFC 3339 strings they marshal to.
+func TestOpenAPITimeSchemas(t *testing.T) {
+	data, err := json.Marshal(openAPISpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := spec.Components.Schemas["Timestamp"]; ok {
+		t.Error("Timestamp is described as an object")
+	}
+	for _, field := range []struct{ schema, property string }{
+		{"Sale", "created_at"},
+		{"Sale", "daystamp"},
+		{"License", "sale_datetime"},
+	} {
+		got := spec.Components.Schemas[field.schema].Properties[field.property]
+		if got["type"] != "string" || got["format"] != "date-time" {
+			t.Errorf("%s.%s schema %v, want a date-time string", field.schema, field.property, got)
+		}
+	}
+
+	sale, _ := json.Marshal(Sale{CreatedAt: Timestamp{Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}})
+	if !strings.Contains(string(sale), `"created_at":"2024-05-01T12:00:00Z"`) {
+		t.Errorf("created_at does not marshal as in the schema: %s", sale)
+	}
+}
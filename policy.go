@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultPolicyKey selects the policy used for products without their own
+// entry in Config.Policies.
+const defaultPolicyKey = "*"
+
+// Policy rules reported when a verification is judged. An allowed result has
+// an empty rule unless it was let through by the cancellation grace period.
+const (
+	ruleBlockedKey           = "blocked_key"
+	ruleBlockedEmail         = "blocked_email"
+	ruleRefunded             = "refunded"
+	ruleChargebacked         = "chargebacked"
+	ruleDisputed             = "disputed"
+	ruleMaxUses              = "max_uses_exceeded"
+	ruleSubscriptionInactive = "subscription_inactive"
+	ruleGracePeriod          = "grace_period"
+)
+
+// LicensePolicy decides whether a license Gumroad considers valid should be
+// accepted by us.
+type LicensePolicy struct {
+	RejectRefunded     bool `json:"reject_refunded"`
+	RejectChargebacked bool `json:"reject_chargebacked"`
+	RejectDisputed     bool `json:"reject_disputed"`
+	// MaxUses rejects keys whose uses count is above it; zero means unlimited
+	MaxUses int `json:"max_uses,omitempty"`
+	// RequireActiveSubscription rejects memberships that were cancelled,
+	// ended or failed, after CancellationGraceDays have passed
+	RequireActiveSubscription bool     `json:"require_active_subscription"`
+	CancellationGraceDays     int      `json:"cancellation_grace_days,omitempty"`
+	BlockedEmails             []string `json:"blocked_emails,omitempty"`
+	BlockedKeys               []string `json:"blocked_keys,omitempty"`
+}
+
+// PolicyDecision is the outcome of evaluating a LicensePolicy.
+type PolicyDecision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// defaultLicensePolicy is used when the config has no policy for a product.
+// Money that went back to the buyer never buys a valid license.
+func defaultLicensePolicy() LicensePolicy {
+	return LicensePolicy{
+		RejectRefunded:     true,
+		RejectChargebacked: true,
+	}
+}
+
+// policyFor returns the policy configured for a Gumroad product.
+func (app *App) policyFor(productID string) LicensePolicy {
+	if policy, ok := app.config.Policies[productID]; ok {
+		return policy
+	}
+	if policy, ok := app.config.Policies[defaultPolicyKey]; ok {
+		return policy
+	}
+	return defaultLicensePolicy()
+}
+
+// evaluate judges a license that Gumroad reported as existing. Rules are
+// checked from most to least specific so the reported rule is the most
+// useful one when several match.
+func (p LicensePolicy) evaluate(licenseKey string, uses int, purchase map[string]interface{}, now time.Time) PolicyDecision {
+	for _, blocked := range p.BlockedKeys {
+		if strings.EqualFold(strings.TrimSpace(blocked), licenseKey) {
+			return PolicyDecision{Rule: ruleBlockedKey, Detail: "License key is blocked"}
+		}
+	}
+
+	if email, ok := purchase["email"].(string); ok && email != "" {
+		for _, blocked := range p.BlockedEmails {
+			if strings.EqualFold(strings.TrimSpace(blocked), email) {
+				return PolicyDecision{Rule: ruleBlockedEmail, Detail: "Purchaser email is blocked"}
+			}
+		}
+	}
+
+	if p.RejectRefunded && purchaseBool(purchase, "refunded") {
+		return PolicyDecision{Rule: ruleRefunded, Detail: "Purchase was refunded"}
+	}
+	if p.RejectChargebacked && purchaseBool(purchase, "chargebacked") {
+		return PolicyDecision{Rule: ruleChargebacked, Detail: "Purchase was chargebacked"}
+	}
+	if p.RejectDisputed && purchaseBool(purchase, "disputed") && !purchaseBool(purchase, "dispute_won") {
+		return PolicyDecision{Rule: ruleDisputed, Detail: "Purchase is disputed"}
+	}
+
+	if p.MaxUses > 0 && uses > p.MaxUses {
+		return PolicyDecision{Rule: ruleMaxUses, Detail: fmt.Sprintf("License used %d times, limit is %d", uses, p.MaxUses)}
+	}
+
+	if p.RequireActiveSubscription {
+		if endedAt := subscriptionExpiry(purchase); endedAt != nil && !now.Before(*endedAt) {
+			grace := time.Duration(p.CancellationGraceDays) * 24 * time.Hour
+			if now.Before(endedAt.Add(grace)) {
+				return PolicyDecision{
+					Allowed: true,
+					Rule:    ruleGracePeriod,
+					Detail:  fmt.Sprintf("Subscription inactive, grace period ends %s", endedAt.Add(grace).Format(time.RFC3339)),
+				}
+			}
+			return PolicyDecision{Rule: ruleSubscriptionInactive, Detail: "Subscription is no longer active"}
+		}
+	}
+
+	return PolicyDecision{Allowed: true}
+}
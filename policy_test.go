@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLicensePolicyEvaluate(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	ended := now.Add(-3 * 24 * time.Hour).Format(time.RFC3339)
+	strict := LicensePolicy{
+		RejectRefunded:            true,
+		RejectChargebacked:        true,
+		RejectDisputed:            true,
+		MaxUses:                   3,
+		RequireActiveSubscription: true,
+		BlockedEmails:             []string{" Bad@Example.com "},
+		BlockedKeys:               []string{"blocked-key"},
+	}
+	graceful := strict
+	graceful.CancellationGraceDays = 7
+
+	tests := []struct {
+		name     string
+		policy   LicensePolicy
+		key      string
+		uses     int
+		purchase map[string]interface{}
+		allowed  bool
+		rule     string
+	}{
+		{"clean purchase", strict, "key", 1, map[string]interface{}{"email": "good@example.com"}, true, ""},
+		{"empty policy allows everything", LicensePolicy{}, "blocked-key", 99, map[string]interface{}{"refunded": true, "subscription_ended_at": ended}, true, ""},
+		{"blocked key, any case", strict, "BLOCKED-KEY", 0, nil, false, ruleBlockedKey},
+		{"blocked email, trimmed and any case", strict, "key", 0, map[string]interface{}{"email": "bad@example.com"}, false, ruleBlockedEmail},
+		{"refunded", strict, "key", 0, map[string]interface{}{"refunded": true}, false, ruleRefunded},
+		{"chargebacked", strict, "key", 0, map[string]interface{}{"chargebacked": true}, false, ruleChargebacked},
+		{"disputed", strict, "key", 0, map[string]interface{}{"disputed": true}, false, ruleDisputed},
+		{"dispute won", strict, "key", 0, map[string]interface{}{"disputed": true, "dispute_won": true}, true, ""},
+		{"at max uses", strict, "key", 3, nil, true, ""},
+		{"over max uses", strict, "key", 4, nil, false, ruleMaxUses},
+		{"subscription ended", strict, "key", 0, map[string]interface{}{"subscription_ended_at": ended}, false, ruleSubscriptionInactive},
+		{"subscription failed", strict, "key", 0, map[string]interface{}{"subscription_failed_at": ended}, false, ruleSubscriptionInactive},
+		{"cancelled, still paid", strict, "key", 0, map[string]interface{}{"subscription_cancelled_at": ended}, true, ""},
+		{"ends in the future", strict, "key", 0, map[string]interface{}{"subscription_ended_at": now.Add(time.Hour).Format(time.RFC3339)}, true, ""},
+		{"within grace period", graceful, "key", 0, map[string]interface{}{"subscription_ended_at": ended}, true, ruleGracePeriod},
+
+		// Precedence: the most specific matching rule is reported
+		{"blocked key before refund", strict, "blocked-key", 9, map[string]interface{}{"email": "bad@example.com", "refunded": true}, false, ruleBlockedKey},
+		{"blocked email before refund", strict, "key", 9, map[string]interface{}{"email": "bad@example.com", "refunded": true}, false, ruleBlockedEmail},
+		{"refund before chargeback", strict, "key", 0, map[string]interface{}{"refunded": true, "chargebacked": true}, false, ruleRefunded},
+		{"dispute before max uses", strict, "key", 9, map[string]interface{}{"disputed": true}, false, ruleDisputed},
+		{"max uses before subscription", graceful, "key", 9, map[string]interface{}{"subscription_ended_at": ended}, false, ruleMaxUses},
+	}
+	for _, tt := range tests {
+		decision := tt.policy.evaluate(tt.key, tt.uses, tt.purchase, now)
+		if decision.Allowed != tt.allowed || decision.Rule != tt.rule {
+			t.Errorf("%s: got allowed=%v rule=%q, want allowed=%v rule=%q", tt.name, decision.Allowed, decision.Rule, tt.allowed, tt.rule)
+		}
+	}
+}
+
+func TestLicensePolicyGraceBoundary(t *testing.T) {
+	ended := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	purchase := map[string]interface{}{"subscription_ended_at": ended.Format(time.RFC3339)}
+	policy := LicensePolicy{RequireActiveSubscription: true, CancellationGraceDays: 2}
+	deadline := ended.Add(48 * time.Hour)
+
+	tests := []struct {
+		now     time.Time
+		allowed bool
+		rule    string
+	}{
+		{ended.Add(-time.Second), true, ""},
+		{ended, true, ruleGracePeriod},
+		{deadline.Add(-time.Second), true, ruleGracePeriod},
+		{deadline, false, ruleSubscriptionInactive},
+		{deadline.Add(time.Second), false, ruleSubscriptionInactive},
+	}
+	for _, tt := range tests {
+		decision := policy.evaluate("key", 0, purchase, tt.now)
+		if decision.Allowed != tt.allowed || decision.Rule != tt.rule {
+			t.Errorf("at %s: got allowed=%v rule=%q, want allowed=%v rule=%q", tt.now.Format(time.RFC3339), decision.Allowed, decision.Rule, tt.allowed, tt.rule)
+		}
+	}
+
+	// Without a grace period the subscription is inactive from the moment it ended
+	policy.CancellationGraceDays = 0
+	if decision := policy.evaluate("key", 0, purchase, ended); decision.Allowed || decision.Rule != ruleSubscriptionInactive {
+		t.Errorf("no grace at the end time: %+v", decision)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	app := &App{config: Config{Policies: map[string]LicensePolicy{
+		"prod":           {MaxUses: 5},
+		defaultPolicyKey: {RejectDisputed: true},
+	}}}
+	if got := app.policyFor("prod"); got.MaxUses != 5 {
+		t.Errorf("product policy %+v", got)
+	}
+	if got := app.policyFor("other"); !got.RejectDisputed || got.RejectRefunded {
+		t.Errorf("wildcard policy %+v", got)
+	}
+	app.config.Policies = nil
+	if got := app.policyFor("other"); !got.RejectRefunded || !got.RejectChargebacked || got.RejectDisputed {
+		t.Errorf("default policy %+v", got)
+	}
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ProductVariant is one variant category of a product as /v2/products
+// lists it, such as "Size" with the options "Small" and "Large". It carries
+// no IDs; editing goes through the variant category endpoints instead.
+type ProductVariant struct {
+	Title   string                 `json:"title"`
+	Options []ProductVariantOption `json:"options"`
+}
+
+type ProductVariantOption struct {
+	Name string `json:"name"`
+	// PriceDifference is added to the product's price, in its currency's
+	// smallest unit
+	PriceDifference  int  `json:"price_difference"`
+	IsPayWhatYouWant bool `json:"is_pay_what_you_want"`
+}
+
+// CustomField is a question buyers answer at checkout. Gumroad addresses
+// custom fields by name.
+type CustomField struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+}
+
+// gumroadCount is a count Gumroad sends as a number in some responses and
+// as a numeric string in others.
+type gumroadCount int
+
+func (c *gumroadCount) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*c = gumroadCount(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*c = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid count %q", s)
+	}
+	*c = gumroadCount(n)
+	return nil
+}
+
+// Int is the count as an int, for templates.
+func (c gumroadCount) Int() int {
+	return int(c)
+}
+
+// RevenueMoney is the product's revenue, which Gumroad reports in US cents
+// whatever the product's currency.
+func (p Product) RevenueMoney() Money {
+	return newMoney(int(p.SalesUSDCents), "usd")
+}
+
+// VariantCategory is a variant category with its variants, as the variant
+// category endpoints return them.
+type VariantCategory struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Variants []Variant `json:"variants"`
+}
+
+type Variant struct {
+	ID                   string `json:"id"`
+	Name                 string `json:"name"`
+	PriceDifferenceCents int    `json:"price_difference_cents"`
+	// MaxPurchaseCount limits how many times the variant can be bought;
+	// nil is unlimited
+	MaxPurchaseCount *int `json:"max_purchase_count"`
+}
+
+// PriceDifference is the variant's price difference in the product's
+// currency.
+func (v Variant) PriceDifference(currency string) Money {
+	return newMoney(v.PriceDifferenceCents, currency)
+}
+
+// variantRequest creates or edits a variant. MaxPurchaseCount of 0 is
+// unlimited.
+type variantRequest struct {
+	Name                 string `json:"name"`
+	PriceDifferenceCents int    `json:"price_difference_cents"`
+	MaxPurchaseCount     int    `json:"max_purchase_count"`
+}
+
+func (req variantRequest) validate() error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.New("the variant needs a name")
+	}
+	if req.MaxPurchaseCount < 0 {
+		return errors.New("the purchase limit cannot be negative")
+	}
+	return nil
+}
+
+// description is the request as recorded in the audit log.
+func (req variantRequest) description() string {
+	limit := "unlimited"
+	if req.MaxPurchaseCount > 0 {
+		limit = fmt.Sprintf("max %d purchases", req.MaxPurchaseCount)
+	}
+	return fmt.Sprintf("%s, %+d cents, %s", strings.TrimSpace(req.Name), req.PriceDifferenceCents, limit)
+}
+
+func (req variantRequest) form() url.Values {
+	form := url.Values{}
+	form.Set("name", strings.TrimSpace(req.Name))
+	form.Set("price_difference_cents", strconv.Itoa(req.PriceDifferenceCents))
+	form.Set("max_purchase_count", "")
+	if req.MaxPurchaseCount > 0 {
+		form.Set("max_purchase_count", strconv.Itoa(req.MaxPurchaseCount))
+	}
+	return form
+}
+
+// productURL returns the Gumroad URL of a product or of one of its
+// sub-resources, e.g. productURL(id, "variant_categories", categoryID).
+func (app *App) productURL(productID string, path ...string) string {
+	target := "/products/" + url.PathEscape(productID)
+	for _, segment := range path {
+		target += "/" + url.PathEscape(segment)
+	}
+	return app.gumroadURL(target)
+}
+
+// setProductPublished enables or disables a product on Gumroad. Disabled
+// products can no longer be bought, but existing buyers keep access.
+func (app *App) setProductPublished(actor, productID string, published bool) (Product, error) {
+	action := "disable"
+	if published {
+		action = "enable"
+	}
+	var response ProductResponse
+	err := app.sendGumroadWrite(actor, "PUT", app.productURL(productID, action), nil, &response)
+	return response.Product, err
+}
+
+// getVariantCategories lists a product's variant categories with their
+// variants. Gumroad lists the variants of each category separately, so this
+// makes one request per category.
+func (app *App) getVariantCategories(productID string) ([]VariantCategory, error) {
+	body, err := app.makeGumroadRequest(app.productURL(productID, "variant_categories"))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Success           bool              `json:"success"`
+		VariantCategories []VariantCategory `json:"variant_categories"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("API request was not successful")
+	}
+
+	categories := response.VariantCategories
+	for i := range categories {
+		body, err := app.makeGumroadRequest(app.productURL(productID, "variant_categories", categories[i].ID, "variants"))
+		if err != nil {
+			return nil, err
+		}
+		var variants struct {
+			Success  bool      `json:"success"`
+			Variants []Variant `json:"variants"`
+		}
+		if err := json.Unmarshal(body, &variants); err != nil {
+			return nil, err
+		}
+		if !variants.Success {
+			return nil, fmt.Errorf("API request was not successful")
+		}
+		categories[i].Variants = variants.Variants
+	}
+	return categories, nil
+}
+
+// variantCategoryWrite creates (empty id) or renames a variant category.
+func (app *App) variantCategoryWrite(actor, productID, id, title string) (VariantCategory, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return VariantCategory{}, errors.New("the variant category needs a title")
+	}
+	method, target := "POST", app.productURL(productID, "variant_categories")
+	if id != "" {
+		method, target = "PUT", app.productURL(productID, "variant_categories", id)
+	}
+	var response struct {
+		VariantCategory VariantCategory `json:"variant_category"`
+	}
+	err := app.sendGumroadWrite(actor, method, target, url.Values{"title": {title}}, &response)
+	return response.VariantCategory, err
+}
+
+func (app *App) deleteVariantCategory(actor, productID, id string) error {
+	return app.sendGumroadWrite(actor, "DELETE", app.productURL(productID, "variant_categories", id), nil, nil)
+}
+
+// variantWrite creates (empty id) or edits a variant of a category.
+func (app *App) variantWrite(actor, productID, categoryID, id string, req variantRequest) (Variant, error) {
+	if err := req.validate(); err != nil {
+		return Variant{}, err
+	}
+	method, target := "POST", app.productURL(productID, "variant_categories", categoryID, "variants")
+	if id != "" {
+		method, target = "PUT", app.productURL(productID, "variant_categories", categoryID, "variants", id)
+	}
+	var response struct {
+		Variant Variant `json:"variant"`
+	}
+	err := app.sendGumroadWrite(actor, method, target, req.form(), &response)
+	return response.Variant, err
+}
+
+func (app *App) deleteVariant(actor, productID, categoryID, id string) error {
+	return app.sendGumroadWrite(actor, "DELETE", app.productURL(productID, "variant_categories", categoryID, "variants", id), nil, nil)
+}
+
+// customFieldWrite creates a custom field, or when update is set changes
+// whether the named field is required.
+func (app *App) customFieldWrite(actor, productID string, field CustomField, update bool) (CustomField, error) {
+	field.Name = strings.TrimSpace(field.Name)
+	if field.Name == "" {
+		return CustomField{}, errors.New("the custom field needs a name")
+	}
+	form := url.Values{}
+	form.Set("required", strconv.FormatBool(field.Required))
+	method, target := "POST", app.productURL(productID, "custom_fields")
+	if update {
+		method, target = "PUT", app.productURL(productID, "custom_fields", field.Name)
+	} else {
+		form.Set("name", field.Name)
+	}
+	var response struct {
+		CustomField CustomField `json:"custom_field"`
+	}
+	err := app.sendGumroadWrite(actor, method, target, form, &response)
+	return response.CustomField, err
+}
+
+func (app *App) deleteCustomField(actor, productID, name string) error {
+	return app.sendGumroadWrite(actor, "DELETE", app.productURL(productID, "custom_fields", name), nil, nil)
+}
+
+// productFromIndex resolves the product index of a product page URL.
+func (app *App) productFromIndex(r *http.Request) (Product, int, error) {
+	index, err := strconv.Atoi(mux.Vars(r)["index"])
+	if err != nil {
+		return Product{}, 0, errors.New("Invalid product index")
+	}
+	products, err := app.getProducts()
+	if err != nil {
+		return Product{}, 0, fmt.Errorf("Failed to fetch products: %w", err)
+	}
+	if index < 0 || index >= len(products) {
+		return Product{}, 0, errProductNotFound
+	}
+	return products[index], index, nil
+}
+
+var errProductNotFound = errors.New("Product not found")
+
+// actionErrorStatus is the status a failed product, offer code or other
+// write action is answered with.
+func actionErrorStatus(err error) int {
+	var statusErr *gumroadStatusError
+	switch {
+	case errors.Is(err, errProductNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &statusErr) && statusErr.Status == http.StatusNotFound:
+		return http.StatusNotFound
+	case errors.As(err, &statusErr) && statusErr.Status < http.StatusInternalServerError:
+		return http.StatusBadRequest
+	case errors.As(err, &statusErr):
+		return http.StatusBadGateway
+	}
+	return http.StatusBadRequest
+}
+
+// actionErrorMessage prefers the message of a Gumroad error response, such
+// as "An offer code with that name already exists.", to the raw body.
+func actionErrorMessage(err error) string {
+	var statusErr *gumroadStatusError
+	if errors.As(err, &statusErr) {
+		var response struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal([]byte(statusErr.Body), &response) == nil && response.Message != "" {
+			return response.Message
+		}
+	}
+	return err.Error()
+}
+
+func writeActionResult(w http.ResponseWriter, result map[string]interface{}, err error) {
+	if err != nil {
+		writeTableError(w, actionErrorStatus(err), actionErrorMessage(err))
+		return
+	}
+	result["success"] = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// productHandler shows a product's details, variants and custom fields, and
+// offers admins controls to edit them.
+func (app *App) productHandler(w http.ResponseWriter, r *http.Request) {
+	product, index, err := app.productFromIndex(r)
+	if err != nil {
+		http.Error(w, err.Error(), actionErrorStatus(err))
+		return
+	}
+	categories, err := app.getVariantCategories(product.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch variants: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := PageData{
+		Title:             app.localizer(r).t("product.title", product.Name),
+		CurrentPage:       "product",
+		BackLink:          "/",
+		ProductID:         product.ID,
+		ProductIndex:      index,
+		Product:           product,
+		VariantCategories: categories,
+	}
+
+	app.renderPage(w, r, data)
+}
+
+// setProductPublishedHandler serves both /enable and /disable.
+func (app *App) setProductPublishedHandler(published bool) http.HandlerFunc {
+	action := auditProductDisable
+	if published {
+		action = auditProductEnable
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		product, _, err := app.productFromIndex(r)
+		var updated Product
+		if err == nil {
+			updated, err = app.setProductPublished(requestActor(r), product.ID, published)
+		}
+		app.audit(r, AuditEvent{
+			Action:     action,
+			TargetType: "product",
+			Target:     product.Name,
+			ProductID:  product.ID,
+		}, err)
+		if err == nil {
+			log.Printf("Product %s published=%v by %s", product.ID, published, requestActor(r))
+		}
+		writeActionResult(w, map[string]interface{}{"product": updated}, err)
+	}
+}
+
+func (app *App) variantCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeTableError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	id := mux.Vars(r)["category"]
+	action := auditVariantCategoryCreate
+	if id != "" {
+		action = auditVariantCategoryUpdate
+	}
+	product, _, err := app.productFromIndex(r)
+	var category VariantCategory
+	if err == nil {
+		category, err = app.variantCategoryWrite(requestActor(r), product.ID, id, req.Title)
+	}
+	app.audit(r, AuditEvent{
+		Action:     action,
+		TargetType: "variant_category",
+		Target:     withDefaultString(category.ID, id),
+		ProductID:  product.ID,
+		Detail:     strings.TrimSpace(req.Title),
+	}, err)
+	writeActionResult(w, map[string]interface{}{"variant_category": category}, err)
+}
+
+func (app *App) deleteVariantCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["category"]
+	product, _, err := app.productFromIndex(r)
+	if err == nil {
+		err = app.deleteVariantCategory(requestActor(r), product.ID, id)
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditVariantCategoryDelete,
+		TargetType: "variant_category",
+		Target:     id,
+		ProductID:  product.ID,
+		Detail:     r.FormValue("title"),
+	}, err)
+	writeActionResult(w, map[string]interface{}{}, err)
+}
+
+func (app *App) variantHandler(w http.ResponseWriter, r *http.Request) {
+	var req variantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeTableError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	vars := mux.Vars(r)
+	action := auditVariantCreate
+	if vars["variant"] != "" {
+		action = auditVariantUpdate
+	}
+	product, _, err := app.productFromIndex(r)
+	var variant Variant
+	if err == nil {
+		variant, err = app.variantWrite(requestActor(r), product.ID, vars["category"], vars["variant"], req)
+	}
+	app.audit(r, AuditEvent{
+		Action:     action,
+		TargetType: "variant",
+		Target:     withDefaultString(variant.ID, vars["variant"]),
+		ProductID:  product.ID,
+		Detail:     req.description(),
+	}, err)
+	writeActionResult(w, map[string]interface{}{"variant": variant}, err)
+}
+
+func (app *App) deleteVariantHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	product, _, err := app.productFromIndex(r)
+	if err == nil {
+		err = app.deleteVariant(requestActor(r), product.ID, vars["category"], vars["variant"])
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditVariantDelete,
+		TargetType: "variant",
+		Target:     vars["variant"],
+		ProductID:  product.ID,
+		Detail:     r.FormValue("name"),
+	}, err)
+	writeActionResult(w, map[string]interface{}{}, err)
+}
+
+func (app *App) customFieldHandler(w http.ResponseWriter, r *http.Request) {
+	var field CustomField
+	if err := json.NewDecoder(r.Body).Decode(&field); err != nil {
+		writeTableError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	action := auditCustomFieldCreate
+	if name, ok := mux.Vars(r)["name"]; ok {
+		action, field.Name = auditCustomFieldUpdate, name
+	}
+	product, _, err := app.productFromIndex(r)
+	var saved CustomField
+	if err == nil {
+		saved, err = app.customFieldWrite(requestActor(r), product.ID, field, action == auditCustomFieldUpdate)
+	}
+	detail := "optional"
+	if field.Required {
+		detail = "required"
+	}
+	app.audit(r, AuditEvent{
+		Action:     action,
+		TargetType: "custom_field",
+		Target:     strings.TrimSpace(field.Name),
+		ProductID:  product.ID,
+		Detail:     detail,
+	}, err)
+	writeActionResult(w, map[string]interface{}{"custom_field": saved}, err)
+}
+
+func (app *App) deleteCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	product, _, err := app.productFromIndex(r)
+	if err == nil {
+		err = app.deleteCustomField(requestActor(r), product.ID, name)
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditCustomFieldDelete,
+		TargetType: "custom_field",
+		Target:     name,
+		ProductID:  product.ID,
+	}, err)
+	writeActionResult(w, map[string]interface{}{}, err)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProductJSON(t *testing.T) {
+	var products []Product
+	data := `[{"id":"a","published":false,"sales_count":"12","sales_usd_cents":"4500","variants":[{"title":"Size","options":[{"name":"Large","price_difference":200}]}],"custom_fields":[{"name":"Company","required":true}],"file_info":{"Size":"1.2 MB","Pages":12}},
+		{"id":"b","published":true,"sales_count":3,"sales_usd_cents":900},
+		{"id":"c","sales_count":""}]`
+	if err := json.Unmarshal([]byte(data), &products); err != nil {
+		t.Fatal(err)
+	}
+	if products[0].SalesCount != 12 || products[0].RevenueMoney() != (Money{4500, "USD"}) || products[1].SalesCount != 3 || products[2].SalesCount != 0 {
+		t.Errorf("sales counts %d %d %d", products[0].SalesCount, products[1].SalesCount, products[2].SalesCount)
+	}
+	if v := products[0].Variants; len(v) != 1 || v[0].Options[0].PriceDifference != 200 {
+		t.Errorf("variants %+v", v)
+	}
+	if f := products[0].CustomFields; len(f) != 1 || !f[0].Required || products[0].FileInfo["Size"] != "1.2 MB" {
+		t.Errorf("custom fields %+v, file info %v", f, products[0].FileInfo)
+	}
+	if err := json.Unmarshal([]byte(`[{"sales_count":"many"}]`), &products); err == nil {
+		t.Error("a non-numeric sales_count was accepted")
+	}
+}
+
+func TestProductHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "product")
+
+	recorder := serve(app.productHandler, httptest.NewRequest("GET", "/products/0", nil), map[string]string{"index": "0"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "product", recorder.Body.Bytes())
+
+	recorder = serve(app.productHandler, httptest.NewRequest("GET", "/products/9", nil), map[string]string{"index": "9"})
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("missing product: status %d, want 404", recorder.Code)
+	}
+}
+
+func TestProductActions(t *testing.T) {
+	app := newTestApp(t)
+	useSimulator(t, app)
+	auditLog, err := newAuditLog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.auditLog = auditLog
+	vars := map[string]string{"index": "0"}
+
+	post := func(handler http.HandlerFunc, path, body string, vars map[string]string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if !strings.HasPrefix(body, "{") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		recorder := serve(handler, req, vars)
+		var response map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	status, response := post(app.setProductPublishedHandler(false), "/products/0/disable", "", vars)
+	if status != http.StatusOK || response["product"].(map[string]interface{})["published"] != false {
+		t.Fatalf("disable: status %d, %v", status, response)
+	}
+
+	status, response = post(app.variantCategoryHandler, "/products/0/variant-categories", `{"title":"Size"}`, vars)
+	if status != http.StatusOK {
+		t.Fatalf("create category: status %d, %v", status, response)
+	}
+	categoryID := response["variant_category"].(map[string]interface{})["id"].(string)
+	categoryVars := map[string]string{"index": "0", "category": categoryID}
+	if status, _ := post(app.variantCategoryHandler, "/products/0/variant-categories/"+categoryID, `{"title":"Sizes"}`, categoryVars); status != http.StatusOK {
+		t.Errorf("rename category: status %d", status)
+	}
+	if status, _ := post(app.variantCategoryHandler, "/products/0/variant-categories", `{"title":" "}`, vars); status != http.StatusBadRequest {
+		t.Errorf("blank title: status %d, want 400", status)
+	}
+
+	status, response = post(app.variantHandler, "/products/0/variant-categories/"+categoryID+"/variants", `{"name":"Large","price_difference_cents":300,"max_purchase_count":20}`, categoryVars)
+	if status != http.StatusOK {
+		t.Fatalf("create variant: status %d, %v", status, response)
+	}
+	variantID := response["variant"].(map[string]interface{})["id"].(string)
+	variantVars := map[string]string{"index": "0", "category": categoryID, "variant": variantID}
+	status, response = post(app.variantHandler, "/products/0/variant-categories/"+categoryID+"/variants/"+variantID, `{"name":"Large","price_difference_cents":400}`, variantVars)
+	if status != http.StatusOK || response["variant"].(map[string]interface{})["max_purchase_count"] != nil {
+		t.Errorf("update variant: status %d, %v", status, response)
+	}
+
+	if status, _ := post(app.customFieldHandler, "/products/0/custom-fields", `{"name":"VAT ID","required":true}`, vars); status != http.StatusOK {
+		t.Errorf("create custom field: status %d", status)
+	}
+	status, response = post(app.customFieldHandler, "/products/0/custom-fields", `{"name":"vat id"}`, vars)
+	if status != http.StatusBadRequest || response["error"] != "A custom field with that name already exists." {
+		t.Errorf("duplicate custom field: status %d, %v", status, response)
+	}
+	fieldVars := map[string]string{"index": "0", "name": "VAT ID"}
+	if status, _ := post(app.customFieldHandler, "/products/0/custom-fields/VAT%20ID", `{"required":false}`, fieldVars); status != http.StatusOK {
+		t.Errorf("update custom field: status %d", status)
+	}
+
+	product, err := app.getProduct(productIDAt(t, app, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := product.Variants[len(product.Variants)-1]
+	if product.Published || last.Title != "Sizes" || len(last.Options) != 1 || last.Options[0].PriceDifference != 400 {
+		t.Errorf("product after edits: published %v, variants %+v", product.Published, product.Variants)
+	}
+	if field := product.CustomFields[len(product.CustomFields)-1]; field.Name != "VAT ID" || field.Required {
+		t.Errorf("custom field after edits %+v", field)
+	}
+
+	if status, _ := post(app.deleteVariantHandler, "/products/0/variant-categories/"+categoryID+"/variants/"+variantID+"/delete", "name=Large", variantVars); status != http.StatusOK {
+		t.Errorf("delete variant: status %d", status)
+	}
+	if status, _ := post(app.deleteVariantCategoryHandler, "/products/0/variant-categories/"+categoryID+"/delete", "title=Sizes", categoryVars); status != http.StatusOK {
+		t.Errorf("delete category: status %d", status)
+	}
+	if status, _ := post(app.deleteCustomFieldHandler, "/products/0/custom-fields/"+url.PathEscape("VAT ID")+"/delete", "", fieldVars); status != http.StatusOK {
+		t.Errorf("delete custom field: status %d", status)
+	}
+	if status, _ := post(app.setProductPublishedHandler(true), "/products/0/enable", "", vars); status != http.StatusOK {
+		t.Errorf("enable: status %d", status)
+	}
+
+	var actions []string
+	for _, event := range app.auditLog.snapshot() {
+		actions = append(actions, event.Action+":"+event.Outcome)
+	}
+	want := "product.disable:success product.variant_category_create:success product.variant_category_update:success " +
+		"product.variant_category_create:failure product.variant_create:success product.variant_update:success " +
+		"product.custom_field_create:success product.custom_field_create:failure product.custom_field_update:success " +
+		"product.variant_delete:success product.variant_category_delete:success product.custom_field_delete:success product.enable:success"
+	if strings.Join(actions, " ") != want {
+		t.Errorf("audit events\n%s\nwant\n%s", strings.Join(actions, " "), want)
+	}
+}
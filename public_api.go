@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// publicAPIVersion is reported in every public verification response and is
+// bumped whenever the schema changes incompatibly.
+const publicAPIVersion = 1
+
+// Default per-IP budget for the public verification endpoint.
+const defaultPublicRateLimit = 60
+
+// maxVerifyRequestSize caps verification request bodies, which only carry a
+// product and a license key.
+const maxVerifyRequestSize = 4 << 10
+
+// Reasons reported by the public verification API. Client software switches
+// on these values, so existing ones must never change meaning.
+const (
+	reasonOK              = "ok"
+	reasonBadRequest      = "bad_request"
+	reasonUnknownProduct  = "unknown_product"
+	reasonInvalidKey      = "invalid_key"
+	reasonDisabled        = "disabled"
+	reasonBlocked         = "blocked"
+	reasonRateLimited     = "rate_limited"
+	reasonUpstreamFailure = "upstream_unavailable"
+)
+
+// PublicProduct maps one of our product slugs to its Gumroad product.
+type PublicProduct struct {
+	GumroadProductID string   `json:"gumroad_product_id"`
+	Entitlements     []string `json:"entitlements,omitempty"`
+}
+
+type PublicVerifyRequest struct {
+	Product       string `json:"product"`
+	LicenseKey    string `json:"license_key"`
+	IncrementUses bool   `json:"increment_uses"`
+}
+
+type PublicVerifyResponse struct {
+	Version      int        `json:"version"`
+	Valid        bool       `json:"valid"`
+	Reason       string     `json:"reason"`
+	Uses         int        `json:"uses"`
+	Entitlements []string   `json:"entitlements"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	// Stale is set when Gumroad was unreachable and the last known-good
+	// result was served instead
+	Stale bool `json:"stale"`
+}
+
+func newPublicVerifyResponse(reason string) PublicVerifyResponse {
+	return PublicVerifyResponse{
+		Version:      publicAPIVersion,
+		Reason:       reason,
+		Entitlements: []string{},
+	}
+}
+
+func writePublicVerifyResponse(w http.ResponseWriter, status int, response PublicVerifyResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// publicVerifyHandler is the unauthenticated verification endpoint called by
+// our client software. Unlike validateLicenseHandler it only accepts our own
+// product slugs and returns a stable schema instead of the raw purchase.
+func (app *App) publicVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	ip := app.clientIP(r)
+	if ok, wait := app.guard.allowIP(app.publicLimiter, ip); !ok {
+		setRetryAfter(w, wait)
+		writePublicVerifyResponse(w, http.StatusTooManyRequests, newPublicVerifyResponse(reasonRateLimited))
+		return
+	}
+
+	var req PublicVerifyRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxVerifyRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Product == "" || req.LicenseKey == "" {
+		writePublicVerifyResponse(w, http.StatusBadRequest, newPublicVerifyResponse(reasonBadRequest))
+		return
+	}
+
+	if ok, wait := app.guard.allowKey(req.Product, req.LicenseKey); !ok {
+		setRetryAfter(w, wait)
+		writePublicVerifyResponse(w, http.StatusTooManyRequests, newPublicVerifyResponse(reasonRateLimited))
+		return
+	}
+
+	product, ok := app.config.PublicProducts[req.Product]
+	if !ok || product.GumroadProductID == "" {
+		writePublicVerifyResponse(w, http.StatusNotFound, newPublicVerifyResponse(reasonUnknownProduct))
+		return
+	}
+
+	result, err := app.verifyLicense(product.GumroadProductID, req.LicenseKey, verifyOptions{IncrementUses: req.IncrementUses})
+	if err != nil {
+		log.Printf("Public verification for %s failed: %v", req.Product, err)
+		writePublicVerifyResponse(w, http.StatusBadGateway, newPublicVerifyResponse(reasonUpstreamFailure))
+		return
+	}
+
+	if !result.Success && result.Policy == nil {
+		app.guard.recordInvalidKey(ip)
+	}
+
+	response := newPublicVerifyResponse(publicRejectReason(result))
+	response.Uses = result.Uses
+	response.Stale = result.Stale
+	if result.Purchase != nil {
+		response.ExpiresAt = subscriptionExpiry(result.Purchase)
+	}
+	if response.Reason == reasonOK {
+		response.Valid = true
+		if product.Entitlements != nil {
+			response.Entitlements = product.Entitlements
+		}
+	}
+
+	writePublicVerifyResponse(w, http.StatusOK, response)
+}
+
+// publicRejectReason maps a judged verification result to a public reason.
+// Blocked keys and emails share one reason so callers can't probe the lists.
+// Refunded and chargebacked purchases are rejected whatever the product's
+// policy says, since the customer got their money back.
+func publicRejectReason(result LicenseValidationResponse) string {
+	if result.Policy != nil && !result.Policy.Allowed {
+		switch result.Policy.Rule {
+		case ruleBlockedKey, ruleBlockedEmail:
+			return reasonBlocked
+		}
+		return result.Policy.Rule
+	}
+
+	if !result.Success {
+		if strings.Contains(strings.ToLower(result.Message), "disabled") {
+			return reasonDisabled
+		}
+		return reasonInvalidKey
+	}
+
+	switch {
+	case purchaseBool(result.Purchase, "refunded"):
+		return ruleRefunded
+	case purchaseBool(result.Purchase, "chargebacked"):
+		return ruleChargebacked
+	case purchaseBool(result.Purchase, "disabled"):
+		return reasonDisabled
+	}
+	return reasonOK
+}
+
+// subscriptionExpiry returns when access ends for a membership purchase, or
+// nil for one-off purchases and subscriptions that are still running. A
+// cancelled subscription stays paid until the end of its period, when
+// Gumroad sets subscription_ended_at, so the cancellation itself is ignored.
+func subscriptionExpiry(purchase map[string]interface{}) *time.Time {
+	for _, field := range []string{"subscription_ended_at", "subscription_failed_at"} {
+		if t := purchaseTime(purchase, field); t != nil {
+			return t
+		}
+	}
+	return nil
+}
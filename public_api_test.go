@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicRejectReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		result LicenseValidationResponse
+		want   string
+	}{
+		{"valid", LicenseValidationResponse{Success: true, Purchase: map[string]interface{}{}}, reasonOK},
+		{"unknown key", LicenseValidationResponse{Message: "That license does not exist for the provided product."}, reasonInvalidKey},
+		{"disabled by Gumroad", LicenseValidationResponse{Message: "This license key has been disabled."}, reasonDisabled},
+		{"disabled purchase", LicenseValidationResponse{Success: true, Purchase: map[string]interface{}{"disabled": true}}, reasonDisabled},
+		{"blocked key", LicenseValidationResponse{Success: true, Policy: &PolicyDecision{Rule: ruleBlockedKey}}, reasonBlocked},
+		{"blocked email", LicenseValidationResponse{Success: true, Policy: &PolicyDecision{Rule: ruleBlockedEmail}}, reasonBlocked},
+		{"policy rule", LicenseValidationResponse{Success: true, Policy: &PolicyDecision{Rule: ruleMaxUses}}, ruleMaxUses},
+		{"grace period", LicenseValidationResponse{Success: true, Policy: &PolicyDecision{Allowed: true, Rule: ruleGracePeriod}}, reasonOK},
+		// Refunds and chargebacks are rejected even when the policy allows them
+		{"refunded, policy allows", LicenseValidationResponse{Success: true, Policy: &PolicyDecision{Allowed: true}, Purchase: map[string]interface{}{"refunded": true}}, ruleRefunded},
+		{"chargebacked, no policy", LicenseValidationResponse{Success: true, Purchase: map[string]interface{}{"chargebacked": true}}, ruleChargebacked},
+	}
+	for _, tt := range tests {
+		if got := publicRejectReason(tt.result); got != tt.want {
+			t.Errorf("%s: reason %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionExpiry(t *testing.T) {
+	ended := "2024-11-01T00:00:00Z"
+	tests := []struct {
+		purchase map[string]interface{}
+		want     string
+	}{
+		{map[string]interface{}{}, ""},
+		{map[string]interface{}{"subscription_ended_at": nil}, ""},
+		// Cancelled memberships stay paid until the period ends
+		{map[string]interface{}{"subscription_cancelled_at": ended}, ""},
+		{map[string]interface{}{"subscription_cancelled_at": "2024-10-01T00:00:00Z", "subscription_ended_at": ended}, ended},
+		{map[string]interface{}{"subscription_failed_at": ended}, ended},
+	}
+	for _, tt := range tests {
+		got := ""
+		if expiry := subscriptionExpiry(tt.purchase); expiry != nil {
+			got = expiry.Format(time.RFC3339)
+		}
+		if got != tt.want {
+			t.Errorf("subscriptionExpiry(%v) = %q, want %q", tt.purchase, got, tt.want)
+		}
+	}
+}
+
+// TestPublicVerifyResponse checks the schema client software relies on: every
+// field is present, with entitlements as a list and expires_at as null or
+// an RFC 3339 time.
+func TestPublicVerifyResponse(t *testing.T) {
+	app := newTestApp(t)
+	app.publicLimiter = newRateLimiter(100, time.Minute)
+	app.config.PublicProducts = map[string]PublicProduct{
+		"my-app":  {GumroadProductID: "prod", Entitlements: []string{"pro"}},
+		"no-perk": {GumroadProductID: "prod"},
+	}
+	app.config.Policies = map[string]LicensePolicy{defaultPolicyKey: {}}
+	fake := newFakeVerifyServer(t, app)
+
+	verify := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/v1/licenses/verify", strings.NewReader(body))
+		recorder := serve(app.publicVerifyHandler, req, nil)
+		if cc := recorder.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("Cache-Control %q", cc)
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		for _, field := range []string{"version", "valid", "reason", "uses", "entitlements", "expires_at", "stale"} {
+			if _, ok := response[field]; !ok {
+				t.Errorf("%s: response %v has no %s", body, response, field)
+			}
+		}
+		if _, ok := response["entitlements"].([]interface{}); !ok {
+			t.Errorf("%s: entitlements %v, want a list", body, response["entitlements"])
+		}
+		if response["version"] != float64(publicAPIVersion) {
+			t.Errorf("%s: version %v", body, response["version"])
+		}
+		return recorder.Code, response
+	}
+
+	fake.respond(http.StatusOK, `{"success":true,"uses":3,"purchase":{"email":"a@example.com","subscription_cancelled_at":"2024-11-01T00:00:00Z"}}`)
+	status, response := verify(`{"product":"my-app","license_key":"KEY-1"}`)
+	if status != http.StatusOK || response["valid"] != true || response["reason"] != reasonOK || response["uses"] != float64(3) ||
+		response["expires_at"] != nil || response["entitlements"].([]interface{})[0] != "pro" {
+		t.Errorf("valid key: status %d, %v", status, response)
+	}
+
+	fake.respond(http.StatusOK, `{"success":true,"uses":1,"purchase":{"subscription_ended_at":"2024-11-01T00:00:00Z"}}`)
+	status, response = verify(`{"product":"no-perk","license_key":"KEY-2"}`)
+	if status != http.StatusOK || response["valid"] != true || response["expires_at"] != "2024-11-01T00:00:00Z" || len(response["entitlements"].([]interface{})) != 0 {
+		t.Errorf("ended membership: status %d, %v", status, response)
+	}
+
+	// The "*" policy above does not reject refunds, the public API still does
+	fake.respond(http.StatusOK, `{"success":true,"uses":1,"purchase":{"refunded":true}}`)
+	if status, response := verify(`{"product":"my-app","license_key":"KEY-3"}`); status != http.StatusOK || response["valid"] != false || response["reason"] != ruleRefunded {
+		t.Errorf("refunded key: status %d, %v", status, response)
+	}
+
+	fake.respond(http.StatusNotFound, `{"success":false,"message":"That license does not exist for the provided product."}`)
+	if status, response := verify(`{"product":"my-app","license_key":"KEY-4"}`); status != http.StatusOK || response["valid"] != false || response["reason"] != reasonInvalidKey {
+		t.Errorf("unknown key: status %d, %v", status, response)
+	}
+
+	if status, response := verify(`{"product":"other-app","license_key":"KEY-5"}`); status != http.StatusNotFound || response["reason"] != reasonUnknownProduct {
+		t.Errorf("unknown product: status %d, %v", status, response)
+	}
+	if status, response := verify(`{"product":"my-app"}`); status != http.StatusBadRequest || response["reason"] != reasonBadRequest {
+		t.Errorf("missing key: status %d, %v", status, response)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a fixed-window request counter keyed by an arbitrary string,
+// such as a client IP address.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:     limit,
+		window:    window,
+		windows:   make(map[string]*rateWindow),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow records a hit for key and reports whether it is within the limit.
+// When it is not, the returned duration is how long the caller must wait
+// before the window resets.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Drop expired windows once per window so idle clients don't pile up
+	if now.Sub(rl.lastSweep) >= rl.window {
+		for k, w := range rl.windows {
+			if now.Sub(w.start) >= rl.window {
+				delete(rl.windows, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	w, ok := rl.windows[key]
+	if !ok || now.Sub(w.start) >= rl.window {
+		w = &rateWindow{start: now}
+		rl.windows[key] = w
+	}
+
+	w.count++
+	if w.count > rl.limit {
+		return false, w.start.Add(rl.window).Sub(now)
+	}
+	return true, 0
+}
+
+// clientIP returns the address of the client that sent the request. The
+// X-Forwarded-For header is only honoured when the app is configured to sit
+// behind a trusted reverse proxy, since clients can set it to anything.
+func (app *App) clientIP(r *http.Request) string {
+	if app.config.TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounding up so
+// clients never retry before the window has actually reset.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxReasonLength caps the reason given for a sale action, which is kept in
+// the audit log.
+const maxReasonLength = 500
+
+var errSaleNotFound = errors.New("Sale not found")
+
+type SaleResponse struct {
+	Success bool `json:"success"`
+	Sale    Sale `json:"sale"`
+}
+
+// RequiresShipping reports whether the sale is of a physical product, which
+// can be marked as shipped.
+func (s Sale) RequiresShipping() bool {
+	return s.ShippingInformation != nil
+}
+
+// saleActionRequest is the body of the sale actions. AmountCents only
+// applies to refunds, where 0 refunds the whole price, and TrackingURL only
+// to marking a sale shipped.
+type saleActionRequest struct {
+	AmountCents int    `json:"amount_cents"`
+	TrackingURL string `json:"tracking_url"`
+	Reason      string `json:"reason"`
+}
+
+func (req saleActionRequest) validateReason() error {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return errors.New("a reason is required")
+	}
+	if len(reason) > maxReasonLength {
+		return fmt.Errorf("the reason cannot be longer than %d characters", maxReasonLength)
+	}
+	return nil
+}
+
+func (app *App) saleURL(id string, action string) string {
+	path := "/sales/" + url.PathEscape(id)
+	if action != "" {
+		path += "/" + action
+	}
+	return app.gumroadURL(path)
+}
+
+func (app *App) getSale(id string) (Sale, error) {
+	body, err := app.makeGumroadRequest(app.saleURL(id, ""))
+	if err != nil {
+		return Sale{}, err
+	}
+
+	var response SaleResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Sale{}, err
+	}
+	if !response.Success {
+		return Sale{}, fmt.Errorf("API request was not successful")
+	}
+	return response.Sale, nil
+}
+
+// refundSale refunds amountCents of a sale, or the whole price when it is 0.
+// Gumroad refuses refunds of more than is left of the price.
+func (app *App) refundSale(actor string, sale Sale, amountCents int) (Sale, error) {
+	switch {
+	case sale.Refunded || sale.Chargebacked:
+		return Sale{}, errors.New("the sale has already been refunded or charged back")
+	case amountCents < 0:
+		return Sale{}, errors.New("the refund amount cannot be negative")
+	case amountCents > sale.Price:
+		return Sale{}, errors.New("the refund cannot be more than the price")
+	}
+	form := url.Values{}
+	if amountCents > 0 {
+		form.Set("amount_cents", strconv.Itoa(amountCents))
+	}
+	var response SaleResponse
+	err := app.sendGumroadWrite(actor, "PUT", app.saleURL(sale.ID, "refund"), form, &response)
+	if err != nil {
+		return Sale{}, err
+	}
+	// A refunded purchase's license no longer verifies
+	if sale.LicenseKey != "" {
+		app.verifyCache.invalidate(sale.ProductID, sale.LicenseKey)
+	}
+	return response.Sale, nil
+}
+
+func (app *App) markSaleShipped(actor string, sale Sale, trackingURL string) (Sale, error) {
+	if !sale.RequiresShipping() {
+		return Sale{}, errors.New("only sales of physical products can be shipped")
+	}
+	form := url.Values{}
+	if trackingURL != "" {
+		parsed, err := url.Parse(trackingURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return Sale{}, errors.New("the tracking URL must be an http or https URL")
+		}
+		form.Set("tracking_url", trackingURL)
+	}
+	var response SaleResponse
+	err := app.sendGumroadWrite(actor, "PUT", app.saleURL(sale.ID, "mark_as_shipped"), form, &response)
+	return response.Sale, err
+}
+
+func (app *App) resendReceipt(actor string, sale Sale) error {
+	return app.sendGumroadWrite(actor, "POST", app.saleURL(sale.ID, "resend_receipt"), nil, nil)
+}
+
+// productSale resolves the product index and sale ID of a sale action URL.
+// The sale must belong to the product, so a stale page cannot act on a sale
+// of another product.
+func (app *App) productSale(r *http.Request) (Product, int, Sale, error) {
+	product, index, err := app.productFromIndex(r)
+	if err != nil {
+		return product, index, Sale{}, err
+	}
+	sale, err := app.getSale(mux.Vars(r)["id"])
+	if err != nil {
+		return product, index, Sale{}, err
+	}
+	if sale.ProductID != product.ID {
+		return product, index, Sale{}, errSaleNotFound
+	}
+	return product, index, sale, nil
+}
+
+// renderSaleRow renders a sale's row of the sales table, for the page to
+// swap in after an action.
+func (app *App) renderSaleRow(r *http.Request, index int, sale Sale) (string, error) {
+	sales := []Sale{sale}
+	if !app.canViewPII(r) {
+		sales = maskSalePII(sales)
+	}
+	data := PageData{
+		Sales:        sales,
+		ProductIndex: index,
+		TableQuery:   tableQuery{path: "/sales/" + strconv.Itoa(index)},
+	}
+	var row bytes.Buffer
+	if err := app.executeTemplate(&row, r, "sale-rows", data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(row.String()), nil
+}
+
+// writeSaleActionResult answers a sale action with the sale's new row.
+func (app *App) writeSaleActionResult(w http.ResponseWriter, r *http.Request, index int, sale Sale, err error) {
+	if err != nil {
+		if errors.Is(err, errSaleNotFound) {
+			writeTableError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeTableError(w, actionErrorStatus(err), actionErrorMessage(err))
+		return
+	}
+	row, err := app.renderSaleRow(r, index, sale)
+	if err != nil {
+		log.Printf("Failed to render sale row: %v", err)
+	}
+	writeActionResult(w, map[string]interface{}{"row": row}, nil)
+}
+
+// decodeSaleAction reads a sale action's body and checks its reason.
+func decodeSaleAction(w http.ResponseWriter, r *http.Request) (saleActionRequest, bool) {
+	var req saleActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeTableError(w, http.StatusBadRequest, "Invalid JSON data")
+		return req, false
+	}
+	if err := req.validateReason(); err != nil {
+		writeTableError(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	return req, true
+}
+
+// refundSaleHandler refunds a sale in full or in part.
+func (app *App) refundSaleHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeSaleAction(w, r)
+	if !ok {
+		return
+	}
+
+	product, index, sale, err := app.productSale(r)
+	refunded := sale
+	if err == nil {
+		refunded, err = app.refundSale(requestActor(r), sale, req.AmountCents)
+	}
+	detail := "full refund"
+	if req.AmountCents > 0 {
+		detail = "refund of " + newMoney(req.AmountCents, sale.Currency).Format(defaultLocale)
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditSaleRefund,
+		TargetType: "sale",
+		Target:     mux.Vars(r)["id"],
+		ProductID:  product.ID,
+		Detail:     detail + ": " + req.Reason,
+	}, err)
+	if err == nil {
+		log.Printf("Sale %s refunded (%s) by %s", sale.ID, detail, requestActor(r))
+	}
+	app.writeSaleActionResult(w, r, index, refunded, err)
+}
+
+func (app *App) resendReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeSaleAction(w, r)
+	if !ok {
+		return
+	}
+
+	product, index, sale, err := app.productSale(r)
+	if err == nil {
+		err = app.resendReceipt(requestActor(r), sale)
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditSaleResendReceipt,
+		TargetType: "sale",
+		Target:     mux.Vars(r)["id"],
+		ProductID:  product.ID,
+		Detail:     req.Reason,
+	}, err)
+	app.writeSaleActionResult(w, r, index, sale, err)
+}
+
+// markShippedHandler marks the sale of a physical product as shipped, with
+// an optional tracking URL.
+func (app *App) markShippedHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeSaleAction(w, r)
+	if !ok {
+		return
+	}
+
+	trackingURL := strings.TrimSpace(req.TrackingURL)
+	product, index, sale, err := app.productSale(r)
+	shipped := sale
+	if err == nil {
+		shipped, err = app.markSaleShipped(requestActor(r), sale, trackingURL)
+	}
+	detail := "no tracking URL"
+	if trackingURL != "" {
+		detail = "tracking " + trackingURL
+	}
+	app.audit(r, AuditEvent{
+		Action:     auditSaleMarkShipped,
+		TargetType: "sale",
+		Target:     mux.Vars(r)["id"],
+		ProductID:  product.ID,
+		Detail:     detail + ": " + req.Reason,
+	}, err)
+	app.writeSaleActionResult(w, r, index, shipped, err)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSaleActionHandlers(t *testing.T) {
+	app := newTestApp(t)
+	useSimulator(t, app)
+	auditLog, err := newAuditLog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.auditLog = auditLog
+
+	// firstSale finds an unrefunded sale of the product at index
+	firstSale := func(index int) Sale {
+		t.Helper()
+		sales, err := app.getSales(productIDAt(t, app, index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sale := range sales {
+			if !sale.Refunded && !sale.Chargebacked && sale.Price > 100 {
+				return sale
+			}
+		}
+		t.Fatalf("no refundable sale of product %d", index)
+		return Sale{}
+	}
+	post := func(handler http.HandlerFunc, index, id, action, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/sales/"+index+"/"+id+"/"+action, strings.NewReader(body))
+		recorder := serve(handler, req, map[string]string{"index": index, "id": id})
+		var response map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	digital := firstSale(0)
+	status, response := post(app.refundSaleHandler, "0", digital.ID, "refund", `{"amount_cents":100,"reason":"Partial refund, asked nicely"}`)
+	if status != http.StatusOK || !strings.Contains(response["row"].(string), "Partially Refunded") {
+		t.Fatalf("partial refund: status %d, %v", status, response)
+	}
+	if status, _ := post(app.refundSaleHandler, "0", digital.ID, "refund", `{"reason":" "}`); status != http.StatusBadRequest {
+		t.Errorf("missing reason: status %d, want 400", status)
+	}
+	if status, _ := post(app.refundSaleHandler, "1", digital.ID, "refund", `{"reason":"Wrong product"}`); status != http.StatusNotFound {
+		t.Errorf("sale of another product: status %d, want 404", status)
+	}
+	status, response = post(app.refundSaleHandler, "0", digital.ID, "refund", `{"reason":"Refund the rest"}`)
+	if status != http.StatusOK || !strings.Contains(response["row"].(string), "status-refunded") {
+		t.Errorf("full refund: status %d, %v", status, response)
+	}
+	if status, _ := post(app.markShippedHandler, "0", digital.ID, "mark-shipped", `{"reason":"Sent"}`); status != http.StatusBadRequest {
+		t.Errorf("shipping a digital sale: status %d, want 400", status)
+	}
+
+	physical := firstSale(2)
+	if status, _ := post(app.markShippedHandler, "2", physical.ID, "mark-shipped", `{"tracking_url":"ftp://example.com","reason":"Sent"}`); status != http.StatusBadRequest {
+		t.Errorf("bad tracking URL: status %d, want 400", status)
+	}
+	status, response = post(app.markShippedHandler, "2", physical.ID, "mark-shipped", `{"tracking_url":"https://track.example.com/1Z999","reason":"Posted today"}`)
+	if row, _ := response["row"].(string); status != http.StatusOK || !strings.Contains(row, "https://track.example.com/1Z999") || strings.Contains(row, "Mark Shipped") {
+		t.Errorf("mark shipped: status %d, %v", status, response)
+	}
+	if status, _ := post(app.resendReceiptHandler, "2", physical.ID, "resend-receipt", `{"reason":"Customer lost it"}`); status != http.StatusOK {
+		t.Errorf("resend receipt: status %d", status)
+	}
+
+	var actions []string
+	for _, event := range app.auditLog.snapshot() {
+		actions = append(actions, event.Action+":"+event.Outcome)
+	}
+	want := "sale.refund:success sale.refund:failure sale.refund:success sale.mark_shipped:failure " +
+		"sale.mark_shipped:failure sale.mark_shipped:success sale.resend_receipt:success"
+	if strings.Join(actions, " ") != want {
+		t.Errorf("audit events\n%s\nwant\n%s", strings.Join(actions, " "), want)
+	}
+	if detail := app.auditLog.snapshot()[0].Detail; !strings.HasSuffix(detail, ": Partial refund, asked nicely") {
+		t.Errorf("refund audit detail %q", detail)
+	}
+}
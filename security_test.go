@@ -0,0 +1,109 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	cspNoncePattern = regexp.MustCompile(`script-src 'self' 'nonce-([A-Za-z0-9+/=]+)'`)
+	scriptTag       = regexp.MustCompile(`<(?:script|style)\b[^>]*>`)
+	nonceAttribute  = regexp.MustCompile(`\bnonce="([^"]*)"`)
+)
+
+// TestPageNonces checks that every script tag on a page carries the nonce of
+// that response's Content-Security-Policy, and that each response gets its
+// own nonce. Without it the browser blocks all of the app's JavaScript.
+func TestPageNonces(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "products")
+	router := app.routes()
+
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status %d", recorder.Code)
+		}
+		policy := recorder.Header().Get("Content-Security-Policy")
+		match := cspNoncePattern.FindStringSubmatch(policy)
+		if match == nil {
+			t.Fatalf("no script nonce in Content-Security-Policy %q", policy)
+		}
+		nonce := match[1]
+		if !strings.Contains(policy, "style-src 'self' 'nonce-"+nonce+"'") || !strings.Contains(policy, "frame-ancestors 'none'") {
+			t.Errorf("Content-Security-Policy %q", policy)
+		}
+
+		tags := scriptTag.FindAllString(recorder.Body.String(), -1)
+		if len(tags) == 0 {
+			t.Fatal("page has no script tags")
+		}
+		for _, tag := range tags {
+			// JSON data blocks are never executed
+			if strings.Contains(tag, `type="application/json"`) {
+				continue
+			}
+			// The template escapes '+' in the base64 nonce, which the
+			// browser decodes again
+			attribute := nonceAttribute.FindStringSubmatch(tag)
+			if attribute == nil || html.UnescapeString(attribute[1]) != nonce {
+				t.Errorf("%s does not carry the nonce %s", tag, nonce)
+			}
+		}
+		nonces = append(nonces, nonce)
+	}
+	if nonces[0] == nonces[1] {
+		t.Errorf("both responses use the nonce %s", nonces[0])
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	app := newTestApp(t)
+	handler := app.securityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cspNonce(r) == "" {
+			t.Error("no nonce in the request context")
+		}
+	}))
+	get := func(target string, headers map[string]string) http.Header {
+		req := httptest.NewRequest("GET", target, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Header()
+	}
+
+	header := get("http://localhost/", nil)
+	for name, want := range map[string]string{
+		"X-Frame-Options":        "DENY",
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "same-origin",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// HSTS is only sent over HTTPS, where browsers honour it
+	proxied := map[string]string{"X-Forwarded-Proto": "https"}
+	if hsts := header.Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("HSTS over plain HTTP: %q", hsts)
+	}
+	if hsts := get("https://localhost/", nil).Get("Strict-Transport-Security"); hsts != "max-age=63072000; includeSubDomains" {
+		t.Errorf("HSTS over TLS: %q", hsts)
+	}
+	if hsts := get("http://localhost/", proxied).Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("HSTS from an untrusted X-Forwarded-Proto: %q", hsts)
+	}
+	app.config.TrustProxyHeaders = true
+	if hsts := get("http://localhost/", proxied).Get("Strict-Transport-Security"); hsts == "" {
+		t.Error("no HSTS behind a trusted HTTPS proxy")
+	}
+}
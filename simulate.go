@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"gumroad-license-manager/gumroadsim"
+)
+
+// runSimulator serves the local Gumroad API simulator. It is started with
+// "gumroad-license-manager simulate [flags]"; point gumroad_api_base in
+// config.json at it to run the app without network access.
+func runSimulator(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	addr := flags.String("addr", ":8087", "address to listen on")
+	fixturePath := flags.String("fixture", "", "JSON fixture to load instead of generating one")
+	seed := flags.Int64("seed", 1, "seed for the generated fixture and fault injection")
+	products := flags.Int("products", 4, "number of products to generate")
+	sales := flags.Int("sales", 25, "number of sales to generate per product")
+	writeFixture := flags.String("write-fixture", "", "write the fixture to this file and exit")
+	latency := flags.Duration("latency", 0, "delay added to every API response")
+	jitter := flags.Duration("jitter", 0, "random extra delay, up to this much")
+	errorRate := flags.Float64("error-rate", 0, "fraction of API requests answered with a 5xx")
+	rateLimitRate := flags.Float64("rate-limit-rate", 0, "fraction of API requests answered with a 429")
+	flags.Parse(args)
+
+	fixture := gumroadsim.Generate(*seed, *products, *sales)
+	if *fixturePath != "" {
+		var err error
+		if fixture, err = gumroadsim.LoadFixture(*fixturePath); err != nil {
+			log.Fatal("Failed to load fixture:", err)
+		}
+	}
+	if *writeFixture != "" {
+		if err := fixture.Save(*writeFixture); err != nil {
+			log.Fatal("Failed to write fixture:", err)
+		}
+		log.Printf("Wrote fixture with %d products and %d sales to %s", len(fixture.Products), len(fixture.Sales), *writeFixture)
+		return
+	}
+
+	sim := gumroadsim.New(fixture, *seed)
+	sim.SetFaults(gumroadsim.Faults{
+		Latency:       *latency,
+		Jitter:        *jitter,
+		ErrorRate:     *errorRate,
+		RateLimitRate: *rateLimitRate,
+	})
+
+	log.Printf("Gumroad simulator listening on %s with %d products and %d sales", *addr, len(fixture.Products), len(fixture.Sales))
+	log.Printf(`Use "gumroad_api_base": "http://localhost%s/v2" and "gumroad_token": %q in config.json`, *addr, fixture.AccessToken)
+	log.Fatal(http.ListenAndServe(*addr, sim))
+}
@@ -0,0 +1,72 @@
+// API keys page functionality
+document.addEventListener('DOMContentLoaded', function() {
+    const form = document.getElementById('createApiKeyForm');
+    if (form) {
+        form.addEventListener('submit', function(e) {
+            e.preventDefault();
+
+            const button = form.querySelector('button[type="submit"]');
+            button.disabled = true;
+
+            fetch('/api-keys', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'X-CSRF-Token': csrfToken(),
+                },
+                body: JSON.stringify({
+                    name: document.getElementById('apiKeyName').value,
+                    scope: document.getElementById('apiKeyScope').value,
+                    expires_in_days: parseInt(document.getElementById('apiKeyExpiry').value, 10)
+                })
+            })
+            .then(response => response.json())
+            .then(data => {
+                button.disabled = false;
+                if (!data.success) {
+                    alert(data.error || 'Failed to create API key');
+                    return;
+                }
+
+                // The token is only available in this response, so show it
+                // instead of reloading the page
+                document.getElementById('newApiKeyToken').textContent = data.token;
+                document.getElementById('newApiKey').style.display = 'block';
+                form.reset();
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                button.disabled = false;
+            });
+        });
+    }
+
+    document.querySelectorAll('.revoke-key-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            if (!confirm(`Revoke the API key "${this.dataset.name}"? Scripts using it will stop working.`)) {
+                return;
+            }
+
+            this.disabled = true;
+            fetch(`/api-keys/${encodeURIComponent(this.dataset.id)}/revoke`, {
+                method: 'POST',
+                headers: {
+                    'X-CSRF-Token': csrfToken(),
+                }
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (data.success) {
+                    window.location.reload();
+                } else {
+                    alert(data.error || 'Failed to revoke API key');
+                    this.disabled = false;
+                }
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                this.disabled = false;
+            });
+        });
+    });
+});
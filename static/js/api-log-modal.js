@@ -1,10 +1,18 @@
 // API Log Modal JavaScript
 let apiCallsData = [];
+let currentCallId = null;
 
-// Fetch API calls data from the server
+// Fetch the calls shown on this page, using the page's own filters, sort
+// and cursor
 async function loadApiCallsData() {
+    const table = document.getElementById('apiLogTable');
+    const params = new URLSearchParams(window.location.search);
+    if (table && table.dataset.limit) {
+        params.set('limit', table.dataset.limit);
+    }
+
     try {
-        const response = await fetch('/api/api-calls');
+        const response = await fetch('/api/api-calls?' + params.toString());
         if (response.ok) {
             apiCallsData = await response.json();
         } else {
@@ -15,14 +23,16 @@ async function loadApiCallsData() {
     }
 }
 
-function showModal(index) {
-    const call = apiCallsData[index];
+function showModal(id) {
+    const call = apiCallsData.find(c => String(c.ID) === String(id));
     if (!call) {
-        console.error('API call not found at index:', index);
+        console.error('API call not found:', id);
         return;
     }
     
     const modal = document.getElementById('apiModal');
+    currentCallId = call.ID;
+    document.getElementById('modal-replay-section').style.display = 'none';
     
     // Populate modal fields
     document.getElementById('modal-method').textContent = call.Method || call.method || '';
@@ -49,6 +59,8 @@ function showModal(index) {
     document.getElementById('modal-duration').textContent = durationMs;
     
     document.getElementById('modal-status').textContent = call.Status || call.status || '';
+    document.getElementById('modal-policy').textContent = call.PolicyRule || '-';
+    document.getElementById('modal-actor').textContent = call.Actor || '-';
     
     // Format request body
     const requestBody = call.RequestBody || call.requestBody || '';
@@ -105,6 +117,110 @@ document.addEventListener('DOMContentLoaded', function() {
         }
     }
     
+    // Open the modal for clicked rows, including ones added by the live stream
+    const tbody = document.querySelector('#apiLogTable tbody');
+    if (tbody) {
+        tbody.addEventListener('click', function(event) {
+            if (event.target.closest('.select-col')) {
+                return; // Selecting a row for export, not opening it
+            }
+            const row = event.target.closest('.api-call-row');
+            if (row) {
+                showModal(row.dataset.id);
+            }
+        });
+    }
+
+    const selectAll = document.getElementById('selectAllCalls');
+    if (selectAll) {
+        selectAll.addEventListener('change', function() {
+            document.querySelectorAll('.call-select').forEach(checkbox => {
+                if (checkbox.closest('tr').style.display !== 'none') {
+                    checkbox.checked = selectAll.checked;
+                }
+            });
+        });
+    }
+
+    const exportBtn = document.getElementById('exportHar');
+    if (exportBtn) {
+        exportBtn.addEventListener('click', exportHAR);
+    }
+
+    const replayBtn = document.getElementById('replayCall');
+    if (replayBtn) {
+        replayBtn.addEventListener('click', replayCurrentCall);
+    }
+
     // Load API calls data when the page loads
     loadApiCallsData();
 });
+
+// Download the selected calls, or every call matching the page filters when
+// nothing is selected, as a HAR file
+function exportHAR() {
+    const ids = Array.from(document.querySelectorAll('.call-select:checked')).map(c => c.value);
+    const params = new URLSearchParams(window.location.search);
+    if (ids.length > 0) {
+        params.set('ids', ids.join(','));
+    }
+    window.location.href = '/api/api-calls/har?' + params.toString();
+}
+
+// Re-issue the call shown in the modal and show the old and new responses side by side
+async function replayCurrentCall() {
+    const replayBtn = document.getElementById('replayCall');
+    const section = document.getElementById('modal-replay-section');
+    const summary = document.getElementById('modal-replay-summary');
+    const oldPre = document.getElementById('modal-diff-old');
+    const newPre = document.getElementById('modal-diff-new');
+
+    replayBtn.disabled = true;
+    section.style.display = 'block';
+    summary.textContent = 'Replaying...';
+    oldPre.innerHTML = '';
+    newPre.innerHTML = '';
+
+    try {
+        const response = await fetch(`/api/api-calls/${currentCallId}/replay`, {
+            method: 'POST',
+            headers: { 'X-CSRF-Token': csrfToken() }
+        });
+        const data = await response.json();
+        if (!data.success) {
+            summary.textContent = data.error || 'Replay failed';
+            return;
+        }
+
+        const changed = data.diff.filter(line => line.op !== ' ').length;
+        summary.textContent = `Status ${data.original.Status} → ${data.replay.Status}, ` +
+            `${Math.round(data.replay.Duration / 1000000)}ms, ` +
+            (changed ? `${changed} changed lines` : 'responses are identical');
+
+        data.diff.forEach(line => {
+            const oldLine = document.createElement('div');
+            const newLine = document.createElement('div');
+            oldLine.className = 'diff-line';
+            newLine.className = 'diff-line';
+
+            if (line.op === '-') {
+                oldLine.textContent = line.text;
+                oldLine.classList.add('diff-removed');
+                newLine.classList.add('diff-empty');
+            } else if (line.op === '+') {
+                newLine.textContent = line.text;
+                newLine.classList.add('diff-added');
+                oldLine.classList.add('diff-empty');
+            } else {
+                oldLine.textContent = line.text;
+                newLine.textContent = line.text;
+            }
+            oldPre.appendChild(oldLine);
+            newPre.appendChild(newLine);
+        });
+    } catch (error) {
+        summary.textContent = 'Replay failed: ' + error.message;
+    } finally {
+        replayBtn.disabled = false;
+    }
+}
@@ -0,0 +1,79 @@
+// Live API call log updates over Server-Sent Events
+document.addEventListener('DOMContentLoaded', function() {
+    const table = document.getElementById('apiLogTable');
+    const liveStatus = document.getElementById('liveStatus');
+
+    // Only the first page in newest-first order can grow at the top
+    if (!table || !table.dataset.live || !window.EventSource) {
+        return;
+    }
+
+    const tbody = table.querySelector('tbody');
+    const emptyState = document.getElementById('apiLogEmpty');
+
+    // Stream with the same filters as the page, starting after the newest
+    // call that was rendered. EventSource resumes from Last-Event-ID itself
+    // when it reconnects.
+    const params = new URLSearchParams(window.location.search);
+    params.set('last_event_id', table.dataset.lastId || '0');
+
+    const source = new EventSource('/api/api-calls/stream?' + params.toString());
+
+    source.addEventListener('open', function() {
+        liveStatus.textContent = '● Live';
+        liveStatus.className = 'live-status connected';
+    });
+
+    source.addEventListener('error', function() {
+        liveStatus.textContent = 'Reconnecting...';
+        liveStatus.className = 'live-status';
+    });
+
+    source.addEventListener('api-call', function(event) {
+        const call = JSON.parse(event.data);
+        if (tbody.querySelector(`tr[data-id="${call.ID}"]`)) {
+            return;
+        }
+
+        apiCallsData.unshift(call);
+        tbody.insertBefore(renderCallRow(call), tbody.firstChild);
+        if (emptyState) {
+            emptyState.style.display = 'none';
+        }
+    });
+
+    function renderCallRow(call) {
+        const row = document.createElement('tr');
+        row.className = 'api-call-row new-row';
+        row.dataset.id = call.ID;
+
+        const selectCell = document.createElement('td');
+        selectCell.className = 'select-col';
+        selectCell.innerHTML = '<input type="checkbox" class="call-select">';
+        selectCell.firstChild.value = call.ID;
+        row.appendChild(selectCell);
+
+        const cells = [
+            '',
+            call.Method,
+            call.URL,
+            call.Status,
+            `${Math.round(call.Duration / 1000000)}ms`,
+            call.Error,
+            call.PolicyRule
+        ];
+        cells.forEach(value => {
+            const cell = document.createElement('td');
+            cell.textContent = value;
+            row.appendChild(cell);
+        });
+
+        // Match the server-rendered <time> of recent calls
+        const time = document.createElement('time');
+        time.dateTime = call.Timestamp;
+        time.title = formatDate(call.Timestamp);
+        time.textContent = t('js.just_now');
+        row.children[1].appendChild(time);
+        return row;
+    }
+});
@@ -5,18 +5,61 @@ document.addEventListener('DOMContentLoaded', function() {
     initializeApp();
 });
 
+// csrfToken returns the token that state-changing requests must send in the
+// X-CSRF-Token header. base.html embeds it in a meta tag.
+function csrfToken() {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.content : '';
+}
+
+// messages holds the translations base.html embeds for the page's language.
+let messages = null;
+
+// t returns the translation of a message key from locales/<language>.json,
+// with {0}, {1}, ... replaced by the arguments.
+function t(key, ...args) {
+    if (messages === null) {
+        const data = document.getElementById('messages');
+        messages = data ? JSON.parse(data.textContent) : {};
+    }
+    const message = messages[key] || key;
+    return message.replace(/\{(\d+)\}/g, (match, i) => i < args.length ? args[i] : match);
+}
+
+// pageLanguage is the language the page was rendered in, for Intl formatting.
+function pageLanguage() {
+    return document.documentElement.lang || undefined;
+}
+
+// pageTimezone is the IANA timezone the page shows times in.
+function pageTimezone() {
+    const meta = document.querySelector('meta[name="timezone"]');
+    return meta && meta.content ? meta.content : undefined;
+}
+
+// formatMoney formats an amount in the minor units of its currency (cents,
+// or whole yen for JPY), as Gumroad reports prices.
+function formatMoney(amount, currency) {
+    const format = new Intl.NumberFormat(pageLanguage(), {
+        style: 'currency',
+        currency: (currency || 'usd').toUpperCase()
+    });
+    const digits = format.resolvedOptions().maximumFractionDigits;
+    return format.format(amount / Math.pow(10, digits));
+}
+
 function initializeApp() {
     // Add loading states to buttons
     addLoadingStates();
     
-    // Add search functionality
-    addSearchFunctionality();
-    
     // Add keyboard shortcuts
     setupKeyboardShortcuts();
     
     // Add copy functionality for license keys
     addCopyFunctionality();
+
+    // Apply a new language or timezone as soon as one is picked
+    setupPreferencesMenu();
 }
 
 function addLoadingStates() {
@@ -31,7 +74,7 @@ function addLoadingStates() {
             
             // Add loading state
             const originalText = this.textContent;
-            this.innerHTML = '<span class="loading"></span> Loading...';
+            this.innerHTML = '<span class="loading"></span> ' + t('js.loading');
             this.style.pointerEvents = 'none';
             
             // Remove loading state after navigation or timeout
@@ -43,105 +86,6 @@ function addLoadingStates() {
     });
 }
 
-function addSearchFunctionality() {
-    // Add search box to licenses page
-    if (window.location.pathname.startsWith('/licenses/')) {
-        addLicenseSearch();
-    }
-    
-    // Add search box to API log page
-    if (window.location.pathname === '/api-log') {
-        addAPILogSearch();
-    }
-}
-
-function addLicenseSearch() {
-    const table = document.querySelector('table');
-    if (!table) return;
-    
-    const searchDiv = document.createElement('div');
-    searchDiv.style.marginBottom = '20px';
-    searchDiv.innerHTML = `
-        <input type="text" id="licenseSearch" placeholder="Search licenses..." 
-               style="width: 100%; padding: 10px; border: 1px solid #ddd; border-radius: 4px; font-size: 16px;">
-    `;
-    
-    table.parentNode.insertBefore(searchDiv, table);
-    
-    const searchInput = document.getElementById('licenseSearch');
-    const rows = table.querySelectorAll('tbody tr');
-    
-    searchInput.addEventListener('input', function() {
-        const searchTerm = this.value.toLowerCase();
-        
-        rows.forEach(row => {
-            const rowText = row.textContent.toLowerCase();
-            if (rowText.includes(searchTerm)) {
-                row.style.display = '';
-            } else {
-                row.style.display = 'none';
-            }
-        });
-    });
-}
-
-function addAPILogSearch() {
-    const table = document.querySelector('table');
-    if (!table) return;
-    
-    const searchDiv = document.createElement('div');
-    searchDiv.style.marginBottom = '20px';
-    searchDiv.innerHTML = `
-        <div style="display: flex; gap: 10px; flex-wrap: wrap;">
-            <input type="text" id="apiLogSearch" placeholder="Search API calls..." 
-                   style="flex: 1; min-width: 200px; padding: 10px; border: 1px solid #ddd; border-radius: 4px; font-size: 16px;">
-            <select id="statusFilter" style="padding: 10px; border: 1px solid #ddd; border-radius: 4px;">
-                <option value="">All Status</option>
-                <option value="200">Success (200)</option>
-                <option value="error">Errors</option>
-            </select>
-        </div>
-    `;
-    
-    table.parentNode.insertBefore(searchDiv, table);
-    
-    const searchInput = document.getElementById('apiLogSearch');
-    const statusFilter = document.getElementById('statusFilter');
-    const rows = table.querySelectorAll('tbody tr');
-    
-    function filterRows() {
-        const searchTerm = searchInput.value.toLowerCase();
-        const statusValue = statusFilter.value;
-        
-        rows.forEach(row => {
-            const rowText = row.textContent.toLowerCase();
-            const statusCell = row.cells[3]; // Status column
-            const statusText = statusCell ? statusCell.textContent : '';
-            
-            let showRow = true;
-            
-            // Text search filter
-            if (searchTerm && !rowText.includes(searchTerm)) {
-                showRow = false;
-            }
-            
-            // Status filter
-            if (statusValue) {
-                if (statusValue === 'error' && statusText === '200') {
-                    showRow = false;
-                } else if (statusValue === '200' && statusText !== '200') {
-                    showRow = false;
-                }
-            }
-            
-            row.style.display = showRow ? '' : 'none';
-        });
-    }
-    
-    searchInput.addEventListener('input', filterRows);
-    statusFilter.addEventListener('change', filterRows);
-}
-
 function setupKeyboardShortcuts() {
     document.addEventListener('keydown', function(e) {
         // Escape to go back
@@ -154,7 +98,7 @@ function setupKeyboardShortcuts() {
         
         // Ctrl/Cmd + F to focus search
         if ((e.ctrlKey || e.metaKey) && e.key === 'f') {
-            const searchInput = document.querySelector('#productSearch, #licenseSearch, #apiLogSearch');
+            const searchInput = document.querySelector('#productSearch, .table-filters input[name="q"], .log-filters input[name="url"]');
             if (searchInput) {
                 e.preventDefault();
                 searchInput.focus();
@@ -168,7 +112,7 @@ function addCopyFunctionality() {
     
     licenseKeys.forEach(licenseKey => {
         licenseKey.style.cursor = 'pointer';
-        licenseKey.title = 'Click to copy';
+        licenseKey.title = t('js.click_to_copy');
         
         licenseKey.addEventListener('click', function() {
             const text = this.textContent;
@@ -191,6 +135,24 @@ function addCopyFunctionality() {
     });
 }
 
+function setupPreferencesMenu() {
+    const form = document.querySelector('.nav-preferences');
+    if (!form) {
+        return;
+    }
+    form.querySelector('button').classList.add('hidden');
+    form.querySelectorAll('select').forEach(select => {
+        select.addEventListener('change', () => form.submit());
+    });
+
+    // Offer the browser's own timezone when it is not listed
+    const zones = form.querySelector('select[name="timezone"]');
+    const browserZone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    if (zones && browserZone && !Array.from(zones.options).some(option => option.value === browserZone)) {
+        zones.add(new Option(browserZone, browserZone));
+    }
+}
+
 function showCopySuccess(element) {
     const originalBg = element.style.backgroundColor;
     element.style.backgroundColor = '#d4edda';
@@ -202,10 +164,22 @@ function showCopySuccess(element) {
     }, 1000);
 }
 
+// formatCount groups the digits of a count as the page's language does.
+function formatCount(n) {
+    return new Intl.NumberFormat(pageLanguage()).format(n);
+}
+
 // Utility function to format dates
 function formatDate(dateString) {
     const date = new Date(dateString);
-    return date.toLocaleDateString() + ' ' + date.toLocaleTimeString();
+    if (isNaN(date)) {
+        return dateString;
+    }
+    return date.toLocaleString(pageLanguage(), {
+        timeZone: pageTimezone(),
+        dateStyle: 'medium',
+        timeStyle: 'short'
+    });
 }
 
 // Add some visual enhancements
@@ -0,0 +1,36 @@
+// Audit log page functionality
+document.addEventListener('DOMContentLoaded', function() {
+    const button = document.getElementById('verifyAuditChain');
+    const resultDiv = document.getElementById('auditVerifyResult');
+    if (!button || !resultDiv) {
+        return;
+    }
+
+    button.addEventListener('click', function() {
+        button.disabled = true;
+        resultDiv.style.display = 'none';
+
+        fetch('/audit/verify', {
+            method: 'POST',
+            headers: {
+                'X-CSRF-Token': csrfToken(),
+            }
+        })
+        .then(response => response.json())
+        .then(data => {
+            button.disabled = false;
+            resultDiv.style.display = 'block';
+            if (data.valid) {
+                resultDiv.className = 'validation-result success';
+                resultDiv.textContent = `Chain intact: all ${data.checked} events verified.`;
+            } else {
+                resultDiv.className = 'validation-result error';
+                resultDiv.textContent = `Chain broken after ${data.checked} good events: ${data.error || 'unknown error'}`;
+            }
+        })
+        .catch(error => {
+            console.error('Error:', error);
+            button.disabled = false;
+        });
+    });
+});
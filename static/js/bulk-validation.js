@@ -0,0 +1,83 @@
+// Bulk license validation functionality
+document.addEventListener('DOMContentLoaded', function() {
+    const form = document.getElementById('bulkValidateForm');
+    const progressDiv = document.getElementById('bulkProgress');
+
+    if (!form || !progressDiv) {
+        return; // Elements not found, probably not on the licenses page
+    }
+
+    const progressFill = document.getElementById('bulkProgressFill');
+    const progressText = document.getElementById('bulkProgressText');
+    const countsText = document.getElementById('bulkCounts');
+    const reportLink = document.getElementById('bulkReportLink');
+
+    form.addEventListener('submit', function(e) {
+        e.preventDefault();
+
+        const formData = new FormData();
+        formData.append('product_id', form.dataset.productId || '');
+        formData.append('keys', document.getElementById('bulkKeys').value);
+
+        const file = document.getElementById('bulkFile').files[0];
+        if (file) {
+            formData.append('file', file);
+        }
+
+        const submitBtn = form.querySelector('button[type="submit"]');
+        submitBtn.disabled = true;
+
+        progressDiv.style.display = 'block';
+        progressText.textContent = t('js.uploading');
+        countsText.textContent = '';
+        reportLink.style.display = 'none';
+        progressFill.style.width = '0%';
+
+        fetch('/bulk-validate', {
+            method: 'POST',
+            headers: {
+                'X-CSRF-Token': csrfToken(),
+            },
+            body: formData
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (!data.success) {
+                progressText.textContent = data.error || t('js.bulk_start_failed');
+                submitBtn.disabled = false;
+                return;
+            }
+            reportLink.href = `/bulk-validate/${data.job.id}/report.csv`;
+            pollBulkStatus(data.job.id);
+        })
+        .catch(error => {
+            console.error('Error:', error);
+            progressText.textContent = t('js.bulk_start_failed_retry');
+            submitBtn.disabled = false;
+        });
+
+        function pollBulkStatus(jobId) {
+            fetch(`/api/bulk-validate/${jobId}`)
+            .then(response => response.json())
+            .then(status => {
+                const percent = status.total ? Math.round(status.processed / status.total * 100) : 100;
+                progressFill.style.width = `${percent}%`;
+                progressText.textContent = t('js.bulk_progress', formatCount(status.processed), formatCount(status.total));
+                countsText.textContent = Object.entries(status.counts)
+                    .map(([name, count]) => `${name}: ${count}`)
+                    .join(' · ');
+
+                if (status.done) {
+                    reportLink.style.display = 'inline-block';
+                    submitBtn.disabled = false;
+                } else {
+                    setTimeout(() => pollBulkStatus(jobId), 1000);
+                }
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                setTimeout(() => pollBulkStatus(jobId), 3000);
+            });
+        }
+    });
+});
@@ -16,18 +16,19 @@ document.addEventListener('DOMContentLoaded', function() {
         }
         
         // Show loading state
-        resultDiv.innerHTML = '<div class="loading-spinner">Validating...</div>';
+        resultDiv.innerHTML = `<div class="loading-spinner">${t('js.validating')}</div>`;
         resultDiv.style.display = 'block';
         resultDiv.className = 'validation-result';
         
-        // Get product ID from the page data
-        const productId = window.pageData ? window.pageData.productID : '';
+        // Get product ID from the form's data attribute
+        const productId = form.dataset.productId || '';
         
         // Make validation request
         fetch('/validate-license', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken(),
             },
             body: JSON.stringify({
                 product_id: productId,
@@ -39,27 +40,51 @@ document.addEventListener('DOMContentLoaded', function() {
             if (data.success) {
                 resultDiv.className = 'validation-result success';
                 resultDiv.innerHTML = `
-                    <h4>✓ Valid License</h4>
+                    <h4>${t('js.valid_license')}</h4>
+                    ${staleNotice(data)}
                     <div class="license-details">
-                        <p><strong>Uses:</strong> ${data.uses}</p>
-                        <p><strong>Purchaser:</strong> ${data.purchase.email}</p>
-                        <p><strong>Product:</strong> ${data.purchase.product_name}</p>
-                        <p><strong>Sale Date:</strong> ${data.purchase.sale_timestamp}</p>
-                        <p><strong>Price:</strong> $${(data.purchase.price / 100).toFixed(2)}</p>
-                        ${data.purchase.refunded ? '<p class="status-warning"><strong>Status:</strong> Refunded</p>' : ''}
-                        ${data.purchase.disputed ? '<p class="status-warning"><strong>Status:</strong> Disputed</p>' : ''}
-                        ${data.purchase.chargebacked ? '<p class="status-error"><strong>Status:</strong> Chargebacked</p>' : ''}
+                        <p><strong>${t('js.uses')}</strong> ${data.uses}</p>
+                        <p><strong>${t('js.purchaser')}</strong> ${data.purchase.email}</p>
+                        <p><strong>${t('js.product')}</strong> ${data.purchase.product_name}</p>
+                        <p><strong>${t('js.sale_date')}</strong> ${formatDate(data.purchase.sale_timestamp)}</p>
+                        <p><strong>${t('js.price')}</strong> ${formatMoney(data.purchase.price, data.purchase.currency)}</p>
+                        ${data.policy && data.policy.rule ? `<p class="status-warning"><strong>${t('js.policy')}</strong> ${data.policy.detail}</p>` : ''}
+                        ${data.purchase.refunded ? `<p class="status-warning"><strong>${t('js.status')}</strong> ${t('js.refunded')}</p>` : ''}
+                        ${data.purchase.disputed ? `<p class="status-warning"><strong>${t('js.status')}</strong> ${t('js.disputed')}</p>` : ''}
+                        ${data.purchase.chargebacked ? `<p class="status-error"><strong>${t('js.status')}</strong> ${t('js.chargebacked')}</p>` : ''}
+                    </div>
+                `;
+            } else if (data.policy && !data.policy.allowed) {
+                resultDiv.className = 'validation-result error';
+                resultDiv.innerHTML = `
+                    <h4>${t('js.rejected_by_policy')}</h4>
+                    ${staleNotice(data)}
+                    <p>${data.message}</p>
+                    <div class="license-details">
+                        <p><strong>${t('js.rule')}</strong> ${data.policy.rule}</p>
+                        <p><strong>${t('js.uses')}</strong> ${data.uses || 0}</p>
+                        <p><strong>${t('js.purchaser')}</strong> ${data.purchase.email}</p>
+                        <p><strong>${t('js.sale_date')}</strong> ${formatDate(data.purchase.sale_timestamp)}</p>
                     </div>
                 `;
             } else {
                 resultDiv.className = 'validation-result error';
-                resultDiv.innerHTML = `<h4>✗ Invalid License</h4><p>${data.message || 'License key is not valid'}</p>`;
+                resultDiv.innerHTML = `<h4>${t('js.invalid_license')}</h4><p>${data.message || t('js.license_not_valid')}</p>`;
             }
         })
         .catch(error => {
             console.error('Error:', error);
             resultDiv.className = 'validation-result error';
-            resultDiv.innerHTML = '<h4>✗ Validation Error</h4><p>Failed to validate license key. Please try again.</p>';
+            resultDiv.innerHTML = `<h4>${t('js.validation_error')}</h4><p>${t('js.validation_failed')}</p>`;
         });
     });
 });
+
+// Warn when Gumroad was unreachable and a cached result was shown instead
+function staleNotice(data) {
+    if (!data.stale) {
+        return '';
+    }
+    const cachedAt = data.cached_at ? formatDate(data.cached_at) : t('js.earlier_check');
+    return `<p class="status-warning"><strong>${t('js.gumroad_unreachable')}</strong> ${t('js.stale_result', cachedAt)}</p>`;
+}
@@ -0,0 +1,126 @@
+// Offer codes page functionality
+document.addEventListener('DOMContentLoaded', function() {
+    // post sends a JSON request and reloads the page when it succeeds
+    function post(url, body, button, failure) {
+        button.disabled = true;
+        return fetch(url, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken(),
+            },
+            body: JSON.stringify(body)
+        })
+        .then(response => response.json())
+        .then(data => {
+            button.disabled = false;
+            if (!data.success) {
+                alert(data.error || failure);
+            }
+            return data;
+        })
+        .catch(error => {
+            console.error('Error:', error);
+            button.disabled = false;
+            alert(t('js.network_error', error.message));
+            return {};
+        });
+    }
+
+    const createForm = document.getElementById('createOfferCodeForm');
+    if (createForm) {
+        createForm.addEventListener('submit', function(e) {
+            e.preventDefault();
+            const form = new FormData(createForm);
+            post(`/offer-codes/${createForm.dataset.productIndex}`, {
+                name: form.get('name'),
+                amount_off: parseInt(form.get('amount_off'), 10),
+                offer_type: form.get('offer_type'),
+                max_purchase_count: parseInt(form.get('max_purchase_count') || '0', 10),
+                universal: form.get('universal') === 'on'
+            }, createForm.querySelector('button[type="submit"]'), t('js.offer_code_create_failed'))
+            .then(data => {
+                if (data.success) {
+                    window.location.reload();
+                }
+            });
+        });
+    }
+
+    const generateForm = document.getElementById('generateOfferCodesForm');
+    if (generateForm) {
+        generateForm.addEventListener('submit', function(e) {
+            e.preventDefault();
+            const form = new FormData(generateForm);
+            const index = generateForm.dataset.productIndex;
+            const prefix = form.get('prefix');
+            const count = parseInt(form.get('count'), 10);
+            const button = generateForm.querySelector('button[type="submit"]');
+            button.textContent = t('js.generating');
+            post(`/offer-codes/${index}/generate`, {
+                prefix: prefix,
+                count: count,
+                amount_off: parseInt(form.get('amount_off'), 10),
+                offer_type: form.get('offer_type')
+            }, button, t('js.offer_code_generate_failed'))
+            .then(data => {
+                button.textContent = t('js.generate');
+                const created = (data.offer_codes || []).length;
+                if (created === 0) {
+                    return;
+                }
+                // Codes created before a failure are offered for download too
+                document.getElementById('generatedOfferCodesText').textContent = t('js.offer_codes_generated', formatCount(created), formatCount(count));
+                document.getElementById('generatedOfferCodesLink').href = `/offer-codes/${index}/export.csv?prefix=${encodeURIComponent(prefix.toUpperCase() + '-')}`;
+                document.getElementById('generatedOfferCodes').classList.remove('hidden');
+            });
+        });
+    }
+
+    document.querySelectorAll('.edit-offer-code-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            const limit = prompt(t('js.offer_code_limit_prompt', this.dataset.name), this.dataset.limit);
+            if (limit === null) {
+                return;
+            }
+            post(`/offer-codes/${this.dataset.productIndex}/${encodeURIComponent(this.dataset.id)}`, {
+                max_purchase_count: parseInt(limit || '0', 10)
+            }, this, t('js.offer_code_update_failed'))
+            .then(data => {
+                if (data.success) {
+                    window.location.reload();
+                }
+            });
+        });
+    });
+
+    document.querySelectorAll('.delete-offer-code-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            if (!confirm(t('js.offer_code_delete_confirm', this.dataset.name))) {
+                return;
+            }
+            this.disabled = true;
+            fetch(`/offer-codes/${this.dataset.productIndex}/${encodeURIComponent(this.dataset.id)}/delete`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/x-www-form-urlencoded',
+                    'X-CSRF-Token': csrfToken(),
+                },
+                body: new URLSearchParams({name: this.dataset.name})
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (data.success) {
+                    window.location.reload();
+                } else {
+                    alert(data.error || t('js.offer_code_delete_failed'));
+                    this.disabled = false;
+                }
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                this.disabled = false;
+            });
+        });
+    });
+});
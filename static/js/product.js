@@ -0,0 +1,147 @@
+// Product page functionality
+document.addEventListener('DOMContentLoaded', function() {
+    // post sends a request and reloads the page when it succeeds. Bodies
+    // that are URLSearchParams go form-encoded, anything else as JSON.
+    function post(url, body, button) {
+        const form = body instanceof URLSearchParams;
+        button.disabled = true;
+        fetch(url, {
+            method: 'POST',
+            headers: {
+                'Content-Type': form ? 'application/x-www-form-urlencoded' : 'application/json',
+                'X-CSRF-Token': csrfToken(),
+            },
+            body: form ? body : JSON.stringify(body)
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (data.success) {
+                window.location.reload();
+                return;
+            }
+            button.disabled = false;
+            alert(data.error || t('js.product_action_failed'));
+        })
+        .catch(error => {
+            console.error('Error:', error);
+            button.disabled = false;
+            alert(t('js.network_error', error.message));
+        });
+    }
+
+    function productURL(index, ...path) {
+        return `/products/${index}` + path.map(segment => '/' + encodeURIComponent(segment)).join('');
+    }
+
+    document.querySelectorAll('.product-publish-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            if (this.dataset.action === 'disable' && !confirm(t('js.product_disable_confirm', this.dataset.name))) {
+                return;
+            }
+            post(productURL(this.dataset.productIndex, this.dataset.action), {}, this);
+        });
+    });
+
+    const categoryForm = document.getElementById('addCategoryForm');
+    if (categoryForm) {
+        categoryForm.addEventListener('submit', function(e) {
+            e.preventDefault();
+            post(productURL(categoryForm.dataset.productIndex, 'variant-categories'), {
+                title: new FormData(categoryForm).get('title')
+            }, categoryForm.querySelector('button[type="submit"]'));
+        });
+    }
+
+    document.querySelectorAll('.rename-category-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            const title = prompt(t('js.variant_category_rename_prompt', this.dataset.title), this.dataset.title);
+            if (!title) {
+                return;
+            }
+            post(productURL(this.dataset.productIndex, 'variant-categories', this.dataset.id), {title: title}, this);
+        });
+    });
+
+    document.querySelectorAll('.delete-category-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            if (!confirm(t('js.variant_category_delete_confirm', this.dataset.title))) {
+                return;
+            }
+            post(productURL(this.dataset.productIndex, 'variant-categories', this.dataset.id, 'delete'),
+                new URLSearchParams({title: this.dataset.title}), this);
+        });
+    });
+
+    document.querySelectorAll('.add-variant-form').forEach(variantForm => {
+        variantForm.addEventListener('submit', function(e) {
+            e.preventDefault();
+            const form = new FormData(variantForm);
+            post(productURL(variantForm.dataset.productIndex, 'variant-categories', variantForm.dataset.category, 'variants'), {
+                name: form.get('name'),
+                price_difference_cents: parseInt(form.get('price_difference_cents') || '0', 10),
+                max_purchase_count: parseInt(form.get('max_purchase_count') || '0', 10)
+            }, variantForm.querySelector('button[type="submit"]'));
+        });
+    });
+
+    document.querySelectorAll('.edit-variant-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            const name = prompt(t('js.variant_name_prompt'), this.dataset.name);
+            if (!name) {
+                return;
+            }
+            const price = prompt(t('js.variant_price_prompt'), this.dataset.price);
+            if (price === null) {
+                return;
+            }
+            const limit = prompt(t('js.variant_limit_prompt'), this.dataset.limit);
+            if (limit === null) {
+                return;
+            }
+            post(productURL(this.dataset.productIndex, 'variant-categories', this.dataset.category, 'variants', this.dataset.id), {
+                name: name,
+                price_difference_cents: parseInt(price || '0', 10),
+                max_purchase_count: parseInt(limit || '0', 10)
+            }, this);
+        });
+    });
+
+    document.querySelectorAll('.delete-variant-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            if (!confirm(t('js.variant_delete_confirm', this.dataset.name))) {
+                return;
+            }
+            post(productURL(this.dataset.productIndex, 'variant-categories', this.dataset.category, 'variants', this.dataset.id, 'delete'),
+                new URLSearchParams({name: this.dataset.name}), this);
+        });
+    });
+
+    const fieldForm = document.getElementById('addCustomFieldForm');
+    if (fieldForm) {
+        fieldForm.addEventListener('submit', function(e) {
+            e.preventDefault();
+            const form = new FormData(fieldForm);
+            post(productURL(fieldForm.dataset.productIndex, 'custom-fields'), {
+                name: form.get('name'),
+                required: form.get('required') === 'on'
+            }, fieldForm.querySelector('button[type="submit"]'));
+        });
+    }
+
+    document.querySelectorAll('.toggle-field-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            post(productURL(this.dataset.productIndex, 'custom-fields', this.dataset.name), {
+                required: this.dataset.required === 'true'
+            }, this);
+        });
+    });
+
+    document.querySelectorAll('.delete-field-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            if (!confirm(t('js.custom_field_delete_confirm', this.dataset.name))) {
+                return;
+            }
+            post(productURL(this.dataset.productIndex, 'custom-fields', this.dataset.name, 'delete'), new URLSearchParams(), this);
+        });
+    });
+});
@@ -0,0 +1,103 @@
+// Sales page row actions: refunds, receipts and shipping
+document.addEventListener('DOMContentLoaded', function() {
+    // askReason prompts for the reason recorded in the audit trail, which
+    // every sale action requires.
+    function askReason() {
+        const reason = prompt(t('js.action_reason_prompt'));
+        if (reason === null) {
+            return null;
+        }
+        if (!reason.trim()) {
+            alert(t('js.reason_required'));
+            return null;
+        }
+        return reason.trim();
+    }
+
+    function collect(button) {
+        const order = button.dataset.order;
+        switch (button.dataset.action) {
+        case 'refund': {
+            const amount = prompt(t('js.refund_amount_prompt', button.dataset.price), '');
+            if (amount === null) {
+                return null;
+            }
+            const reason = askReason();
+            if (reason === null) {
+                return null;
+            }
+            const cents = parseInt(amount || '0', 10);
+            if (!confirm(t('js.refund_confirm', order))) {
+                return null;
+            }
+            return {amount_cents: cents, reason: reason};
+        }
+        case 'mark-shipped': {
+            const trackingURL = prompt(t('js.tracking_url_prompt'), '');
+            if (trackingURL === null) {
+                return null;
+            }
+            const reason = askReason();
+            if (reason === null || !confirm(t('js.mark_shipped_confirm', order))) {
+                return null;
+            }
+            return {tracking_url: trackingURL, reason: reason};
+        }
+        default: {
+            const reason = askReason();
+            if (reason === null || !confirm(t('js.resend_receipt_confirm', order))) {
+                return null;
+            }
+            return {reason: reason};
+        }
+        }
+    }
+
+    document.addEventListener('click', function(e) {
+        const button = e.target.closest('.sale-action-btn');
+        if (!button) {
+            return;
+        }
+        const body = collect(button);
+        if (body === null) {
+            return;
+        }
+        const action = button.dataset.action;
+        const row = button.closest('tr');
+        button.disabled = true;
+        fetch(`/sales/${button.dataset.productIndex}/${encodeURIComponent(button.dataset.id)}/${action}`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken(),
+            },
+            body: JSON.stringify(body)
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (!data.success) {
+                button.disabled = false;
+                alert(data.error || t('js.sale_action_failed'));
+                return;
+            }
+            if (!data.row) {
+                window.location.reload();
+                return;
+            }
+            const template = document.createElement('template');
+            template.innerHTML = data.row;
+            const updated = template.content.firstElementChild;
+            row.replaceWith(updated);
+            if (action === 'resend-receipt') {
+                const resent = updated.querySelector('.sale-action-btn[data-action="resend-receipt"]');
+                resent.textContent = t('js.receipt_resent');
+                resent.disabled = true;
+            }
+        })
+        .catch(error => {
+            console.error('Error:', error);
+            button.disabled = false;
+            alert(t('js.network_error', error.message));
+        });
+    });
+});
@@ -0,0 +1,83 @@
+// Setup page functionality
+document.addEventListener('DOMContentLoaded', function() {
+    const tokenForm = document.getElementById('tokenForm');
+    const tokenInput = document.getElementById('token');
+    const toggleBtn = document.getElementById('toggleToken');
+    const errorMsg = document.getElementById('error-message');
+    const successMsg = document.getElementById('success-message');
+
+    // Toggle password visibility
+    toggleBtn.addEventListener('click', function() {
+        if (tokenInput.type === 'password') {
+            tokenInput.type = 'text';
+            toggleBtn.textContent = t('js.hide');
+        } else {
+            tokenInput.type = 'password';
+            toggleBtn.textContent = t('js.show');
+        }
+    });
+
+    // Handle form submission
+    tokenForm.addEventListener('submit', async function(e) {
+        e.preventDefault();
+        
+        const token = tokenInput.value.trim();
+        if (!token) {
+            showError(t('js.token_required'));
+            return;
+        }
+
+        const submitBtn = e.target.querySelector('button[type="submit"]');
+        const originalText = submitBtn.textContent;
+        
+        // Show loading state
+        submitBtn.disabled = true;
+        submitBtn.innerHTML = '<span class="loading"></span> ' + t('js.saving');
+        
+        hideMessages();
+
+        try {
+            const response = await fetch('/setup/submit', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'X-CSRF-Token': csrfToken(),
+                },
+                body: JSON.stringify({ token: token })
+            });
+
+            const result = await response.json();
+
+            if (response.ok && result.success) {
+                showSuccess(t('js.token_saved'));
+                setTimeout(() => {
+                    window.location.href = '/';
+                }, 2000);
+            } else {
+                showError(result.error || t('js.token_save_failed'));
+            }
+        } catch (error) {
+            showError(t('js.network_error', error.message));
+        } finally {
+            submitBtn.disabled = false;
+            submitBtn.textContent = originalText;
+        }
+    });
+
+    function showError(message) {
+        errorMsg.textContent = message;
+        errorMsg.style.display = 'block';
+        successMsg.style.display = 'none';
+    }
+
+    function showSuccess(message) {
+        successMsg.textContent = message;
+        successMsg.style.display = 'block';
+        errorMsg.style.display = 'none';
+    }
+
+    function hideMessages() {
+        errorMsg.style.display = 'none';
+        successMsg.style.display = 'none';
+    }
+});
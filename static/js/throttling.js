@@ -0,0 +1,34 @@
+// Throttled clients page functionality
+document.addEventListener('DOMContentLoaded', function() {
+    document.querySelectorAll('.unban-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            const ip = this.dataset.ip;
+            if (!confirm(`Lift the ban on ${ip}?`)) {
+                return;
+            }
+
+            this.disabled = true;
+            fetch('/throttling/unban', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'X-CSRF-Token': csrfToken(),
+                },
+                body: JSON.stringify({ ip: ip })
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (data.success) {
+                    window.location.reload();
+                } else {
+                    alert(data.error || 'Failed to lift ban');
+                    this.disabled = false;
+                }
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                this.disabled = false;
+            });
+        });
+    });
+});
@@ -0,0 +1,112 @@
+// Users page functionality
+document.addEventListener('DOMContentLoaded', function() {
+    function postUser(url, payload) {
+        return fetch(url, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken(),
+            },
+            body: payload ? JSON.stringify(payload) : undefined
+        })
+        .then(response => response.json());
+    }
+
+    const form = document.getElementById('createUserForm');
+    if (form) {
+        form.addEventListener('submit', function(e) {
+            e.preventDefault();
+
+            const button = form.querySelector('button[type="submit"]');
+            button.disabled = true;
+
+            postUser('/users', {
+                username: document.getElementById('userUsername').value,
+                password: document.getElementById('userPassword').value,
+                role: document.getElementById('userRole').value
+            })
+            .then(data => {
+                if (data.success) {
+                    window.location.reload();
+                } else {
+                    alert(data.error || 'Failed to create user');
+                    button.disabled = false;
+                }
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                button.disabled = false;
+            });
+        });
+    }
+
+    document.querySelectorAll('.user-role-select').forEach(select => {
+        select.addEventListener('change', function() {
+            const previous = this.dataset.role;
+            this.disabled = true;
+
+            postUser(`/users/${encodeURIComponent(this.dataset.id)}`, { role: this.value })
+            .then(data => {
+                this.disabled = false;
+                if (data.success) {
+                    this.dataset.role = this.value;
+                } else {
+                    alert(data.error || 'Failed to change role');
+                    this.value = previous;
+                }
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                this.disabled = false;
+                this.value = previous;
+            });
+        });
+    });
+
+    document.querySelectorAll('.reset-password-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            const password = prompt(`New password for ${this.dataset.name} (8+ characters). This logs them out everywhere.`);
+            if (!password) {
+                return;
+            }
+
+            this.disabled = true;
+            postUser(`/users/${encodeURIComponent(this.dataset.id)}`, { password: password })
+            .then(data => {
+                this.disabled = false;
+                if (data.success) {
+                    alert(`Password for ${this.dataset.name} changed`);
+                } else {
+                    alert(data.error || 'Failed to change password');
+                }
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                this.disabled = false;
+            });
+        });
+    });
+
+    document.querySelectorAll('.delete-user-btn').forEach(button => {
+        button.addEventListener('click', function() {
+            if (!confirm(`Delete the user "${this.dataset.name}"? They will be logged out.`)) {
+                return;
+            }
+
+            this.disabled = true;
+            postUser(`/users/${encodeURIComponent(this.dataset.id)}/delete`)
+            .then(data => {
+                if (data.success) {
+                    window.location.reload();
+                } else {
+                    alert(data.error || 'Failed to delete user');
+                    this.disabled = false;
+                }
+            })
+            .catch(error => {
+                console.error('Error:', error);
+                this.disabled = false;
+            });
+        });
+    });
+});
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Subscriber states shown on the subscribers page. Gumroad's statuses are
+// finer grained; State maps them onto these.
+const (
+	subscriberActive    = "active"
+	subscriberPastDue   = "past_due"
+	subscriberCancelled = "cancelled"
+	subscriberEnded     = "ended"
+)
+
+var subscriberStates = []string{subscriberActive, subscriberPastDue, subscriberCancelled, subscriberEnded}
+
+// churnWindow is the period the churn rate is measured over.
+const churnWindow = 30 * 24 * time.Hour
+
+// recurrenceMonths is the length of Gumroad's billing periods in months.
+var recurrenceMonths = map[string]int{
+	"monthly":         1,
+	"quarterly":       3,
+	"biannually":      6,
+	"yearly":          12,
+	"every_two_years": 24,
+}
+
+// Subscriber is a membership of a recurring product, as returned by
+// /v2/products/:id/subscribers and /v2/subscribers/:id.
+type Subscriber struct {
+	ID          string `json:"id"`
+	ProductID   string `json:"product_id"`
+	ProductName string `json:"product_name"`
+	UserID      string `json:"user_id"`
+	UserEmail   string `json:"user_email"`
+	// PurchaseIDs are the sales charged to the subscription
+	PurchaseIDs []string  `json:"purchase_ids"`
+	CreatedAt   Timestamp `json:"created_at"`
+	// The dates below are zero until the subscription gets there
+	CancelledAt     Timestamp `json:"cancelled_at"`
+	EndedAt         Timestamp `json:"ended_at"`
+	FailedAt        Timestamp `json:"failed_at"`
+	FreeTrialEndsAt Timestamp `json:"free_trial_ends_at"`
+	// ChargeOccurrenceCount limits the number of charges of a fixed-length
+	// membership; it is nil for ones that renew until cancelled
+	ChargeOccurrenceCount *int   `json:"charge_occurrence_count"`
+	Recurrence            string `json:"recurrence"`
+	Status                string `json:"status"`
+}
+
+type SubscribersResponse struct {
+	Success     bool         `json:"success"`
+	Subscribers []Subscriber `json:"subscribers"`
+}
+
+type SubscriberResponse struct {
+	Success    bool       `json:"success"`
+	Subscriber Subscriber `json:"subscriber"`
+}
+
+// State is the subscriber's status as one of subscriberStates. Unknown
+// statuses are judged by which dates are set.
+func (s Subscriber) State() string {
+	switch s.Status {
+	case "alive":
+		return subscriberActive
+	case "pending_failure":
+		return subscriberPastDue
+	case "pending_cancellation", "cancelled":
+		return subscriberCancelled
+	case "failed_payment", "fixed_subscription_period_ended", "ended":
+		return subscriberEnded
+	}
+	switch {
+	case !s.EndedAt.IsZero():
+		return subscriberEnded
+	case !s.CancelledAt.IsZero():
+		return subscriberCancelled
+	case !s.FailedAt.IsZero():
+		return subscriberPastDue
+	}
+	return subscriberActive
+}
+
+// ChurnedAt is when the subscriber stopped paying: the cancellation or end
+// of the subscription, or the zero time while it is active.
+func (s Subscriber) ChurnedAt() time.Time {
+	switch s.State() {
+	case subscriberCancelled:
+		if !s.CancelledAt.IsZero() {
+			return s.CancelledAt.Time
+		}
+		return s.EndedAt.Time
+	case subscriberEnded:
+		for _, t := range []Timestamp{s.EndedAt, s.CancelledAt, s.FailedAt} {
+			if !t.IsZero() {
+				return t.Time
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// InFreeTrial reports whether the subscriber has not been charged yet.
+func (s Subscriber) InFreeTrial(now time.Time) bool {
+	return s.FreeTrialEndsAt.After(now)
+}
+
+// chargedTo reports whether a sale was a charge of the subscription.
+func (s Subscriber) chargedTo(sale Sale) bool {
+	if sale.SubscriptionID != "" {
+		return sale.SubscriptionID == s.ID
+	}
+	for _, id := range s.PurchaseIDs {
+		if id == sale.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) getSubscribers(productID string) ([]Subscriber, error) {
+	body, err := app.makeGumroadRequest(app.gumroadURL("/products/" + url.PathEscape(productID) + "/subscribers"))
+	if err != nil {
+		return nil, err
+	}
+
+	var response SubscribersResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.Success {
+		return nil, fmt.Errorf("API request was not successful")
+	}
+
+	return response.Subscribers, nil
+}
+
+func (app *App) getSubscriber(id string) (Subscriber, error) {
+	body, err := app.makeGumroadRequest(app.gumroadURL("/subscribers/" + url.PathEscape(id)))
+	if err != nil {
+		return Subscriber{}, err
+	}
+
+	var response SubscriberResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return Subscriber{}, err
+	}
+
+	if !response.Success {
+		return Subscriber{}, fmt.Errorf("API request was not successful")
+	}
+
+	return response.Subscriber, nil
+}
+
+// subscriberCharges returns the sales charged to a subscriber, newest first.
+func subscriberCharges(subscriber Subscriber, sales []Sale) []Sale {
+	var charges []Sale
+	for _, sale := range sales {
+		if subscriber.chargedTo(sale) {
+			charges = append(charges, sale)
+		}
+	}
+	sort.SliceStable(charges, func(i, j int) bool { return charges[i].SoldAt().After(charges[j].SoldAt()) })
+	return charges
+}
+
+// subscriberSummary holds the figures shown above the subscribers table.
+type subscriberSummary struct {
+	// Counts are the number of subscribers in each of subscriberStates
+	Counts map[string]int `json:"counts"`
+	Total  int            `json:"total"`
+	// MRR is the monthly recurring revenue of active subscribers per
+	// currency: each one's latest charge spread over its billing period
+	MRR []Money `json:"mrr"`
+	// ReportingMRR is the sum of MRR in the reporting currency, leaving out
+	// the currencies in Unconverted, which have no exchange rate
+	ReportingMRR *Money   `json:"reporting_mrr,omitempty"`
+	Unconverted  []string `json:"unconverted,omitempty"`
+	// Churned subscribers cancelled or ended within churnWindow, out of
+	// ActiveAtStart paying ones when the window began
+	Churned       int     `json:"churned"`
+	ActiveAtStart int     `json:"active_at_start"`
+	ChurnRate     float64 `json:"churn_rate"`
+}
+
+// ChurnPercent is the churn rate rounded for display, e.g. "4.2".
+func (s subscriberSummary) ChurnPercent() string {
+	return strconv.FormatFloat(s.ChurnRate*100, 'f', 1, 64)
+}
+
+// monthlyAmount spreads a charge over the months of a billing period.
+// Unknown periods are taken to be monthly.
+func monthlyAmount(charge Money, recurrence string) Money {
+	months, ok := recurrenceMonths[recurrence]
+	if !ok {
+		months = 1
+	}
+	return Money{Amount: int64(math.Round(float64(charge.Amount) / float64(months))), Currency: charge.Currency}
+}
+
+// summarizeSubscribers counts subscribers by state and works out MRR and
+// churn as of now. sales are the product's sales, which hold the charges;
+// subscribers without one are counted at the product's price.
+func (app *App) summarizeSubscribers(subscribers []Subscriber, sales []Sale, product Product, now time.Time) subscriberSummary {
+	summary := subscriberSummary{Counts: make(map[string]int, len(subscriberStates)), Total: len(subscribers)}
+	for _, state := range subscriberStates {
+		summary.Counts[state] = 0
+	}
+
+	mrr := make(map[string]int64)
+	windowStart := now.Add(-churnWindow)
+	for _, subscriber := range subscribers {
+		state := subscriber.State()
+		summary.Counts[state]++
+
+		churnedAt := subscriber.ChurnedAt()
+		churned := !churnedAt.IsZero() && !churnedAt.After(now)
+		if subscriber.CreatedAt.Before(windowStart) && (!churned || !churnedAt.Before(windowStart)) {
+			summary.ActiveAtStart++
+			if churned {
+				summary.Churned++
+			}
+		}
+
+		if state != subscriberActive || subscriber.InFreeTrial(now) {
+			continue
+		}
+		price := product.PriceMoney()
+		for _, charge := range subscriberCharges(subscriber, sales) {
+			if !charge.Refunded && !charge.Chargebacked {
+				price = charge.PriceMoney()
+				break
+			}
+		}
+		monthly := monthlyAmount(price, withDefaultString(subscriber.Recurrence, product.SubscriptionDuration))
+		mrr[monthly.Currency] += monthly.Amount
+	}
+	if summary.ActiveAtStart > 0 {
+		summary.ChurnRate = float64(summary.Churned) / float64(summary.ActiveAtStart)
+	}
+
+	for currency, amount := range mrr {
+		summary.MRR = append(summary.MRR, Money{Amount: amount, Currency: currency})
+	}
+	sort.Slice(summary.MRR, func(i, j int) bool { return summary.MRR[i].Currency < summary.MRR[j].Currency })
+
+	reporting := strings.ToUpper(app.config.ReportingCurrency)
+	if reporting == "" || len(summary.MRR) == 0 {
+		return summary
+	}
+	rates := app.exchangeRates()
+	total := Money{Currency: reporting}
+	for _, amount := range summary.MRR {
+		converted, ok := amount.convert(reporting, rates)
+		if !ok {
+			summary.Unconverted = append(summary.Unconverted, amount.Currency)
+			continue
+		}
+		total.Amount += converted.Amount
+	}
+	summary.ReportingMRR = &total
+	return summary
+}
+
+var subscriberSortColumns = []string{"date", "email", "status"}
+
+var subscriberSorts = map[string]func(a, b Subscriber) bool{
+	"date":   func(a, b Subscriber) bool { return a.CreatedAt.Before(b.CreatedAt.Time) },
+	"email":  func(a, b Subscriber) bool { return strings.ToLower(a.UserEmail) < strings.ToLower(b.UserEmail) },
+	"status": func(a, b Subscriber) bool { return a.State() < b.State() },
+}
+
+// querySubscribers filters, sorts and paginates subscribers. The status
+// filter takes the subscriberStates.
+func querySubscribers(subscribers []Subscriber, q tableQuery) ([]Subscriber, tablePage) {
+	matching := make([]Subscriber, 0, len(subscribers))
+	for _, subscriber := range subscribers {
+		if q.matches(subscriber.State(), subscriber.CreatedAt.Time, subscriber.UserEmail, subscriber.ID) {
+			matching = append(matching, subscriber)
+		}
+	}
+
+	less := subscriberSorts[q.Sort]
+	sort.SliceStable(matching, func(i, j int) bool {
+		if q.Descending {
+			return less(matching[j], matching[i])
+		}
+		return less(matching[i], matching[j])
+	})
+
+	start, end, page := q.paginate(len(matching), len(subscribers))
+	return matching[start:end], page
+}
+
+// productSubscribers fetches a product's subscribers and sales and
+// summarizes them, masking purchaser details when the requester may not see
+// them.
+func (app *App) productSubscribers(r *http.Request, product Product) ([]Subscriber, subscriberSummary, error) {
+	subscribers, err := app.getSubscribers(product.ID)
+	if err != nil {
+		return nil, subscriberSummary{}, fmt.Errorf("Failed to fetch subscribers: %w", err)
+	}
+	sales, err := app.getSales(product.ID)
+	if err != nil {
+		return nil, subscriberSummary{}, fmt.Errorf("Failed to fetch sales: %w", err)
+	}
+	summary := app.summarizeSubscribers(subscribers, sales, product, app.currentTime())
+	if !app.canViewPII(r) {
+		subscribers = maskSubscriberPII(subscribers)
+	}
+	return subscribers, summary, nil
+}
+
+func (app *App) subscribersHandler(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(mux.Vars(r)["index"])
+	if err != nil {
+		http.Error(w, "Invalid product index", http.StatusBadRequest)
+		return
+	}
+
+	products, err := app.getProducts()
+	if err != nil {
+		http.Error(w, "Failed to fetch products: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if index < 0 || index >= len(products) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	product := products[index]
+
+	subscribers, summary, err := app.productSubscribers(r, product)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := parseTableQuery(r, subscriberSortColumns, app.timezone(r))
+	subscribers, page := querySubscribers(subscribers, query)
+
+	data := PageData{
+		Title:             app.localizer(r).t("subscribers.title", product.Name),
+		CurrentPage:       "subscribers",
+		BackLink:          "/",
+		ProductID:         product.ID,
+		ProductIndex:      index,
+		Subscribers:       subscribers,
+		SubscriberSummary: summary,
+		TableQuery:        query,
+		TablePage:         page,
+		TableStatuses:     subscriberStates,
+		PIIMasked:         !app.canViewPII(r),
+	}
+
+	app.renderPage(w, r, data)
+}
+
+// subscriberHandler shows one subscriber and the charges made to their
+// subscription.
+func (app *App) subscriberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	index := vars["index"]
+
+	subscriber, err := app.getSubscriber(vars["id"])
+	var statusErr *gumroadStatusError
+	if errors.As(err, &statusErr) && statusErr.Status == http.StatusNotFound {
+		http.Error(w, "Subscriber not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch subscriber: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sales, err := app.getSales(subscriber.ProductID)
+	if err != nil {
+		http.Error(w, "Failed to fetch sales: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	charges := subscriberCharges(subscriber, sales)
+	masked := !app.canViewPII(r)
+	if masked {
+		subscriber = maskSubscriberPII([]Subscriber{subscriber})[0]
+		charges = maskSalePII(charges)
+	}
+
+	data := PageData{
+		Title:       app.localizer(r).t("subscriber.title", subscriber.ProductName),
+		CurrentPage: "subscriber",
+		BackLink:    "/subscribers/" + index,
+		ProductID:   subscriber.ProductID,
+		Subscriber:  subscriber,
+		Charges:     charges,
+		PIIMasked:   masked,
+	}
+
+	app.renderPage(w, r, data)
+}
+
+// productSubscribersJSONHandler is the JSON equivalent of the subscribers
+// page, addressed by Gumroad product ID.
+func (app *App) productSubscribersJSONHandler(w http.ResponseWriter, r *http.Request) {
+	product, err := app.getProduct(mux.Vars(r)["id"])
+	if err != nil {
+		writeTableError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch product: %v", err))
+		return
+	}
+	subscribers, summary, err := app.productSubscribers(r, product)
+	if err != nil {
+		writeTableError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	rows, page := querySubscribers(subscribers, parseTableQuery(r, subscriberSortColumns, app.timezone(r)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"subscribers": rows,
+		"pagination":  page,
+		"summary":     summary,
+	})
+}
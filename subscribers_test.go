@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSubscriberState(t *testing.T) {
+	cancelled := Timestamp{Time: testNow}
+	tests := []struct {
+		subscriber Subscriber
+		want       string
+	}{
+		{Subscriber{Status: "alive"}, subscriberActive},
+		{Subscriber{Status: "pending_failure"}, subscriberPastDue},
+		{Subscriber{Status: "pending_cancellation"}, subscriberCancelled},
+		{Subscriber{Status: "cancelled"}, subscriberCancelled},
+		{Subscriber{Status: "failed_payment"}, subscriberEnded},
+		{Subscriber{Status: "fixed_subscription_period_ended"}, subscriberEnded},
+		{Subscriber{Status: "paused", CancelledAt: cancelled}, subscriberCancelled},
+	}
+	for _, tt := range tests {
+		if got := tt.subscriber.State(); got != tt.want {
+			t.Errorf("%q: state %q, want %q", tt.subscriber.Status, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeSubscribers(t *testing.T) {
+	daysAgo := func(days int) Timestamp { return Timestamp{Time: testNow.AddDate(0, 0, -days)} }
+	subscribers := []Subscriber{
+		{ID: "a", Status: "alive", Recurrence: "monthly", CreatedAt: daysAgo(90)},
+		{ID: "b", Status: "alive", Recurrence: "yearly", CreatedAt: daysAgo(200)},
+		{ID: "c", Status: "alive", Recurrence: "monthly", CreatedAt: daysAgo(5)},
+		{ID: "d", Status: "pending_failure", Recurrence: "monthly", CreatedAt: daysAgo(60), FailedAt: daysAgo(2)},
+		{ID: "e", Status: "cancelled", Recurrence: "monthly", CreatedAt: daysAgo(120), CancelledAt: daysAgo(10)},
+		{ID: "f", Status: "cancelled", Recurrence: "monthly", CreatedAt: daysAgo(300), CancelledAt: daysAgo(100)},
+		{ID: "g", Status: "alive", Recurrence: "monthly", CreatedAt: daysAgo(3), FreeTrialEndsAt: Timestamp{Time: testNow.AddDate(0, 0, 4)}},
+	}
+	sales := []Sale{
+		{ID: "a1", SubscriptionID: "a", Price: 1000, Currency: "usd", CreatedAt: daysAgo(60)},
+		{ID: "a2", SubscriptionID: "a", Price: 1200, Currency: "usd", CreatedAt: daysAgo(30)},
+		{ID: "b1", SubscriptionID: "b", Price: 12000, Currency: "eur", CreatedAt: daysAgo(200)},
+		{ID: "c1", SubscriptionID: "c", Price: 1500, Currency: "usd", CreatedAt: daysAgo(5), Refunded: true},
+	}
+	product := Product{Price: 900, Currency: "usd", SubscriptionDuration: "monthly"}
+
+	app := &App{config: Config{ReportingCurrency: "usd", ExchangeRates: map[string]float64{"eur": 1.10}}}
+	got := app.summarizeSubscribers(subscribers, sales, product, testNow)
+
+	want := subscriberSummary{
+		Counts: map[string]int{subscriberActive: 4, subscriberPastDue: 1, subscriberCancelled: 2, subscriberEnded: 0},
+		Total:  7,
+		// a's latest charge, b's yearly €120, and c at the product price as
+		// its only charge was refunded; g is still in its free trial
+		MRR:          []Money{{1000, "EUR"}, {1200 + 900, "USD"}},
+		ReportingMRR: &Money{2100 + 1100, "USD"},
+		// a, b, d and e were paying 30 days ago; e has cancelled since
+		Churned:       1,
+		ActiveAtStart: 4,
+		ChurnRate:     0.25,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summary =\n%+v\nwant\n%+v", got, want)
+	}
+	if got.ChurnPercent() != "25.0" {
+		t.Errorf("churn percent %q", got.ChurnPercent())
+	}
+}
+
+func TestMonthlyAmount(t *testing.T) {
+	if got := monthlyAmount(Money{10000, "USD"}, "quarterly"); got != (Money{3333, "USD"}) {
+		t.Errorf("quarterly: %v", got)
+	}
+	if got := monthlyAmount(Money{500, "JPY"}, "weekly"); got != (Money{500, "JPY"}) {
+		t.Errorf("unknown recurrence: %v", got)
+	}
+}
+
+func TestSubscribersHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "subscribers")
+
+	recorder := serve(app.subscribersHandler, httptest.NewRequest("GET", "/subscribers/1", nil), map[string]string{"index": "1"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "subscribers", recorder.Body.Bytes())
+
+	recorder = serve(app.subscribersHandler, httptest.NewRequest("GET", "/subscribers/9", nil), map[string]string{"index": "9"})
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown product index: status %d, want 404", recorder.Code)
+	}
+}
+
+func TestSubscriberHandler(t *testing.T) {
+	app := newTestApp(t)
+	useCassette(t, app, "subscriber")
+
+	subscribers, err := app.getSubscribers(subscriberTestProduct(t, app))
+	if err != nil || len(subscribers) == 0 {
+		t.Fatalf("no subscribers: %v", err)
+	}
+	id := subscribers[0].ID
+	recorder := serve(app.subscriberHandler, httptest.NewRequest("GET", "/subscribers/1/"+id, nil), map[string]string{"index": "1", "id": id})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	assertGolden(t, "subscriber", recorder.Body.Bytes())
+
+	recorder = serve(app.subscriberHandler, httptest.NewRequest("GET", "/subscribers/1/missing", nil), map[string]string{"index": "1", "id": "missing"})
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown subscriber: status %d, want 404", recorder.Code)
+	}
+}
+
+// subscriberTestProduct returns the ID of the recorded membership product.
+func subscriberTestProduct(t *testing.T, app *App) string {
+	t.Helper()
+	products, err := app.getProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, product := range products {
+		if product.IsMembership() {
+			return product.ID
+		}
+	}
+	t.Fatal("no membership product")
+	return ""
+}
+
+func TestSubscriberEmailsMaskedForViewers(t *testing.T) {
+	subscribers := maskSubscriberPII([]Subscriber{{UserEmail: "alice@example.com", UserID: "u1"}})
+	if subscribers[0].UserEmail != "a•••••@example.com" || subscribers[0].UserID != "" {
+		t.Errorf("masked subscriber %+v", subscribers[0])
+	}
+}
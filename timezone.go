@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	// The timezone database is compiled in, since the Docker image has none
+	_ "time/tzdata"
+)
+
+const (
+	timezoneCookieName = "glm_tz"
+	// recentTimeWindow is how long times are shown relative to now, as in
+	// "3 hours ago"; older ones are shown as dates
+	recentTimeWindow = 7 * 24 * time.Hour
+)
+
+// commonTimezones are offered in the timezone menu, along with the
+// configured and the current one.
+var commonTimezones = []string{
+	"UTC",
+	"America/Los_Angeles", "America/Denver", "America/Chicago", "America/New_York",
+	"America/Mexico_City", "America/Sao_Paulo",
+	"Europe/London", "Europe/Madrid", "Europe/Berlin", "Europe/Athens",
+	"Asia/Kolkata", "Asia/Singapore", "Asia/Tokyo", "Australia/Sydney",
+}
+
+// Timestamp is a time sent by the Gumroad API. Gumroad does not write all
+// its times in one format, so they are parsed with parseGumroadTime; text that
+// does not parse is kept and sent on unchanged.
+type Timestamp struct {
+	time.Time
+	raw string
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*t = Timestamp{Time: parseGumroadTime(value), raw: value}
+	return nil
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return json.Marshal(t.raw)
+	}
+	return json.Marshal(t.Format(time.RFC3339))
+}
+
+var (
+	locationsMu sync.Mutex
+	locations   = map[string]*time.Location{}
+)
+
+// loadLocation returns an IANA timezone such as "Europe/Berlin". Loaded
+// zones are kept, since each load reads the timezone database.
+func loadLocation(name string) (*time.Location, bool) {
+	if name == "" {
+		return nil, false
+	}
+	locationsMu.Lock()
+	defer locationsMu.Unlock()
+
+	if loc, ok := locations[name]; ok {
+		return loc, loc != nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = nil
+	}
+	locations[name] = loc
+	return loc, loc != nil
+}
+
+// timezone returns the zone times are shown and date filters are read in:
+// the logged-in user's choice, the one picked in this browser, the
+// configured timezone, or UTC.
+func (app *App) timezone(r *http.Request) *time.Location {
+	user, ok := currentUser(r)
+	if !ok {
+		user, _ = app.sessionUser(r)
+	}
+	var cookie string
+	if c, err := r.Cookie(timezoneCookieName); err == nil {
+		cookie = c.Value
+	}
+	for _, name := range []string{user.Timezone, cookie, app.config.Timezone} {
+		if loc, ok := loadLocation(name); ok {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// currentTime is time.Now, unless a test has fixed the clock.
+func (app *App) currentTime() time.Time {
+	if app.now != nil {
+		return app.now()
+	}
+	return time.Now()
+}
+
+// timezones lists the timezone menu: the common zones plus the configured
+// and current ones, sorted by name.
+func (app *App) timezones(current *time.Location) []string {
+	names := append([]string{}, commonTimezones...)
+	for _, name := range []string{app.config.Timezone, current.String()} {
+		if _, ok := loadLocation(name); !ok {
+			continue
+		}
+		known := false
+		for _, existing := range names {
+			known = known || existing == name
+		}
+		if !known {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// datetime writes a time in the request's timezone with the catalog's
+// "format.datetime" layout.
+func (l localizer) datetime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(l.location).Format(l.t("format.datetime"))
+}
+
+// relative writes how long ago, or how far ahead, a time is, in the largest
+// whole unit: "3 hours ago", "in 2 days".
+func (l localizer) relative(t time.Time) string {
+	d := l.now.Sub(t)
+	phrase := "time.ago"
+	if d < 0 {
+		d, phrase = -d, "time.from_now"
+	}
+	if d < time.Minute {
+		return l.t("time.just_now")
+	}
+
+	units := []struct {
+		size     time.Duration
+		one, few string
+	}{
+		{365 * 24 * time.Hour, "time.year", "time.years"},
+		{30 * 24 * time.Hour, "time.month", "time.months"},
+		{24 * time.Hour, "time.day", "time.days"},
+		{time.Hour, "time.hour", "time.hours"},
+		{time.Minute, "time.minute", "time.minutes"},
+	}
+	for _, unit := range units {
+		if n := int(d / unit.size); n >= 1 {
+			amount := l.t(unit.few, l.number(n))
+			if n == 1 {
+				amount = l.t(unit.one)
+			}
+			return l.t(phrase, amount)
+		}
+	}
+	return l.t("time.just_now")
+}
+
+// timestamp renders a time as a <time> element. Recent times read as
+// relative ones with the date in the tooltip; older ones the other way
+// around.
+func (l localizer) timestamp(t time.Time) template.HTML {
+	if t.IsZero() {
+		return ""
+	}
+	text, title := l.datetime(t), l.relative(t)
+	if d := l.now.Sub(t); d >= 0 && d < recentTimeWindow {
+		text, title = title, text
+	}
+	return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+		t.In(l.location).Format(time.RFC3339),
+		template.HTMLEscapeString(title),
+		template.HTMLEscapeString(text)))
+}
+
+// timestampOf is the template function form of timestamp, for both
+// time.Time fields and Gumroad Timestamps. Timestamps that did not parse are
+// shown as Gumroad sent them.
+func (l localizer) timestampOf(value interface{}) template.HTML {
+	switch v := value.(type) {
+	case time.Time:
+		return l.timestamp(v)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return l.timestamp(*v)
+	case Timestamp:
+		if v.IsZero() {
+			return template.HTML(template.HTMLEscapeString(v.raw))
+		}
+		return l.timestamp(v.Time)
+	}
+	return template.HTML(template.HTMLEscapeString(fmt.Sprint(value)))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+		out  string
+	}{
+		{`"2024-03-01T12:30:00Z"`, time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), `"2024-03-01T12:30:00Z"`},
+		{`"2024-03-01T12:30:00+01:00"`, time.Date(2024, 3, 1, 11, 30, 0, 0, time.UTC), `"2024-03-01T12:30:00+01:00"`},
+		{`"1 Mar 2024 2:05 PM"`, time.Date(2024, 3, 1, 14, 5, 0, 0, time.UTC), `"2024-03-01T14:05:00Z"`},
+		{`"2024-03-01"`, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), `"2024-03-01T00:00:00Z"`},
+		{`"last Tuesday"`, time.Time{}, `"last Tuesday"`},
+		{`""`, time.Time{}, `""`},
+	}
+	for _, tt := range tests {
+		var ts Timestamp
+		if err := json.Unmarshal([]byte(tt.in), &ts); err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+		if !ts.Equal(tt.want) {
+			t.Errorf("%s parsed as %v, want %v", tt.in, ts.Time, tt.want)
+		}
+		if out, _ := json.Marshal(ts); string(out) != tt.out {
+			t.Errorf("%s marshalled as %s, want %s", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	app := newTestApp(t)
+	now := testNow
+	tests := []struct {
+		language string
+		t        time.Time
+		want     string
+	}{
+		{"en", now.Add(-30 * time.Second), "just now"},
+		{"en", now.Add(-time.Minute), "1 minute ago"},
+		{"en", now.Add(-5 * time.Hour), "5 hours ago"},
+		{"en", now.AddDate(0, 0, -45), "1 month ago"},
+		{"en", now.AddDate(-3, 0, 0), "3 years ago"},
+		{"en", now.Add(49 * time.Hour), "in 2 days"},
+		{"de", now.Add(-3 * 24 * time.Hour), "vor 3 Tagen"},
+		{"es", now.Add(-2 * time.Hour), "hace 2 horas"},
+	}
+	for _, tt := range tests {
+		l := localizer{language: tt.language, catalogs: app.catalogs, location: time.UTC, now: now}
+		if got := l.relative(tt.t); got != tt.want {
+			t.Errorf("%s %v = %q, want %q", tt.language, now.Sub(tt.t), got, tt.want)
+		}
+	}
+}
+
+func TestTimestampsInTimezone(t *testing.T) {
+	app := newTestApp(t)
+	berlin, _ := loadLocation("Europe/Berlin")
+	l := localizer{language: "de", catalogs: app.catalogs, location: berlin, now: testNow}
+
+	old := time.Date(2024, 7, 1, 22, 30, 0, 0, time.UTC)
+	want := `<time datetime="2024-07-02T00:30:00+02:00" title="vor 4 Monaten">02.07.2024 00:30</time>`
+	if got := string(l.timestamp(old)); got != want {
+		t.Errorf("old time = %s, want %s", got, want)
+	}
+	recent := testNow.Add(-3 * time.Hour)
+	want = `<time datetime="2024-11-22T10:00:00+01:00" title="22.11.2024 10:00">vor 3 Stunden</time>`
+	if got := string(l.timestamp(recent)); got != want {
+		t.Errorf("recent time = %s, want %s", got, want)
+	}
+
+	// The timezone comes from the cookie, then the config, then UTC
+	req := httptest.NewRequest("GET", "/", nil)
+	if got := app.timezone(req); got != time.UTC {
+		t.Errorf("default timezone = %v, want UTC", got)
+	}
+	app.config.Timezone = "America/New_York"
+	if got := app.timezone(req).String(); got != "America/New_York" {
+		t.Errorf("configured timezone = %v", got)
+	}
+	req.AddCookie(&http.Cookie{Name: timezoneCookieName, Value: "Asia/Tokyo"})
+	if got := app.timezone(req).String(); got != "Asia/Tokyo" {
+		t.Errorf("chosen timezone = %v", got)
+	}
+}
+
+func TestDateFiltersUseTimezone(t *testing.T) {
+	sales := []Sale{
+		{ID: "before", CreatedAt: Timestamp{Time: time.Date(2024, 3, 9, 22, 59, 0, 0, time.UTC)}},
+		{ID: "first", CreatedAt: Timestamp{Time: time.Date(2024, 3, 9, 23, 0, 0, 0, time.UTC)}},
+		{ID: "last", CreatedAt: Timestamp{Time: time.Date(2024, 3, 10, 22, 59, 0, 0, time.UTC)}},
+		{ID: "after", CreatedAt: Timestamp{Time: time.Date(2024, 3, 10, 23, 0, 0, 0, time.UTC)}},
+		{ID: "daystamp", Daystamp: Timestamp{Time: time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)}},
+	}
+	berlin, _ := loadLocation("Europe/Berlin")
+	req := httptest.NewRequest("GET", "/sales/0?from=2024-03-10&to=2024-03-10&sort=date", nil)
+	var ids []string
+	for _, sale := range filterSales(sales, parseTableQuery(req, saleSortColumns, berlin)) {
+		ids = append(ids, sale.ID)
+	}
+	// 10 March in Berlin runs from 23:00 UTC on the 9th to 23:00 on the 10th
+	if got := strings.Join(ids, ","); got != "first,last,daystamp" {
+		t.Errorf("sales on 10 March in Berlin = %s, want first,last,daystamp", got)
+	}
+}
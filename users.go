@@ -0,0 +1,853 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const usersFile = "users.json"
+
+// Roles, from least to most privileged. Viewers only see masked purchaser
+// details; support can see everything and act on licenses; admins also
+// manage users and API keys.
+const (
+	roleViewer  = "viewer"
+	roleSupport = "support"
+	roleAdmin   = "admin"
+)
+
+var roleRanks = map[string]int{
+	roleViewer:  1,
+	roleSupport: 2,
+	roleAdmin:   3,
+}
+
+func roleAllows(have, need string) bool {
+	return roleRanks[have] > 0 && roleRanks[have] >= roleRanks[need]
+}
+
+const (
+	sessionCookieName = "glm_session"
+	sessionTTL        = 12 * time.Hour
+	minPasswordLength = 8
+	loginRateLimit    = 10 // attempts per minute per IP
+
+	// OWASP's 2023 recommendation for PBKDF2-HMAC-SHA256
+	passwordIterations = 600000
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+)
+
+type User struct {
+	ID           string     `json:"id"`
+	Username     string     `json:"username"`
+	Role         string     `json:"role"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	// Language is the catalog chosen from the language menu, such as "de",
+	// and Timezone the IANA zone times are shown in; empty for the defaults
+	Language string `json:"language,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// public returns the user without the password hash.
+func (u User) public() User {
+	u.PasswordHash = ""
+	return u
+}
+
+// Actor identifies the user in logs and in the API call log.
+func (u User) Actor() string {
+	return "user:" + u.Username
+}
+
+// pbkdf2SHA256 derives a key as specified in RFC 8018, section 5.2.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLength := prf.Size()
+	blocks := (keyLength + hashLength - 1) / hashLength
+
+	derived := make([]byte, 0, blocks*hashLength)
+	u := make([]byte, hashLength)
+	var counter [4]byte
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		derived = prf.Sum(derived)
+
+		t := derived[len(derived)-hashLength:]
+		copy(u, t)
+		for i := 2; i <= iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return derived[:keyLength]
+}
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<key>" with the
+// salt and key base64 encoded.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordIterations, passwordKeyLength)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	got := pbkdf2SHA256([]byte(password), salt, iterations, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+var (
+	errUserNotFound   = errors.New("user not found")
+	errUsernameTaken  = errors.New("username is already taken")
+	errLastAdmin      = errors.New("there must be at least one admin")
+	errPasswordLength = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	errFirstAdminCLI  = errors.New("the first admin has to be created with the create-admin command")
+)
+
+// userStore keeps users in a JSON file in the data directory, and their
+// login sessions in memory. Restarting the app logs everyone out.
+type userStore struct {
+	mu       sync.Mutex
+	path     string
+	users    []User
+	sessions map[string]userSession
+}
+
+type userSession struct {
+	userID    string
+	expiresAt time.Time
+}
+
+func newUserStore(dataDir string) (*userStore, error) {
+	store := &userStore{
+		path:     filepath.Join(dataDir, usersFile),
+		sessions: make(map[string]userSession),
+	}
+
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.users); err != nil {
+		return nil, fmt.Errorf("%s: %w", store.path, err)
+	}
+	return store, nil
+}
+
+// save writes the users atomically. The caller holds s.mu.
+func (s *userStore) save() error {
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// enabled reports whether access control is on. Until the first user is
+// created with the create-admin command the app stays open, as it was
+// before users existed.
+func (s *userStore) enabled() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.users) > 0
+}
+
+func (s *userStore) list() []User {
+	s.mu.Lock()
+	users := make([]User, len(s.users))
+	copy(users, s.users)
+	s.mu.Unlock()
+
+	for i := range users {
+		users[i] = users[i].public()
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+func (s *userStore) get(id string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// adminCount is the number of admins. The caller holds s.mu.
+func (s *userStore) adminCount() int {
+	count := 0
+	for _, user := range s.users {
+		if user.Role == roleAdmin {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *userStore) create(username, password, role string) (User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return User{}, errors.New("username is required")
+	}
+	if roleRanks[role] == 0 {
+		return User{}, fmt.Errorf("unknown role %q", role)
+	}
+	if len(password) < minPasswordLength {
+		return User{}, errPasswordLength
+	}
+
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Username, username) {
+			return User{}, errUsernameTaken
+		}
+	}
+	// The first user has to be able to manage the others
+	if len(s.users) == 0 && role != roleAdmin {
+		return User{}, errLastAdmin
+	}
+
+	user := User{
+		ID:           hex.EncodeToString(id),
+		Username:     username,
+		Role:         role,
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now(),
+	}
+	s.users = append(s.users, user)
+	if err := s.save(); err != nil {
+		s.users = s.users[:len(s.users)-1]
+		return User{}, err
+	}
+	return user, nil
+}
+
+// update changes a user's role and, when password is non-empty, password.
+func (s *userStore) update(id, role, password string) (User, error) {
+	if role != "" && roleRanks[role] == 0 {
+		return User{}, fmt.Errorf("unknown role %q", role)
+	}
+	passwordHash := ""
+	if password != "" {
+		if len(password) < minPasswordLength {
+			return User{}, errPasswordLength
+		}
+		var err error
+		if passwordHash, err = hashPassword(password); err != nil {
+			return User{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID != id {
+			continue
+		}
+		previous := s.users[i]
+		if role != "" {
+			if previous.Role == roleAdmin && role != roleAdmin && s.adminCount() == 1 {
+				return User{}, errLastAdmin
+			}
+			s.users[i].Role = role
+		}
+		if passwordHash != "" {
+			s.users[i].PasswordHash = passwordHash
+			s.dropSessions(id)
+		}
+		if err := s.save(); err != nil {
+			s.users[i] = previous
+			return User{}, err
+		}
+		return s.users[i], nil
+	}
+	return User{}, errUserNotFound
+}
+
+// setPreferences saves a user's choices from the language and timezone menus.
+func (s *userStore) setPreferences(id, language, timezone string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID != id {
+			continue
+		}
+		previous := s.users[i]
+		s.users[i].Language = language
+		s.users[i].Timezone = timezone
+		if err := s.save(); err != nil {
+			s.users[i] = previous
+			return User{}, err
+		}
+		return s.users[i].public(), nil
+	}
+	return User{}, errUserNotFound
+}
+
+func (s *userStore) delete(id string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, user := range s.users {
+		if user.ID != id {
+			continue
+		}
+		if user.Role == roleAdmin && s.adminCount() == 1 {
+			return User{}, errLastAdmin
+		}
+		previous := s.users
+		s.users = append(s.users[:i:i], s.users[i+1:]...)
+		if err := s.save(); err != nil {
+			s.users = previous
+			return User{}, err
+		}
+		s.dropSessions(id)
+		return user, nil
+	}
+	return User{}, errUserNotFound
+}
+
+// dropSessions logs a user out everywhere. The caller holds s.mu.
+func (s *userStore) dropSessions(userID string) {
+	for token, session := range s.sessions {
+		if session.userID == userID {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// login checks a username and password and starts a session.
+func (s *userStore) login(username, password string) (User, string, bool) {
+	s.mu.Lock()
+	var user User
+	found := false
+	for _, candidate := range s.users {
+		if strings.EqualFold(candidate.Username, strings.TrimSpace(username)) {
+			user, found = candidate, true
+		}
+	}
+	s.mu.Unlock()
+
+	// Hash even for unknown users so response times don't reveal which
+	// usernames exist
+	if !found {
+		checkPassword("pbkdf2-sha256$"+strconv.Itoa(passwordIterations)+"$AAAAAAAAAAAAAAAAAAAAAA$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", password)
+		return User{}, "", false
+	}
+	if !checkPassword(user.PasswordHash, password) {
+		return User{}, "", false
+	}
+
+	token, err := s.startSession(user.ID)
+	if err != nil {
+		log.Printf("Failed to start session: %v", err)
+		return User{}, "", false
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for i := range s.users {
+		if s.users[i].ID == user.ID {
+			s.users[i].LastLoginAt = &now
+			user = s.users[i]
+		}
+	}
+	if err := s.save(); err != nil {
+		log.Printf("Failed to save last login time: %v", err)
+	}
+	s.mu.Unlock()
+	return user, token, true
+}
+
+func (s *userStore) startSession(userID string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for t, session := range s.sessions {
+		if now.After(session.expiresAt) {
+			delete(s.sessions, t)
+		}
+	}
+	s.sessions[token] = userSession{userID: userID, expiresAt: now.Add(sessionTTL)}
+	return token, nil
+}
+
+func (s *userStore) endSession(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// sessionUser returns the user a session token belongs to. The user is
+// looked up on every request so role changes apply immediately.
+func (s *userStore) sessionUser(token string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return User{}, false
+	}
+	if time.Now().After(session.expiresAt) {
+		delete(s.sessions, token)
+		return User{}, false
+	}
+	for _, user := range s.users {
+		if user.ID == session.userID {
+			return user.public(), true
+		}
+	}
+	return User{}, false
+}
+
+type userContextKey struct{}
+
+// currentUser returns the logged-in user of the request, if any.
+func currentUser(r *http.Request) (User, bool) {
+	user, ok := r.Context().Value(userContextKey{}).(User)
+	return user, ok
+}
+
+// canViewPII reports whether purchaser emails and license keys may be shown
+// unmasked to the requester.
+func (app *App) canViewPII(r *http.Request) bool {
+	if !app.users.enabled() {
+		return true
+	}
+	user, ok := currentUser(r)
+	return ok && roleAllows(user.Role, roleSupport)
+}
+
+// maskEmail keeps the first character of the local part and the domain.
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return maskLicenseKey(email)
+	}
+	return email[:1] + strings.Repeat("•", 5) + email[at:]
+}
+
+func maskLicensePII(licenses []License) []License {
+	masked := make([]License, len(licenses))
+	for i, license := range licenses {
+		license.PurchaserEmail = maskEmail(license.PurchaserEmail)
+		license.LicenseKey = maskLicenseKey(license.LicenseKey)
+		masked[i] = license
+	}
+	return masked
+}
+
+func maskSalePII(sales []Sale) []Sale {
+	masked := make([]Sale, len(sales))
+	for i, sale := range sales {
+		sale.Email = maskEmail(sale.Email)
+		sale.LicenseKey = maskLicenseKey(sale.LicenseKey)
+		sale.PurchaserID = ""
+		if sale.ShippingInformation != nil {
+			// The address is dropped, but the sale stays marked as physical
+			sale.ShippingInformation = map[string]interface{}{}
+		}
+		masked[i] = sale
+	}
+	return masked
+}
+
+func maskSubscriberPII(subscribers []Subscriber) []Subscriber {
+	masked := make([]Subscriber, len(subscribers))
+	for i, subscriber := range subscribers {
+		subscriber.UserEmail = maskEmail(subscriber.UserEmail)
+		subscriber.UserID = ""
+		masked[i] = subscriber
+	}
+	return masked
+}
+
+// wantsJSON reports whether an unauthorised request should get a JSON error
+// rather than a redirect to the login page.
+func wantsJSON(r *http.Request) bool {
+	return r.Method != "GET" || strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// require wraps a handler so only users with at least the given role can
+// use it. While no users exist every request is let through.
+func (app *App) require(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.users.enabled() {
+			next(w, r)
+			return
+		}
+
+		user, ok := app.sessionUser(r)
+		if !ok {
+			if wantsJSON(r) {
+				writeAuthError(w, http.StatusUnauthorized, "Login required")
+				return
+			}
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		if !roleAllows(user.Role, role) {
+			log.Printf("Denied %s %s to %s (role %s, needs %s)", r.Method, r.URL.Path, user.Username, user.Role, role)
+			if wantsJSON(r) {
+				writeAuthError(w, http.StatusForbidden, fmt.Sprintf("This needs the %s role", role))
+				return
+			}
+			http.Error(w, fmt.Sprintf("Forbidden: this page needs the %s role, you are %s", role, user.Role), http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+func (app *App) sessionUser(r *http.Request) (User, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return User{}, false
+	}
+	return app.users.sessionUser(cookie.Value)
+}
+
+func writeAuthError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
+
+func (app *App) setSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   app.isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isHTTPS reports whether the client connected over TLS, directly or via a
+// trusted reverse proxy.
+func (app *App) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return app.config.TrustProxyHeaders && r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// safeRedirectTarget only allows local paths, so the login form cannot be
+// used to send users to another site.
+func safeRedirectTarget(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+func (app *App) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.users.enabled() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.renderPage(w, r, PageData{
+		Title:       app.localizer(r).t("login.title"),
+		CurrentPage: "login",
+		LoginNext:   safeRedirectTarget(r.URL.Query().Get("next")),
+	})
+}
+
+func (app *App) loginSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	next := safeRedirectTarget(r.FormValue("next"))
+	data := PageData{
+		Title:       app.localizer(r).t("login.title"),
+		CurrentPage: "login",
+		LoginNext:   next,
+	}
+
+	if ok, wait := app.loginLimiter.allow(app.clientIP(r)); !ok {
+		setRetryAfter(w, wait)
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusTooManyRequests)
+		data.LoginError = "Too many login attempts, try again in a minute"
+		app.renderPage(w, r, data)
+		return
+	}
+
+	user, token, ok := app.users.login(r.FormValue("username"), r.FormValue("password"))
+	loginEvent := AuditEvent{Action: auditUserLogin, TargetType: "user", Target: strings.TrimSpace(r.FormValue("username"))}
+	if !ok {
+		app.audit(r, loginEvent, errors.New("invalid username or password"))
+		log.Printf("Failed login for %q from %s", r.FormValue("username"), app.clientIP(r))
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusUnauthorized)
+		data.LoginError = "Invalid username or password"
+		app.renderPage(w, r, data)
+		return
+	}
+
+	log.Printf("User %s logged in from %s", user.Username, app.clientIP(r))
+	loginEvent.Actor = user.Actor()
+	app.audit(r, loginEvent, nil)
+	app.setSessionCookie(w, r, token)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (app *App) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if user, ok := app.users.sessionUser(cookie.Value); ok {
+			app.audit(r, AuditEvent{Action: auditUserLogout, Actor: user.Actor(), TargetType: "user", Target: user.Username}, nil)
+		}
+		app.users.endSession(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (app *App) usersHandler(w http.ResponseWriter, r *http.Request) {
+	app.renderPage(w, r, PageData{
+		Title:       app.localizer(r).t("users.title"),
+		CurrentPage: "users",
+		Users:       app.users.list(),
+	})
+}
+
+type userRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+func writeUserResult(w http.ResponseWriter, user User, err error) {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		writeAuthError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeAuthError(w, http.StatusBadRequest, err.Error())
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"user":    user.public(),
+		})
+	}
+}
+
+// createUserHandler adds a user. While no users exist every page is open, so
+// the first admin cannot be created here: anyone who reached the server
+// first would own it. It is created with the create-admin command instead.
+func (app *App) createUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAuthError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	event := AuditEvent{Action: auditUserCreate, TargetType: "user", Target: req.Username, Detail: "role " + req.Role}
+	if !app.users.enabled() {
+		app.audit(r, event, errFirstAdminCLI)
+		log.Printf("Refused to create first user %q from %s over HTTP", req.Username, app.clientIP(r))
+		writeAuthError(w, http.StatusForbidden, errFirstAdminCLI.Error())
+		return
+	}
+
+	user, err := app.users.create(req.Username, req.Password, req.Role)
+	app.audit(r, event, err)
+	if err == nil {
+		log.Printf("User %s created with role %s by %s", user.Username, user.Role, requestActor(r))
+	}
+	writeUserResult(w, user, err)
+}
+
+// runCreateAdmin creates an admin from the command line. It is started with
+// "gumroad-license-manager create-admin -username <name>" and reads the
+// password from ADMIN_PASSWORD or, when that is unset, from standard input.
+// This is the only way to create the first user; it also recovers access
+// when every admin password is lost.
+func runCreateAdmin(args []string) {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	username := flags.String("username", "", "username of the new admin")
+	flags.Parse(args)
+	if *username == "" {
+		log.Fatal("create-admin needs -username")
+	}
+
+	config, err := loadConfig(withDefaultString(os.Getenv("CONFIG_PATH"), defaultConfigPath))
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatal("Failed to read password:", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	user, err := createAdmin(withDefaultString(config.DataDir, "."), *username, password)
+	if err != nil {
+		log.Fatal("Failed to create admin:", err)
+	}
+	log.Printf("Admin %s created; log in at /login", user.Username)
+}
+
+// createAdmin adds an admin to the user store in dataDir and records it in
+// the audit log.
+func createAdmin(dataDir, username, password string) (User, error) {
+	users, err := newUserStore(dataDir)
+	if err != nil {
+		return User{}, err
+	}
+	user, err := users.create(username, password, roleAdmin)
+
+	auditLog, auditErr := newAuditLog(dataDir)
+	if auditErr != nil {
+		log.Printf("Failed to open audit log: %v", auditErr)
+		return user, err
+	}
+	defer auditLog.file.Close()
+	event := AuditEvent{
+		Time:       time.Now(),
+		Actor:      auditActorCLI,
+		Action:     auditUserCreate,
+		TargetType: "user",
+		Target:     strings.TrimSpace(username),
+		Outcome:    auditOutcomeSuccess,
+		Detail:     "role " + roleAdmin,
+	}
+	if err != nil {
+		event.Outcome = auditOutcomeFailure
+		event.Detail = err.Error()
+	}
+	if _, auditErr := auditLog.append(event); auditErr != nil {
+		log.Printf("Failed to write audit log: %v", auditErr)
+	}
+	return user, err
+}
+
+func (app *App) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAuthError(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	user, err := app.users.update(mux.Vars(r)["id"], req.Role, req.Password)
+	detail := ""
+	if req.Role != "" {
+		detail = "role " + req.Role
+	}
+	if req.Password != "" {
+		detail = strings.TrimPrefix(detail+", password changed", ", ")
+	}
+	app.audit(r, AuditEvent{Action: auditUserUpdate, TargetType: "user", Target: withDefaultString(user.Username, mux.Vars(r)["id"]), Detail: detail}, err)
+	if err == nil {
+		log.Printf("User %s updated (role %s, password changed: %v) by %s", user.Username, user.Role, req.Password != "", requestActor(r))
+	}
+	writeUserResult(w, user, err)
+}
+
+func (app *App) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.delete(mux.Vars(r)["id"])
+	app.audit(r, AuditEvent{Action: auditUserDelete, TargetType: "user", Target: withDefaultString(user.Username, mux.Vars(r)["id"])}, err)
+	if err == nil {
+		log.Printf("User %s deleted by %s", user.Username, requestActor(r))
+	}
+	writeUserResult(w, user, err)
+}
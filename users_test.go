@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPBKDF2SHA256 checks the key derivation against the PBKDF2-HMAC-SHA256
+// vectors of RFC 7914, section 11.
+func TestPBKDF2SHA256(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.want)
+		if got := pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, len(want)); hex.EncodeToString(got) != tt.want {
+			t.Errorf("PBKDF2(%q, %q, %d) = %x, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+		// Shorter keys are a prefix of the longer ones
+		if got := pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, 20); hex.EncodeToString(got) != tt.want[:40] {
+			t.Errorf("20-byte PBKDF2(%q) = %x", tt.password, got)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	encoded, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "pbkdf2-sha256$600000$") {
+		t.Errorf("encoded hash %q", encoded)
+	}
+	if !checkPassword(encoded, "correct horse") || checkPassword(encoded, "correct horsE") {
+		t.Error("password check does not match the hash")
+	}
+	for _, malformed := range []string{"", "plain", "md5$1$c2FsdA$a2V5", "pbkdf2-sha256$0$c2FsdA$a2V5", "pbkdf2-sha256$x$c2FsdA$a2V5", "pbkdf2-sha256$1$!!$a2V5"} {
+		if checkPassword(malformed, "") {
+			t.Errorf("%q accepted", malformed)
+		}
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	roles := []string{roleViewer, roleSupport, roleAdmin}
+	for i, have := range roles {
+		for j, need := range roles {
+			if got := roleAllows(have, need); got != (i >= j) {
+				t.Errorf("roleAllows(%s, %s) = %v", have, need, got)
+			}
+		}
+	}
+	if roleAllows("", roleViewer) || roleAllows("owner", roleViewer) {
+		t.Error("an unknown role was allowed")
+	}
+}
+
+func TestLastAdminProtection(t *testing.T) {
+	store, err := newUserStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.create("first", "password1", roleSupport); !errors.Is(err, errLastAdmin) {
+		t.Errorf("first user as support: %v, want errLastAdmin", err)
+	}
+	admin, err := store.create("admin", "password1", roleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.update(admin.ID, roleSupport, ""); !errors.Is(err, errLastAdmin) {
+		t.Errorf("demoting the last admin: %v", err)
+	}
+	if _, err := store.delete(admin.ID); !errors.Is(err, errLastAdmin) {
+		t.Errorf("deleting the last admin: %v", err)
+	}
+
+	second, err := store.create("second", "password2", roleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.update(admin.ID, roleViewer, ""); err != nil {
+		t.Errorf("demoting one of two admins: %v", err)
+	}
+	if _, err := store.delete(second.ID); !errors.Is(err, errLastAdmin) {
+		t.Errorf("deleting the remaining admin: %v", err)
+	}
+	if _, err := store.delete(admin.ID); err != nil {
+		t.Errorf("deleting a viewer: %v", err)
+	}
+}
+
+func TestMaskPII(t *testing.T) {
+	if got := maskEmail("alice@example.com"); got != "a•••••@example.com" {
+		t.Errorf("maskEmail = %q", got)
+	}
+	if got := maskEmail("not-an-email"); strings.Contains(got, "email") {
+		t.Errorf("maskEmail without @ = %q", got)
+	}
+
+	sales := []Sale{
+		{Email: "bob@example.com", LicenseKey: "ABCD1234-EFGH5678", PurchaserID: "p1", ShippingInformation: map[string]interface{}{"street_address": "1 Example Street"}},
+		{Email: "carol@example.com"},
+	}
+	masked := maskSalePII(sales)
+	if masked[0].Email != "b•••••@example.com" || masked[0].LicenseKey != "ABCD••••5678" || masked[0].PurchaserID != "" {
+		t.Errorf("masked sale %+v", masked[0])
+	}
+	if !masked[0].RequiresShipping() || len(masked[0].ShippingInformation) != 0 || masked[1].RequiresShipping() {
+		t.Errorf("shipping after masking %v, %v", masked[0].ShippingInformation, masked[1].ShippingInformation)
+	}
+	if sales[0].Email != "bob@example.com" || sales[0].ShippingInformation["street_address"] == nil {
+		t.Error("masking changed the original sales")
+	}
+
+	licenses := maskLicensePII([]License{{PurchaserEmail: "dan@example.com", LicenseKey: "ABCD1234-EFGH5678"}})
+	subscribers := maskSubscriberPII([]Subscriber{{UserEmail: "erin@example.com", UserID: "u1"}})
+	if licenses[0].PurchaserEmail != "d•••••@example.com" || licenses[0].LicenseKey != "ABCD••••5678" || subscribers[0].UserEmail != "e•••••@example.com" || subscribers[0].UserID != "" {
+		t.Errorf("masked license %+v, subscriber %+v", licenses[0], subscribers[0])
+	}
+}
+
+func TestCanViewPII(t *testing.T) {
+	app := &App{}
+	req := httptest.NewRequest("GET", "/sales/0", nil)
+	if !app.canViewPII(req) {
+		t.Error("PII masked while access control is off")
+	}
+
+	app.users = &userStore{users: []User{{ID: "u1", Role: roleAdmin}}, sessions: make(map[string]userSession)}
+	if app.canViewPII(req) {
+		t.Error("PII shown without a user")
+	}
+	for role, want := range map[string]bool{roleViewer: false, roleSupport: true, roleAdmin: true} {
+		withUser := req.WithContext(context.WithValue(req.Context(), userContextKey{}, User{Role: role}))
+		if got := app.canViewPII(withUser); got != want {
+			t.Errorf("%s: canViewPII = %v, want %v", role, got, want)
+		}
+	}
+}
+
+// TestFirstAdminBootstrap checks that the first user cannot be created over
+// HTTP while the app is open, only with create-admin.
+func TestFirstAdminBootstrap(t *testing.T) {
+	dir := t.TempDir()
+	app := newTestApp(t)
+	app.users, _ = newUserStore(dir)
+	app.auditLog, _ = newAuditLog(dir)
+
+	create := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+		recorder := serve(app.require(roleAdmin, app.createUserHandler), req, nil)
+		var response map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	status, response := create(`{"username":"mallory","password":"password1","role":"admin"}`)
+	if status != http.StatusForbidden || response["error"] != errFirstAdminCLI.Error() || app.users.enabled() {
+		t.Fatalf("first user over HTTP: status %d, %v", status, response)
+	}
+	if events := app.auditLog.snapshot(); len(events) != 1 || events[0].Outcome != auditOutcomeFailure {
+		t.Errorf("refusal not audited: %+v", events)
+	}
+	app.auditLog.file.Close()
+
+	admin, err := createAdmin(dir, " owner ", "password1")
+	if err != nil || admin.Role != roleAdmin || admin.Username != "owner" {
+		t.Fatalf("createAdmin: %+v, %v", admin, err)
+	}
+	if _, err := createAdmin(dir, "owner", "password2"); !errors.Is(err, errUsernameTaken) {
+		t.Errorf("duplicate admin: %v", err)
+	}
+	events, err := readAuditFile(filepath.Join(dir, auditLogFile))
+	if err != nil || len(events) != 3 || events[1].Actor != auditActorCLI || events[1].Outcome != auditOutcomeSuccess || events[2].Outcome != auditOutcomeFailure {
+		t.Errorf("create-admin audit events %+v, %v", events, err)
+	}
+	if _, err := verifyAuditChain(events); err != nil {
+		t.Errorf("audit chain after create-admin: %v", err)
+	}
+
+	// With access control on, creating users needs an admin session
+	app.users, _ = newUserStore(dir)
+	if status, _ := create(`{"username":"mallory","password":"password1","role":"admin"}`); status != http.StatusUnauthorized {
+		t.Errorf("anonymous create after bootstrap: status %d, want 401", status)
+	}
+}
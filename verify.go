@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errGumroadUnavailable marks failures where Gumroad could not give an answer
+// at all, as opposed to answering that a key is invalid.
+var errGumroadUnavailable = errors.New("gumroad unavailable")
+
+type verifyOptions struct {
+	IncrementUses bool
+	// Live skips fresh cache entries. Cached results are still served as a
+	// stale fallback when Gumroad is unreachable.
+	Live bool
+}
+
+// verifyLicense checks a license key and judges it against the product's
+// policy. Results come from the verification cache when possible; every call
+// that does reach Gumroad is recorded in the API call log.
+func (app *App) verifyLicense(productID, licenseKey string, opts verifyOptions) (LicenseValidationResponse, error) {
+	// Incrementing uses is a write, so it always has to reach Gumroad
+	if !opts.IncrementUses && !opts.Live {
+		if cached, ok := app.verifyCache.fresh(productID, licenseKey); ok {
+			app.judgeLicense(productID, licenseKey, &cached)
+			return cached, nil
+		}
+	}
+
+	response, apiCall, err := app.callGumroadVerify(productID, licenseKey, opts.IncrementUses)
+	if err != nil {
+		app.recordAPICall(apiCall)
+		if !errors.Is(err, errGumroadUnavailable) {
+			return LicenseValidationResponse{}, err
+		}
+
+		cached, ok := app.verifyCache.lastKnownGood(productID, licenseKey)
+		if !ok {
+			return LicenseValidationResponse{}, err
+		}
+		log.Printf("Gumroad unavailable, serving stale verification from %s: %v", cached.CachedAt.Format(time.RFC3339), err)
+		cached.Stale = true
+		app.judgeLicense(productID, licenseKey, &cached)
+		return cached, nil
+	}
+
+	if response.Success {
+		app.verifyCache.store(productID, licenseKey, response)
+	} else {
+		app.verifyCache.invalidate(productID, licenseKey)
+	}
+
+	app.judgeLicense(productID, licenseKey, &response)
+	if response.Policy != nil {
+		apiCall.PolicyRule = response.Policy.Rule
+	}
+	app.recordAPICall(apiCall)
+	return response, nil
+}
+
+// judgeLicense applies the product's policy to a license Gumroad knows about.
+// Gumroad only says whether the key exists; whether we accept it is up to us.
+func (app *App) judgeLicense(productID, licenseKey string, response *LicenseValidationResponse) {
+	if !response.Success {
+		return
+	}
+
+	decision := app.policyFor(productID).evaluate(licenseKey, response.Uses, response.Purchase, time.Now())
+	response.Policy = &decision
+	if !decision.Allowed {
+		response.Success = false
+		response.Message = decision.Detail
+	}
+}
+
+// callGumroadVerify performs the verification request. The returned APICall
+// is always populated and left for the caller to record.
+func (app *App) callGumroadVerify(productID, licenseKey string, incrementUses bool) (LicenseValidationResponse, APICall, error) {
+	form := url.Values{}
+	form.Set("product_id", productID)
+	form.Set("license_key", licenseKey)
+	form.Set("increment_uses_count", strconv.FormatBool(incrementUses))
+	data := form.Encode()
+
+	startTime := time.Now()
+	apiCall := APICall{
+		Timestamp:   startTime,
+		Method:      "POST",
+		URL:         app.gumroadURL("/licenses/verify"),
+		RequestBody: data,
+	}
+	fail := func(err error) (LicenseValidationResponse, APICall, error) {
+		apiCall.Duration = time.Since(startTime)
+		apiCall.Error = err.Error()
+		return LicenseValidationResponse{}, apiCall, err
+	}
+
+	httpReq, err := http.NewRequest("POST", apiCall.URL, strings.NewReader(data))
+	if err != nil {
+		return fail(err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	apiCall.Headers = make(map[string]string)
+	apiCall.Headers["Content-Type"] = httpReq.Header.Get("Content-Type")
+
+	resp, err := app.httpClient(30 * time.Second).Do(httpReq)
+	if err != nil {
+		return fail(fmt.Errorf("%w: %v", errGumroadUnavailable, err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	apiCall.Status = resp.StatusCode
+	apiCall.ResponseBody = string(body)
+	apiCall.ResponseHeaders = make(map[string]string)
+	for k, v := range resp.Header {
+		apiCall.ResponseHeaders[k] = v[0]
+	}
+	if err != nil {
+		return fail(fmt.Errorf("%w: %v", errGumroadUnavailable, err))
+	}
+
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return fail(fmt.Errorf("%w: status %d", errGumroadUnavailable, resp.StatusCode))
+	}
+
+	// Gumroad answers unknown keys with a 404 and a JSON body, so the status
+	// code alone does not tell us whether the response is usable
+	var gumroadResponse map[string]interface{}
+	if err := json.Unmarshal(body, &gumroadResponse); err != nil {
+		return fail(fmt.Errorf("failed to parse response (status %d): %v", resp.StatusCode, err))
+	}
+	apiCall.Duration = time.Since(startTime)
+
+	response := LicenseValidationResponse{
+		Success: false,
+	}
+
+	if success, ok := gumroadResponse["success"].(bool); ok && success {
+		response.Success = true
+
+		if uses, ok := gumroadResponse["uses"].(float64); ok {
+			response.Uses = int(uses)
+		}
+
+		if purchase, ok := gumroadResponse["purchase"].(map[string]interface{}); ok {
+			response.Purchase = purchase
+		}
+	} else {
+		if msg, ok := gumroadResponse["message"].(string); ok {
+			response.Message = msg
+		} else {
+			response.Message = "Invalid license key"
+		}
+	}
+
+	return response, apiCall, nil
+}
+
+// purchaseBool reads a boolean flag from a Gumroad purchase object.
+func purchaseBool(purchase map[string]interface{}, field string) bool {
+	value, _ := purchase[field].(bool)
+	return value
+}
+
+// purchaseTime reads a timestamp from a Gumroad purchase object. Missing,
+// null or unparseable values yield nil.
+func purchaseTime(purchase map[string]interface{}, field string) *time.Time {
+	value, ok := purchase[field].(string)
+	if !ok || value == "" {
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05.000Z07:00", "2006-01-02 15:04:05 MST"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+	return nil
+}